@@ -16,6 +16,7 @@ import (
 
 	"github.com/threefoldtech/zos4/pkg"
 	"github.com/threefoldtech/zos4/pkg/identity"
+	"github.com/threefoldtech/zos4/pkg/maintenance"
 	"github.com/threefoldtech/zosbase/pkg/environment"
 
 	"github.com/rs/zerolog/log"
@@ -49,9 +50,10 @@ func main() {
 		ver      bool
 		debug    bool
 
-		id   bool
-		net  bool
-		farm bool
+		id     bool
+		net    bool
+		farm   bool
+		rotate bool
 	)
 
 	flag.StringVar(&root, "root", "/var/cache/modules/identityd", "root working directory of the module")
@@ -62,6 +64,7 @@ func main() {
 	flag.BoolVar(&id, "id", false, "prints the node ID/pubkey and exits needed for loki service")
 	flag.BoolVar(&net, "net", false, "prints the node network and exits")
 	flag.BoolVar(&farm, "farm", false, "prints the node farm id and exits")
+	flag.BoolVar(&rotate, "rotate", false, "rotates the node key, prints the new pubkey and exits")
 
 	flag.Parse()
 	if ver {
@@ -90,6 +93,22 @@ func main() {
 		// fmt.Println(stub.NodeID(ctx))
 		fmt.Println(base64.StdEncoding.EncodeToString(pubKey))
 
+		os.Exit(0)
+	} else if rotate {
+		ctx := context.Background()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to connect to zbus")
+		}
+		stub := stubs.NewIdentityManagerStub(client)
+		rotation, err := stub.RotateKey(ctx)
+		if err != nil {
+			// a pending rotation is completed by identityd once the
+			// registrar is reachable
+			log.Fatal().Err(err).Msg("failed to rotate node key")
+		}
+
+		fmt.Println(base64.StdEncoding.EncodeToString(rotation.NewKey))
+
 		os.Exit(0)
 	}
 
@@ -99,12 +118,22 @@ func main() {
 
 	// 2. Register the node to BCDB
 	// at this point we are running latest version
-	idMgr, err := getIdentityMgr(root, debug)
+	idMgr, err := getIdentityMgr(root, debug, stubs.NewRegistrarGatewayStub(client))
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to create identity manager")
 	}
 
-	upgrader, err := upgrade.NewUpgrader(root, upgrade.NoZosUpgrade(debug), upgrade.ZbusClient(client))
+	schedule, err := maintenance.Load()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load some maintenance windows")
+	}
+
+	upgrader, err := upgrade.NewUpgrader(
+		root,
+		upgrade.NoZosUpgrade(debug),
+		upgrade.ZbusClient(client),
+		upgrade.MaintenanceWindow(schedule),
+	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to initialize upgrader")
 	}
@@ -149,8 +178,8 @@ func main() {
 	}
 }
 
-func getIdentityMgr(root string, debug bool) (pkg.IdentityManager, error) {
-	manager, err := identity.NewManager(root, debug)
+func getIdentityMgr(root string, debug bool, registrar identity.Registrar) (pkg.IdentityManager, error) {
+	manager, err := identity.NewManager(root, debug, registrar)
 	if err != nil {
 		return nil, err
 	}
@@ -5,6 +5,7 @@ import (
 	"crypto/ed25519"
 	// "encoding/hex"
 	"fmt"
+	"strconv"
 
 	// "github.com/cenkalti/backoff/v3"
 	"github.com/rs/zerolog/log"
@@ -13,12 +14,19 @@ import (
 	registrar "github.com/threefoldtech/zos4/pkg/registrar_gateway"
 	"github.com/threefoldtech/zos4/pkg/stubs"
 	"github.com/threefoldtech/zosbase/pkg/environment"
+	"github.com/threefoldtech/zosbase/pkg/kernel"
 	"github.com/threefoldtech/zosbase/pkg/utils"
 	zosapi "github.com/threefoldtech/zosbase/pkg/zos_api_light"
 	"github.com/urfave/cli/v2"
 )
 
-const module = "api-gateway"
+const (
+	module = "api-gateway"
+
+	// paramWorkers kernel param with the number of workers, it overrides
+	// the workers flag
+	paramWorkers = "api-gateway-workers"
+)
 
 // Module entry point
 var Module cli.Command = cli.Command{
@@ -30,10 +38,15 @@ var Module cli.Command = cli.Command{
 			Usage: "connection string to the message `BROKER`",
 			Value: "unix:///var/run/redis.sock",
 		},
+		&cli.StringFlag{
+			Name:  "root",
+			Usage: "`ROOT` working directory of the module",
+			Value: "/var/cache/modules/api-gateway",
+		},
 		&cli.UintFlag{
 			Name:  "workers",
-			Usage: "number of workers `N`",
-			Value: 1,
+			Usage: "number of workers `N`, calls of different operations are handled in parallel",
+			Value: 4,
 		},
 	},
 	Action: action,
@@ -42,9 +55,19 @@ var Module cli.Command = cli.Command{
 func action(cli *cli.Context) error {
 	var (
 		msgBrokerCon string = cli.String("broker")
+		root         string = cli.String("root")
 		workerNr     uint   = cli.Uint("workers")
 	)
 
+	if value, ok := kernel.GetParams().GetOne(paramWorkers); ok {
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || n == 0 {
+			log.Error().Str("value", value).Msgf("invalid %s kernel param, using %d workers", paramWorkers, workerNr)
+		} else {
+			workerNr = uint(n)
+		}
+	}
+
 	server, err := zbus.NewRedisServer(module, msgBrokerCon, workerNr)
 	if err != nil {
 		return fmt.Errorf("fail to connect to message broker server: %w", err)
@@ -67,7 +90,7 @@ func action(cli *cli.Context) error {
 	// }
 
 	router := peer.NewRouter()
-	gw, err := registrar.NewRegistrarGateway(cli.Context, redis)
+	gw, err := registrar.NewRegistrarGateway(cli.Context, redis, root)
 	if err != nil {
 		return fmt.Errorf("failed to create api gateway: %w", err)
 	}
@@ -90,8 +113,8 @@ func action(cli *cli.Context) error {
 		}
 	}()
 
-	farm, err := gw.GetFarm(uint64(environment.MustGet().FarmID))
-	if err != nil {
+	farm, rerr := gw.GetFarm(uint64(environment.MustGet().FarmID))
+	if err := rerr.Err(); err != nil {
 		return fmt.Errorf("failed to get farm: %w", err)
 	}
 
@@ -0,0 +1,100 @@
+package decommission
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos4/pkg/decommission"
+	"github.com/urfave/cli/v2"
+)
+
+// Module entry point
+var Module cli.Command = cli.Command{
+	Name:  "decommission",
+	Usage: "retires the node: drains deployments, removes the node from the registrar and wipes its identity",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "broker",
+			Value: "unix:///var/run/redis.sock",
+			Usage: "connection string to the message `BROKER`",
+		},
+		&cli.DurationFlag{
+			Name:  "notice",
+			Value: 24 * time.Hour,
+			Usage: "time given to deployment owners to remove their deployments before they are deprovisioned",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "deprovision running deployments right away (same as --notice 0)",
+		},
+		&cli.BoolFlag{
+			Name:  "abort",
+			Usage: "abort a running decommission, only possible before the node is removed from the registrar",
+		},
+		&cli.BoolFlag{
+			Name:  "status",
+			Usage: "show the state of a running decommission",
+		},
+	},
+	Action: action,
+}
+
+func action(cli *cli.Context) error {
+	var (
+		msgBrokerCon string        = cli.String("broker")
+		notice       time.Duration = cli.Duration("notice")
+	)
+
+	if cli.Bool("force") {
+		notice = 0
+	}
+
+	cl, err := zbus.NewRedisClient(msgBrokerCon)
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize zbus client")
+	}
+
+	d := decommission.New(decommission.NewZbusNode(cl), decommission.StatePath)
+
+	if cli.Bool("status") {
+		state, ok, err := d.State()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("no decommission is running")
+			return nil
+		}
+
+		fmt.Printf("step: %s\n", state.Step)
+		fmt.Printf("started: %s\n", time.Unix(state.Started, 0))
+		fmt.Printf("deadline: %s\n", time.Unix(state.Deadline, 0))
+		return nil
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if cli.Bool("abort") {
+		if err := d.Abort(ctx); err != nil {
+			return err
+		}
+
+		log.Info().Msg("decommission aborted, node accepts deployments again")
+		return nil
+	}
+
+	if err := d.Run(ctx, notice); err != nil {
+		return err
+	}
+
+	log.Info().Msg("node is decommissioned, it starts with a new identity on next boot")
+	return nil
+}
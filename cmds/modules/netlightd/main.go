@@ -126,11 +126,14 @@ func action(cli *cli.Context) error {
 			return fmt.Errorf("failed to create mycelium link: %w", err)
 		}
 	}
-	err = resource.SetupMycelium(nil, hostMyCelium, myceliumSeedFromIdentity(identity.PrivateKey(cli.Context)))
+	seed := myceliumSeedFromIdentity(identity.PrivateKey(cli.Context))
+	err = resource.SetupMycelium(nil, hostMyCelium, seed)
 	if err != nil {
 		return fmt.Errorf("failed to setup mycelium on host: %w", err)
 	}
 
+	go watchRotations(ctx, identity, hostMyCelium, seed)
+
 	// if err := nft.DropTrafficToLAN(""); err != nil {
 	// 	return fmt.Errorf("failed to drop traffic to lan: %w", err)
 	// }
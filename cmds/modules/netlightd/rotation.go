@@ -0,0 +1,77 @@
+package netlightd
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos4/pkg/stubs"
+	"github.com/threefoldtech/zosbase/pkg/netlight/resource"
+	"github.com/vishvananda/netlink"
+)
+
+// watchRotations sets up the host mycelium again each time the node key is
+// rotated, since the mycelium seed is derived from the node key. seed is the
+// seed mycelium is currently running with.
+func watchRotations(ctx context.Context, identity *stubs.IdentityManagerStub, hostMyCelium string, seed []byte) {
+	for {
+		rotations, err := identity.KeyRotations(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to watch node key rotations")
+		} else {
+			// a rotation can be completed while identityd restarts
+			seed = reloadMycelium(identity.PrivateKey(ctx), hostMyCelium, seed)
+			for range rotations {
+				seed = reloadMycelium(identity.PrivateKey(ctx), hostMyCelium, seed)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+// reloadMycelium restarts the host mycelium with the seed of sk if it's not
+// the current seed, it returns the seed mycelium runs with
+func reloadMycelium(sk []byte, hostMyCelium string, current []byte) []byte {
+	seed := myceliumSeedFromIdentity(sk)
+	if bytes.Equal(seed, current) {
+		return current
+	}
+
+	log.Info().Msg("node key rotated, restarting host mycelium")
+	if err := resource.SetupMycelium(nil, hostMyCelium, seed); err != nil {
+		log.Error().Err(err).Msg("failed to setup mycelium on host with the rotated key")
+		return current
+	}
+
+	// the gateway address of the old seed is not valid anymore
+	if err := removeMyceliumAddr(hostMyCelium, current); err != nil {
+		log.Error().Err(err).Msg("failed to remove old mycelium address")
+	}
+
+	return seed
+}
+
+func removeMyceliumAddr(hostMyCelium string, seed []byte) error {
+	inspect, err := resource.InspectMycelium(seed)
+	if err != nil {
+		return err
+	}
+
+	gw, err := inspect.Gateway()
+	if err != nil {
+		return err
+	}
+
+	link, err := netlink.LinkByName(hostMyCelium)
+	if err != nil {
+		return err
+	}
+
+	return netlink.AddrDel(link, &netlink.Addr{IPNet: &gw})
+}
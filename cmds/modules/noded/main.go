@@ -9,12 +9,14 @@ import (
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 
+	"github.com/threefoldtech/zos4/pkg/events"
+	zos4healthcheck "github.com/threefoldtech/zos4/pkg/perf/healthcheck"
 	registrar "github.com/threefoldtech/zos4/pkg/registrar_light"
 	zos4stubs "github.com/threefoldtech/zos4/pkg/stubs"
 	"github.com/threefoldtech/zosbase/pkg/app"
 	"github.com/threefoldtech/zosbase/pkg/capacity"
+	dmiTypes "github.com/threefoldtech/zosbase/pkg/capacity/dmi"
 	"github.com/threefoldtech/zosbase/pkg/environment"
-	"github.com/threefoldtech/zosbase/pkg/events"
 	"github.com/threefoldtech/zosbase/pkg/monitord"
 	"github.com/threefoldtech/zosbase/pkg/perf"
 	"github.com/threefoldtech/zosbase/pkg/perf/cpubench"
@@ -32,7 +34,8 @@ import (
 const (
 	module          = "node"
 	registrarModule = "registrar"
-	eventsBlock     = "/tmp/events.chain"
+	eventsCursor    = "/var/cache/modules/noded/contract-events"
+	nodeEventsState = "/var/cache/modules/noded/node-events"
 )
 
 // Module is entry point for module
@@ -149,13 +152,28 @@ func action(cli *cli.Context) error {
 			log.Info().Uint16("vendor", gpu.Vendor).Uint16("device", device.ID).Msg("found GPU (can't look up device name)")
 		}
 
-		info = info.WithGPU(gpu.ShortID())
+		info = info.WithGPU(registrar.GPUInventory(gpu))
+	}
+
+	// the inventory is best effort, missing parts are not published
+	nics, err := registrar.NICsInventory(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list network interfaces for inventory")
+	}
+
+	pools, err := stubs.NewStorageModuleStub(redis).Metrics(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list disks for inventory")
 	}
 
 	info = info.WithCapacity(cap).
 		WithSerialNumber(dmi.BoardVersion()).
 		WithSecureBoot(secureBoot).
-		WithVirtualized(len(hypervisor) != 0)
+		WithVirtualized(len(hypervisor) != 0).
+		WithNICs(nics).
+		WithDisks(registrar.DisksInventory(pools)).
+		WithBoard(registrar.DMIInventory(dmi, dmiTypes.TypeBaseboard)).
+		WithSystem(registrar.DMIInventory(dmi, dmiTypes.TypeSystem))
 
 	go registerationServer(ctx, msgBrokerCon, env, info)
 	log.Info().Msg("start perf scheduler")
@@ -169,7 +187,7 @@ func action(cli *cli.Context) error {
 		return errors.Wrap(err, "failed to create a zbus client to the msgBroker")
 	}
 	ctx = perf.WithZbusClient(ctx, zcl)
-	healthcheck.RunNTPCheck(ctx)
+	zos4healthcheck.RunNTPCheck(ctx)
 	perfMon.AddTask(iperf.NewTask())
 	perfMon.AddTask(cpubench.NewTask())
 	perfMon.AddTask(publicip.NewTask())
@@ -209,16 +227,19 @@ func action(cli *cli.Context) error {
 		return errors.Wrap(err, "failed to get node id")
 	}
 
-	sub, err := environment.GetSubstrate()
+	contractEvents, err := events.NewContractStream(zos4stubs.NewRegistrarGatewayStub(redis), msgBrokerCon, node, eventsCursor)
 	if err != nil {
 		return err
 	}
+	go contractEvents.Start(ctx)
 
-	events, err := events.NewRedisStream(sub, msgBrokerCon, env.FarmID, node, eventsBlock)
+	// power targets and public config were published by the chain events
+	// stream before, they are polled from the registrar now
+	nodeEvents, err := events.NewNodeStream(zos4stubs.NewRegistrarGatewayStub(redis), msgBrokerCon, env.FarmID, node, nodeEventsState)
 	if err != nil {
 		return err
 	}
-	go events.Start(ctx)
+	go nodeEvents.Start(ctx)
 
 	system, err := monitord.NewSystemMonitor(node, 2*time.Second, redis)
 	if err != nil {
@@ -87,7 +87,7 @@ func action(cli *cli.Context) error {
 
 	registrarGateway := zos4stub.NewRegistrarGatewayStub(cl)
 
-	uptime, err := power.NewUptime(registrarGateway)
+	uptime, err := power.NewUptime(registrarGateway, identity)
 	if err != nil {
 		return errors.Wrap(err, "failed to initialize uptime reported")
 	}
@@ -2,49 +2,122 @@ package provisiond
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/cenkalti/backoff/v3"
 	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/joncrlsn/dque"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
 	"github.com/threefoldtech/zos4/pkg/stubs"
 	gridtypes "github.com/threefoldtech/zosbase/pkg/gridtypes"
 	"github.com/threefoldtech/zosbase/pkg/provision"
 )
 
+const (
+	// capacityFlushInterval is how often pending updates are
+	// collected into a single batch
+	capacityFlushInterval = 30 * time.Second
+	// capacityMaxBatch max number of contracts set in a single call
+	capacityMaxBatch = 200
+	// capacityMaxRetryInterval max wait between retries of a failed batch
+	capacityMaxRetryInterval = 5 * time.Minute
+)
+
 type DeploymentID struct {
 	Twin     uint32
 	Contract uint64
 }
 
+// capacityBatch is a set of deployments which consumption
+// is set in a single call
+type capacityBatch struct {
+	IDs []DeploymentID
+}
+
+// CapacitySetter sets contracts used capacity on the registrar. Updates
+// are persisted as soon as they are received, then deduplicated and sent
+// in batches so a burst of changes (or a slow registrar) never blocks
+// the engine and nothing is lost on restart.
 type CapacitySetter struct {
 	registrarGateway *stubs.RegistrarGatewayStub
-	ch               chan DeploymentID
 	storage          provision.Storage
+	// pending holds single updates as received from the engine
+	pending *dque.DQue
+	// batches holds deduplicated updates ready to be sent
+	batches *dque.DQue
 }
 
-func NewCapacitySetter(registrarGateway *stubs.RegistrarGatewayStub, storage provision.Storage) CapacitySetter {
-	return CapacitySetter{
+func openQueue(name, root string, builder func() interface{}) (*dque.DQue, error) {
+	queue, err := dque.NewOrOpen(name, root, 1024, builder)
+	if err != nil {
+		// data types has changed or queue is corrupted, we can
+		// only start over. active contracts are set again on start
+		os.RemoveAll(filepath.Join(root, name))
+		queue, err = dque.NewOrOpen(name, root, 1024, builder)
+	}
+
+	return queue, err
+}
+
+// NewCapacitySetter creates a new capacity setter, with its queues stored under root
+func NewCapacitySetter(registrarGateway *stubs.RegistrarGatewayStub, storage provision.Storage, root string) (*CapacitySetter, error) {
+	pending, err := openQueue("capacity", root, func() interface{} { return &DeploymentID{} })
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to setup capacity persisted queue")
+	}
+
+	batches, err := openQueue("capacity-batches", root, func() interface{} { return &capacityBatch{} })
+	if err != nil {
+		pending.Close()
+		return nil, errors.Wrap(err, "failed to setup capacity batches persisted queue")
+	}
+
+	return &CapacitySetter{
 		registrarGateway: registrarGateway,
 		storage:          storage,
-		ch:               make(chan DeploymentID, 215),
+		pending:          pending,
+		batches:          batches,
+	}, nil
+}
+
+// Close the setter queues
+func (c *CapacitySetter) Close() error {
+	if err := c.pending.Close(); err != nil {
+		return err
 	}
+
+	return c.batches.Close()
 }
 
+// Callback is called by the engine on each deployment change. It only
+// persists the change so it never blocks on the registrar.
 func (c *CapacitySetter) Callback(twin uint32, contract uint64, delete bool) {
 	// we don't set capacity on the grid on deletion
 	if delete {
 		return
 	}
 
-	// we just push it to channel so we can return as soon
-	// as possible. The channel should have enough capacity
-	// to accept enough active contracts.
-	c.ch <- DeploymentID{Twin: twin, Contract: contract}
+	c.Push(DeploymentID{Twin: twin, Contract: contract})
+}
+
+// Push schedules setting the used capacity of the given deployments
+func (c *CapacitySetter) Push(ids ...DeploymentID) {
+	for i := range ids {
+		if err := c.pending.Enqueue(&ids[i]); err != nil {
+			log.Error().Err(err).
+				Uint32("twin", ids[i].Twin).
+				Uint64("contract", ids[i].Contract).
+				Msg("failed to schedule contract used capacity update")
+		}
+	}
 }
 
-func (c *CapacitySetter) setWithClient(deployments ...gridtypes.Deployment) error {
+func (c *CapacitySetter) setWithClient(ctx context.Context, deployments ...gridtypes.Deployment) error {
 	caps := make([]substrate.ContractResources, 0, len(deployments))
 	for _, deployment := range deployments {
 		var total gridtypes.Capacity
@@ -82,45 +155,183 @@ func (c *CapacitySetter) setWithClient(deployments ...gridtypes.Deployment) erro
 		caps = append(caps, cap)
 	}
 
-	bo := backoff.WithMaxRetries(
-		backoff.NewConstantBackOff(6*time.Second),
-		4,
-	)
+	return c.set(ctx, caps)
+}
 
-	return backoff.RetryNotify(func() error {
-		return c.registrarGateway.SetContractConsumption(context.Background(), caps...)
-	}, bo, func(err error, d time.Duration) {
+// retryable returns true if the registrar could not take the call now
+// but may take it later
+func retryable(rerr zos4pkg.RegistrarError) bool {
+	return rerr.IsCode(zos4pkg.RegistrarCodeUnavailable, zos4pkg.RegistrarCodeRateLimited)
+}
+
+// set sets the used capacity of contracts. It never gives up while the
+// registrar can't take the call, the batch stays in the queue until it's
+// set. If the registrar rejects the batch, it's split to find the rejected
+// contracts which are dropped, so they don't hold back the others.
+func (c *CapacitySetter) set(ctx context.Context, caps []substrate.ContractResources) error {
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxInterval = capacityMaxRetryInterval
+	bo.MaxElapsedTime = 0
+
+	var rejected zos4pkg.RegistrarError
+	err := backoff.RetryNotify(func() error {
+		// a queued batch is sent by the gateway once the registrar is back
+		rerr := c.registrarGateway.SetContractConsumption(ctx, caps...)
+		if rerr.IsQueued() {
+			log.Warn().Int("contracts", len(caps)).Msg("contract consumption is queued")
+			return nil
+		}
+
+		if rerr.IsError() && !retryable(rerr) {
+			rejected = rerr
+			return nil
+		}
+
+		return rerr.Err()
+	}, backoff.WithContext(bo, ctx), func(err error, d time.Duration) {
 		log.Error().Err(err).Dur("retry-in", d).Msg("failed to set contract consumption")
 	})
-}
 
-func (c *CapacitySetter) Set(deployment ...gridtypes.Deployment) error {
-	if len(deployment) == 0 {
+	if err != nil || !rejected.IsError() {
+		return err
+	}
+
+	if len(caps) == 1 {
+		log.Error().Err(rejected.Err()).
+			Uint64("contract", uint64(caps[0].ContractID)).
+			Msg("registrar rejected contract consumption, dropping it")
 		return nil
 	}
 
-	return c.setWithClient(deployment...)
+	log.Warn().Err(rejected.Err()).Int("contracts", len(caps)).Msg("registrar rejected contract consumption batch, splitting it")
+	half := len(caps) / 2
+	if err := c.set(ctx, caps[:half]); err != nil {
+		return err
+	}
+
+	return c.set(ctx, caps[half:])
 }
 
-func (c *CapacitySetter) Run(ctx context.Context) error {
-	for {
-		var id DeploymentID
-		select {
-		case <-ctx.Done():
+// flush moves all pending updates to batches, dropping duplicates. Updates
+// that were drained but not yet persisted as a batch when the node goes down
+// are recovered on start since all active contracts are pushed again.
+func (c *CapacitySetter) flush() error {
+	seen := make(map[DeploymentID]struct{})
+	var batch capacityBatch
+
+	push := func() error {
+		if len(batch.IDs) == 0 {
 			return nil
-		case id = <-c.ch:
 		}
 
-		log := log.With().Uint32("twin", id.Twin).Uint64("contract", id.Contract).Logger()
+		if err := c.batches.Enqueue(&batch); err != nil {
+			return errors.Wrap(err, "failed to persist capacity batch")
+		}
+
+		batch = capacityBatch{}
+		return nil
+	}
+
+	for {
+		item, err := c.pending.Dequeue()
+		if errors.Is(err, dque.ErrEmpty) {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "failed to read pending capacity updates")
+		}
 
+		id := *item.(*DeploymentID)
+		if _, ok := seen[id]; ok {
+			continue
+		}
+
+		seen[id] = struct{}{}
+		batch.IDs = append(batch.IDs, id)
+		if len(batch.IDs) >= capacityMaxBatch {
+			if err := push(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return push()
+}
+
+// send sets the used capacity of a batch. Deployments are loaded at
+// send time so the latest state is always what is set.
+func (c *CapacitySetter) send(ctx context.Context, batch *capacityBatch) error {
+	deployments := make([]gridtypes.Deployment, 0, len(batch.IDs))
+	for _, id := range batch.IDs {
 		deployment, err := c.storage.Get(id.Twin, id.Contract)
-		if err != nil {
-			log.Error().Err(err).Msg("failed to get deployment")
+		if errors.Is(err, provision.ErrDeploymentNotExists) {
+			// deleted since, nothing to set
+			continue
+		} else if err != nil {
+			log.Error().Err(err).
+				Uint32("twin", id.Twin).
+				Uint64("contract", id.Contract).
+				Msg("failed to get deployment")
 			continue
 		}
 
-		if err := c.Set(deployment); err != nil {
-			log.Error().Err(err).Msg("failed to set contract usage")
+		deployments = append(deployments, deployment)
+	}
+
+	if len(deployments) == 0 {
+		return nil
+	}
+
+	return c.setWithClient(ctx, deployments...)
+}
+
+func (c *CapacitySetter) pusher(ctx context.Context) error {
+	for {
+		item, err := c.batches.Peek()
+		if errors.Is(err, dque.ErrEmpty) {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(capacityFlushInterval):
+				continue
+			}
+		} else if err != nil {
+			return errors.Wrap(err, "failed to peek into capacity batches queue")
+		}
+
+		if err := c.send(ctx, item.(*capacityBatch)); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		// only removed once it's set
+		if _, err := c.batches.Dequeue(); err != nil {
+			return errors.Wrap(err, "failed to remove capacity batch from queue")
+		}
+	}
+}
+
+// Run collects pending updates every interval and sends them until ctx is cancelled
+func (c *CapacitySetter) Run(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.pusher(ctx)
+	}()
+
+	ticker := time.NewTicker(capacityFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errCh:
+			return err
+		case <-ticker.C:
+			if err := c.flush(); err != nil {
+				log.Error().Err(err).Msg("failed to batch pending capacity updates")
+			}
 		}
 	}
 }
@@ -46,8 +46,8 @@ func (r *ContractEventHandler) sync(ctx context.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "failed to get current active contracts")
 	}
-	onchain, err := r.registrarGateway.GetNodeContracts(ctx, uint32(r.node))
-	if err != nil {
+	onchain, rerr := r.registrarGateway.GetNodeContracts(ctx, uint32(r.node))
+	if err := rerr.Err(); err != nil {
 		return errors.Wrap(err, "failed to get active node contracts")
 	}
 
@@ -88,9 +88,9 @@ func (r *ContractEventHandler) sync(ctx context.Context) error {
 			Uint64("contract", id).
 			Logger()
 
-		contract, err := r.registrarGateway.GetContract(ctx, id)
-		if err.IsError() {
-			logger.Error().Err(err.Err).Msg("failed to get contract from chain")
+		contract, rerr := r.registrarGateway.GetContract(ctx, id)
+		if err := rerr.Err(); err != nil {
+			logger.Error().Err(err).Msg("failed to get contract from chain")
 			continue
 		}
 		// locked is chain state for that contract
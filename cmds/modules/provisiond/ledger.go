@@ -0,0 +1,96 @@
+package provisiond
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zbus"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/ledger"
+	zos4stubs "github.com/threefoldtech/zos4/pkg/stubs"
+	"github.com/urfave/cli/v2"
+)
+
+// ledgerCommand exports the consumption ledger of a running provisiond
+var ledgerCommand = cli.Command{
+	Name:  "ledger",
+	Usage: "export the signed consumption ledger as json lines",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "broker",
+			Usage: "connection string to the message `BROKER`",
+			Value: "unix:///var/run/redis.sock",
+		},
+		&cli.Uint64Flag{
+			Name:  "contract",
+			Usage: "only export entries of `CONTRACT`",
+		},
+		&cli.TimestampFlag{
+			Name:   "from",
+			Usage:  "only export entries with windows ending at or after `TIME`",
+			Layout: time.RFC3339,
+		},
+		&cli.TimestampFlag{
+			Name:   "to",
+			Usage:  "only export entries with windows starting at or before `TIME`",
+			Layout: time.RFC3339,
+		},
+		&cli.BoolFlag{
+			Name:  "verify",
+			Usage: "verify entries signatures against the node keys, fails on the first invalid entry",
+		},
+	},
+	Action: exportLedger,
+}
+
+func exportLedger(c *cli.Context) error {
+	cl, err := zbus.NewRedisClient(c.String("broker"))
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to message broker server")
+	}
+
+	query := zos4pkg.LedgerQuery{
+		ContractID: c.Uint64("contract"),
+	}
+	if from := c.Timestamp("from"); from != nil {
+		query.From = from.Unix()
+	}
+	if to := c.Timestamp("to"); to != nil {
+		query.To = to.Unix()
+	}
+
+	ctx := context.Background()
+	stub := zos4stubs.NewLedgerStub(cl)
+	keys, err := stub.PublicKeys(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to get ledger keys")
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		page, err := stub.Entries(ctx, query)
+		if err != nil {
+			return errors.Wrap(err, "failed to list ledger entries")
+		}
+
+		for i := range page.Entries {
+			entry := &page.Entries[i]
+			if c.Bool("verify") && !ledger.VerifyWith(entry, keys) {
+				return fmt.Errorf("invalid signature of ledger entry '%d'", entry.ID)
+			}
+
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+		}
+
+		if !page.More {
+			return nil
+		}
+		query.Cursor = page.Next
+	}
+}
@@ -5,21 +5,24 @@ import (
 	"context"
 	"crypto/ed25519"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/cenkalti/backoff/v3"
 	"github.com/pkg/errors"
-	"github.com/threefoldtech/zosbase/pkg"
 	"github.com/threefoldtech/zosbase/pkg/app"
 	"github.com/threefoldtech/zosbase/pkg/capacity"
 	"github.com/threefoldtech/zosbase/pkg/environment"
 	"github.com/threefoldtech/zosbase/pkg/events"
 	"github.com/threefoldtech/zosbase/pkg/gridtypes"
 	"github.com/threefoldtech/zosbase/pkg/gridtypes/zos"
+	"github.com/threefoldtech/zosbase/pkg/network/mycelium"
 	"github.com/threefoldtech/zosbase/pkg/primitives"
-	"github.com/threefoldtech/zosbase/pkg/provision/storage"
 	fsStorage "github.com/threefoldtech/zosbase/pkg/provision/storage.fs"
 
 	"github.com/urfave/cli/v2"
@@ -31,13 +34,23 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/threefoldtech/zbus"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/ledger"
+	"github.com/threefoldtech/zos4/pkg/maintenance"
+	zos4primitives "github.com/threefoldtech/zos4/pkg/primitives"
 	"github.com/threefoldtech/zos4/pkg/provision"
+	"github.com/threefoldtech/zos4/pkg/provision/api"
+	"github.com/threefoldtech/zos4/pkg/provision/storage"
+	"github.com/threefoldtech/zos4/pkg/reservation"
 )
 
 const (
 	serverName       = "provision"
 	provisionModule  = "provision"
 	statisticsModule = "statistics"
+	provisionerObj   = "provisioner"
+	meteringObj      = "metering"
+	ledgerObj        = "ledger"
 	gib              = 1024 * 1024 * 1024
 
 	boltStorageDB = "workloads.bolt"
@@ -45,9 +58,18 @@ const (
 	metricsStorageDBOld = "metrics.bolt"
 	// new style db after rrd implementation change
 	metricsStorageDB = "metrics-diff.bolt"
+	// signed record of all consumption reports
+	ledgerDB = "ledger.bolt"
 
 	// deprecated, kept for migration
 	fsStorageDB = "workloads"
+
+	// historyCompactInterval is how often deployments history is compacted
+	historyCompactInterval = 24 * time.Hour
+
+	// apiMyceliumHost is the api listen host that stands for the node
+	// mycelium address
+	apiMyceliumHost = "mycelium"
 )
 
 // Module entry point
@@ -69,6 +91,24 @@ var Module cli.Command = cli.Command{
 			Name:  "integrity",
 			Usage: "run some integrity checks on some files",
 		},
+		&cli.StringSliceFlag{
+			Name:  "limit",
+			Usage: "max number of concurrent provision operations per workload type in the form `TYPE=N`, 0 means no limit",
+			Value: cli.NewStringSlice(fmt.Sprintf("%s=2", zos.ZMachineLightType)),
+		},
+		&cli.StringFlag{
+			Name:  "api-listen",
+			Usage: "`ADDRESS` of the local rest api server, empty disables the api. The host 'mycelium' is the node mycelium address",
+			Value: net.JoinHostPort(apiMyceliumHost, "2021"),
+		},
+		&cli.IntFlag{
+			Name:  "history-keep",
+			Usage: "number of state transitions to keep per workload in deployments history, 0 keeps everything",
+			Value: 100,
+		},
+	},
+	Subcommands: []*cli.Command{
+		&ledgerCommand,
 	},
 	Action: action,
 }
@@ -130,8 +170,15 @@ func action(cli *cli.Context) error {
 		msgBrokerCon string = cli.String("broker")
 		rootDir      string = cli.String("root")
 		integrity    bool   = cli.Bool("integrity")
+		historyKeep  int    = cli.Int("history-keep")
+		apiListen    string = cli.String("api-listen")
 	)
 
+	limits, err := parseLimits(cli.StringSlice("limit"))
+	if err != nil {
+		return errors.Wrap(err, "invalid concurrency limits")
+	}
+
 	server, err := zbus.NewRedisServer(serverName, msgBrokerCon, 1)
 	if err != nil {
 		return errors.Wrap(err, "failed to connect to message broker")
@@ -176,7 +223,7 @@ func action(cli *cli.Context) error {
 		// disable providiond on this node
 		// we don't have a valid farmer id set
 		log.Info().Msg("orphan node, we won't provision anything at all")
-		select {}
+		return runOrphan(ctx, server, cl)
 	}
 
 	identity := zos4stubs.NewIdentityManagerStub(cl)
@@ -216,13 +263,27 @@ func action(cli *cli.Context) error {
 		log.Error().Err(err).Msg("failed to purge deleted deployments history")
 	}
 
-	provisioners := primitives.NewPrimitivesProvisioner(cl)
+	provisioners := zos4primitives.NewPrimitivesProvisioner(
+		cl,
+		// throttle heavy operations (like booting vms) so a burst
+		// of deployments does not choke the node
+		provision.WithConcurrencyLimits(limits),
+	)
 
-	cap, err := capacity.NewResourceOracle(stubs.NewStorageModuleStub(cl)).Total()
+	physical, err := capacity.NewResourceOracle(stubs.NewStorageModuleStub(cl)).Total()
 	if err != nil {
 		return errors.Wrap(err, "failed to get node capacity")
 	}
 
+	policy, err := reservation.Load()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load reservation policy, using defaults for invalid values")
+	}
+	log.Info().Interface("policy", policy).Msg("host reservation policy")
+
+	// capacity offered to workloads, cpu can be overcommitted by the farmer
+	cap := policy.Total(physical)
+
 	var active []gridtypes.Deployment
 	if !app.IsFirstBoot(serverName) {
 		// if this is the first boot of this module.
@@ -243,15 +304,21 @@ func action(cli *cli.Context) error {
 		}
 	}
 
+	reserved := getNodeReserved(cl, &policy, physical)
+
 	// statistics collects information about workload statistics
 	// also does some checks on capacity
 	statistics := primitives.NewStatistics(
 		cap,
 		store,
-		getNodeReserved(cl, cap),
+		reserved,
 		provisioners,
 	)
 
+	// statistics only checks memory, the guard enforces the reservation
+	// policy on the rest of the resources
+	guard := reservation.NewGuard(cap, store, reserved, statistics)
+
 	registrarGateway := zos4stubs.NewRegistrarGatewayStub(cl)
 	users, err := provision.NewRegistrarTwins(registrarGateway)
 	if err != nil {
@@ -268,13 +335,13 @@ func action(cli *cli.Context) error {
 		return errors.Wrap(err, "failed to get public key of secure key")
 	}
 
-	twin, err := registrarGateway.GetTwinByPubKey(ctx, pubKey)
-	if err != nil {
+	twin, rerr := registrarGateway.GetTwinByPubKey(ctx, pubKey)
+	if err := rerr.Err(); err != nil {
 		return errors.Wrap(err, "failed to get node twin id")
 	}
 
-	node, err := registrarGateway.GetNodeByTwinID(ctx, twin)
-	if err != nil {
+	node, rerr := registrarGateway.GetNodeByTwinID(ctx, twin)
+	if err := rerr.Err(); err != nil {
 		return errors.Wrap(err, "failed to get node from twin")
 	}
 	nodeID := node.NodeID
@@ -284,24 +351,31 @@ func action(cli *cli.Context) error {
 		return errors.Wrap(err, "failed to create storage for queues")
 	}
 
-	setter := NewCapacitySetter(registrarGateway, store)
-
-	log.Info().Int("contracts", len(active)).Msg("setting used capacity by contracts")
-	if err := setter.Set(active...); err != nil {
-		log.Error().Err(err).Msg("failed to set capacity for active contracts")
+	setter, err := NewCapacitySetter(registrarGateway, store, queues)
+	if err != nil {
+		return errors.Wrap(err, "failed to setup contracts capacity setter")
 	}
+	defer setter.Close()
 
-	log.Info().Msg("setting contracts used capacity done")
+	log.Info().Int("contracts", len(active)).Msg("scheduling used capacity of active contracts")
+	for _, deployment := range active {
+		setter.Push(DeploymentID{Twin: deployment.TwinID, Contract: deployment.ContractID})
+	}
 
 	go func() {
 		if err := setter.Run(ctx); err != nil {
-			log.Fatal().Err(err).Msg("capacity reporter exited unexpectedly")
+			log.Fatal().Err(err).Msg("capacity setter exited unexpectedly")
 		}
 	}()
 
+	schedule, err := maintenance.Load()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load some maintenance windows")
+	}
+
 	engine, err := provision.New(
 		store,
-		statistics,
+		guard,
 		queues,
 		provision.WithTwins(users),
 		provision.WithAdmins(admins),
@@ -326,6 +400,9 @@ func action(cli *cli.Context) error {
 		// be called. this one used by the setter to set used
 		// capacity on chain.
 		provision.WithCallback(setter.Callback),
+		// updates can restart user workloads so they are
+		// only applied during the farmer maintenance windows
+		provision.WithMaintenance(schedule),
 	)
 	if err != nil {
 		return errors.Wrap(err, "failed to instantiate provision engine")
@@ -333,14 +410,22 @@ func action(cli *cli.Context) error {
 
 	server.Register(
 		zbus.ObjectID{Name: provisionModule, Version: "0.0.1"},
-		pkg.Provision(engine),
+		zos4pkg.Provision(engine),
 	)
 
+	statisticsStream := primitives.NewStatisticsStream(statistics)
 	server.Register(
 		zbus.ObjectID{Name: statisticsModule, Version: "0.0.1"},
-		primitives.NewStatisticsStream(statistics),
+		statisticsStream,
 	)
 
+	if waiting, ok := provisioners.(zos4pkg.Provisioner); ok {
+		server.Register(
+			zbus.ObjectID{Name: provisionerObj, Version: "0.0.1"},
+			waiting,
+		)
+	}
+
 	log.Info().
 		Str("broker", msgBrokerCon).
 		Msg("starting provision module")
@@ -362,6 +447,33 @@ func action(cli *cli.Context) error {
 		log.Error().Err(err).Msg("failed to mark module as booted")
 	}
 
+	if historyKeep > 0 {
+		go compactHistory(ctx, store, historyKeep)
+	}
+
+	if apiListen != "" {
+		apiServer := api.NewServer(
+			engine,
+			stubs.NewNetworkerLightStub(cl),
+			statisticsStream,
+			users,
+			admins,
+			swaggerFs,
+		)
+
+		go func() {
+			listen, err := apiAddress(ctx, apiListen)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to get api server address")
+				return
+			}
+
+			if err := apiServer.Run(ctx, listen); err != nil {
+				log.Error().Err(err).Msg("api server exited unexpectedly")
+			}
+		}()
+	}
+
 	consumer, err := events.NewConsumer(msgBrokerCon, provisionModule)
 	if err != nil {
 		return errors.Wrap(err, "failed to create event consumer")
@@ -378,11 +490,29 @@ func action(cli *cli.Context) error {
 	// clean up old rrd db that uses previous style reporting
 	_ = os.Remove(filepath.Join(rootDir, metricsStorageDBOld))
 
-	reporter, err := NewReporter(filepath.Join(rootDir, metricsStorageDB), cl, queues)
+	book, err := ledger.Open(filepath.Join(rootDir, ledgerDB), sk)
+	if err != nil {
+		return errors.Wrap(err, "failed to open consumption ledger")
+	}
+	defer book.Close()
+
+	server.Register(
+		zbus.ObjectID{Name: ledgerObj, Version: "0.0.1"},
+		zos4pkg.Ledger(book),
+	)
+
+	reporter, err := NewReporter(filepath.Join(rootDir, metricsStorageDB), cl, queues, store, book)
 	if err != nil {
 		return errors.Wrap(err, "failed to setup capacity reporter")
 	}
 
+	server.Register(
+		zbus.ObjectID{Name: meteringObj, Version: "0.0.1"},
+		zos4pkg.Metering(reporter),
+	)
+
+	go watchRotations(ctx, identity, reporter)
+
 	// also spawn the capacity reporter
 	go func() {
 		defer reporter.Close()
@@ -409,7 +539,49 @@ func action(cli *cli.Context) error {
 	return nil
 }
 
-func getNodeReserved(cl zbus.Client, available gridtypes.Capacity) primitives.Reserved {
+// apiAddress resolves the api listen address. The mycelium host is replaced
+// with the node mycelium address, waiting for it to be set up.
+func apiAddress(ctx context.Context, listen string) (string, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid api address '%s'", listen)
+	}
+
+	if host != apiMyceliumHost {
+		return listen, nil
+	}
+
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxInterval = time.Minute
+	bo.MaxElapsedTime = 0
+
+	var ip net.IP
+	err = backoff.RetryNotify(func() error {
+		addresses, err := net.InterfaceAddrs()
+		if err != nil {
+			return err
+		}
+
+		for _, address := range addresses {
+			if ipNet, ok := address.(*net.IPNet); ok && mycelium.MyRange.Contains(ipNet.IP) {
+				ip = ipNet.IP
+				return nil
+			}
+		}
+
+		return fmt.Errorf("no mycelium address found")
+	}, backoff.WithContext(bo, ctx), func(err error, d time.Duration) {
+		log.Warn().Err(err).Dur("retry-in", d).Msg("waiting for mycelium address")
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+func getNodeReserved(cl zbus.Client, policy *reservation.Policy, physical gridtypes.Capacity) primitives.Reserved {
 	return func() (counter gridtypes.Capacity, err error) {
 		storage := stubs.NewStorageModuleStub(cl)
 		fs, err := storage.Cache(context.TODO())
@@ -417,12 +589,45 @@ func getNodeReserved(cl zbus.Client, available gridtypes.Capacity) primitives.Re
 			return counter, err
 		}
 
-		counter.SRU += fs.Usage.Size
-		counter.MRU += gridtypes.Max(
-			available.MRU*10/100,
-			2*gridtypes.Gigabyte,
-		)
+		return policy.Reserved(physical, fs.Usage.Size), nil
+	}
+}
+
+// parseLimits parses concurrency limits in the form type=n
+func parseLimits(values []string) (map[gridtypes.WorkloadType]int, error) {
+	limits := make(map[gridtypes.WorkloadType]int)
+	for _, value := range values {
+		typ, n, ok := strings.Cut(value, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid limit '%s' expecting type=n", value)
+		}
 
-		return
+		max, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil || max < 0 {
+			return nil, fmt.Errorf("invalid limit value for type '%s'", typ)
+		}
+
+		limits[gridtypes.WorkloadType(strings.TrimSpace(typ))] = max
+	}
+
+	return limits, nil
+}
+
+// compactHistory trims deployments history to the last `keep` transitions of
+// each workload, once on start and then every historyCompactInterval
+func compactHistory(ctx context.Context, store *storage.BoltStorage, keep int) {
+	for {
+		dropped, err := store.CompactHistory(keep)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to compact deployments history")
+		} else {
+			log.Info().Int("dropped", dropped).Msg("deployments history compacted")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(historyCompactInterval):
+		}
 	}
 }
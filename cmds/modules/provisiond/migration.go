@@ -8,9 +8,9 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos4/pkg/provision/storage"
 	gridtypes "github.com/threefoldtech/zosbase/pkg/gridtypes"
 	"github.com/threefoldtech/zosbase/pkg/gridtypes/zos"
-	"github.com/threefoldtech/zosbase/pkg/provision/storage"
 	fsStorage "github.com/threefoldtech/zosbase/pkg/provision/storage.fs"
 )
 
@@ -0,0 +1,168 @@
+package provisiond
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zbus"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/provision"
+	"github.com/threefoldtech/zosbase/pkg"
+	"github.com/threefoldtech/zosbase/pkg/app"
+	"github.com/threefoldtech/zosbase/pkg/capacity"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+	"github.com/threefoldtech/zosbase/pkg/stubs"
+)
+
+// errOrphanNode is returned by all mutating calls on an orphan node
+var errOrphanNode = fmt.Errorf("orphan node: provisioning is disabled because node has no valid farm")
+
+// orphanProvision is a read-only provision object served on orphan nodes. It has
+// no deployments at all, it only exists so other modules and the ui do not
+// hang waiting for provisiond.
+type orphanProvision struct{}
+
+var (
+	_ zos4pkg.Provision   = (*orphanProvision)(nil)
+	_ zos4pkg.Provisioner = (*orphanProvision)(nil)
+)
+
+func (o *orphanProvision) DecommissionCached(id string, reason string) error {
+	return errOrphanNode
+}
+
+func (o *orphanProvision) GetWorkloadStatus(id string) (gridtypes.ResultState, bool, error) {
+	return "", false, nil
+}
+
+func (o *orphanProvision) CreateOrUpdate(twin uint32, deployment gridtypes.Deployment, update bool) error {
+	return errOrphanNode
+}
+
+func (o *orphanProvision) Get(twin uint32, contractID uint64) (gridtypes.Deployment, error) {
+	return gridtypes.Deployment{}, provision.ErrDeploymentNotFound
+}
+
+func (o *orphanProvision) List(twin uint32) ([]gridtypes.Deployment, error) {
+	return []gridtypes.Deployment{}, nil
+}
+
+func (o *orphanProvision) Changes(twin uint32, contractID uint64) ([]gridtypes.Workload, error) {
+	return nil, provision.ErrDeploymentNotFound
+}
+
+func (o *orphanProvision) ListPublicIPs() ([]string, error) {
+	return []string{}, nil
+}
+
+func (o *orphanProvision) ListPrivateIPs(twin uint32, network gridtypes.Name) ([]string, error) {
+	return []string{}, nil
+}
+
+func (o *orphanProvision) ListPaged(twin uint32, query zos4pkg.HistoryQuery) (zos4pkg.DeploymentsPage, error) {
+	return zos4pkg.DeploymentsPage{Deployments: []gridtypes.Deployment{}}, nil
+}
+
+func (o *orphanProvision) ChangesPaged(twin uint32, contractID uint64, query zos4pkg.HistoryQuery) (zos4pkg.ChangesPage, error) {
+	return zos4pkg.ChangesPage{}, provision.ErrDeploymentNotFound
+}
+
+func (o *orphanProvision) Status() zos4pkg.ProvisionState {
+	return zos4pkg.ProvisionOrphan
+}
+
+// Drain does nothing since an orphan node never accepts deployments
+func (o *orphanProvision) Drain(draining bool) error {
+	return nil
+}
+
+func (o *orphanProvision) ActiveDeployments() (uint32, error) {
+	return 0, nil
+}
+
+func (o *orphanProvision) DeprovisionAll(reason string) error {
+	return nil
+}
+
+func (o *orphanProvision) Waiting() []zos4pkg.WorkloadQueue {
+	return []zos4pkg.WorkloadQueue{}
+}
+
+// orphanStatistics reports the node total capacity with zero reservations
+type orphanStatistics struct {
+	total gridtypes.Capacity
+}
+
+var _ pkg.Statistics = (*orphanStatistics)(nil)
+
+func (s *orphanStatistics) ReservedStream(ctx context.Context) <-chan gridtypes.Capacity {
+	ch := make(chan gridtypes.Capacity, 1)
+	// nothing will ever change, so a single update is enough
+	ch <- gridtypes.Capacity{}
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+
+	return ch
+}
+
+func (s *orphanStatistics) Current() (gridtypes.Capacity, error) {
+	return gridtypes.Capacity{}, nil
+}
+
+func (s *orphanStatistics) Total() gridtypes.Capacity {
+	return s.total
+}
+
+func (s *orphanStatistics) Workloads() (int, error) {
+	return 0, nil
+}
+
+func (s *orphanStatistics) GetCounters() (pkg.Counters, error) {
+	return pkg.Counters{Total: s.total}, nil
+}
+
+func (s *orphanStatistics) ListGPUs() ([]pkg.GPUInfo, error) {
+	return []pkg.GPUInfo{}, nil
+}
+
+// runOrphan serves the read-only provision and statistics objects
+// until ctx is cancelled
+func runOrphan(ctx context.Context, server zbus.Server, cl zbus.Client) error {
+	cap, err := capacity.NewResourceOracle(stubs.NewStorageModuleStub(cl)).Total()
+	if err != nil {
+		return errors.Wrap(err, "failed to get node capacity")
+	}
+
+	orphan := &orphanProvision{}
+	server.Register(
+		zbus.ObjectID{Name: provisionModule, Version: "0.0.1"},
+		zos4pkg.Provision(orphan),
+	)
+
+	server.Register(
+		zbus.ObjectID{Name: statisticsModule, Version: "0.0.1"},
+		&orphanStatistics{total: cap},
+	)
+
+	server.Register(
+		zbus.ObjectID{Name: provisionerObj, Version: "0.0.1"},
+		zos4pkg.Provisioner(orphan),
+	)
+
+	// nothing to provision so the module is booted as soon as the
+	// read-only objects are registered
+	if err := app.MarkBooted(serverName); err != nil {
+		log.Error().Err(err).Msg("failed to mark module as booted")
+	}
+
+	log.Info().Msg("serving read-only provision api for orphan node")
+	if err := server.Run(ctx); err != nil && err != context.Canceled {
+		return err
+	}
+
+	return nil
+}
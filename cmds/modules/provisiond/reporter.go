@@ -3,8 +3,11 @@ package provisiond
 import (
 	"context"
 	"crypto/ed25519"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
@@ -13,9 +16,13 @@ import (
 	"github.com/rs/zerolog/log"
 	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
 	"github.com/threefoldtech/zbus"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/ledger"
+	"github.com/threefoldtech/zos4/pkg/metering"
 	zos4stubs "github.com/threefoldtech/zos4/pkg/stubs"
-	"github.com/threefoldtech/zosbase/pkg"
+	"github.com/threefoldtech/zosbase/pkg/environment"
 	gridtypes "github.com/threefoldtech/zosbase/pkg/gridtypes"
+	"github.com/threefoldtech/zosbase/pkg/provision"
 	"github.com/threefoldtech/zosbase/pkg/rrd"
 	"github.com/threefoldtech/zosbase/pkg/stubs"
 )
@@ -23,10 +30,23 @@ import (
 const (
 	every           = 60 * 60 // 1 hour
 	lastReportedKey = ".last-reported-ts"
+
+	metricsWindow    = 5 * time.Minute
+	metricsRetention = 24 * time.Hour
+	// maxTrafficSamples max number of samples in a single traffic query
+	maxTrafficSamples = 1000
+	// submitTimeout max time ReportNow waits for queued reports to be submitted
+	submitTimeout = 5 * time.Minute
 )
 
 type Report struct {
 	Consumption []substrate.NruConsumption
+	Resources   []zos4pkg.ResourceConsumption
+	// Ledger ids of the report entries
+	Ledger []uint64
+	// More is set if the next queued report is the rest of the same
+	// report, the ledger entries are submitted with the last part
+	More bool
 }
 
 // Reporter structure
@@ -34,17 +54,28 @@ type Reporter struct {
 	cl  zbus.Client
 	rrd rrd.RRD
 
+	// mu protects identity which changes if the node key is rotated
+	mu               sync.Mutex
 	identity         substrate.Identity
 	queue            *dque.DQue
 	registrarGateway *zos4stubs.RegistrarGatewayStub
+	metering         *metering.Watcher
+	storage          provision.Storage
+	ledger           *ledger.Ledger
+
+	// now requests an out of period report, the report error
+	// is sent back on the given channel
+	now chan chan error
 }
 
+var _ zos4pkg.Metering = (*Reporter)(nil)
+
 func reportBuilder() interface{} {
 	return &Report{}
 }
 
 func ReportChecks(metricsPath string) error {
-	rrd, err := rrd.NewRRDBolt(metricsPath, 5*time.Minute, 24*time.Hour)
+	rrd, err := rrd.NewRRDBolt(metricsPath, metricsWindow, metricsRetention)
 	if err != nil {
 		return errors.Wrap(err, "failed to create metrics database")
 	}
@@ -57,7 +88,7 @@ func ReportChecks(metricsPath string) error {
 }
 
 // NewReporter creates a new capacity reporter
-func NewReporter(metricsPath string, cl zbus.Client, root string) (*Reporter, error) {
+func NewReporter(metricsPath string, cl zbus.Client, root string, storage provision.Storage, book *ledger.Ledger) (*Reporter, error) {
 	idMgr := zos4stubs.NewIdentityManagerStub(cl)
 	sk := ed25519.PrivateKey(idMgr.PrivateKey(context.TODO()))
 	id, err := substrate.NewIdentityFromEd25519Key(sk)
@@ -82,17 +113,26 @@ func NewReporter(metricsPath string, cl zbus.Client, root string) (*Reporter, er
 
 	registrarGateway := zos4stubs.NewRegistrarGatewayStub(cl)
 
-	rrd, err := rrd.NewRRDBolt(metricsPath, 5*time.Minute, 24*time.Hour)
+	rrd, err := rrd.NewRRDBolt(metricsPath, metricsWindow, metricsRetention)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create metrics database")
 	}
 
+	env, err := environment.Get()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get node environment")
+	}
+
 	return &Reporter{
 		cl:               cl,
 		rrd:              rrd,
 		identity:         id,
 		queue:            queue,
 		registrarGateway: registrarGateway,
+		metering:         metering.NewWatcher(env.RunningMode),
+		storage:          storage,
+		ledger:           book,
+		now:              make(chan chan error),
 	}, nil
 }
 
@@ -104,14 +144,24 @@ func (r *Reporter) pushOne() error {
 
 	report := item.(*Report)
 
-	log.Info().Int("len", len(report.Consumption)).Msgf("sending capacity report")
+	log.Info().
+		Int("len", len(report.Consumption)).
+		Int("resources", len(report.Resources)).
+		Msgf("sending capacity report")
 
-	hash, err := r.registrarGateway.Report(context.Background(), report.Consumption)
+	hash, err := r.send(report)
 	if err != nil {
-		return errors.Wrap(err, "failed to publish consumption report")
+		if err := r.ledger.Failed(report.Ledger, err); err != nil {
+			log.Error().Err(err).Msg("failed to update consumption ledger")
+		}
+		return err
 	}
 
-	log.Info().Str("hash", hash.Hex()).Msg("report block hash")
+	if !report.More {
+		if err := r.ledger.Submitted(report.Ledger, hash); err != nil {
+			log.Error().Err(err).Msg("failed to update consumption ledger")
+		}
+	}
 
 	// only removed if report is reported to substrate
 	// remove item from queue
@@ -120,6 +170,33 @@ func (r *Reporter) pushOne() error {
 	return err
 }
 
+// send submits a report, and returns the submission hash
+func (r *Reporter) send(report *Report) (string, error) {
+	var hash string
+	if len(report.Consumption) > 0 {
+		// a queued report is sent again until the gateway returns the
+		// result of the queued call, so the ledger entries stay pending
+		// until the report is delivered
+		h, rerr := r.registrarGateway.Report(context.Background(), report.Consumption)
+		if err := rerr.Err(); err != nil {
+			return hash, errors.Wrap(err, "failed to publish consumption report")
+		}
+
+		if h != (types.Hash{}) {
+			hash = h.Hex()
+			log.Info().Str("hash", hash).Msg("report block hash")
+		}
+	}
+
+	if len(report.Resources) > 0 {
+		if err := r.registrarGateway.ReportResources(context.Background(), report.Resources).Err(); err != nil {
+			return hash, errors.Wrap(err, "failed to publish resources consumption report")
+		}
+	}
+
+	return hash, nil
+}
+
 func (r *Reporter) pusher(ctx context.Context) {
 	for {
 		select {
@@ -157,10 +234,15 @@ func (r *Reporter) getVmMetrics(ctx context.Context, slot rrd.Slot) error {
 	}
 
 	for vm, consumption := range metrics {
-		nu := r.computeNU(consumption)
-		log.Debug().Str("vm", vm).Uint64("computed", uint64(nu)).Msgf("consumption: %+v", consumption)
-		if err := slot.Counter(vm, float64(nu)); err != nil {
-			return errors.Wrapf(err, "failed to store metrics for '%s'", vm)
+		log.Debug().Str("vm", vm).Msgf("consumption: %+v", consumption)
+		// the raw counters of each class and direction are stored separately
+		// so the breakdown can be queried later on. Weights are applied to the
+		// increase once it's reported.
+		for _, sample := range metering.Samples(consumption) {
+			key := metering.TrafficKey(vm, sample.Class, sample.Direction, sample.Unit)
+			if err := slot.Counter(key, sample.Value); err != nil {
+				return errors.Wrapf(err, "failed to store metrics for '%s'", key)
+			}
 		}
 	}
 
@@ -177,11 +259,24 @@ func (r *Reporter) getMetrics(ctx context.Context) error {
 		log.Error().Err(err).Msg("failed to get vm public ip consumption")
 	}
 
+	active, err := r.storage.Capacity()
+	if err != nil {
+		return errors.Wrap(err, "failed to list active deployments")
+	}
+
+	if err := r.getReservationMetrics(slot, active.Deployments); err != nil {
+		log.Error().Err(err).Msg("failed to get public ips and gpus consumption")
+	}
+
+	if err := r.getDiskMetrics(ctx, slot, active.Deployments); err != nil {
+		log.Error().Err(err).Msg("failed to get disks io consumption")
+	}
+
 	return nil
 }
 
 func (r *Reporter) metrics(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Minute)
+	ticker := time.NewTicker(metricsWindow)
 	defer ticker.Stop()
 	for {
 		select {
@@ -229,6 +324,7 @@ func (r *Reporter) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	go r.metering.Run(ctx)
 	go r.metrics(ctx)
 	go r.pusher(ctx)
 
@@ -263,23 +359,56 @@ func (r *Reporter) Run(ctx context.Context) error {
 		// wait for delay
 		log.Debug().Int64("duration", delay).Msg("seconds to wait before collecting consumption")
 
+		var reply chan error
 		select {
 		case <-ctx.Done():
 			return nil
 		case <-time.After(time.Duration(delay) * time.Second):
+		case reply = <-r.now:
+			log.Info().Msg("reporting consumption on request")
 		}
 
 		since := time.Unix(lastReport, 0)
 		log.Debug().Time("since", since).Msg("collecting consumption since")
 		ts, err := r.report(ctx, since)
+		if err == nil {
+			err = r.setLastReportTime(ts.Unix())
+		}
+
+		if reply != nil {
+			reply <- err
+		}
+
 		if err != nil {
 			return errors.Wrap(err, "failed to create consumption report")
 		}
+	}
+}
 
-		if err := r.setLastReportTime(ts.Unix()); err != nil {
-			return err
+// ReportNow implements pkg.Metering
+func (r *Reporter) ReportNow() error {
+	reply := make(chan error, 1)
+	select {
+	case r.now <- reply:
+	case <-time.After(submitTimeout):
+		return fmt.Errorf("reporter is not running")
+	}
+
+	if err := <-reply; err != nil {
+		return err
+	}
+
+	// the pusher submits the queued reports in the background
+	deadline := time.After(submitTimeout)
+	for r.queue.Size() > 0 {
+		select {
+		case <-deadline:
+			return fmt.Errorf("timed out waiting for %d queued reports to be submitted", r.queue.Size())
+		case <-time.After(time.Second):
 		}
 	}
+
+	return nil
 }
 
 func (r *Reporter) report(ctx context.Context, since time.Time) (time.Time, error) {
@@ -290,29 +419,67 @@ func (r *Reporter) report(ctx context.Context, since time.Time) (time.Time, erro
 		return now, errors.Wrap(err, "failed to get stored metrics from rrd")
 	}
 
+	config := r.metering.Config()
 	reports := make(map[uint64]substrate.NruConsumption)
+	resources := make(map[uint64]zos4pkg.ResourceConsumption)
 	for key, value := range values {
 		if key == lastReportedKey {
 			continue
 		}
 
-		_, deployment, _, err := gridtypes.WorkloadID(key).Parts()
+		// metrics stored before the per class breakdown
+		// are keyed by workload id only
+		workload, class, counter := key, metering.ClassPublic, ""
+		if wl, cls, _, nu, ok := config.Traffic(key, value); ok {
+			workload, class, value = wl, cls, nu
+		} else if wl, cls, cnt, ok := metering.ParseKey(key); ok {
+			workload, class, counter = wl, cls, cnt
+		}
+
+		_, deployment, _, err := gridtypes.WorkloadID(workload).Parts()
 		if err != nil {
 			log.Error().Err(err).Msgf("failed to parse metric key '%s'", key)
 			continue
 		}
 
-		rep, ok := reports[deployment]
+		if metering.IsTraffic(class) {
+			rep, ok := reports[deployment]
+			if !ok {
+				rep = substrate.NruConsumption{
+					ContractID: types.U64(deployment),
+					Timestamp:  types.U64(now.Unix()),
+					Window:     types.U64(window / time.Second),
+				}
+			}
+
+			rep.NRU += types.U64(value)
+			reports[deployment] = rep
+			continue
+		}
+
+		rep, ok := resources[deployment]
 		if !ok {
-			rep = substrate.NruConsumption{
-				ContractID: types.U64(deployment),
-				Timestamp:  types.U64(now.Unix()),
-				Window:     types.U64(window / time.Second),
+			rep = zos4pkg.ResourceConsumption{
+				ContractID: deployment,
+				Timestamp:  now.Unix(),
+				Window:     uint64(window / time.Second),
 			}
 		}
 
-		rep.NRU += types.U64(value)
-		reports[deployment] = rep
+		switch {
+		case class == metering.ResourcePublicIP:
+			rep.PublicIPSeconds += uint64(value)
+		case class == metering.ResourceGPU:
+			rep.GPUSeconds += uint64(value)
+		case class == metering.ResourceDisk && counter == metering.CounterRead:
+			rep.DiskReadBytes += uint64(value)
+		case class == metering.ResourceDisk && counter == metering.CounterWrite:
+			rep.DiskWriteBytes += uint64(value)
+		default:
+			log.Error().Msgf("unknown metric key '%s'", key)
+			continue
+		}
+		resources[deployment] = rep
 	}
 
 	var report Report
@@ -324,24 +491,193 @@ func (r *Reporter) report(ctx context.Context, since time.Time) (time.Time, erro
 		report.Consumption = append(report.Consumption, v)
 	}
 
+	for _, v := range resources {
+		if v.PublicIPSeconds+v.GPUSeconds+v.DiskReadBytes+v.DiskWriteBytes == 0 {
+			continue
+		}
+		report.Resources = append(report.Resources, v)
+	}
+
+	ids, err := r.ledger.Record(ledgerEntries(since, &report)...)
+	if err != nil {
+		// a missing ledger entry should not block billing
+		log.Error().Err(err).Msg("failed to record report in consumption ledger")
+	}
+	report.Ledger = ids
+
 	return now, r.push(report)
 }
 
+// ledgerEntries builds a ledger entry per contract in the report
+func ledgerEntries(since time.Time, report *Report) []zos4pkg.LedgerEntry {
+	entries := make(map[uint64]*zos4pkg.LedgerEntry)
+	get := func(contract uint64, to int64) *zos4pkg.LedgerEntry {
+		entry, ok := entries[contract]
+		if !ok {
+			entry = &zos4pkg.LedgerEntry{ContractID: contract, From: since.Unix(), To: to}
+			entries[contract] = entry
+		}
+		return entry
+	}
+
+	for _, c := range report.Consumption {
+		get(uint64(c.ContractID), int64(c.Timestamp)).NRU = uint64(c.NRU)
+	}
+
+	for _, c := range report.Resources {
+		entry := get(c.ContractID, c.Timestamp)
+		entry.PublicIPSeconds = c.PublicIPSeconds
+		entry.GPUSeconds = c.GPUSeconds
+		entry.DiskReadBytes = c.DiskReadBytes
+		entry.DiskWriteBytes = c.DiskWriteBytes
+	}
+
+	result := make([]zos4pkg.LedgerEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, *entry)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ContractID < result[j].ContractID
+	})
+
+	return result
+}
+
+// push queues the report. The resources and nru consumption are queued as
+// separate parts, so a part is never sent again once it's delivered.
+// Resources go first so the last part carries the report block hash.
 func (r *Reporter) push(report Report) error {
-	if len(report.Consumption) == 0 {
+	if len(report.Consumption) == 0 && len(report.Resources) == 0 {
 		return nil
 	}
+
+	if len(report.Consumption) > 0 && len(report.Resources) > 0 {
+		resources := Report{Resources: report.Resources, Ledger: report.Ledger, More: true}
+		if err := r.queue.Enqueue(&resources); err != nil {
+			return err
+		}
+		report.Resources = nil
+	}
+
 	return r.queue.Enqueue(&report)
 }
 
-func (r *Reporter) computeNU(m pkg.MachineMetric) gridtypes.Unit {
-	const (
-		// weights knobs for nu calculations
-		public  float64 = 1.0
-		private float64 = 0
-	)
+// twinRecords returns the traffic records of twin workloads out of rrd counters
+func twinRecords(twin uint32, config *metering.Config, counters map[string]float64) []zos4pkg.TrafficRecord {
+	type recordKey struct {
+		workload, class, direction string
+	}
+
+	totals := make(map[recordKey]float64)
+	for key, value := range counters {
+		workload, class, direction, nu, ok := config.Traffic(key, value)
+		if !ok || nu <= 0 {
+			continue
+		}
 
-	nu := m.Public.Nu()*public + m.Private.Nu()*private
+		owner, _, _, err := gridtypes.WorkloadID(workload).Parts()
+		if err != nil || owner != twin {
+			continue
+		}
+
+		totals[recordKey{workload, class, direction}] += nu
+	}
+
+	records := []zos4pkg.TrafficRecord{}
+	for key, nu := range totals {
+		records = append(records, zos4pkg.TrafficRecord{
+			Workload:  gridtypes.WorkloadID(key.workload),
+			Class:     key.class,
+			Direction: key.direction,
+			NU:        uint64(nu),
+		})
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		a, b := &records[i], &records[j]
+		if a.Workload != b.Workload {
+			return a.Workload < b.Workload
+		}
+		if a.Class != b.Class {
+			return a.Class < b.Class
+		}
+		return a.Direction < b.Direction
+	})
+
+	return records
+}
+
+// TwinTraffic implements pkg.Metering
+func (r *Reporter) TwinTraffic(twin uint32, query zos4pkg.TrafficQuery) ([]zos4pkg.TrafficSample, error) {
+	now := time.Now()
+	from := time.Unix(int64(query.From), 0)
+	to := now
+	if query.To != 0 && time.Unix(int64(query.To), 0).Before(now) {
+		to = time.Unix(int64(query.To), 0)
+	}
+
+	if oldest := now.Add(-metricsRetention); from.Before(oldest) {
+		from = oldest
+	}
+
+	if !from.Before(to) {
+		return nil, fmt.Errorf("invalid traffic query window")
+	}
+
+	step := to.Sub(from)
+	if query.Step != 0 {
+		step = time.Duration(query.Step) * time.Second
+		if step < metricsWindow {
+			return nil, fmt.Errorf("step can't be less than %d seconds", metricsWindow/time.Second)
+		}
+	}
+
+	if to.Sub(from)/step > maxTrafficSamples {
+		return nil, fmt.Errorf("too many samples, max is %d", maxTrafficSamples)
+	}
+
+	// Counters returns the increase since a time until now, so the increase
+	// within a sample is the difference between the counters at both its ends
+	end := map[string]float64{}
+	if to.Before(now) {
+		var err error
+		if end, err = r.rrd.Counters(to); err != nil {
+			return nil, errors.Wrap(err, "failed to get stored metrics from rrd")
+		}
+	}
+
+	config := r.metering.Config()
+	var samples []zos4pkg.TrafficSample
+	for start := to; start.After(from); {
+		stop := start
+		start = stop.Add(-step)
+		if start.Before(from) {
+			start = from
+		}
+
+		counters, err := r.rrd.Counters(start)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get stored metrics from rrd")
+		}
+
+		diff := make(map[string]float64, len(counters))
+		for key, value := range counters {
+			diff[key] = value - end[key]
+		}
+		end = counters
+
+		samples = append(samples, zos4pkg.TrafficSample{
+			From:    gridtypes.Timestamp(start.Unix()),
+			To:      gridtypes.Timestamp(stop.Unix()),
+			Records: twinRecords(twin, &config, diff),
+		})
+	}
+
+	// oldest first
+	for i, j := 0, len(samples)-1; i < j; i, j = i+1, j-1 {
+		samples[i], samples[j] = samples[j], samples[i]
+	}
 
-	return gridtypes.Unit(nu)
+	return samples, nil
 }
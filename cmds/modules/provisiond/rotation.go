@@ -0,0 +1,56 @@
+package provisiond
+
+import (
+	"context"
+	"crypto/ed25519"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	zos4stubs "github.com/threefoldtech/zos4/pkg/stubs"
+)
+
+// SetKey switches the reporter and its ledger to the node key sk after the
+// key is rotated
+func (r *Reporter) SetKey(sk ed25519.PrivateKey) error {
+	id, err := substrate.NewIdentityFromEd25519Key(sk)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.identity = id
+	r.mu.Unlock()
+
+	return r.ledger.SetKey(sk)
+}
+
+// watchRotations reloads the node key of the reporter each time the key is
+// rotated. The key is also reloaded each time the stream is opened again,
+// since a rotation can be completed while identityd restarts.
+func watchRotations(ctx context.Context, identity *zos4stubs.IdentityManagerStub, reporter *Reporter) {
+	reload := func() {
+		if err := reporter.SetKey(identity.PrivateKey(ctx)); err != nil {
+			log.Error().Err(err).Msg("failed to reload node key")
+		}
+	}
+
+	for {
+		rotations, err := identity.KeyRotations(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to watch node key rotations")
+		} else {
+			reload()
+			for range rotations {
+				log.Info().Msg("node key rotated, reloading reporter key")
+				reload()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package provisiond
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos4/pkg/metering"
+	zos4stubs "github.com/threefoldtech/zos4/pkg/stubs"
+	gridtypes "github.com/threefoldtech/zosbase/pkg/gridtypes"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes/zos"
+	"github.com/threefoldtech/zosbase/pkg/rrd"
+)
+
+// accumulate adds delta to a counter that is computed by the node
+// (like reserved time) rather than read from the system
+func (r *Reporter) accumulate(slot rrd.Slot, key string, delta float64) error {
+	last, ok, err := r.rrd.Last(key)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		// a counter first value is only used as a base, so
+		// we start from zero to not lose the first delta
+		if err := slot.Counter(key, 0); err != nil {
+			return err
+		}
+	}
+
+	return slot.Counter(key, last+delta)
+}
+
+// getReservationMetrics meters the time public ips and gpus has been
+// reserved by active workloads in the last metrics window
+func (r *Reporter) getReservationMetrics(slot rrd.Slot, deployments []gridtypes.Deployment) error {
+	seconds := metricsWindow.Seconds()
+	for _, deployment := range deployments {
+		for i := range deployment.Workloads {
+			wl := &deployment.Workloads[i]
+			if !wl.Result.State.IsOkay() {
+				continue
+			}
+
+			id, err := gridtypes.NewWorkloadID(deployment.TwinID, deployment.ContractID, wl.Name)
+			if err != nil {
+				return err
+			}
+
+			var key string
+			var delta float64
+			switch wl.Type {
+			case zos.PublicIPType, zos.PublicIPv4Type:
+				key = metering.Key(id.String(), metering.ResourcePublicIP, metering.CounterSeconds)
+				delta = seconds
+			case zos.ZMachineType, zos.ZMachineLightType:
+				gpus, err := workloadGPUs(wl)
+				if err != nil || gpus == 0 {
+					continue
+				}
+				key = metering.Key(id.String(), metering.ResourceGPU, metering.CounterSeconds)
+				delta = seconds * float64(gpus)
+			default:
+				continue
+			}
+
+			if err := r.accumulate(slot, key, delta); err != nil {
+				return errors.Wrapf(err, "failed to store metrics for '%s'", key)
+			}
+		}
+	}
+
+	return nil
+}
+
+func workloadGPUs(wl *gridtypes.Workload) (int, error) {
+	data, err := wl.WorkloadData()
+	if err != nil {
+		return 0, err
+	}
+
+	switch vm := data.(type) {
+	case *zos.ZMachine:
+		return len(vm.GPU), nil
+	case *zos.ZMachineLight:
+		return len(vm.GPU), nil
+	}
+
+	return 0, nil
+}
+
+// getDiskMetrics meters io of volumes and zmounts of active workloads
+func (r *Reporter) getDiskMetrics(ctx context.Context, slot rrd.Slot, deployments []gridtypes.Deployment) error {
+	// only disks of those workloads are metered, other disks (like
+	// vms root fs and cloud-init images) are part of the vm itself
+	disks := make(map[string]struct{})
+	for _, deployment := range deployments {
+		for i := range deployment.Workloads {
+			wl := &deployment.Workloads[i]
+			if wl.Type != zos.ZMountType && wl.Type != zos.VolumeType {
+				continue
+			}
+
+			id, err := gridtypes.NewWorkloadID(deployment.TwinID, deployment.ContractID, wl.Name)
+			if err != nil {
+				return err
+			}
+			disks[id.String()] = struct{}{}
+		}
+	}
+
+	if len(disks) == 0 {
+		return nil
+	}
+
+	counters, err := zos4stubs.NewVMMetricsStub(r.cl).DiskMetrics(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to get disks io")
+	}
+
+	for name, io := range counters {
+		if _, ok := disks[name]; !ok {
+			continue
+		}
+
+		for counter, value := range map[string]uint64{
+			metering.CounterRead:  io.Read,
+			metering.CounterWrite: io.Write,
+		} {
+			key := metering.Key(name, metering.ResourceDisk, counter)
+			if err := slot.Counter(key, float64(value)); err != nil {
+				return errors.Wrapf(err, "failed to store metrics for '%s'", key)
+			}
+		}
+	}
+
+	return nil
+}
@@ -144,6 +144,7 @@ func action(cli *cli.Context) error {
 	}
 
 	server.Register(zbus.ObjectID{Name: "manager", Version: "0.0.1"}, mod)
+	server.Register(zbus.ObjectID{Name: "metrics", Version: "0.0.1"}, &vmMetrics{})
 
 	ctx, _ := utils.WithSignal(context.Background())
 	utils.OnDone(ctx, func(_ error) {
@@ -0,0 +1,211 @@
+package vmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zosbase/pkg/vm"
+)
+
+const (
+	// virtiofsd is the process that serves volumes to vms
+	virtiofsd = "virtiofsd-rs"
+	// metricsTimeout is the max time to get the counters of a single vm
+	metricsTimeout = 5 * time.Second
+)
+
+// vmMetrics implements zos4pkg.VMMetrics
+type vmMetrics struct{}
+
+var _ zos4pkg.VMMetrics = (*vmMetrics)(nil)
+
+// DiskMetrics implements zos4pkg.VMMetrics. Zmount disks io is reported by
+// cloud-hypervisor, volumes are served with virtiofsd so their io is the io
+// of the virtiofsd process serving the volume.
+func (m *vmMetrics) DiskMetrics() (zos4pkg.DiskMetrics, error) {
+	metrics, err := volumesIO()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to collect volumes io")
+		metrics = make(zos4pkg.DiskMetrics)
+	}
+
+	machines, err := vm.FindAll()
+	if err != nil {
+		return metrics, errors.Wrap(err, "failed to list running vms")
+	}
+
+	for name, ps := range machines {
+		sockets, ok := ps.GetParam("--api-socket")
+		if !ok || len(sockets) == 0 {
+			continue
+		}
+
+		disks, err := disksIO(sockets[0])
+		if err != nil {
+			log.Debug().Err(err).Str("vm", name).Msg("failed to get vm disks io")
+			continue
+		}
+
+		for disk, io := range disks {
+			metrics[disk] = io
+		}
+	}
+
+	return metrics, nil
+}
+
+// disksIO returns io counters of all disks attached to a vm, keyed by the
+// disk file name. It uses the cloud-hypervisor api socket of the vm.
+func disksIO(socket string) (zos4pkg.DiskMetrics, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), metricsTimeout)
+	defer cancel()
+
+	cl := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	get := func(path string, v interface{}) error {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://unix/api/v1/"+path, nil)
+		if err != nil {
+			return err
+		}
+
+		response, err := cl.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("got unexpected http code '%s' on %s", response.Status, path)
+		}
+
+		return json.NewDecoder(response.Body).Decode(v)
+	}
+
+	var info struct {
+		Config struct {
+			Disks []struct {
+				ID   string `json:"id"`
+				Path string `json:"path"`
+			} `json:"disks"`
+		} `json:"config"`
+	}
+
+	if err := get("vm.info", &info); err != nil {
+		return nil, errors.Wrap(err, "failed to get vm info")
+	}
+
+	var counters map[string]map[string]uint64
+	if err := get("vm.counters", &counters); err != nil {
+		return nil, errors.Wrap(err, "failed to get vm counters")
+	}
+
+	result := make(zos4pkg.DiskMetrics)
+	for _, disk := range info.Config.Disks {
+		values, ok := counters[disk.ID]
+		if !ok {
+			continue
+		}
+
+		result[filepath.Base(disk.Path)] = zos4pkg.DiskIO{
+			Read:  values["read_bytes"],
+			Write: values["write_bytes"],
+		}
+	}
+
+	return result, nil
+}
+
+// volumesIO returns io counters of all volumes served to vms, keyed by the
+// volume directory name
+func volumesIO() (zos4pkg.DiskMetrics, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(zos4pkg.DiskMetrics)
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+
+		cmdline, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "cmdline"))
+		if err != nil || !bytes.Contains(cmdline, []byte(virtiofsd)) {
+			continue
+		}
+
+		ps := vm.Process{Args: strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00")}
+		shared, ok := ps.GetParam("--shared-dir")
+		if !ok || len(shared) == 0 {
+			continue
+		}
+
+		io, err := processIO(filepath.Join("/proc", entry.Name(), "io"))
+		if err != nil {
+			log.Debug().Err(err).Str("volume", shared[0]).Msg("failed to read volume io")
+			continue
+		}
+
+		// the same volume can be served to a vm more than once
+		name := filepath.Base(shared[0])
+		current := result[name]
+		current.Read += io.Read
+		current.Write += io.Write
+		result[name] = current
+	}
+
+	return result, nil
+}
+
+// processIO parses a /proc/<pid>/io file
+func processIO(path string) (zos4pkg.DiskIO, error) {
+	var io zos4pkg.DiskIO
+
+	file, err := os.Open(path)
+	if err != nil {
+		return io, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch key {
+		case "read_bytes":
+			io.Read = n
+		case "write_bytes":
+			io.Write = n
+		}
+	}
+
+	return io, scanner.Err()
+}
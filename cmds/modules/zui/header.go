@@ -3,16 +3,18 @@ package zui
 import (
 	"context"
 	"fmt"
-	"strings"
 	"syscall"
+	"time"
 	"unsafe"
 
+	"github.com/blang/semver"
 	"github.com/gizak/termui/v3/widgets"
 	"github.com/pkg/errors"
 
 	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/zbus"
-	registrar "github.com/threefoldtech/zos4/pkg/registrar_light"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/maintenance"
 	zos4Stubs "github.com/threefoldtech/zos4/pkg/stubs"
 	"github.com/threefoldtech/zosbase/pkg/app"
 	"github.com/threefoldtech/zosbase/pkg/environment"
@@ -27,8 +29,45 @@ func red(s string) string {
 	return fmt.Sprintf("[%s](fg:red)", s)
 }
 
-func isInProgressError(err error) bool {
-	return strings.Contains(err.Error(), registrar.ErrInProgress.Error())
+func maintenanceStatus(schedule *maintenance.Schedule) string {
+	status := schedule.Status(time.Now())
+	switch {
+	case !status.Configured:
+		return green("no windows")
+	case status.Open:
+		return green(fmt.Sprintf("open until %s", status.Until.Format("Mon 15:04 MST")))
+	case status.Next.IsZero():
+		return red("closed")
+	default:
+		return fmt.Sprintf("[closed, opens %s](fg:yellow)", status.Next.Format("Mon 15:04 MST"))
+	}
+}
+
+// registrationStatus returns the node id if the node is registered, or the
+// registration state otherwise
+func registrationStatus(state zos4pkg.RegistrationState) string {
+	switch state.Status {
+	case zos4pkg.RegistrationDone:
+		return green(fmt.Sprint(state.NodeID))
+	case zos4pkg.RegistrationFailed:
+		status := fmt.Sprintf("%d (unregistered)", state.NodeID)
+		if state.NextRetry != 0 {
+			status += fmt.Sprintf(", retrying at %s", time.Unix(state.NextRetry, 0).Format("15:04:05"))
+		}
+		return red(status)
+	default:
+		return green(fmt.Sprintf("registration in progress (%s)", state.Step))
+	}
+}
+
+// failedRegistration returns the error of a failed registration, or an
+// empty string
+func failedRegistration(state zos4pkg.RegistrationState) string {
+	if state.Status != zos4pkg.RegistrationFailed {
+		return ""
+	}
+
+	return fmt.Sprintf("%s: %s", state.Step, state.Error)
 }
 
 // func headerRenderer(c zbus.Client, h *widgets.Paragraph, r *Flag) error {
@@ -41,6 +80,11 @@ func headerRenderer(ctx context.Context, c zbus.Client, h *widgets.Paragraph, r
 	identity := zos4Stubs.NewIdentityManagerStub(c)
 	registrar := zos4Stubs.NewRegistrarStub(c)
 
+	schedule, err := maintenance.Load()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load some maintenance windows")
+	}
+
 	h.Text = "\n    Fetching realtime node information... please wait."
 
 	s := "          Welcome to [Zero-OS](fg:yellow), [ThreeFold](fg:blue) Autonomous Operating System\n" +
@@ -48,7 +92,7 @@ func headerRenderer(ctx context.Context, c zbus.Client, h *widgets.Paragraph, r
 		" This is node %s (farmer %s)\n" +
 		" running Zero-OS version [%s](fg:blue) (mode [%s](fg:cyan))\n" +
 		" kernel: %s\n" +
-		" cache disk: %s"
+		" cache disk: %s  maintenance: %s"
 
 	host := stubs.NewVersionMonitorStub(c)
 	ch, err := host.Version(ctx)
@@ -56,6 +100,11 @@ func headerRenderer(ctx context.Context, c zbus.Client, h *widgets.Paragraph, r
 		return errors.Wrap(err, "failed to start update stream for version")
 	}
 
+	states, err := registrar.State(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to start update stream for registration state")
+	}
+
 	go func() {
 		registrarLable := "registrar"
 		zui := stubs.NewZUIStub(c)
@@ -66,7 +115,29 @@ func headerRenderer(ctx context.Context, c zbus.Client, h *widgets.Paragraph, r
 		}
 
 		farmID := identity.FarmID(ctx)
-		for version := range ch {
+		state := registrar.CurrentState(ctx)
+		var version semver.Version
+		var received bool
+		var pushed string
+		for {
+			select {
+			case v, ok := <-ch:
+				if !ok {
+					return
+				}
+				version, received = v, true
+			case s, ok := <-states:
+				if !ok {
+					states = nil
+					continue
+				}
+				state = s
+			}
+
+			if !received {
+				continue
+			}
+
 			var name string
 			var nodeID string
 			var farm string
@@ -76,17 +147,16 @@ func headerRenderer(ctx context.Context, c zbus.Client, h *widgets.Paragraph, r
 				farm = green(fmt.Sprintf("%d: %s", farmID, name))
 			}
 
-			if node, err := registrar.NodeID(ctx); err != nil {
-				if isInProgressError(err) {
-					nodeID = green(err.Error())
-				} else {
-					nodeID = red(fmt.Sprintf("%d (unregistered)", node))
-					if zuiErr := zui.PushErrors(ctx, registrarLable, []string{err.Error()}); zuiErr != nil {
-						log.Info().Err(zuiErr).Send()
-					}
+			nodeID = registrationStatus(state)
+			if failure := failedRegistration(state); failure != pushed {
+				errs := []string{}
+				if failure != "" {
+					errs = append(errs, failure)
 				}
-			} else {
-				nodeID = green(fmt.Sprint(node))
+				if zuiErr := zui.PushErrors(ctx, registrarLable, errs); zuiErr != nil {
+					log.Info().Err(zuiErr).Send()
+				}
+				pushed = failure
 			}
 
 			cache := green("OK")
@@ -104,7 +174,7 @@ func headerRenderer(ctx context.Context, c zbus.Client, h *widgets.Paragraph, r
 				uname = green(string(unsafe.Slice((*byte)(unsafe.Pointer(&utsname.Release)), len(utsname.Release))))
 			}
 
-			h.Text = fmt.Sprintf(s, nodeID, farm, version.String(), env.RunningMode.String(), uname, cache)
+			h.Text = fmt.Sprintf(s, nodeID, farm, version.String(), env.RunningMode.String(), uname, cache, maintenanceStatus(&schedule))
 			r.Signal()
 		}
 	}()
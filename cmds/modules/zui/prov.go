@@ -3,11 +3,18 @@ package zui
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
 	ui "github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/zbus"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/reservation"
+	zos4stubs "github.com/threefoldtech/zos4/pkg/stubs"
 	gridtypes "github.com/threefoldtech/zosbase/pkg/gridtypes"
 	"github.com/threefoldtech/zosbase/pkg/stubs"
 )
@@ -16,6 +23,8 @@ const (
 	gig     = 1024 * 1024 * 1024.0
 	mb      = 1024 * 1024.0
 	loading = "Loading..."
+
+	registrarInterval = 10 * time.Second
 )
 
 func resourcesRender(client zbus.Client, grid *ui.Grid, render *signalFlag) error {
@@ -46,14 +55,20 @@ func provRender(client zbus.Client, render *signalFlag, prov *widgets.Table) err
 	prov.RowSeparator = false
 
 	prov.Rows = [][]string{
-		{"", "Total", "Reserved"},
-		{"CRU", loading, loading},
-		{"Memory", loading, loading},
-		{"SSD", loading, loading},
-		{"HDD", loading, loading},
-		{"IPv4", loading, loading},
+		{"", "Total", "Reserved", "System Policy"},
+		{"CRU", loading, loading, ""},
+		{"Memory", loading, loading, ""},
+		{"SSD", loading, loading, ""},
+		{"HDD", loading, loading, ""},
+		{"IPv4", loading, loading, ""},
 	}
 
+	policy, err := reservation.Load()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load reservation policy")
+	}
+	assignPolicy(prov, &policy)
+
 	monitor := stubs.NewStatisticsStub(client)
 
 	total := monitor.Total(context.Background())
@@ -89,6 +104,8 @@ func usageRender(client zbus.Client, render *signalFlag, usage *widgets.Table) e
 	usage.Rows = [][]string{
 		{"CPU", loading},
 		{"Memory", loading},
+		{"Registrar", loading},
+		{"Clock", loading},
 	}
 
 	sysMonitor := stubs.NewSystemMonitorStub(client)
@@ -116,9 +133,92 @@ func usageRender(client zbus.Client, render *signalFlag, usage *widgets.Table) e
 		}
 	}()
 
+	gateway := zos4stubs.NewRegistrarGatewayStub(client)
+	go func() {
+		for {
+			usage.Rows[2][1] = registrarConnectivity(gateway)
+			usage.Rows[3][1] = clockSkew(gateway)
+			render.Signal()
+			<-time.After(registrarInterval)
+		}
+	}()
+
 	return nil
 }
 
+// registrarConnectivity returns the active registrar endpoint, its latency and
+// how many of the configured endpoints are healthy
+func registrarConnectivity(gateway *zos4stubs.RegistrarGatewayStub) string {
+	stats, rerr := gateway.RegistrarStats(context.Background())
+	if rerr.IsError() {
+		return red("unknown")
+	}
+
+	var active zos4pkg.RegistrarEndpointStats
+	healthy := 0
+	for _, endpoint := range stats.Endpoints {
+		if endpoint.URL == stats.Active {
+			active = endpoint
+		}
+		if endpoint.State == zos4pkg.RegistrarEndpointClosed {
+			healthy++
+		}
+	}
+
+	host := stats.Active
+	if u, err := url.Parse(stats.Active); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	status := fmt.Sprintf("%s %dms (%d/%d up)", host, active.Latency, healthy, len(stats.Endpoints))
+	if active.State != zos4pkg.RegistrarEndpointClosed {
+		return red(status)
+	}
+	if healthy != len(stats.Endpoints) {
+		return fmt.Sprintf("[%s](fg:yellow)", status)
+	}
+
+	return green(status)
+}
+
+// clockSkew returns the skew of the node clock against the registrar clock
+func clockSkew(gateway *zos4stubs.RegistrarGatewayStub) string {
+	skew, rerr := gateway.ClockSkew(context.Background())
+	if rerr.IsError() || skew.Checked == 0 {
+		return "unknown"
+	}
+
+	status := fmt.Sprintf("%+.1fs", float64(skew.Skew)/1000)
+	if skew.Blocked {
+		return red(status + " uptime blocked")
+	}
+	if skew.Duration() > zos4pkg.ClockSkewWarning {
+		return fmt.Sprintf("[%s](fg:yellow)", status+" drifting")
+	}
+
+	return green(status)
+}
+
+func assignPolicy(prov *widgets.Table, policy *reservation.Policy) {
+	rows := prov.Rows
+	rows[1][3] = policy.CRU.String(true)
+	if policy.Overcommit != 1 {
+		rows[1][3] += fmt.Sprintf(" x%g overcommit", policy.Overcommit)
+	}
+	rows[2][3] = policy.MRU.String(false)
+	// ssd reservation always includes the cache disk usage
+	sru := []string{"cache"}
+	if rule := policy.SRU.String(false); rule != "none" {
+		sru = append([]string{rule}, sru...)
+	}
+	if policy.Headroom != 0 {
+		sru = append(sru, fmt.Sprintf("%.2f GB", float64(policy.Headroom)/gig))
+	}
+	rows[3][3] = strings.Join(sru, " + ")
+	rows[4][3] = policy.HRU.String(false)
+	rows[5][3] = "-"
+}
+
 func assignTotalResources(prov *widgets.Table, total gridtypes.Capacity) {
 	rows := prov.Rows
 	rows[1][1] = fmt.Sprint(total.CRU)
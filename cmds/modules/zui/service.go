@@ -2,11 +2,13 @@ package zui
 
 import (
 	"context"
+	"fmt"
 
 	ui "github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
 	"github.com/threefoldtech/zbus"
 
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
 	zos4stubs "github.com/threefoldtech/zos4/pkg/stubs"
 	"github.com/threefoldtech/zosbase/pkg/stubs"
 )
@@ -58,6 +60,8 @@ func serviceRender(ctx context.Context, client zbus.Client, grid *ui.Grid, rende
 		type serviceStatus struct {
 			service string
 			status  bool
+			// label overrides the default status label if set
+			label string
 		}
 		servicesStatus := make(chan serviceStatus)
 
@@ -68,7 +72,11 @@ func serviceRender(ctx context.Context, client zbus.Client, grid *ui.Grid, rende
 
 		go func() {
 			getStatisticsStatus(ctx, client)
-			servicesStatus <- serviceStatus{service: statisticsService, status: true}
+			status := serviceStatus{service: statisticsService, status: true}
+			if getProvisionState(ctx, client) == zos4pkg.ProvisionOrphan {
+				status.label = fmt.Sprintf("[%s](fg:yellow)", zos4pkg.ProvisionOrphan)
+			}
+			servicesStatus <- status
 		}()
 
 		go func() {
@@ -96,6 +104,9 @@ func serviceRender(ctx context.Context, client zbus.Client, grid *ui.Grid, rende
 			if service.status {
 				status = green(activeStatus)
 			}
+			if service.label != "" {
+				status = service.label
+			}
 
 			switch service.service {
 			case networkdService:
@@ -123,13 +134,14 @@ func serviceRender(ctx context.Context, client zbus.Client, grid *ui.Grid, rende
 
 func getRegistrarStatus(ctx context.Context, client zbus.Client) string {
 	register := zos4stubs.NewRegistrarStub(client)
-	if _, err := register.NodeID(ctx); err != nil {
-		if isInProgressError(err) {
-			return InProgressStatus
-		}
+	switch register.CurrentState(ctx).Status {
+	case zos4pkg.RegistrationDone:
+		return green(activeStatus)
+	case zos4pkg.RegistrationFailed:
 		return red(FailedStatus)
+	default:
+		return InProgressStatus
 	}
-	return green(activeStatus)
 }
 
 func getNetworkStatus(ctx context.Context, client zbus.Client) bool {
@@ -146,6 +158,11 @@ func getStatisticsStatus(ctx context.Context, client zbus.Client) {
 	statistics.Total(ctx)
 }
 
+func getProvisionState(ctx context.Context, client zbus.Client) zos4pkg.ProvisionState {
+	provision := zos4stubs.NewProvisionStub(client)
+	return provision.Status(ctx)
+}
+
 func getContainerdStatus(ctx context.Context, client zbus.Client) {
 	statistics := stubs.NewContainerModuleStub(client)
 	statistics.ListNS(ctx)
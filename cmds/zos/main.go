@@ -9,6 +9,7 @@ import (
 	"github.com/rs/zerolog/log"
 	apigateway "github.com/threefoldtech/zos4/cmds/modules/api_gateway"
 	"github.com/threefoldtech/zos4/cmds/modules/contd"
+	"github.com/threefoldtech/zos4/cmds/modules/decommission"
 	"github.com/threefoldtech/zos4/cmds/modules/flistd"
 	"github.com/threefoldtech/zos4/cmds/modules/gateway"
 	"github.com/threefoldtech/zos4/cmds/modules/netlightd"
@@ -56,6 +57,7 @@ func main() {
 			&gateway.Module,
 			&powerd.Module,
 			&apigateway.Module,
+			&decommission.Module,
 		},
 		Before: func(c *cli.Context) error {
 			if c.Bool("debug") {
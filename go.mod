@@ -22,7 +22,7 @@ require (
 	github.com/gizak/termui/v3 v3.1.0
 	github.com/go-co-op/gocron v1.33.1 // indirect
 	github.com/go-redis/redis v6.15.9+incompatible
-	github.com/gomodule/redigo v2.0.0+incompatible // indirect
+	github.com/gomodule/redigo v2.0.0+incompatible
 	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/gtank/merlin v0.1.1 // indirect
@@ -51,6 +51,8 @@ require (
 	gopkg.in/yaml.v2 v2.4.0
 )
 
+require go.etcd.io/bbolt v1.3.11
+
 require (
 	github.com/Microsoft/go-winio v0.5.2 // indirect
 	github.com/Microsoft/hcsshim v0.8.25 // indirect
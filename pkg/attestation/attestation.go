@@ -0,0 +1,283 @@
+// Package attestation makes and verifies TPM attestations of the node boot
+// state. An attestation is a quote of the boot PCRs signed by a persistent
+// attestation key (AK) of the TPM. The quote is qualified with a nonce derived
+// from the node public key, the attestation time and the registrar nonce, and
+// the attestation is signed by the node key, so it can't be replayed for
+// another node.
+//
+// The AK is certified with a credential activation: the registrar makes a
+// credential for the node endorsement key (EK) and AK name, which only the
+// TPM holding both keys can activate, and the node sends back the secret with
+// a quote qualified with the registrar nonce. If the registrar does not issue
+// challenges the attestation is unverified: any key can sign a quote, and the
+// vendor and emulated flag are what the node reports.
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+// MaxAge is how far the time of an attestation can be from now to be accepted
+const MaxAge = 10 * time.Minute
+
+// PCRs are the quoted PCRs, they cover the boot chain: firmware (0, 1),
+// option roms (2, 3), boot loader (4, 5), platform (6) and the secure boot
+// policy (7)
+var PCRs = []int{0, 1, 2, 3, 4, 5, 6, 7}
+
+// ErrNoChallenge is returned by a Challenger if the registrar does not
+// issue attestation challenges
+var ErrNoChallenge = errors.New("registrar does not issue attestation challenges")
+
+// Quoter is the TPM of the node
+type Quoter interface {
+	// Keys returns the public parts of the EK and the AK, the AK is
+	// created once and kept in the TPM
+	Keys(ctx context.Context) (zos4Pkg.AttestationKeys, error)
+	// Activate recovers the secret of a credential made for the EK and the AK
+	Activate(ctx context.Context, credential []byte) ([]byte, error)
+	// Quote makes a quote of the sha256 pcrs qualified with nonce with the
+	// AK. The returned attestation has the pcrs, quote and tpm fields set.
+	Quote(ctx context.Context, nonce []byte, pcrs []int) (zos4Pkg.NodeAttestation, error)
+}
+
+// Challenger gets an attestation challenge from the registrar
+type Challenger func(keys zos4Pkg.AttestationKeys) (zos4Pkg.AttestationChallenge, error)
+
+// Nonce returns the qualifying data of a quote made for the node key pk at
+// timestamp for the registrar challenge nonce, which is empty if the AK is
+// not certified
+func Nonce(pk ed25519.PublicKey, timestamp int64, challenge []byte) []byte {
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(timestamp))
+
+	h := sha256.New()
+	h.Write(pk)
+	h.Write(ts[:])
+	h.Write(challenge)
+	return h.Sum(nil)
+}
+
+// signingBytes returns the bytes of the attestation signed by the node key,
+// which is the json encoding of the attestation without signature
+func signingBytes(a zos4Pkg.NodeAttestation) ([]byte, error) {
+	a.Signature = nil
+	return json.Marshal(a)
+}
+
+// Attest makes an attestation of the node with key sk. If challenger is set
+// the AK is certified with the registrar challenge, unless the registrar does
+// not issue challenges.
+func Attest(ctx context.Context, quoter Quoter, sk ed25519.PrivateKey, challenger Challenger) (zos4Pkg.NodeAttestation, error) {
+	var attestation zos4Pkg.NodeAttestation
+
+	keys, err := quoter.Keys(ctx)
+	if err != nil {
+		return attestation, errors.Wrap(err, "failed to get tpm keys")
+	}
+
+	ak, err := parsePublic(keys.AKPublic)
+	if err != nil {
+		return attestation, err
+	}
+
+	var (
+		challenge zos4Pkg.AttestationChallenge
+		secret    []byte
+	)
+	if challenger != nil {
+		challenge, err = challenger(keys)
+		if errors.Is(err, ErrNoChallenge) {
+			log.Info().Msg("registrar does not certify attestation keys, attestation is unverified")
+		} else if err != nil {
+			return attestation, errors.Wrap(err, "failed to get attestation challenge")
+		} else if secret, err = quoter.Activate(ctx, challenge.Credential); err != nil {
+			return attestation, errors.Wrap(err, "failed to activate attestation credential")
+		}
+	}
+
+	timestamp := time.Now().Unix()
+	pk := sk.Public().(ed25519.PublicKey)
+
+	attestation, err = quoter.Quote(ctx, Nonce(pk, timestamp, challenge.Nonce), PCRs)
+	if err != nil {
+		return attestation, errors.Wrap(err, "failed to quote pcrs")
+	}
+	attestation.Timestamp = timestamp
+	attestation.EKPublic = keys.EKPublic
+	if attestation.AKPublic, err = encodePublicKey(ak.key); err != nil {
+		return attestation, err
+	}
+
+	if secret != nil {
+		attestation.AKCertified = true
+		attestation.Nonce = challenge.Nonce
+		attestation.Secret = secret
+	}
+
+	data, err := signingBytes(attestation)
+	if err != nil {
+		return attestation, errors.Wrap(err, "failed to encode attestation")
+	}
+	attestation.Signature = ed25519.Sign(sk, data)
+
+	return attestation, nil
+}
+
+// Verify verifies an attestation made by the node with key pk. It checks
+// the attestation is signed by the node, is recent, and the quote is signed
+// by the AK over the attestation PCR values. It does not prove the AK is in
+// a genuine TPM, a certified attestation must also be checked against the
+// challenge it answers with Challenge.Verify.
+func Verify(a zos4Pkg.NodeAttestation, pk ed25519.PublicKey, now time.Time) error {
+	if a.AKCertified != (len(a.Secret) != 0) || a.AKCertified != (len(a.Nonce) != 0) {
+		return fmt.Errorf("certified attestations must have a nonce and a secret")
+	}
+
+	data, err := signingBytes(a)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode attestation")
+	}
+
+	if !ed25519.Verify(pk, data, a.Signature) {
+		return fmt.Errorf("invalid node signature")
+	}
+
+	if math.Abs(now.Sub(time.Unix(a.Timestamp, 0)).Seconds()) > MaxAge.Seconds() {
+		return fmt.Errorf("attestation is too old")
+	}
+
+	ak, err := parsePublicKey(a.AKPublic)
+	if err != nil {
+		return errors.Wrap(err, "invalid attestation key")
+	}
+
+	digest := sha256.Sum256(a.Quote)
+	if err := rsa.VerifyPKCS1v15(ak, crypto.SHA256, digest[:], a.QuoteSignature); err != nil {
+		return errors.Wrap(err, "invalid quote signature")
+	}
+
+	info, err := parseQuote(a.Quote)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(info.extraData, Nonce(pk, a.Timestamp, a.Nonce)) {
+		return fmt.Errorf("quote is not made for this node")
+	}
+
+	if len(info.selection) != 1 || len(info.selection[algSHA256]) == 0 {
+		return fmt.Errorf("quote must only select sha256 pcrs")
+	}
+
+	selected := info.selection[algSHA256]
+	if len(selected) != len(a.PCRs) {
+		return fmt.Errorf("quoted pcrs do not match attestation pcrs")
+	}
+
+	h := sha256.New()
+	for i, pcr := range a.PCRs {
+		if pcr.Index != selected[i] || len(pcr.Digest) != sha256.Size {
+			return fmt.Errorf("quoted pcrs do not match attestation pcrs")
+		}
+		h.Write(pcr.Digest)
+	}
+
+	if !bytes.Equal(h.Sum(nil), info.pcrDigest) {
+		return fmt.Errorf("pcr values do not match the quote")
+	}
+
+	return nil
+}
+
+// Challenge is the registrar side of an AK certification, it's kept by the
+// registrar until the node sends its next attestation
+type Challenge struct {
+	Nonce  []byte
+	Secret []byte
+	// AK is the attestation key the credential is made for
+	AK *rsa.PublicKey
+}
+
+// NewChallenge makes a challenge for the node keys. The AK must be a
+// restricted signing key fixed to the TPM, and the credential is made for the
+// EK and the AK name, so it can only be activated by the TPM holding both.
+func NewChallenge(keys zos4Pkg.AttestationKeys) (Challenge, zos4Pkg.AttestationChallenge, error) {
+	var (
+		challenge Challenge
+		issued    zos4Pkg.AttestationChallenge
+	)
+
+	ek, err := parsePublicKey(keys.EKPublic)
+	if err != nil {
+		return challenge, issued, errors.Wrap(err, "invalid endorsement key")
+	}
+
+	ak, err := parsePublic(keys.AKPublic)
+	if err != nil {
+		return challenge, issued, err
+	}
+
+	challenge = Challenge{
+		Nonce:  make([]byte, sha256.Size),
+		Secret: make([]byte, secretSize),
+		AK:     ak.key,
+	}
+	if _, err := rand.Read(challenge.Nonce); err != nil {
+		return challenge, issued, err
+	}
+	if _, err := rand.Read(challenge.Secret); err != nil {
+		return challenge, issued, err
+	}
+
+	credential, err := MakeCredential(ek, ak.name, challenge.Secret)
+	if err != nil {
+		return challenge, issued, err
+	}
+
+	issued = zos4Pkg.AttestationChallenge{Nonce: challenge.Nonce, Credential: credential}
+	return challenge, issued, nil
+}
+
+// Verify checks a certified attestation answers the challenge. The
+// attestation itself must be verified with Verify.
+func (c *Challenge) Verify(a zos4Pkg.NodeAttestation) error {
+	if !a.AKCertified {
+		return fmt.Errorf("attestation is not certified")
+	}
+
+	if !bytes.Equal(a.Nonce, c.Nonce) {
+		return fmt.Errorf("attestation is not made for this challenge")
+	}
+
+	if subtle.ConstantTimeCompare(a.Secret, c.Secret) != 1 {
+		return fmt.Errorf("invalid credential secret")
+	}
+
+	ak, err := parsePublicKey(a.AKPublic)
+	if err != nil {
+		return errors.Wrap(err, "invalid attestation key")
+	}
+
+	if !ak.Equal(c.AK) {
+		return fmt.Errorf("attestation is not made with the challenged key")
+	}
+
+	return nil
+}
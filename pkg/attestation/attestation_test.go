@@ -0,0 +1,164 @@
+package attestation
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+func TestAttestation(t *testing.T) {
+	require := require.New(t)
+
+	pk, sk, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	quoter, err := NewSoftware()
+	require.NoError(err)
+	for _, pcr := range PCRs {
+		digest := sha256.Sum256([]byte{byte(pcr)})
+		quoter.PCRs[pcr] = digest[:]
+	}
+
+	attestation, err := Attest(context.Background(), quoter, sk, nil)
+	require.NoError(err)
+	require.Len(attestation.PCRs, len(PCRs))
+
+	now := time.Now()
+	require.NoError(Verify(attestation, pk, now))
+
+	// attestations are only accepted for a while
+	require.Error(Verify(attestation, pk, now.Add(MaxAge+time.Minute)))
+
+	// the attestation is tied to the node key
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+	require.Error(Verify(attestation, other, now))
+
+	// pcr values must match the quote
+	tampered := attestation
+	tampered.PCRs = append([]zos4Pkg.PCRValue{}, attestation.PCRs...)
+	tampered.PCRs[0] = zos4Pkg.PCRValue{Index: 0, Digest: make([]byte, sha256.Size)}
+	require.Error(Verify(tampered, pk, now))
+
+	// a quote made for another node is rejected even if signed by this node
+	copied, err := quoter.Quote(context.Background(), Nonce(other, attestation.Timestamp, nil), PCRs)
+	require.NoError(err)
+	copied.Timestamp = attestation.Timestamp
+	data, err := signingBytes(copied)
+	require.NoError(err)
+	copied.Signature = ed25519.Sign(sk, data)
+	require.ErrorContains(Verify(copied, pk, now), "not made for this node")
+
+	// the node can't claim a certified ak without a challenge
+	certified := attestation
+	certified.AKCertified = true
+	data, err = signingBytes(certified)
+	require.NoError(err)
+	certified.Signature = ed25519.Sign(sk, data)
+	require.ErrorContains(Verify(certified, pk, now), "must have a nonce and a secret")
+}
+
+func TestCertifiedAttestation(t *testing.T) {
+	require := require.New(t)
+
+	pk, sk, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+
+	quoter, err := NewSoftware()
+	require.NoError(err)
+
+	var challenge Challenge
+	challenger := func(keys zos4Pkg.AttestationKeys) (issued zos4Pkg.AttestationChallenge, err error) {
+		challenge, issued, err = NewChallenge(keys)
+		return issued, err
+	}
+
+	attestation, err := Attest(context.Background(), quoter, sk, challenger)
+	require.NoError(err)
+	require.True(attestation.AKCertified)
+	require.Equal(challenge.Nonce, attestation.Nonce)
+
+	require.NoError(Verify(attestation, pk, time.Now()))
+	require.NoError(challenge.Verify(attestation))
+
+	// the secret must be the one of the challenge
+	tampered := attestation
+	tampered.Secret = make([]byte, secretSize)
+	require.Error(challenge.Verify(tampered))
+
+	// a credential made for another tpm can't be activated
+	other, err := NewSoftware()
+	require.NoError(err)
+	keys, err := other.Keys(context.Background())
+	require.NoError(err)
+	_, issued, err := NewChallenge(keys)
+	require.NoError(err)
+	_, err = quoter.Activate(context.Background(), issued.Credential)
+	require.Error(err)
+
+	// without a challenge the attestation is not certified
+	unverified, err := Attest(context.Background(), quoter, sk, func(zos4Pkg.AttestationKeys) (zos4Pkg.AttestationChallenge, error) {
+		return zos4Pkg.AttestationChallenge{}, ErrNoChallenge
+	})
+	require.NoError(err)
+	require.False(unverified.AKCertified)
+	require.NoError(Verify(unverified, pk, time.Now()))
+	require.Error(challenge.Verify(unverified))
+}
+
+func TestParseQuote(t *testing.T) {
+	require := require.New(t)
+
+	nonce := []byte("nonce")
+	digest := sha256.Sum256(nil)
+	info, err := parseQuote(marshalQuote(nonce, []int{0, 7, 16}, digest[:]))
+	require.NoError(err)
+	require.Equal(nonce, info.extraData)
+	require.Equal(map[uint16][]int{algSHA256: {0, 7, 16}}, info.selection)
+	require.Equal(digest[:], info.pcrDigest)
+
+	_, err = parseQuote([]byte("not a quote"))
+	require.Error(err)
+
+	quote := marshalQuote(nonce, []int{0}, digest[:])
+	_, err = parseQuote(quote[:len(quote)-1])
+	require.Error(err)
+}
+
+func TestParseVendor(t *testing.T) {
+	const swtpm = `
+TPM2_PT_FAMILY_INDICATOR:
+  raw: 0x322E3000
+  value: "2.0"
+TPM2_PT_REVISION:
+  raw: 0xA4
+  value: 1.64
+TPM2_PT_MANUFACTURER:
+  raw: 0x49424D00
+  value: "IBM"
+TPM2_PT_VENDOR_STRING_1:
+  raw: 0x53572020
+  value: "SW"
+TPM2_PT_VENDOR_STRING_2:
+  raw: 0x2054504D
+  value: " TPM"
+TPM2_PT_VENDOR_STRING_3:
+  raw: 0x0
+  value: ""
+TPM2_PT_VENDOR_TPM_TYPE:
+  raw: 0x1
+`
+	vendor, err := parseVendor([]byte(swtpm))
+	require.NoError(t, err)
+	require.Equal(t, "SW TPM", vendor)
+
+	vendor, err = parseVendor([]byte("TPM2_PT_MANUFACTURER:\n  raw: 0x494E5443\n  value: \"INTC\"\n"))
+	require.NoError(t, err)
+	require.Equal(t, "INTC", vendor)
+}
@@ -0,0 +1,173 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// credentialMagic and credentialVersion are the header of a credential
+	// blob as written by tpm2_makecredential
+	credentialMagic   = 0xbadcc0de
+	credentialVersion = 1
+
+	// ekSymmetricBits is the aes key size of the default rsa EK template
+	ekSymmetricBits = 128
+	// secretSize of the credentials made by the registrar
+	secretSize = 32
+)
+
+// kdfa is the TPM key derivation function (counter mode hmac sha256)
+func kdfa(key []byte, label string, contextU, contextV []byte, bits int) []byte {
+	var out []byte
+	for counter := uint32(1); len(out)*8 < bits; counter++ {
+		mac := hmac.New(sha256.New, key)
+		_ = binary.Write(mac, binary.BigEndian, counter)
+		mac.Write([]byte(label))
+		mac.Write([]byte{0})
+		mac.Write(contextU)
+		mac.Write(contextV)
+		_ = binary.Write(mac, binary.BigEndian, uint32(bits))
+		out = mac.Sum(out)
+	}
+
+	return out[:bits/8]
+}
+
+// cfb encrypts or decrypts data with aes cfb with a zero iv like the TPM does
+// for credentials
+func cfb(key, data []byte, encrypt bool) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	out := make([]byte, len(data))
+	if encrypt {
+		cipher.NewCFBEncrypter(block, iv).XORKeyStream(out, data)
+	} else {
+		cipher.NewCFBDecrypter(block, iv).XORKeyStream(out, data)
+	}
+
+	return out, nil
+}
+
+func sized(b []byte) []byte {
+	out := make([]byte, 2, 2+len(b))
+	binary.BigEndian.PutUint16(out, uint16(len(b)))
+	return append(out, b...)
+}
+
+func readSized(r io.Reader) ([]byte, error) {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, size)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+// MakeCredential makes a credential blob (TPM2_MakeCredential) that wraps
+// secret for the TPM holding the ek and the key named name. The blob is in
+// the tpm2-tools format so it can be activated with tpm2_activatecredential.
+func MakeCredential(ek *rsa.PublicKey, name, secret []byte) ([]byte, error) {
+	seed := make([]byte, sha256.Size)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+
+	encSeed, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, ek, seed, []byte("IDENTITY\x00"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt credential seed")
+	}
+
+	symKey := kdfa(seed, "STORAGE", name, nil, ekSymmetricBits)
+	encIdentity, err := cfb(symKey, sized(secret), true)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, kdfa(seed, "INTEGRITY", nil, nil, sha256.Size*8))
+	mac.Write(encIdentity)
+	mac.Write(name)
+
+	idObject := append(sized(mac.Sum(nil)), encIdentity...)
+
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, uint32(credentialMagic))
+	_ = binary.Write(&buf, binary.BigEndian, uint32(credentialVersion))
+	buf.Write(sized(idObject))
+	buf.Write(sized(encSeed))
+
+	return buf.Bytes(), nil
+}
+
+// activateCredential recovers the secret of a credential blob made for the
+// ek and the key named name (TPM2_ActivateCredential)
+func activateCredential(ek *rsa.PrivateKey, name, blob []byte) ([]byte, error) {
+	r := bytes.NewReader(blob)
+
+	var magic, version uint32
+	if err := binary.Read(r, binary.BigEndian, &magic); err != nil {
+		return nil, fmt.Errorf("invalid credential")
+	}
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, fmt.Errorf("invalid credential")
+	}
+	if magic != credentialMagic || version != credentialVersion {
+		return nil, fmt.Errorf("unsupported credential format")
+	}
+
+	idObject, err := readSized(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential")
+	}
+	encSeed, err := readSized(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential")
+	}
+
+	seed, err := rsa.DecryptOAEP(sha256.New(), nil, ek, encSeed, []byte("IDENTITY\x00"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt credential seed")
+	}
+
+	ir := bytes.NewReader(idObject)
+	integrity, err := readSized(ir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential")
+	}
+	encIdentity := idObject[len(idObject)-ir.Len():]
+
+	mac := hmac.New(sha256.New, kdfa(seed, "INTEGRITY", nil, nil, sha256.Size*8))
+	mac.Write(encIdentity)
+	mac.Write(name)
+	if !hmac.Equal(mac.Sum(nil), integrity) {
+		return nil, fmt.Errorf("credential is not made for this key")
+	}
+
+	identity, err := cfb(kdfa(seed, "STORAGE", name, nil, ekSymmetricBits), encIdentity, false)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := readSized(bytes.NewReader(identity))
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential")
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,168 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	algRSA    = 0x0001
+	algNull   = 0x0010
+	algRSASSA = 0x0014
+
+	// object attributes of an attestation key
+	attrFixedTPM            = 1 << 1
+	attrFixedParent         = 1 << 4
+	attrSensitiveDataOrigin = 1 << 5
+	attrUserWithAuth        = 1 << 6
+	attrRestricted          = 1 << 16
+	attrDecrypt             = 1 << 17
+	attrSign                = 1 << 18
+
+	akAttributes = attrFixedTPM | attrFixedParent | attrSensitiveDataOrigin | attrUserWithAuth | attrRestricted | attrSign
+
+	defaultExponent = 65537
+)
+
+// akPublic is an attestation key out of its TPMT_PUBLIC area
+type akPublic struct {
+	key *rsa.PublicKey
+	// name is the TPM name of the key, the name algorithm followed by the
+	// hash of the public area
+	name []byte
+}
+
+// parsePublic parses the TPMT_PUBLIC area of an attestation key. Only sha256
+// rsa keys that are restricted signing keys fixed to the TPM are accepted.
+func parsePublic(area []byte) (ak akPublic, err error) {
+	r := bytes.NewReader(area)
+	read := func(v interface{}) {
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, v)
+		}
+	}
+	// sized reads a TPM2B structure
+	sized := func() (b []byte) {
+		var size uint16
+		read(&size)
+		if err != nil {
+			return nil
+		}
+		b = make([]byte, size)
+		_, err = io.ReadFull(r, b)
+		return b
+	}
+
+	var (
+		typ, nameAlg, symmetric, scheme uint16
+		attributes, exponent            uint32
+		bits                            uint16
+	)
+
+	read(&typ)
+	read(&nameAlg)
+	read(&attributes)
+	// auth policy
+	sized()
+	read(&symmetric)
+	if symmetric != algNull {
+		// key bits and mode
+		read(make([]byte, 4))
+	}
+	read(&scheme)
+	if scheme != algNull {
+		// hash algorithm
+		read(make([]byte, 2))
+	}
+	read(&bits)
+	read(&exponent)
+	modulus := sized()
+	if err != nil {
+		return ak, fmt.Errorf("invalid public area")
+	}
+
+	if typ != algRSA || nameAlg != algSHA256 {
+		return ak, fmt.Errorf("attestation key must be a sha256 rsa key")
+	}
+
+	if attributes&akAttributes != akAttributes || attributes&attrDecrypt != 0 {
+		return ak, fmt.Errorf("attestation key must be a restricted signing key fixed to the tpm")
+	}
+
+	if exponent == 0 {
+		exponent = defaultExponent
+	}
+
+	digest := sha256.Sum256(area)
+	ak.name = append([]byte{algSHA256 >> 8, algSHA256 & 0xff}, digest[:]...)
+	ak.key = &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(exponent)}
+
+	return ak, nil
+}
+
+// marshalPublic encodes the TPMT_PUBLIC area of an attestation key made like
+// tpm2_createak does with `-G rsa -g sha256 -s rsassa`
+func marshalPublic(key *rsa.PublicKey) []byte {
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		_ = binary.Write(&buf, binary.BigEndian, v)
+	}
+
+	exponent := uint32(key.E)
+	if exponent == defaultExponent {
+		exponent = 0
+	}
+
+	write(uint16(algRSA))
+	write(uint16(algSHA256))
+	write(uint32(akAttributes))
+	// auth policy
+	write(uint16(0))
+	write(uint16(algNull))
+	write(uint16(algRSASSA))
+	write(uint16(algSHA256))
+	write(uint16(key.N.BitLen()))
+	write(exponent)
+	modulus := key.N.Bytes()
+	write(uint16(len(modulus)))
+	buf.Write(modulus)
+
+	return buf.Bytes()
+}
+
+func encodePublicKey(key interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode public key")
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+func parsePublicKey(data string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("invalid public key")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key must be an rsa key")
+	}
+
+	return rsaKey, nil
+}
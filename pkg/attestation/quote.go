@@ -0,0 +1,99 @@
+package attestation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// tpmGenerated is the magic of structures made by the TPM
+	tpmGenerated = 0xff544347
+	// tpmSTAttestQuote is the type of a quote structure
+	tpmSTAttestQuote = 0x8018
+
+	algSHA256 = 0x000b
+)
+
+// quoteInfo is the part of a TPMS_ATTEST quote structure needed to verify
+// a quote
+type quoteInfo struct {
+	extraData []byte
+	// selection are the quoted pcrs by hash algorithm
+	selection map[uint16][]int
+	pcrDigest []byte
+}
+
+// parseQuote parses a TPMS_ATTEST structure of a quote
+func parseQuote(data []byte) (info quoteInfo, err error) {
+	r := bytes.NewReader(data)
+	read := func(v interface{}) {
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, v)
+		}
+	}
+	// sized reads a TPM2B structure
+	sized := func() (b []byte) {
+		var size uint16
+		read(&size)
+		if err != nil {
+			return nil
+		}
+		b = make([]byte, size)
+		_, err = io.ReadFull(r, b)
+		return b
+	}
+
+	var (
+		magic uint32
+		typ   uint16
+	)
+	read(&magic)
+	read(&typ)
+	if err != nil {
+		return info, fmt.Errorf("invalid quote")
+	}
+	if magic != tpmGenerated || typ != tpmSTAttestQuote {
+		return info, fmt.Errorf("quote is not made by a tpm")
+	}
+
+	// qualified signer
+	sized()
+	info.extraData = sized()
+	// clock info (17 bytes) and firmware version (8 bytes)
+	read(make([]byte, 17+8))
+
+	var count uint32
+	read(&count)
+	if err == nil && count > 16 {
+		return info, fmt.Errorf("invalid quote pcr selection")
+	}
+
+	info.selection = make(map[uint16][]int)
+	for i := uint32(0); i < count && err == nil; i++ {
+		var (
+			alg  uint16
+			size uint8
+		)
+		read(&alg)
+		read(&size)
+		bitmap := make([]byte, size)
+		read(bitmap)
+
+		for n, b := range bitmap {
+			for bit := 0; bit < 8; bit++ {
+				if b&(1<<bit) != 0 {
+					info.selection[alg] = append(info.selection[alg], n*8+bit)
+				}
+			}
+		}
+	}
+
+	info.pcrDigest = sized()
+	if err != nil {
+		return info, fmt.Errorf("invalid quote")
+	}
+
+	return info, nil
+}
@@ -0,0 +1,122 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+// Software makes quotes like a TPM does with a software attestation key, it's
+// meant for tests where no TPM (or swtpm) is available. Its attestations are
+// always marked as emulated.
+type Software struct {
+	ek *rsa.PrivateKey
+	ak *rsa.PrivateKey
+	// PCRs values by index, missing pcrs are zero
+	PCRs map[int][]byte
+}
+
+var _ Quoter = (*Software)(nil)
+
+// NewSoftware creates a software quoter with new endorsement and attestation
+// keys
+func NewSoftware() (*Software, error) {
+	ek, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate endorsement key")
+	}
+
+	ak, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate attestation key")
+	}
+
+	return &Software{ek: ek, ak: ak, PCRs: make(map[int][]byte)}, nil
+}
+
+// Keys implements Quoter
+func (s *Software) Keys(_ context.Context) (keys zos4Pkg.AttestationKeys, err error) {
+	keys.EKPublic, err = encodePublicKey(&s.ek.PublicKey)
+	keys.AKPublic = marshalPublic(&s.ak.PublicKey)
+	return keys, err
+}
+
+// Activate implements Quoter
+func (s *Software) Activate(_ context.Context, credential []byte) ([]byte, error) {
+	ak, err := parsePublic(marshalPublic(&s.ak.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return activateCredential(s.ek, ak.name, credential)
+}
+
+// Quote implements Quoter
+func (s *Software) Quote(_ context.Context, nonce []byte, pcrs []int) (attestation zos4Pkg.NodeAttestation, err error) {
+	h := sha256.New()
+	for _, pcr := range pcrs {
+		digest, ok := s.PCRs[pcr]
+		if !ok {
+			digest = make([]byte, sha256.Size)
+		}
+
+		attestation.PCRs = append(attestation.PCRs, zos4Pkg.PCRValue{Index: pcr, Digest: digest})
+		h.Write(digest)
+	}
+
+	attestation.Quote = marshalQuote(nonce, pcrs, h.Sum(nil))
+	digest := sha256.Sum256(attestation.Quote)
+	attestation.QuoteSignature, err = rsa.SignPKCS1v15(rand.Reader, s.ak, crypto.SHA256, digest[:])
+	if err != nil {
+		return attestation, errors.Wrap(err, "failed to sign quote")
+	}
+
+	attestation.AKPublic, err = encodePublicKey(&s.ak.PublicKey)
+	if err != nil {
+		return attestation, err
+	}
+
+	attestation.Vendor = "software"
+	attestation.Emulated = true
+
+	return attestation, nil
+}
+
+// marshalQuote encodes a TPMS_ATTEST quote structure of sha256 pcrs
+func marshalQuote(nonce []byte, pcrs []int, digest []byte) []byte {
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		_ = binary.Write(&buf, binary.BigEndian, v)
+	}
+	sized := func(b []byte) {
+		write(uint16(len(b)))
+		buf.Write(b)
+	}
+
+	write(uint32(tpmGenerated))
+	write(uint16(tpmSTAttestQuote))
+	// qualified signer
+	sized(nil)
+	sized(nonce)
+	// clock info and firmware version
+	buf.Write(make([]byte, 17+8))
+
+	bitmap := make([]byte, 3)
+	for _, pcr := range pcrs {
+		bitmap[pcr/8] |= 1 << (pcr % 8)
+	}
+	write(uint32(1))
+	write(uint16(algSHA256))
+	write(uint8(len(bitmap)))
+	buf.Write(bitmap)
+	sized(digest)
+
+	return buf.Bytes()
+}
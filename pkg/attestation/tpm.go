@@ -0,0 +1,269 @@
+package attestation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zosbase/pkg/identity/store/tpm"
+	"gopkg.in/yaml.v2"
+)
+
+// TPM makes quotes with the node TPM using tpm2-tools. A software TPM
+// (like swtpm attached to a qemu vm) works the same, its attestations are
+// marked as emulated.
+type TPM struct{}
+
+var _ Quoter = (*TPM)(nil)
+
+// NewTPM creates a TPM quoter
+func NewTPM() *TPM {
+	return &TPM{}
+}
+
+// Available returns true if the node has a TPM
+func Available(ctx context.Context) bool {
+	return tpm.IsTPMEnabled(ctx)
+}
+
+func run(ctx context.Context, name string, arg ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, fmt.Sprintf("tpm2_%s", name), arg...)
+	log.Debug().Msgf("executing command: %s", cmd.String())
+
+	output, err := cmd.Output()
+	if err, ok := err.(*exec.ExitError); ok {
+		return nil, errors.Wrapf(err, "error while running command: (%s)", string(err.Stderr))
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to run tpm")
+	}
+
+	return output, nil
+}
+
+// akHandle is the persistent handle of the attestation key, the AK is
+// created once so it can be certified by the registrar and stays the same
+// between attestations
+const akHandle = "0x81010002"
+
+// tempDir creates a temporary directory, the returned function gives the
+// path of a file in it
+func tempDir() (string, func(name string) string, error) {
+	dir, err := os.MkdirTemp("", "attestation")
+	if err != nil {
+		return "", nil, err
+	}
+
+	return dir, func(name string) string {
+		return filepath.Join(dir, name)
+	}, nil
+}
+
+// createEK loads the endorsement key in the context file ek.ctx, the EK is
+// derived from the TPM endorsement seed so it's always the same key. The
+// public key is written to ek.pem
+func createEK(ctx context.Context, file func(name string) string) error {
+	if _, err := run(ctx, "createek", "-c", file("ek.ctx"), "-G", "rsa", "-u", file("ek.pem"), "-f", "pem"); err != nil {
+		return errors.Wrap(err, "failed to create endorsement key")
+	}
+
+	return nil
+}
+
+// Keys implements Quoter. The AK is created under the EK and made persistent
+// if it does not exist yet.
+func (t *TPM) Keys(ctx context.Context) (keys zos4Pkg.AttestationKeys, err error) {
+	dir, file, err := tempDir()
+	if err != nil {
+		return keys, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := createEK(ctx, file); err != nil {
+		return keys, err
+	}
+
+	// context files are flushed by the resource manager once the commands
+	// exit, only the persistent AK stays loaded
+	if _, err := run(ctx, "readpublic", "-c", akHandle, "-o", file("ak.pub")); err != nil {
+		log.Info().Msg("no persistent attestation key, creating a new one")
+
+		if _, err := run(ctx, "createak",
+			"-C", file("ek.ctx"),
+			"-c", file("ak.ctx"),
+			"-G", "rsa", "-g", "sha256", "-s", "rsassa",
+			"-u", file("ak.pub"),
+		); err != nil {
+			return keys, errors.Wrap(err, "failed to create attestation key")
+		}
+
+		if _, err := run(ctx, "evictcontrol", "-C", "o", "-c", file("ak.ctx"), akHandle); err != nil {
+			return keys, errors.Wrap(err, "failed to persist attestation key")
+		}
+	}
+
+	ek, err := os.ReadFile(file("ek.pem"))
+	if err != nil {
+		return keys, errors.Wrap(err, "failed to read endorsement key")
+	}
+
+	// the public key is a TPM2B_PUBLIC, the AK area is what follows the size
+	public, err := os.ReadFile(file("ak.pub"))
+	if err != nil || len(public) < 2 {
+		return keys, fmt.Errorf("failed to read attestation key")
+	}
+
+	keys.EKPublic = string(ek)
+	keys.AKPublic = public[2:]
+	return keys, nil
+}
+
+// Activate implements Quoter
+func (t *TPM) Activate(ctx context.Context, credential []byte) ([]byte, error) {
+	dir, file, err := tempDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := createEK(ctx, file); err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(file("credential.bin"), credential, 0600); err != nil {
+		return nil, err
+	}
+
+	// the EK is only usable with a policy session for the endorsement hierarchy
+	if _, err := run(ctx, "startauthsession", "--policy-session", "-S", file("session.ctx")); err != nil {
+		return nil, errors.Wrap(err, "failed to start policy session")
+	}
+	defer func() {
+		if _, err := run(ctx, "flushcontext", file("session.ctx")); err != nil {
+			log.Error().Err(err).Msg("failed to flush policy session")
+		}
+	}()
+
+	if _, err := run(ctx, "policysecret", "-S", file("session.ctx"), "-c", "e"); err != nil {
+		return nil, errors.Wrap(err, "failed to satisfy endorsement policy")
+	}
+
+	if _, err := run(ctx, "activatecredential",
+		"-c", akHandle,
+		"-C", file("ek.ctx"),
+		"-i", file("credential.bin"),
+		"-o", file("secret.bin"),
+		"-P", "session:"+file("session.ctx"),
+	); err != nil {
+		return nil, errors.Wrap(err, "failed to activate credential")
+	}
+
+	secret, err := os.ReadFile(file("secret.bin"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read credential secret")
+	}
+
+	return secret, nil
+}
+
+// Quote implements Quoter
+func (t *TPM) Quote(ctx context.Context, nonce []byte, pcrs []int) (attestation zos4Pkg.NodeAttestation, err error) {
+	dir, file, err := tempDir()
+	if err != nil {
+		return attestation, err
+	}
+	defer os.RemoveAll(dir)
+
+	selection := make([]string, 0, len(pcrs))
+	for _, pcr := range pcrs {
+		selection = append(selection, fmt.Sprint(pcr))
+	}
+
+	if _, err := run(ctx, "quote",
+		"-c", akHandle,
+		"-l", "sha256:"+strings.Join(selection, ","),
+		"-q", hex.EncodeToString(nonce),
+		"-g", "sha256",
+		"-m", file("quote.msg"),
+		"-s", file("quote.sig"), "-f", "plain",
+		"-o", file("pcrs.bin"), "-F", "values",
+	); err != nil {
+		return attestation, errors.Wrap(err, "failed to quote pcrs")
+	}
+
+	read := func(name string) []byte {
+		var data []byte
+		if err == nil {
+			data, err = os.ReadFile(file(name))
+		}
+		return data
+	}
+
+	quote, signature, values := read("quote.msg"), read("quote.sig"), read("pcrs.bin")
+	if err != nil {
+		return attestation, errors.Wrap(err, "failed to read quote")
+	}
+
+	if len(values) != len(pcrs)*sha256.Size {
+		return attestation, fmt.Errorf("expected %d pcr values, got %d bytes", len(pcrs), len(values))
+	}
+
+	for i, pcr := range pcrs {
+		attestation.PCRs = append(attestation.PCRs, zos4Pkg.PCRValue{
+			Index:  pcr,
+			Digest: values[i*sha256.Size : (i+1)*sha256.Size],
+		})
+	}
+
+	properties, err := run(ctx, "getcap", "properties-fixed")
+	if err != nil {
+		return attestation, errors.Wrap(err, "failed to get tpm properties")
+	}
+
+	attestation.Vendor, err = parseVendor(properties)
+	if err != nil {
+		return attestation, err
+	}
+
+	// swtpm reports `SW TPM` as vendor
+	attestation.Emulated = strings.HasPrefix(attestation.Vendor, "SW")
+	attestation.Quote = quote
+	attestation.QuoteSignature = signature
+
+	return attestation, nil
+}
+
+// parseVendor returns the vendor out of the tpm fixed properties, it's
+// the vendor strings or the manufacturer if they are not set
+func parseVendor(data []byte) (string, error) {
+	var properties map[string]interface{}
+	if err := yaml.Unmarshal(data, &properties); err != nil {
+		return "", errors.Wrap(err, "invalid tpm properties")
+	}
+
+	value := func(name string) string {
+		property, ok := properties[name].(map[interface{}]interface{})
+		if !ok || property["value"] == nil {
+			return ""
+		}
+		return fmt.Sprint(property["value"])
+	}
+
+	var vendor strings.Builder
+	for i := 1; i <= 4; i++ {
+		vendor.WriteString(value(fmt.Sprintf("TPM2_PT_VENDOR_STRING_%d", i)))
+	}
+
+	if v := strings.Join(strings.Fields(vendor.String()), " "); v != "" {
+		return v, nil
+	}
+
+	return strings.TrimSpace(value("TPM2_PT_MANUFACTURER")), nil
+}
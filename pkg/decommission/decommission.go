@@ -0,0 +1,311 @@
+// Package decommission retires a node from the grid. The decommission runs
+// in steps, each step is persisted once done so an interrupted decommission
+// (for example by a reboot) resumes where it stopped:
+//
+//   - drain: the node stops accepting new deployments
+//   - deployments: running deployments are given a notice period to be
+//     removed by their owners, after which they are deprovisioned
+//   - reports: final uptime and consumption reports are submitted, and
+//     delivered to the registrar
+//   - remove: the node is removed from the registrar
+//   - wipe: the node stops draining (the drain survives reboots) and the
+//     node identity key is destroyed
+package decommission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Flag is set right before the node is removed from the registrar. It
+	// stops the node from registering or reporting uptime again until the
+	// next boot, where the node starts with a new identity.
+	Flag = "decommissioned"
+
+	// StatePath is where the decommission state is kept
+	StatePath = "/var/cache/modules/decommission/state.json"
+
+	// Reason is the reason deployments are deprovisioned with
+	Reason = "node is decommissioned"
+
+	pollEvery = time.Minute
+)
+
+// Step of the decommission
+type Step string
+
+const (
+	StepDrain       Step = "drain"
+	StepDeployments Step = "deployments"
+	StepReports     Step = "reports"
+	StepRemove      Step = "remove"
+	StepWipe        Step = "wipe"
+	StepDone        Step = "done"
+)
+
+var (
+	// ErrNotRunning is returned on abort if there is no decommission to abort
+	ErrNotRunning = fmt.Errorf("no decommission is running")
+	// ErrCanNotAbort is returned on abort once the node is being removed
+	// from the registrar
+	ErrCanNotAbort = fmt.Errorf("node is already being removed, decommission can not be aborted")
+)
+
+// State of a decommission
+type State struct {
+	Step Step `json:"step"`
+	// Started is when the decommission started
+	Started int64 `json:"started"`
+	// Deadline is when running deployments are deprovisioned
+	Deadline int64 `json:"deadline"`
+	// Deprovisioned is set once all deployments are scheduled for deprovision
+	Deprovisioned bool `json:"deprovisioned"`
+}
+
+// Node is what a decommission runs against
+type Node interface {
+	// Drain stops (or resumes) accepting new deployments
+	Drain(ctx context.Context, draining bool) error
+	// ActiveDeployments returns the number of active deployments
+	ActiveDeployments(ctx context.Context) (uint32, error)
+	// DeprovisionAll deprovisions all active deployments with reason
+	DeprovisionAll(ctx context.Context, reason string) error
+	// ReportUptime submits the node uptime
+	ReportUptime(ctx context.Context) error
+	// ReportConsumption submits the consumption since the last report
+	ReportConsumption(ctx context.Context) error
+	// PendingReports returns the number of registrar calls that are queued
+	// to be sent later
+	PendingReports(ctx context.Context) (uint64, error)
+	// RemoveNode removes the node from the registrar, it must succeed if
+	// the node is already removed
+	RemoveNode(ctx context.Context) error
+	// WipeIdentity destroys the node identity key
+	WipeIdentity(ctx context.Context) error
+}
+
+// Decommission runs a node decommission
+type Decommission struct {
+	node Node
+	path string
+	poll time.Duration
+	now  func() time.Time
+}
+
+// New creates a decommission of node, the state is kept at path
+func New(node Node, path string) *Decommission {
+	return &Decommission{
+		node: node,
+		path: path,
+		poll: pollEvery,
+		now:  time.Now,
+	}
+}
+
+// State returns the state of the running decommission, and false if
+// no decommission is running
+func (d *Decommission) State() (State, bool, error) {
+	var state State
+	data, err := os.ReadFile(d.path)
+	if os.IsNotExist(err) {
+		return state, false, nil
+	} else if err != nil {
+		return state, false, errors.Wrap(err, "failed to read decommission state")
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, false, errors.Wrapf(err, "invalid decommission state '%s'", d.path)
+	}
+
+	return state, true, nil
+}
+
+func (d *Decommission) save(state State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		return errors.Wrap(err, "failed to create decommission state directory")
+	}
+
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrap(err, "failed to write decommission state")
+	}
+
+	return errors.Wrap(os.Rename(tmp, d.path), "failed to write decommission state")
+}
+
+// Run starts the decommission, or resumes a running one. Deployments are
+// given notice before they are deprovisioned, a shorter notice than the one
+// of a running decommission moves its deadline earlier. Run blocks until
+// the decommission is done.
+func (d *Decommission) Run(ctx context.Context, notice time.Duration) error {
+	state, ok, err := d.State()
+	if err != nil {
+		return err
+	}
+
+	now := d.now()
+	deadline := now.Add(notice).Unix()
+	if !ok {
+		state = State{Step: StepDrain, Started: now.Unix(), Deadline: deadline}
+	} else if deadline < state.Deadline {
+		state.Deadline = deadline
+	}
+
+	if err := d.save(state); err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("step", string(state.Step)).
+		Time("deadline", time.Unix(state.Deadline, 0)).
+		Msg("running node decommission")
+
+	for state.Step != StepDone {
+		next, err := d.step(ctx, &state)
+		if err != nil {
+			return errors.Wrapf(err, "decommission step '%s' failed", state.Step)
+		}
+
+		log.Info().Str("step", string(state.Step)).Msg("decommission step done")
+		state.Step = next
+		if err := d.save(state); err != nil {
+			return err
+		}
+	}
+
+	// the node has a new identity on next boot, it can be decommissioned again
+	if err := os.Remove(d.path); err != nil {
+		return errors.Wrap(err, "failed to remove decommission state")
+	}
+
+	return nil
+}
+
+// step runs the current step and returns the next one
+func (d *Decommission) step(ctx context.Context, state *State) (Step, error) {
+	switch state.Step {
+	case StepDrain:
+		return StepDeployments, d.node.Drain(ctx, true)
+	case StepDeployments:
+		return StepReports, d.deployments(ctx, state)
+	case StepReports:
+		if err := d.node.ReportUptime(ctx); err != nil {
+			return state.Step, errors.Wrap(err, "failed to report uptime")
+		}
+		if err := d.node.ReportConsumption(ctx); err != nil {
+			return state.Step, errors.Wrap(err, "failed to report consumption")
+		}
+		return StepRemove, d.delivered(ctx)
+	case StepRemove:
+		return StepWipe, d.node.RemoveNode(ctx)
+	case StepWipe:
+		// the node comes back with a new identity, it must accept
+		// deployments again
+		if err := d.node.Drain(ctx, false); err != nil {
+			return state.Step, errors.Wrap(err, "failed to stop draining")
+		}
+		return StepDone, d.node.WipeIdentity(ctx)
+	}
+
+	return state.Step, fmt.Errorf("unknown step '%s'", state.Step)
+}
+
+// delivered waits for queued reports to reach the registrar, the registrar
+// drops reports of a removed node
+func (d *Decommission) delivered(ctx context.Context) error {
+	for {
+		pending, err := d.node.PendingReports(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to get pending reports")
+		}
+
+		if pending == 0 {
+			return nil
+		}
+
+		log.Info().Uint64("pending", pending).Msg("waiting for reports to reach the registrar")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d.poll):
+		}
+	}
+}
+
+// deployments waits for all deployments to be removed, deployments that are
+// still active at the deadline are deprovisioned
+func (d *Decommission) deployments(ctx context.Context, state *State) error {
+	for {
+		count, err := d.node.ActiveDeployments(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to count active deployments")
+		}
+
+		if count == 0 {
+			return nil
+		}
+
+		deadline := time.Unix(state.Deadline, 0)
+		if !state.Deprovisioned && !d.now().Before(deadline) {
+			log.Info().Uint32("deployments", count).Msg("notice period is over, deprovisioning deployments")
+			if err := d.node.DeprovisionAll(ctx, Reason); err != nil {
+				return errors.Wrap(err, "failed to deprovision deployments")
+			}
+
+			state.Deprovisioned = true
+			if err := d.save(*state); err != nil {
+				return err
+			}
+		} else {
+			log.Info().
+				Uint32("deployments", count).
+				Time("deadline", deadline).
+				Msg("waiting for deployments to be removed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d.poll):
+		}
+	}
+}
+
+// Abort stops a running decommission and the node accepts deployments
+// again. A decommission can't be aborted once the node is being removed.
+func (d *Decommission) Abort(ctx context.Context) error {
+	state, ok, err := d.State()
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrNotRunning
+	}
+
+	switch state.Step {
+	case StepDrain, StepDeployments, StepReports:
+	default:
+		return ErrCanNotAbort
+	}
+
+	if err := d.node.Drain(ctx, false); err != nil {
+		return errors.Wrap(err, "failed to stop draining")
+	}
+
+	return errors.Wrap(os.Remove(d.path), "failed to remove decommission state")
+}
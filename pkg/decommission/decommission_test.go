@@ -0,0 +1,188 @@
+package decommission
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeNode struct {
+	calls       []string
+	draining    bool
+	deployments uint32
+	// removeErr fails the node removal once
+	removeErr error
+	// pending reports, one is delivered on each check
+	pending uint64
+}
+
+func (n *fakeNode) Drain(_ context.Context, draining bool) error {
+	n.calls = append(n.calls, fmt.Sprintf("drain(%t)", draining))
+	n.draining = draining
+	return nil
+}
+
+func (n *fakeNode) ActiveDeployments(context.Context) (uint32, error) {
+	// owners remove a deployment on each check
+	count := n.deployments
+	if n.deployments > 0 {
+		n.deployments--
+	}
+	return count, nil
+}
+
+func (n *fakeNode) DeprovisionAll(_ context.Context, reason string) error {
+	n.calls = append(n.calls, "deprovision")
+	n.deployments = 0
+	return nil
+}
+
+func (n *fakeNode) ReportUptime(context.Context) error {
+	n.calls = append(n.calls, "uptime")
+	return nil
+}
+
+func (n *fakeNode) ReportConsumption(context.Context) error {
+	n.calls = append(n.calls, "consumption")
+	return nil
+}
+
+func (n *fakeNode) PendingReports(context.Context) (uint64, error) {
+	count := n.pending
+	if n.pending > 0 {
+		n.pending--
+	} else {
+		n.calls = append(n.calls, "delivered")
+	}
+	return count, nil
+}
+
+func (n *fakeNode) RemoveNode(context.Context) error {
+	if err := n.removeErr; err != nil {
+		n.removeErr = nil
+		return err
+	}
+	n.calls = append(n.calls, "remove")
+	return nil
+}
+
+func (n *fakeNode) WipeIdentity(context.Context) error {
+	n.calls = append(n.calls, "wipe")
+	return nil
+}
+
+func testDecommission(t *testing.T, node Node) *Decommission {
+	d := New(node, filepath.Join(t.TempDir(), "state.json"))
+	d.poll = time.Millisecond
+	return d
+}
+
+func TestDecommission(t *testing.T) {
+	require := require.New(t)
+
+	node := &fakeNode{deployments: 3, pending: 2}
+	d := testDecommission(t, node)
+
+	// deployments are removed by their owners within the notice
+	require.NoError(d.Run(context.Background(), time.Hour))
+	require.Equal([]string{"drain(true)", "uptime", "consumption", "delivered", "remove", "drain(false)", "wipe"}, node.calls)
+
+	_, ok, err := d.State()
+	require.NoError(err)
+	require.False(ok)
+}
+
+func TestDecommissionForce(t *testing.T) {
+	require := require.New(t)
+
+	node := &fakeNode{deployments: 100}
+	d := testDecommission(t, node)
+
+	require.NoError(d.Run(context.Background(), 0))
+	require.Equal([]string{"drain(true)", "deprovision", "uptime", "consumption", "delivered", "remove", "drain(false)", "wipe"}, node.calls)
+}
+
+func TestDecommissionResume(t *testing.T) {
+	require := require.New(t)
+
+	node := &fakeNode{deployments: 100, removeErr: fmt.Errorf("registrar is down")}
+	d := testDecommission(t, node)
+
+	// the notice period is not over yet
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(d.Run(ctx, time.Hour), context.DeadlineExceeded)
+
+	state, ok, err := d.State()
+	require.NoError(err)
+	require.True(ok)
+	require.Equal(StepDeployments, state.Step)
+
+	// a shorter notice moves the deadline
+	require.Error(d.Run(context.Background(), 0))
+	state, _, err = d.State()
+	require.NoError(err)
+	require.Equal(StepRemove, state.Step)
+	require.True(state.Deprovisioned)
+	require.True(node.draining)
+
+	// once removal started the decommission can't be aborted
+	require.ErrorIs(d.Abort(context.Background()), ErrCanNotAbort)
+
+	require.NoError(d.Run(context.Background(), time.Hour))
+	require.False(node.draining)
+	require.Equal([]string{"drain(true)", "deprovision", "uptime", "consumption", "delivered", "remove", "drain(false)", "wipe"}, node.calls)
+}
+
+func TestDecommissionAbort(t *testing.T) {
+	require := require.New(t)
+
+	node := &fakeNode{deployments: 100}
+	d := testDecommission(t, node)
+
+	require.ErrorIs(d.Abort(context.Background()), ErrNotRunning)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.Error(d.Run(ctx, time.Hour))
+	require.True(node.draining)
+
+	require.NoError(d.Abort(context.Background()))
+	require.False(node.draining)
+
+	_, ok, err := d.State()
+	require.NoError(err)
+	require.False(ok)
+}
+
+func TestDecommissioned(t *testing.T) {
+	require := require.New(t)
+
+	root := t.TempDir()
+	statePath := filepath.Join(root, "state.json")
+	flagPath := filepath.Join(root, "decommissioned")
+
+	old, _, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+	new, _, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+
+	require.False(decommissioned(statePath, flagPath, old))
+
+	// removal started but the flag is not written yet
+	d := New(&fakeNode{}, statePath)
+	require.NoError(d.save(State{Step: StepRemove}))
+	require.True(decommissioned(statePath, flagPath, old))
+
+	require.NoError(setFlag(flagPath, old))
+	require.NoError(os.Remove(statePath))
+	// the flag survives reboots until the node has a new identity
+	require.True(decommissioned(statePath, flagPath, old))
+	require.False(decommissioned(statePath, flagPath, new))
+}
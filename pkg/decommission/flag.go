@@ -0,0 +1,78 @@
+package decommission
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos4/pkg/stubs"
+	"github.com/threefoldtech/zosbase/pkg/app"
+)
+
+// FlagPath keeps the public key of the decommissioned node. Unlike Flag it
+// survives reboots, so a node that reboots before its identity is wiped
+// does not register again with the old key.
+const FlagPath = "/var/cache/modules/decommission/decommissioned"
+
+// SetFlag marks the node with key pk as decommissioned
+func SetFlag(pk ed25519.PublicKey) error {
+	if err := setFlag(FlagPath, pk); err != nil {
+		return err
+	}
+
+	return app.SetFlag(Flag)
+}
+
+func setFlag(path string, pk ed25519.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "failed to create decommission flag directory")
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pk, 0644); err != nil {
+		return errors.Wrap(err, "failed to write decommission flag")
+	}
+
+	return errors.Wrap(os.Rename(tmp, path), "failed to write decommission flag")
+}
+
+// Decommissioned returns true if the node with key pk is decommissioned, or
+// is being removed from the registrar. Such node must not register or
+// report uptime. Once the node has a new identity it's not decommissioned
+// anymore.
+func Decommissioned(pk ed25519.PublicKey) bool {
+	return decommissioned(StatePath, FlagPath, pk)
+}
+
+// DecommissionedNode is Decommissioned for the node identity
+func DecommissionedNode(ctx context.Context, identity *stubs.IdentityManagerStub) bool {
+	sk := ed25519.PrivateKey(identity.PrivateKey(ctx))
+	return Decommissioned(sk.Public().(ed25519.PublicKey))
+}
+
+func decommissioned(statePath, flagPath string, pk ed25519.PublicKey) bool {
+	if app.CheckFlag(Flag) {
+		return true
+	}
+
+	state, ok, err := New(nil, statePath).State()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load decommission state")
+	} else if ok && (state.Step == StepRemove || state.Step == StepWipe) {
+		return true
+	}
+
+	flagged, err := os.ReadFile(flagPath)
+	if os.IsNotExist(err) {
+		return false
+	} else if err != nil {
+		log.Error().Err(err).Msg("failed to read decommission flag")
+		return false
+	}
+
+	return bytes.Equal(flagged, pk)
+}
@@ -0,0 +1,88 @@
+package decommission
+
+import (
+	"context"
+	"crypto/ed25519"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/host"
+	"github.com/threefoldtech/zbus"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/stubs"
+)
+
+// zbusNode implements Node over the zos modules
+type zbusNode struct {
+	provision *stubs.ProvisionStub
+	metering  *stubs.MeteringStub
+	gateway   *stubs.RegistrarGatewayStub
+	identity  *stubs.IdentityManagerStub
+}
+
+// NewZbusNode returns a Node that runs the decommission against the
+// running zos modules
+func NewZbusNode(cl zbus.Client) Node {
+	return &zbusNode{
+		provision: stubs.NewProvisionStub(cl),
+		metering:  stubs.NewMeteringStub(cl),
+		gateway:   stubs.NewRegistrarGatewayStub(cl),
+		identity:  stubs.NewIdentityManagerStub(cl),
+	}
+}
+
+func (n *zbusNode) Drain(ctx context.Context, draining bool) error {
+	return n.provision.Drain(ctx, draining)
+}
+
+func (n *zbusNode) ActiveDeployments(ctx context.Context) (uint32, error) {
+	return n.provision.ActiveDeployments(ctx)
+}
+
+func (n *zbusNode) DeprovisionAll(ctx context.Context, reason string) error {
+	return n.provision.DeprovisionAll(ctx, reason)
+}
+
+func (n *zbusNode) ReportUptime(ctx context.Context) error {
+	uptime, err := host.Uptime()
+	if err != nil {
+		return errors.Wrap(err, "failed to get uptime")
+	}
+
+	// a queued report is waited for before the node is removed
+	rerr := n.gateway.UpdateNodeUptimeV2(ctx, uptime, time.Now().Unix())
+	if rerr.IsQueued() {
+		return nil
+	}
+
+	return rerr.Err()
+}
+
+func (n *zbusNode) ReportConsumption(ctx context.Context) error {
+	return n.metering.ReportNow(ctx)
+}
+
+func (n *zbusNode) PendingReports(ctx context.Context) (uint64, error) {
+	stats, rerr := n.gateway.OutboxStats(ctx)
+	return stats.Depth, rerr.Err()
+}
+
+func (n *zbusNode) RemoveNode(ctx context.Context) error {
+	// the flag must be set first, so the node does not register again
+	// right after it's removed
+	sk := ed25519.PrivateKey(n.identity.PrivateKey(ctx))
+	if err := SetFlag(sk.Public().(ed25519.PublicKey)); err != nil {
+		return errors.Wrap(err, "failed to set decommissioned flag")
+	}
+
+	rerr := n.gateway.RemoveNode(ctx)
+	if rerr.IsCode(zos4pkg.RegistrarCodeNotFound) {
+		return nil
+	}
+
+	return rerr.Err()
+}
+
+func (n *zbusNode) WipeIdentity(ctx context.Context) error {
+	return n.identity.Wipe(ctx)
+}
@@ -0,0 +1,194 @@
+// Package events produces node events from the registrar. Events are
+// published on the same redis streams and with the same types as the
+// substrate events stream, so consumers (like provisiond and powerd) work
+// without a chain.
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/stubs"
+	"github.com/threefoldtech/zosbase/pkg"
+	"github.com/threefoldtech/zosbase/pkg/utils"
+)
+
+const (
+	maxStreamLen = 1024
+	bodyTag      = "body"
+
+	// those must match the streams consumed by the zosbase events consumer
+	streamContractCancelled   = "stream:contract-cancelled"
+	streamContractGracePeriod = "stream:contract-lock"
+	streamPublicConfig        = "stream:public-config"
+	streamPowerTargetChange   = "stream:power-target"
+
+	// pollInterval is the wait between polls if there were no new events
+	pollInterval = 10 * time.Second
+)
+
+// ContractStream polls the registrar gateway for state changes of the node
+// contracts and publishes them as contract events. The id of the last
+// published event is persisted so events are not lost or replayed across
+// restarts.
+type ContractStream struct {
+	gw     *stubs.RegistrarGatewayStub
+	node   uint32
+	cursor string
+	pool   *redis.Pool
+}
+
+// NewContractStream creates a new contract events stream for node. cursor is
+// the path of the file where the last published event id is kept.
+func NewContractStream(gw *stubs.RegistrarGatewayStub, address string, node uint32, cursor string) (*ContractStream, error) {
+	pool, err := utils.NewRedisPool(address, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ContractStream{
+		gw:     gw,
+		node:   node,
+		cursor: cursor,
+		pool:   pool,
+	}, nil
+}
+
+func (s *ContractStream) load() (uint64, error) {
+	data, err := os.ReadFile(s.cursor)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func (s *ContractStream) save(cursor uint64) error {
+	if err := os.MkdirAll(filepath.Dir(s.cursor), 0755); err != nil {
+		return err
+	}
+
+	tmp := s.cursor + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(cursor, 10)), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.cursor)
+}
+
+// push publishes event on the redis stream queue
+func push(con redis.Conn, queue string, event interface{}) error {
+	var buffer bytes.Buffer
+	enc := gob.NewEncoder(&buffer)
+	if err := enc.Encode(event); err != nil {
+		return errors.Wrap(err, "failed to encode event")
+	}
+
+	_, err := con.Do("XADD",
+		queue,
+		"MAXLEN", "~", maxStreamLen,
+		"*",
+		bodyTag, buffer.Bytes())
+
+	return err
+}
+
+func (s *ContractStream) publish(event *zos4pkg.ContractEvent) error {
+	con := s.pool.Get()
+	defer con.Close()
+
+	log.Info().
+		Uint64("contract", event.ContractID).
+		Str("kind", string(event.Kind)).
+		Msg("got contract event")
+
+	switch event.Kind {
+	case zos4pkg.ContractEventCancelled:
+		return push(con, streamContractCancelled, pkg.ContractCancelledEvent{
+			Contract: event.ContractID,
+			TwinId:   event.TwinID,
+		})
+	case zos4pkg.ContractEventLocked, zos4pkg.ContractEventUnlocked:
+		return push(con, streamContractGracePeriod, pkg.ContractLockedEvent{
+			Contract: event.ContractID,
+			TwinId:   event.TwinID,
+			Lock:     event.Kind == zos4pkg.ContractEventLocked,
+		})
+	}
+
+	log.Warn().Str("kind", string(event.Kind)).Msg("unknown contract event kind")
+	return nil
+}
+
+// poll publishes events after cursor and returns the new cursor
+func (s *ContractStream) poll(ctx context.Context, cursor uint64) (uint64, int, error) {
+	events, rerr := s.gw.GetNodeContractEvents(ctx, s.node, cursor)
+	if err := rerr.Err(); err != nil {
+		return cursor, 0, errors.Wrap(err, "failed to get contract events")
+	}
+
+	for i := range events {
+		event := &events[i]
+		if err := s.publish(event); err != nil {
+			return cursor, i, errors.Wrapf(err, "failed to publish event '%d'", event.ID)
+		}
+
+		cursor = event.ID
+		if err := s.save(cursor); err != nil {
+			// we still can continue, worst case events are
+			// published again after a restart
+			log.Error().Err(err).Msg("failed to persist contract events cursor")
+		}
+	}
+
+	return cursor, len(events), nil
+}
+
+// Start publishing events, blocks until the context is cancelled
+func (s *ContractStream) Start(ctx context.Context) {
+	cursor, err := s.load()
+	if err != nil {
+		log.Error().Err(err).Str("path", s.cursor).Msg("failed to load contract events cursor, starting from the beginning")
+	}
+
+	log.Info().Uint64("cursor", cursor).Msg("start polling contract events")
+
+	exp := backoff.NewExponentialBackOff()
+	exp.MaxInterval = time.Minute
+	exp.MaxElapsedTime = 0
+
+	for {
+		var count int
+		cursor, count, err = s.poll(ctx, cursor)
+
+		wait := pollInterval
+		if err != nil {
+			wait = exp.NextBackOff()
+			log.Error().Err(err).Str("retry-in", wait.String()).Msg("failed to poll contract events")
+		} else {
+			exp.Reset()
+			if count != 0 {
+				wait = 0
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	"github.com/threefoldtech/zos4/pkg/stubs"
+	"github.com/threefoldtech/zosbase/pkg"
+	"github.com/threefoldtech/zosbase/pkg/utils"
+)
+
+// nodePollInterval is the wait between polls of the farm nodes power
+// targets and the node public config
+const nodePollInterval = time.Minute
+
+// NodeStream polls the registrar gateway for the power targets of the farm
+// nodes and the public config of the node, and publishes changes as power
+// target and public config events. The last seen values are persisted so
+// changes made while the node was down are published on start.
+type NodeStream struct {
+	gw    *stubs.RegistrarGatewayStub
+	farm  pkg.FarmID
+	node  uint32
+	state string
+	pool  *redis.Pool
+}
+
+// nodeState is the last published state
+type nodeState struct {
+	Targets      map[uint32]substrate.Power    `json:"targets"`
+	PublicConfig *substrate.OptionPublicConfig `json:"public_config"`
+}
+
+// NewNodeStream creates a new node events stream for node in farm. state is
+// the path of the file where the last published values are kept.
+func NewNodeStream(gw *stubs.RegistrarGatewayStub, address string, farm pkg.FarmID, node uint32, state string) (*NodeStream, error) {
+	pool, err := utils.NewRedisPool(address, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NodeStream{
+		gw:    gw,
+		farm:  farm,
+		node:  node,
+		state: state,
+		pool:  pool,
+	}, nil
+}
+
+// load returns the last published state, nil if nothing was published yet
+func (s *NodeStream) load() (*nodeState, error) {
+	data, err := os.ReadFile(s.state)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var state nodeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+func (s *NodeStream) save(state *nodeState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.state), 0755); err != nil {
+		return err
+	}
+
+	tmp := s.state + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.state)
+}
+
+// changes returns the events between the last published state and the
+// current one. Nothing is published if there is no last state, the first
+// poll only records the current values.
+func (s *NodeStream) changes(last, current *nodeState) (targets []pkg.PowerTargetChangeEvent, config *pkg.PublicConfigEvent) {
+	if last == nil {
+		return nil, nil
+	}
+
+	for node, target := range current.Targets {
+		if old, ok := last.Targets[node]; ok && old == target {
+			continue
+		}
+
+		targets = append(targets, pkg.PowerTargetChangeEvent{
+			FarmID: s.farm,
+			NodeID: node,
+			Target: target,
+		})
+	}
+
+	if !reflect.DeepEqual(last.PublicConfig, current.PublicConfig) {
+		config = &pkg.PublicConfigEvent{PublicConfig: *current.PublicConfig}
+	}
+
+	return targets, config
+}
+
+// current gets the power targets of the farm nodes and the node public config
+func (s *NodeStream) current(ctx context.Context) (*nodeState, error) {
+	nodes, rerr := s.gw.GetNodes(ctx, uint64(s.farm))
+	if err := rerr.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to list farm nodes")
+	}
+
+	state := nodeState{Targets: make(map[uint32]substrate.Power)}
+	for _, node := range nodes {
+		power, rerr := s.gw.GetNodePowerTarget(ctx, uint32(node))
+		if err := rerr.Err(); err != nil {
+			return nil, errors.Wrapf(err, "failed to get power target of node '%d'", node)
+		}
+
+		state.Targets[uint32(node)] = power.Target
+	}
+
+	config, rerr := s.gw.GetNodePublicConfig(ctx, s.node)
+	if err := rerr.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to get node public config")
+	}
+	state.PublicConfig = &config
+
+	return &state, nil
+}
+
+// poll publishes changes since last and returns the new state
+func (s *NodeStream) poll(ctx context.Context, last *nodeState) (*nodeState, error) {
+	current, err := s.current(ctx)
+	if err != nil {
+		return last, err
+	}
+
+	targets, config := s.changes(last, current)
+
+	con := s.pool.Get()
+	defer con.Close()
+
+	for _, event := range targets {
+		log.Info().Uint32("node", event.NodeID).Bool("up", event.Target.IsUp).Msg("got power target change")
+		if err := push(con, streamPowerTargetChange, event); err != nil {
+			return last, errors.Wrap(err, "failed to publish power target event")
+		}
+	}
+
+	if config != nil {
+		log.Info().Msgf("got a public config update: %+v", config.PublicConfig)
+		if err := push(con, streamPublicConfig, *config); err != nil {
+			return last, errors.Wrap(err, "failed to publish public config event")
+		}
+	}
+
+	if last == nil || len(targets) != 0 || config != nil {
+		if err := s.save(current); err != nil {
+			// worst case events are published again after a restart
+			log.Error().Err(err).Msg("failed to persist node events state")
+		}
+	}
+
+	return current, nil
+}
+
+// Start publishing events, blocks until the context is cancelled
+func (s *NodeStream) Start(ctx context.Context) {
+	last, err := s.load()
+	if err != nil {
+		log.Error().Err(err).Str("path", s.state).Msg("failed to load node events state, changes made while the node was down are not published")
+	}
+
+	exp := backoff.NewExponentialBackOff()
+	exp.MaxInterval = 5 * time.Minute
+	exp.MaxElapsedTime = 0
+
+	for {
+		last, err = s.poll(ctx, last)
+
+		wait := nodePollInterval
+		if err != nil {
+			wait = exp.NextBackOff()
+			log.Error().Err(err).Str("retry-in", wait.String()).Msg("failed to poll node events")
+		} else {
+			exp.Reset()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
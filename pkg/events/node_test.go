@@ -0,0 +1,47 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	"github.com/threefoldtech/zosbase/pkg"
+)
+
+func TestNodeStreamChanges(t *testing.T) {
+	require := require.New(t)
+
+	s := NodeStream{farm: 1, node: 10}
+	up := substrate.Power{IsUp: true}
+	down := substrate.Power{IsDown: true}
+
+	first := &nodeState{
+		Targets:      map[uint32]substrate.Power{10: up, 11: up},
+		PublicConfig: &substrate.OptionPublicConfig{},
+	}
+
+	// nothing is published before the first state is recorded
+	targets, config := s.changes(nil, first)
+	require.Empty(targets)
+	require.Nil(config)
+
+	targets, config = s.changes(first, first)
+	require.Empty(targets)
+	require.Nil(config)
+
+	second := &nodeState{
+		Targets: map[uint32]substrate.Power{10: up, 11: down, 12: up},
+		PublicConfig: &substrate.OptionPublicConfig{
+			HasValue: true,
+			AsValue:  substrate.PublicConfig{IP4: substrate.IP{IP: "185.69.166.10/24", GW: "185.69.166.1"}},
+		},
+	}
+
+	targets, config = s.changes(first, second)
+	require.ElementsMatch([]pkg.PowerTargetChangeEvent{
+		{FarmID: 1, NodeID: 11, Target: down},
+		{FarmID: 1, NodeID: 12, Target: up},
+	}, targets)
+	require.NotNil(config)
+	require.Equal(*second.PublicConfig, config.PublicConfig)
+}
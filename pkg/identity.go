@@ -1,6 +1,10 @@
 package pkg
 
-import "github.com/threefoldtech/zosbase/pkg"
+import (
+	"context"
+
+	"github.com/threefoldtech/zosbase/pkg"
+)
 
 //go:generate mkdir -p stubs
 //go:generate zbusc -module identityd -version 0.0.1 -name manager -package stubs github.com/threefoldtech/zos4/pkg+IdentityManager stubs/identity_stub.go
@@ -26,6 +30,20 @@ func (s Address) String() string {
 	return string(s)
 }
 
+// KeyRotation is a statement that the node identity key (and its twin key)
+// changes from OldKey to NewKey. The statement is signed by both keys, the
+// old key authorizes the rotation and the new key proves the node owns it.
+type KeyRotation struct {
+	TwinID    uint64 `json:"twin_id"`
+	OldKey    []byte `json:"old_key"`
+	NewKey    []byte `json:"new_key"`
+	Timestamp int64  `json:"timestamp"`
+	// OldSignature and NewSignature are the signatures of the rotation
+	// challenge by the old and new keys
+	OldSignature []byte `json:"old_signature"`
+	NewSignature []byte `json:"new_signature"`
+}
+
 // IdentityManager interface.
 type IdentityManager interface {
 	// Store returns the key store kind
@@ -68,4 +86,17 @@ type IdentityManager interface {
 
 	// PrivateKey sends the keypair
 	PrivateKey() []byte
+
+	// Wipe destroys the node key in the key store, the node gets a new
+	// identity on next boot
+	Wipe() error
+
+	// RotateKey replaces the node key with a new one. The new key is set
+	// on the node twin before it's used, a rotation that could not reach
+	// the registrar is completed later.
+	RotateKey() (KeyRotation, error)
+
+	// KeyRotations streams completed key rotations, modules that keep the
+	// node key reload it (PrivateKey) on each rotation
+	KeyRotations(ctx context.Context) <-chan KeyRotation
 }
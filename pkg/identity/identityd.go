@@ -1,7 +1,9 @@
 package identity
 
 import (
+	"context"
 	"net/url"
+	"sync"
 
 	"github.com/rs/zerolog/log"
 
@@ -17,9 +19,18 @@ import (
 )
 
 type identityManager struct {
-	kind string
-	key  identity.KeyPair
-	env  environment.Environment
+	kind      string
+	root      string
+	store     store.Store
+	env       environment.Environment
+	registrar Registrar
+
+	// mu protects key and subscribers
+	mu          sync.RWMutex
+	key         identity.KeyPair
+	subscribers map[chan zos4pkg.KeyRotation]struct{}
+	// rotating serializes key rotations
+	rotating sync.Mutex
 
 	farm string
 }
@@ -31,12 +42,41 @@ type identityManager struct {
 // mode. Right now only the key store uses this flag. In case of debug migrated keys
 // to tpm are not deleted from disks. This allow switching back and forth between tpm
 // and non-tpm key stores.
-func NewManager(root string, debug bool) (zos4pkg.IdentityManager, error) {
+// registrar is used to set the new key on the node twin when the key is rotated.
+func NewManager(root string, debug bool, registrar Registrar) (zos4pkg.IdentityManager, error) {
 	st, err := identity.NewStore(root, !debug)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create key store")
 	}
 	log.Info().Str("kind", st.Kind()).Msg("key store loaded")
+
+	env, err := environment.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	return newManager(root, st, env, registrar)
+}
+
+func newManager(root string, st store.Store, env environment.Environment, registrar Registrar) (*identityManager, error) {
+	pending, err := loadPending(root)
+	if err != nil {
+		// the stored key is kept, if the registrar accepted the rotation the
+		// twin must be recovered manually
+		log.Error().Err(err).Msg("failed to load pending key rotation")
+	} else if pending != nil && pending.Accepted {
+		// the registrar only knows the new key, so it must be stored before
+		// the key is loaded
+		if err := st.Set(pending.key()); err != nil {
+			return nil, errors.Wrap(err, "failed to complete key rotation")
+		}
+		if err := dropPending(root); err != nil {
+			log.Error().Err(err).Msg("failed to drop completed rotation")
+		}
+		pending = nil
+		log.Info().Msg("completed interrupted key rotation")
+	}
+
 	key, err := st.Get()
 	var pair identity.KeyPair
 	if errors.Is(err, store.ErrKeyDoesNotExist) {
@@ -57,16 +97,36 @@ func NewManager(root string, debug bool) (zos4pkg.IdentityManager, error) {
 		pair = identity.KeyPairFromKey(key)
 	}
 
-	env, err := environment.Get()
-	if err != nil {
-		return nil, err
+	m := &identityManager{
+		kind:        st.Kind(),
+		root:        root,
+		store:       st,
+		env:         env,
+		registrar:   registrar,
+		key:         pair,
+		subscribers: make(map[chan zos4pkg.KeyRotation]struct{}),
+	}
+
+	if pending != nil {
+		go m.resume(context.Background())
 	}
 
-	return &identityManager{
-		kind: st.Kind(),
-		key:  pair,
-		env:  env,
-	}, nil
+	return m, nil
+}
+
+// pair returns the current key pair of the node
+func (d *identityManager) pair() identity.KeyPair {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.key
+}
+
+// Wipe destroys the node key in the key store. The loaded key is still
+// used until the node reboots, a new key is generated on next boot.
+func (d *identityManager) Wipe() error {
+	log.Warn().Str("kind", d.kind).Msg("wiping node identity key")
+	return errors.Wrap(d.store.Annihilate(), "failed to wipe key store")
 }
 
 // StoreKind returns store kind
@@ -76,7 +136,7 @@ func (d *identityManager) StoreKind() string {
 
 // NodeID returns the node identity
 func (d *identityManager) NodeID() zos4pkg.StrIdentifier {
-	return zos4pkg.StrIdentifier(d.key.Identity())
+	return zos4pkg.StrIdentifier(d.pair().Identity())
 }
 
 func (d *identityManager) Farm() (name string, err error) {
@@ -112,36 +172,36 @@ func (d *identityManager) FarmSecret() string {
 
 // Sign signs the message with privateKey and returns a signature.
 func (d *identityManager) Sign(message []byte) ([]byte, error) {
-	return crypto.Sign(d.key.PrivateKey, message)
+	return crypto.Sign(d.pair().PrivateKey, message)
 }
 
 // Verify reports whether sig is a valid signature of message by publicKey.
 func (d *identityManager) Verify(message, sig []byte) error {
-	return crypto.Verify(d.key.PublicKey, message, sig)
+	return crypto.Verify(d.pair().PublicKey, message, sig)
 }
 
 // Encrypt encrypts message with the public key of the node
 func (d *identityManager) Encrypt(message []byte) ([]byte, error) {
-	return crypto.Encrypt(message, d.key.PublicKey)
+	return crypto.Encrypt(message, d.pair().PublicKey)
 }
 
 // Decrypt decrypts message with the private of the node
 func (d *identityManager) Decrypt(message []byte) ([]byte, error) {
-	return crypto.Decrypt(message, d.key.PrivateKey)
+	return crypto.Decrypt(message, d.pair().PrivateKey)
 }
 
 // EncryptECDH encrypt msg using AES with shared key derived from private key of the node and public key of the other party using Elliptic curve Diffie Helman algorithm
 // the nonce if prepended to the encrypted message
 func (d *identityManager) EncryptECDH(msg []byte, pk []byte) ([]byte, error) {
-	return crypto.EncryptECDH(msg, d.key.PrivateKey, pk)
+	return crypto.EncryptECDH(msg, d.pair().PrivateKey, pk)
 }
 
 // DecryptECDH decrypt AES encrypted msg using a shared key derived from private key of the node and public key of the other party using Elliptic curve Diffie Helman algorithm
 func (d *identityManager) DecryptECDH(msg []byte, pk []byte) ([]byte, error) {
-	return crypto.DecryptECDH(msg, d.key.PrivateKey, pk)
+	return crypto.DecryptECDH(msg, d.pair().PrivateKey, pk)
 }
 
 // PrivateKey returns the private key of the node
 func (d *identityManager) PrivateKey() []byte {
-	return d.key.PrivateKey
+	return d.pair().PrivateKey
 }
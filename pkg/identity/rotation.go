@@ -0,0 +1,355 @@
+package identity
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zosbase/pkg/crypto"
+	"github.com/threefoldtech/zosbase/pkg/identity"
+)
+
+const (
+	// rotationFile keeps an unfinished rotation in the manager root
+	rotationFile    = "rotation.json"
+	rotationTimeout = 2 * time.Minute
+)
+
+// ErrRotationPending is returned by RotateKey if the registrar could not be
+// reached. The rotation is kept and completed once the registrar is back.
+var ErrRotationPending = fmt.Errorf("key rotation is pending")
+
+// Registrar sets the node twin key on the registrar, it's implemented by
+// the registrar gateway stub
+type Registrar interface {
+	GetTwinByPubKey(ctx context.Context, pk []byte) (uint64, zos4pkg.RegistrarError)
+	RotateTwinKey(ctx context.Context, rotation zos4pkg.KeyRotation) zos4pkg.RegistrarError
+}
+
+// RotationChallenge returns the message signed by both keys of a rotation
+func RotationChallenge(r zos4pkg.KeyRotation) []byte {
+	return []byte(fmt.Sprintf("rotate:%d:%x:%x:%d", r.TwinID, r.OldKey, r.NewKey, r.Timestamp))
+}
+
+// NewRotation makes a rotation statement of twin from key old to new
+func NewRotation(twin uint64, old, new ed25519.PrivateKey) (zos4pkg.KeyRotation, error) {
+	rotation := zos4pkg.KeyRotation{
+		TwinID:    twin,
+		OldKey:    old.Public().(ed25519.PublicKey),
+		NewKey:    new.Public().(ed25519.PublicKey),
+		Timestamp: time.Now().Unix(),
+	}
+
+	challenge := RotationChallenge(rotation)
+	var err error
+	if rotation.OldSignature, err = crypto.Sign(old, challenge); err != nil {
+		return rotation, errors.Wrap(err, "failed to sign rotation with old key")
+	}
+	if rotation.NewSignature, err = crypto.Sign(new, challenge); err != nil {
+		return rotation, errors.Wrap(err, "failed to sign rotation with new key")
+	}
+
+	return rotation, nil
+}
+
+// VerifyRotation verifies both signatures of a rotation
+func VerifyRotation(r zos4pkg.KeyRotation) error {
+	if len(r.OldKey) != ed25519.PublicKeySize || len(r.NewKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid rotation keys")
+	}
+
+	challenge := RotationChallenge(r)
+	if err := crypto.Verify(r.OldKey, challenge, r.OldSignature); err != nil {
+		return errors.Wrap(err, "invalid old key signature")
+	}
+	if err := crypto.Verify(r.NewKey, challenge, r.NewSignature); err != nil {
+		return errors.Wrap(err, "invalid new key signature")
+	}
+
+	return nil
+}
+
+// pendingRotation is a rotation that is not done yet. It's written before
+// the registrar is called so the new key is never lost: if the node
+// restarts, the rotation is completed on next start.
+type pendingRotation struct {
+	Seed     []byte              `json:"seed"`
+	Rotation zos4pkg.KeyRotation `json:"rotation"`
+	// Accepted is set once the registrar has the new key, from there on
+	// only the new key can be used
+	Accepted bool `json:"accepted"`
+}
+
+func (p *pendingRotation) key() ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(p.Seed)
+}
+
+// loadPending loads the pending rotation in root, nil if there is none
+func loadPending(root string) (*pendingRotation, error) {
+	data, err := os.ReadFile(filepath.Join(root, rotationFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read pending rotation")
+	}
+
+	var pending pendingRotation
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, errors.Wrap(err, "failed to load pending rotation")
+	}
+
+	if len(pending.Seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid pending rotation seed")
+	}
+
+	return &pending, nil
+}
+
+func savePending(root string, pending *pendingRotation) error {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(root, rotationFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "failed to write pending rotation")
+	}
+
+	return errors.Wrap(os.Rename(tmp, path), "failed to write pending rotation")
+}
+
+func dropPending(root string) error {
+	err := os.Remove(filepath.Join(root, rotationFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// RotateKey implements pkg.IdentityManager
+func (d *identityManager) RotateKey() (zos4pkg.KeyRotation, error) {
+	d.rotating.Lock()
+	defer d.rotating.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), rotationTimeout)
+	defer cancel()
+
+	pending, err := loadPending(d.root)
+	if err != nil {
+		return zos4pkg.KeyRotation{}, err
+	}
+
+	if pending == nil {
+		pending, err = d.newRotation(ctx)
+		if err != nil {
+			return zos4pkg.KeyRotation{}, err
+		}
+	}
+
+	return pending.Rotation, d.rotate(ctx, pending)
+}
+
+// newRotation generates a new key and a rotation to it
+func (d *identityManager) newRotation(ctx context.Context) (*pendingRotation, error) {
+	current := d.pair()
+
+	var twin uint64
+	rerr := registrarCall(func() (rerr zos4pkg.RegistrarError) {
+		twin, rerr = d.registrar.GetTwinByPubKey(ctx, current.PublicKey)
+		return
+	})
+	if err := rerr.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to get node twin")
+	}
+
+	pair, err := identity.GenerateKeyPair()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate key pair")
+	}
+
+	rotation, err := NewRotation(twin, current.PrivateKey, pair.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := &pendingRotation{
+		Seed:     pair.PrivateKey.Seed(),
+		Rotation: rotation,
+	}
+
+	return pending, savePending(d.root, pending)
+}
+
+// rotate sets the new key of a pending rotation on the registrar then
+// switches to it
+func (d *identityManager) rotate(ctx context.Context, pending *pendingRotation) error {
+	if !pending.Accepted {
+		rerr := registrarCall(func() zos4pkg.RegistrarError {
+			return d.registrar.RotateTwinKey(ctx, pending.Rotation)
+		})
+
+		if rerr.IsCode(zos4pkg.RegistrarCodeUnavailable, zos4pkg.RegistrarCodeRateLimited) {
+			return errors.Wrap(ErrRotationPending, rerr.Message)
+		} else if err := rerr.Err(); err != nil {
+			// a previous attempt could have been applied without the node
+			// getting the response, in that case the retry is rejected
+			// since the twin key is not the old key anymore. The seed is
+			// only dropped once the registrar confirms it doesn't have it.
+			holds, cerr := d.holdsKey(ctx, pending.Rotation)
+			if cerr != nil {
+				return errors.Wrapf(ErrRotationPending, "failed to check rotation after rejection (%s): %s", err, cerr)
+			}
+
+			if !holds {
+				// the registrar refused the new key, the old key stays in use
+				if err := dropPending(d.root); err != nil {
+					log.Error().Err(err).Msg("failed to drop rejected rotation")
+				}
+				return errors.Wrap(err, "registrar rejected key rotation")
+			}
+
+			log.Info().Msg("registrar already has the rotated key")
+		}
+
+		pending.Accepted = true
+		if err := savePending(d.root, pending); err != nil {
+			return err
+		}
+	}
+
+	return d.switchKey(pending)
+}
+
+// holdsKey checks if the rotation twin already has the new key on the
+// registrar
+func (d *identityManager) holdsKey(ctx context.Context, rotation zos4pkg.KeyRotation) (bool, error) {
+	var twin uint64
+	rerr := registrarCall(func() (rerr zos4pkg.RegistrarError) {
+		twin, rerr = d.registrar.GetTwinByPubKey(ctx, rotation.NewKey)
+		return
+	})
+
+	if rerr.IsCode(zos4pkg.RegistrarCodeNotFound) {
+		return false, nil
+	} else if err := rerr.Err(); err != nil {
+		return false, err
+	}
+
+	return twin == rotation.TwinID, nil
+}
+
+// switchKey stores the key of an accepted rotation and starts using it
+func (d *identityManager) switchKey(pending *pendingRotation) error {
+	pair := identity.KeyPairFromKey(pending.key())
+	if err := d.store.Set(pair.PrivateKey); err != nil {
+		return errors.Wrap(err, "failed to persist rotated key")
+	}
+
+	d.mu.Lock()
+	d.key = pair
+	d.mu.Unlock()
+
+	if err := dropPending(d.root); err != nil {
+		log.Error().Err(err).Msg("failed to drop completed rotation")
+	}
+
+	log.Info().
+		Uint64("twin", pending.Rotation.TwinID).
+		Str("identity", pair.Identity()).
+		Msg("node key rotated")
+
+	d.notify(pending.Rotation)
+	return nil
+}
+
+// resume completes a rotation that could not reach the registrar
+func (d *identityManager) resume(ctx context.Context) {
+	exp := backoff.NewExponentialBackOff()
+	exp.MaxInterval = 10 * time.Minute
+	exp.MaxElapsedTime = 0
+
+	_ = backoff.RetryNotify(func() error {
+		d.rotating.Lock()
+		defer d.rotating.Unlock()
+
+		pending, err := loadPending(d.root)
+		if err != nil {
+			return backoff.Permanent(err)
+		} else if pending == nil {
+			// completed with a call to RotateKey
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, rotationTimeout)
+		defer cancel()
+
+		err = d.rotate(ctx, pending)
+		if err != nil && !errors.Is(err, ErrRotationPending) {
+			log.Error().Err(err).Msg("failed to complete key rotation")
+			return backoff.Permanent(err)
+		}
+
+		return err
+	}, backoff.WithContext(exp, ctx), func(err error, d time.Duration) {
+		log.Debug().Err(err).Str("sleep", d.String()).Msg("key rotation still pending")
+	})
+}
+
+// KeyRotations implements pkg.IdentityManager
+func (d *identityManager) KeyRotations(ctx context.Context) <-chan zos4pkg.KeyRotation {
+	ch := make(chan zos4pkg.KeyRotation, 1)
+
+	d.mu.Lock()
+	d.subscribers[ch] = struct{}{}
+	d.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		d.mu.Lock()
+		delete(d.subscribers, ch)
+		d.mu.Unlock()
+		close(ch)
+	}()
+
+	return ch
+}
+
+func (d *identityManager) notify(rotation zos4pkg.KeyRotation) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for ch := range d.subscribers {
+		select {
+		case ch <- rotation:
+		default:
+			log.Warn().Msg("key rotation subscriber is lagging")
+		}
+	}
+}
+
+// registrarCall runs fn and reports a failure to reach the gateway as the
+// registrar being unavailable, the gateway stub panics if the call can't
+// be delivered over zbus (the gateway is started after identityd)
+func registrarCall(fn func() zos4pkg.RegistrarError) (rerr zos4pkg.RegistrarError) {
+	defer func() {
+		if r := recover(); r != nil {
+			rerr = zos4pkg.RegistrarError{
+				Code:    zos4pkg.RegistrarCodeUnavailable,
+				Message: fmt.Sprint(r),
+			}
+		}
+	}()
+
+	return fn()
+}
@@ -0,0 +1,226 @@
+package identity
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zosbase/pkg/environment"
+	"github.com/threefoldtech/zosbase/pkg/identity/store"
+)
+
+type memStore struct {
+	key ed25519.PrivateKey
+}
+
+func (s *memStore) Get() (ed25519.PrivateKey, error) {
+	if s.key == nil {
+		return nil, store.ErrKeyDoesNotExist
+	}
+	return s.key, nil
+}
+
+func (s *memStore) Set(key ed25519.PrivateKey) error {
+	s.key = key
+	return nil
+}
+
+func (s *memStore) Exists() (bool, error) {
+	return s.key != nil, nil
+}
+
+func (s *memStore) Annihilate() error {
+	s.key = nil
+	return nil
+}
+
+func (s *memStore) Kind() string {
+	return "memory"
+}
+
+// testRegistrar keeps the key of a single twin
+type testRegistrar struct {
+	twin uint64
+	key  []byte
+	code zos4pkg.RegistrarErrorCode
+	// lost applies the rotation but the node doesn't get the response
+	lost bool
+}
+
+func (r *testRegistrar) GetTwinByPubKey(_ context.Context, pk []byte) (uint64, zos4pkg.RegistrarError) {
+	if string(pk) != string(r.key) {
+		return 0, zos4pkg.RegistrarError{Code: zos4pkg.RegistrarCodeNotFound, Message: "twin not found"}
+	}
+	return r.twin, zos4pkg.RegistrarError{}
+}
+
+func (r *testRegistrar) RotateTwinKey(_ context.Context, rotation zos4pkg.KeyRotation) zos4pkg.RegistrarError {
+	if r.code != zos4pkg.RegistrarCodeNoError {
+		return zos4pkg.RegistrarError{Code: r.code, Message: r.code.String()}
+	}
+	if err := VerifyRotation(rotation); err != nil {
+		return zos4pkg.RegistrarError{Code: zos4pkg.RegistrarCodeUnauthorized, Message: err.Error()}
+	}
+	if rotation.TwinID != r.twin || string(rotation.OldKey) != string(r.key) {
+		return zos4pkg.RegistrarError{Code: zos4pkg.RegistrarCodeUnauthorized, Message: "not the twin key"}
+	}
+
+	r.key = rotation.NewKey
+	if r.lost {
+		return zos4pkg.RegistrarError{Code: zos4pkg.RegistrarCodeUnavailable, Message: "timeout"}
+	}
+	return zos4pkg.RegistrarError{}
+}
+
+func testManager(t *testing.T, root string, st store.Store, registrar *testRegistrar) *identityManager {
+	mgr, err := newManager(root, st, environment.Environment{}, registrar)
+	require.NoError(t, err)
+
+	if registrar.key == nil {
+		registrar.key = mgr.pair().PublicKey
+	}
+
+	return mgr
+}
+
+func TestRotationVerify(t *testing.T) {
+	require := require.New(t)
+
+	_, old, _ := ed25519.GenerateKey(nil)
+	_, new, _ := ed25519.GenerateKey(nil)
+
+	rotation, err := NewRotation(10, old, new)
+	require.NoError(err)
+	require.NoError(VerifyRotation(rotation))
+
+	tampered := rotation
+	tampered.TwinID = 11
+	require.Error(VerifyRotation(tampered))
+
+	// the new key must prove it's owned by the node
+	_, other, _ := ed25519.GenerateKey(nil)
+	forged := rotation
+	forged.NewKey = other.Public().(ed25519.PublicKey)
+	require.Error(VerifyRotation(forged))
+}
+
+func TestRotateKey(t *testing.T) {
+	require := require.New(t)
+
+	st := &memStore{}
+	registrar := &testRegistrar{twin: 10}
+	mgr := testManager(t, t.TempDir(), st, registrar)
+	old := mgr.pair()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rotations := mgr.KeyRotations(ctx)
+
+	rotation, err := mgr.RotateKey()
+	require.NoError(err)
+	require.EqualValues(10, rotation.TwinID)
+	require.Equal([]byte(old.PublicKey), rotation.OldKey)
+
+	current := mgr.pair()
+	require.Equal([]byte(current.PublicKey), rotation.NewKey)
+	require.Equal(current.PrivateKey, st.key)
+	require.Equal(rotation.NewKey, registrar.key)
+	require.Equal(rotation, <-rotations)
+
+	pending, err := loadPending(mgr.root)
+	require.NoError(err)
+	require.Nil(pending)
+}
+
+func TestRotateKeyPending(t *testing.T) {
+	require := require.New(t)
+
+	root := t.TempDir()
+	st := &memStore{}
+	registrar := &testRegistrar{twin: 10}
+	mgr := testManager(t, root, st, registrar)
+	old := mgr.pair()
+
+	registrar.code = zos4pkg.RegistrarCodeUnavailable
+	rotation, err := mgr.RotateKey()
+	require.ErrorIs(err, ErrRotationPending)
+
+	// the old key is used until the registrar accepts the rotation
+	require.Equal(old, mgr.pair())
+	require.Equal(old.PrivateKey, st.key)
+
+	// the same rotation is sent again
+	registrar.code = zos4pkg.RegistrarCodeNoError
+	again, err := mgr.RotateKey()
+	require.NoError(err)
+	require.Equal(rotation, again)
+	require.Equal([]byte(mgr.pair().PublicKey), rotation.NewKey)
+}
+
+func TestRotateKeyRejected(t *testing.T) {
+	require := require.New(t)
+
+	root := t.TempDir()
+	registrar := &testRegistrar{twin: 10}
+	mgr := testManager(t, root, &memStore{}, registrar)
+	old := mgr.pair()
+
+	registrar.code = zos4pkg.RegistrarCodeUnauthorized
+	_, err := mgr.RotateKey()
+	require.Error(err)
+	require.NotErrorIs(err, ErrRotationPending)
+	require.Equal(old, mgr.pair())
+
+	pending, err := loadPending(root)
+	require.NoError(err)
+	require.Nil(pending)
+}
+
+func TestRotateKeyLostResponse(t *testing.T) {
+	require := require.New(t)
+
+	root := t.TempDir()
+	st := &memStore{}
+	registrar := &testRegistrar{twin: 10}
+	mgr := testManager(t, root, st, registrar)
+
+	registrar.lost = true
+	rotation, err := mgr.RotateKey()
+	require.ErrorIs(err, ErrRotationPending)
+	require.Equal(rotation.NewKey, registrar.key)
+
+	// the retry is rejected since the twin has the new key already, the
+	// rotation must still be completed
+	registrar.lost = false
+	_, err = mgr.RotateKey()
+	require.NoError(err)
+	require.Equal([]byte(mgr.pair().PublicKey), rotation.NewKey)
+	require.Equal(mgr.pair().PrivateKey, st.key)
+}
+
+func TestRotateKeyResume(t *testing.T) {
+	require := require.New(t)
+
+	root := t.TempDir()
+	st := &memStore{}
+	registrar := &testRegistrar{twin: 10}
+	mgr := testManager(t, root, st, registrar)
+
+	_, new, _ := ed25519.GenerateKey(nil)
+	rotation, err := NewRotation(10, mgr.pair().PrivateKey, new)
+	require.NoError(err)
+
+	// the node restarted after the registrar accepted the rotation but
+	// before the new key was stored
+	require.NoError(savePending(root, &pendingRotation{Seed: new.Seed(), Rotation: rotation, Accepted: true}))
+
+	mgr = testManager(t, root, st, registrar)
+	require.Equal(new, mgr.pair().PrivateKey)
+	require.Equal(new, st.key)
+
+	pending, err := loadPending(root)
+	require.NoError(err)
+	require.Nil(pending)
+}
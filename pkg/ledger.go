@@ -0,0 +1,79 @@
+package pkg
+
+//go:generate zbusc -module provision -version 0.0.1 -name ledger -package stubs github.com/threefoldtech/zos4/pkg+Ledger stubs/ledger_stub.go
+
+// LedgerStatus is the submission status of a ledger entry
+type LedgerStatus string
+
+const (
+	// LedgerPending the entry is not submitted yet
+	LedgerPending LedgerStatus = "pending"
+	// LedgerSubmitted the entry was accepted by the registrar
+	LedgerSubmitted LedgerStatus = "submitted"
+)
+
+// LedgerEntry is the consumption of a single contract over a window as
+// reported by the node. Signature is made by the node identity key over
+// the entry Challenge, submission fields are not part of the signature.
+type LedgerEntry struct {
+	ID         uint64 `json:"id"`
+	ContractID uint64 `json:"contract_id"`
+	// From start of the window (unix time)
+	From int64 `json:"from"`
+	// To end of the window (unix time)
+	To int64 `json:"to"`
+
+	NRU             uint64 `json:"nru"`
+	PublicIPSeconds uint64 `json:"public_ip_seconds"`
+	GPUSeconds      uint64 `json:"gpu_seconds"`
+	DiskReadBytes   uint64 `json:"disk_read_bytes"`
+	DiskWriteBytes  uint64 `json:"disk_write_bytes"`
+
+	Signature []byte `json:"signature"`
+	// PublicKey is the node key the entry is signed with. It's empty for
+	// entries recorded before signer keys were tracked, those are signed
+	// by the first key of the ledger.
+	PublicKey []byte `json:"public_key"`
+
+	Status LedgerStatus `json:"status"`
+	// Hash returned by the registrar on submission
+	Hash string `json:"hash"`
+	// SubmittedAt time of submission (unix time)
+	SubmittedAt int64 `json:"submitted_at"`
+	// Error of the last failed submission
+	Error string `json:"error"`
+}
+
+// LedgerQuery filters and paginates ledger entries
+type LedgerQuery struct {
+	// Cursor is the `Next` value of the previous page, 0 starts from the beginning
+	Cursor uint64 `json:"cursor"`
+	// Limit is the max number of entries in a page, 0 means default page size
+	Limit uint32 `json:"limit"`
+	// ContractID only include entries of this contract
+	ContractID uint64 `json:"contract_id"`
+	// From only include entries with windows ending at or after this time
+	From int64 `json:"from"`
+	// To only include entries with windows starting at or before this time
+	To int64 `json:"to"`
+}
+
+// LedgerPage is a single page of ledger entries
+type LedgerPage struct {
+	Entries []LedgerEntry `json:"entries"`
+	// Next is the cursor of the next page
+	Next uint64 `json:"next"`
+	// More is true if there are more entries after this page
+	More bool `json:"more"`
+}
+
+// Ledger is the local record of all consumption reports made by the node
+type Ledger interface {
+	// Entries lists ledger entries oldest first
+	Entries(query LedgerQuery) (LedgerPage, error)
+	// PublicKey returns the key new entries are signed with
+	PublicKey() []byte
+	// PublicKeys returns all keys entries were signed with oldest first,
+	// the node key changes if it's rotated
+	PublicKeys() ([][]byte, error)
+}
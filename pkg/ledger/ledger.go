@@ -0,0 +1,308 @@
+// Package ledger keeps a local record of all consumption reports generated
+// by the node. Each entry is signed with the node identity key so farmers
+// and users can reconcile billing with a proof of what the node reported.
+package ledger
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos4/pkg"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+
+	bucketEntries = "entries"
+	// bucketKeys keeps the public keys entries were signed with in order
+	bucketKeys = "keys"
+)
+
+// Challenge returns the signed bytes of an entry. It covers the reported
+// consumption only, submission status is local bookkeeping.
+func Challenge(e *pkg.LedgerEntry) []byte {
+	return []byte(fmt.Sprintf(
+		"%d:%d:%d:%d:%d:%d:%d:%d:%d",
+		e.ID, e.ContractID, e.From, e.To,
+		e.NRU, e.PublicIPSeconds, e.GPUSeconds, e.DiskReadBytes, e.DiskWriteBytes,
+	))
+}
+
+// Verify checks the entry signature against the node public key
+func Verify(e *pkg.LedgerEntry, pk ed25519.PublicKey) bool {
+	if len(pk) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(pk, Challenge(e), e.Signature)
+}
+
+// VerifyWith checks the entry signature against the key that signed it,
+// keys are the ledger keys (see PublicKeys). An entry signed by a key that
+// is not a ledger key is invalid.
+func VerifyWith(e *pkg.LedgerEntry, keys [][]byte) bool {
+	if len(keys) == 0 {
+		return false
+	}
+
+	if len(e.PublicKey) == 0 {
+		// recorded before signer keys were tracked
+		return Verify(e, keys[0])
+	}
+
+	for _, key := range keys {
+		if bytes.Equal(key, e.PublicKey) {
+			return Verify(e, key)
+		}
+	}
+
+	return false
+}
+
+// Ledger is a bolt backed ledger
+type Ledger struct {
+	db *bolt.DB
+
+	mu sync.RWMutex
+	sk ed25519.PrivateKey
+}
+
+var _ pkg.Ledger = (*Ledger)(nil)
+
+// Open opens (or creates) the ledger at path. sk is the node
+// identity key used to sign entries
+func Open(path string, sk ed25519.PrivateKey) (*Ledger, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open ledger database")
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(bucketEntries)); err != nil {
+			return err
+		}
+
+		_, err := tx.CreateBucketIfNotExists([]byte(bucketKeys))
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to initialize ledger database")
+	}
+
+	l := &Ledger{db: db}
+	if err := l.SetKey(sk); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// SetKey replaces the key used to sign new entries after the node key is
+// rotated. The key is added to the ledger keys so entries signed by the
+// previous keys can still be verified.
+func (l *Ledger) SetKey(sk ed25519.PrivateKey) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	pk := sk.Public().(ed25519.PublicKey)
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketKeys))
+		cur := bucket.Cursor()
+		if last, value := cur.Last(); last != nil && bytes.Equal(value, pk) {
+			return nil
+		}
+
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(u64(id), pk)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to store ledger key")
+	}
+
+	l.sk = sk
+	return nil
+}
+
+func (l *Ledger) key() ed25519.PrivateKey {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.sk
+}
+
+// Close the ledger
+func (l *Ledger) Close() error {
+	return l.db.Close()
+}
+
+func u64(v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return b[:]
+}
+
+func put(bucket *bolt.Bucket, entry *pkg.LedgerEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put(u64(entry.ID), data)
+}
+
+// Record adds entries to the ledger as pending. Entries are assigned
+// ids and signed, the ids are returned in the same order.
+func (l *Ledger) Record(entries ...pkg.LedgerEntry) ([]uint64, error) {
+	sk := l.key()
+	ids := make([]uint64, 0, len(entries))
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketEntries))
+		for i := range entries {
+			entry := &entries[i]
+			id, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			entry.ID = id
+			entry.Status = pkg.LedgerPending
+			entry.Signature = ed25519.Sign(sk, Challenge(entry))
+			entry.PublicKey = sk.Public().(ed25519.PublicKey)
+			if err := put(bucket, entry); err != nil {
+				return errors.Wrapf(err, "failed to store ledger entry for contract '%d'", entry.ContractID)
+			}
+
+			ids = append(ids, id)
+		}
+
+		return nil
+	})
+
+	return ids, err
+}
+
+func (l *Ledger) update(ids []uint64, fn func(entry *pkg.LedgerEntry)) error {
+	return l.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketEntries))
+		for _, id := range ids {
+			data := bucket.Get(u64(id))
+			if data == nil {
+				continue
+			}
+
+			var entry pkg.LedgerEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return errors.Wrapf(err, "failed to load ledger entry '%d'", id)
+			}
+
+			fn(&entry)
+			if err := put(bucket, &entry); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Submitted marks entries as submitted with the registrar hash
+func (l *Ledger) Submitted(ids []uint64, hash string) error {
+	now := time.Now().Unix()
+	return l.update(ids, func(entry *pkg.LedgerEntry) {
+		entry.Status = pkg.LedgerSubmitted
+		entry.Hash = hash
+		entry.SubmittedAt = now
+		entry.Error = ""
+	})
+}
+
+// Failed records the error of a failed submission, entries stay pending
+func (l *Ledger) Failed(ids []uint64, cause error) error {
+	return l.update(ids, func(entry *pkg.LedgerEntry) {
+		entry.Error = cause.Error()
+	})
+}
+
+func match(entry *pkg.LedgerEntry, query *pkg.LedgerQuery) bool {
+	if query.ContractID != 0 && entry.ContractID != query.ContractID {
+		return false
+	}
+
+	if query.From != 0 && entry.To < query.From {
+		return false
+	}
+
+	if query.To != 0 && entry.From > query.To {
+		return false
+	}
+
+	return true
+}
+
+// Entries implements pkg.Ledger
+func (l *Ledger) Entries(query pkg.LedgerQuery) (pkg.LedgerPage, error) {
+	size := defaultPageSize
+	if query.Limit > maxPageSize {
+		size = maxPageSize
+	} else if query.Limit != 0 {
+		size = int(query.Limit)
+	}
+
+	page := pkg.LedgerPage{Entries: []pkg.LedgerEntry{}}
+	err := l.db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket([]byte(bucketEntries)).Cursor()
+		for k, v := cur.Seek(u64(query.Cursor + 1)); k != nil; k, v = cur.Next() {
+			var entry pkg.LedgerEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return errors.Wrapf(err, "failed to load ledger entry '%d'", binary.BigEndian.Uint64(k))
+			}
+
+			if !match(&entry, &query) {
+				continue
+			}
+
+			if len(page.Entries) == size {
+				page.More = true
+				break
+			}
+
+			page.Entries = append(page.Entries, entry)
+			page.Next = entry.ID
+		}
+
+		return nil
+	})
+
+	return page, err
+}
+
+// PublicKey implements pkg.Ledger
+func (l *Ledger) PublicKey() []byte {
+	return l.key().Public().(ed25519.PublicKey)
+}
+
+// PublicKeys implements pkg.Ledger
+func (l *Ledger) PublicKeys() ([][]byte, error) {
+	var keys [][]byte
+	err := l.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucketKeys)).ForEach(func(_, value []byte) error {
+			keys = append(keys, append([]byte(nil), value...))
+			return nil
+		})
+	})
+
+	return keys, err
+}
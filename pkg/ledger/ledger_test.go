@@ -0,0 +1,124 @@
+package ledger
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos4/pkg"
+)
+
+func TestLedger(t *testing.T) {
+	require := require.New(t)
+
+	pk, sk, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+
+	l, err := Open(filepath.Join(t.TempDir(), "ledger.bolt"), sk)
+	require.NoError(err)
+	defer l.Close()
+
+	var entries []pkg.LedgerEntry
+	for i := 0; i < 5; i++ {
+		entries = append(entries, pkg.LedgerEntry{
+			ContractID: uint64(i%2 + 1),
+			From:       int64(i * 3600),
+			To:         int64((i + 1) * 3600),
+			NRU:        uint64(i * 100),
+		})
+	}
+
+	ids, err := l.Record(entries...)
+	require.NoError(err)
+	require.Equal([]uint64{1, 2, 3, 4, 5}, ids)
+
+	require.NoError(l.Failed(ids[:2], fmt.Errorf("registrar is down")))
+	require.NoError(l.Submitted(ids[:1], "0xabc"))
+
+	page, err := l.Entries(pkg.LedgerQuery{Limit: 2})
+	require.NoError(err)
+	require.Len(page.Entries, 2)
+	require.True(page.More)
+	require.EqualValues(2, page.Next)
+
+	first, second := page.Entries[0], page.Entries[1]
+	require.Equal(pkg.LedgerSubmitted, first.Status)
+	require.Equal("0xabc", first.Hash)
+	require.Empty(first.Error)
+	require.Equal(pkg.LedgerPending, second.Status)
+	require.Equal("registrar is down", second.Error)
+
+	// status changes do not invalidate the signature
+	require.True(Verify(&first, pk))
+	require.True(Verify(&second, ed25519.PublicKey(l.PublicKey())))
+
+	// but any change to the consumption does
+	second.NRU++
+	require.False(Verify(&second, pk))
+
+	page, err = l.Entries(pkg.LedgerQuery{Cursor: page.Next, ContractID: 1})
+	require.NoError(err)
+	require.False(page.More)
+	require.Len(page.Entries, 2)
+	require.EqualValues(3, page.Entries[0].ID)
+	require.EqualValues(5, page.Entries[1].ID)
+
+	page, err = l.Entries(pkg.LedgerQuery{From: 2 * 3600, To: 3 * 3600})
+	require.NoError(err)
+	// windows touching the range are included
+	require.Len(page.Entries, 3)
+}
+
+func TestLedgerKeyRotation(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "ledger.bolt")
+	_, old, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+
+	l, err := Open(path, old)
+	require.NoError(err)
+	_, err = l.Record(pkg.LedgerEntry{ContractID: 1, NRU: 10})
+	require.NoError(err)
+
+	_, new, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+	require.NoError(l.SetKey(new))
+	_, err = l.Record(pkg.LedgerEntry{ContractID: 1, NRU: 20})
+	require.NoError(err)
+	require.NoError(l.Close())
+
+	// the rotated key is used after a restart
+	l, err = Open(path, new)
+	require.NoError(err)
+	defer l.Close()
+
+	keys, err := l.PublicKeys()
+	require.NoError(err)
+	require.Equal([][]byte{old.Public().(ed25519.PublicKey), new.Public().(ed25519.PublicKey)}, keys)
+
+	page, err := l.Entries(pkg.LedgerQuery{})
+	require.NoError(err)
+	require.Len(page.Entries, 2)
+	for i := range page.Entries {
+		require.True(VerifyWith(&page.Entries[i], keys))
+	}
+	require.Equal(keys[0], page.Entries[0].PublicKey)
+	require.Equal(keys[1], page.Entries[1].PublicKey)
+
+	// entries recorded before signer keys were tracked are signed by the
+	// first key
+	legacy := page.Entries[0]
+	legacy.PublicKey = nil
+	require.True(VerifyWith(&legacy, keys))
+
+	// an entry signed by another key is rejected even if it carries the key
+	_, other, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+	forged := page.Entries[1]
+	forged.PublicKey = other.Public().(ed25519.PublicKey)
+	forged.Signature = ed25519.Sign(other, Challenge(&forged))
+	require.False(VerifyWith(&forged, keys))
+}
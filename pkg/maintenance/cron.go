@@ -0,0 +1,171 @@
+package maintenance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// field is a set of allowed values of a single cron field
+type field struct {
+	bits uint64
+	// all is set if the field is a `*` which is needed
+	// to implement the standard cron day matching rules
+	all bool
+}
+
+func (f field) has(v int) bool {
+	return f.bits&(1<<uint(v)) != 0
+}
+
+// parseField parses a cron field. supported syntax is `*`, `n`, `a-b`, `*/step`,
+// `a-b/step` and a comma separated list of any of them.
+func parseField(s string, min, max int) (field, error) {
+	var f field
+	if s == "*" {
+		f.all = true
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return f, fmt.Errorf("invalid step '%s'", stepStr)
+			}
+		}
+
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return f, fmt.Errorf("invalid range '%s'", rng)
+			}
+			if hi, err = strconv.Atoi(b); err != nil {
+				return f, fmt.Errorf("invalid range '%s'", rng)
+			}
+		default:
+			v, err := strconv.Atoi(rng)
+			if err != nil {
+				return f, fmt.Errorf("invalid value '%s'", rng)
+			}
+			lo, hi = v, v
+			if hasStep {
+				// `n/step` means starting from n to the end
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return f, fmt.Errorf("value '%s' out of range [%d-%d]", rng, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			f.bits |= 1 << uint(v)
+		}
+	}
+
+	return f, nil
+}
+
+// schedule is a parsed 5 fields cron expression
+type schedule struct {
+	minute, hour, dom, month, dow field
+}
+
+func parseCron(s string) (schedule, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ':'
+	})
+
+	if len(fields) != 5 {
+		return schedule{}, fmt.Errorf("expecting 5 cron fields got %d", len(fields))
+	}
+
+	var (
+		c   schedule
+		err error
+	)
+
+	if c.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return c, fmt.Errorf("invalid minute field: %w", err)
+	}
+	if c.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return c, fmt.Errorf("invalid hour field: %w", err)
+	}
+	if c.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return c, fmt.Errorf("invalid day of month field: %w", err)
+	}
+	if c.month, err = parseField(fields[3], 1, 12); err != nil {
+		return c, fmt.Errorf("invalid month field: %w", err)
+	}
+	if c.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return c, fmt.Errorf("invalid day of week field: %w", err)
+	}
+
+	// 7 is also sunday
+	if c.dow.has(7) {
+		c.dow.bits |= 1
+	}
+
+	return c, nil
+}
+
+func (c *schedule) matchDay(t time.Time) bool {
+	dom := c.dom.has(t.Day())
+	dow := c.dow.has(int(t.Weekday()))
+
+	// standard cron behavior, if both fields are restricted
+	// a day matches if any of them matches.
+	if !c.dom.all && !c.dow.all {
+		return dom || dow
+	}
+
+	return dom && dow
+}
+
+func (c *schedule) match(t time.Time) bool {
+	return c.month.has(int(t.Month())) &&
+		c.matchDay(t) &&
+		c.hour.has(t.Hour()) &&
+		c.minute.has(t.Minute())
+}
+
+// next returns the first time strictly after t that matches the schedule. Zero
+// time is returned if nothing matches in the next 5 years (for example 30th of feb)
+func (c *schedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !c.month.has(int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !c.hour.has(t.Hour()) {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+
+		if !c.minute.has(t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
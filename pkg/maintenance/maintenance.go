@@ -0,0 +1,196 @@
+// Package maintenance implements farmer defined maintenance windows. A window
+// is a cron expression that defines when the window opens and a duration
+// of how long it stays open. Disruptive operations (like workload updates
+// and system upgrades) are only carried out while a window is open.
+//
+// Windows are set from the farm boot configuration as kernel params, for example
+//
+//	zos-maintenance=0:2:*:*:6+4h
+//
+// opens a window every saturday at 02:00 UTC for 4 hours. Multiple windows
+// can be defined by repeating the param. Cron fields are separated with `:`
+// (or spaces) since spaces are not allowed in kernel params.
+package maintenance
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zosbase/pkg/kernel"
+)
+
+const (
+	// KernelParam is the kernel param used to define maintenance windows
+	KernelParam = "zos-maintenance"
+
+	maxDuration = 7 * 24 * time.Hour
+)
+
+// Window is a single maintenance window
+type Window struct {
+	cron     schedule
+	spec     string
+	Duration time.Duration
+}
+
+// ParseWindow parses a window in the form `<cron>+<duration>`
+func ParseWindow(spec string) (Window, error) {
+	idx := strings.LastIndex(spec, "+")
+	if idx < 0 {
+		return Window{}, fmt.Errorf("invalid window '%s' expecting <cron>+<duration>", spec)
+	}
+
+	cron, err := parseCron(spec[:idx])
+	if err != nil {
+		return Window{}, errors.Wrapf(err, "invalid window '%s'", spec)
+	}
+
+	duration, err := time.ParseDuration(spec[idx+1:])
+	if err != nil {
+		return Window{}, errors.Wrapf(err, "invalid window '%s' duration", spec)
+	}
+
+	if duration < time.Minute || duration > maxDuration {
+		return Window{}, fmt.Errorf("invalid window '%s' duration must be between 1m and %s", spec, maxDuration)
+	}
+
+	return Window{cron: cron, spec: spec, Duration: duration}, nil
+}
+
+// String returns the window spec
+func (w *Window) String() string {
+	return w.spec
+}
+
+// start returns the start time of the window that is open at time t
+func (w *Window) start(t time.Time) (time.Time, bool) {
+	t = t.UTC()
+	base := t.Truncate(time.Minute)
+	for back := time.Duration(0); back < w.Duration; back += time.Minute {
+		start := base.Add(-back)
+		if w.cron.match(start) && t.Before(start.Add(w.Duration)) {
+			return start, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// IsOpen checks if the window is open at time t
+func (w *Window) IsOpen(t time.Time) bool {
+	_, ok := w.start(t)
+	return ok
+}
+
+// Next returns next time the window opens after t
+func (w *Window) Next(t time.Time) time.Time {
+	return w.cron.next(t.UTC())
+}
+
+// Schedule is a set of maintenance windows. An empty schedule
+// means no restrictions, hence it's always open.
+type Schedule struct {
+	Windows []Window
+}
+
+// Status of the maintenance schedule at certain time
+type Status struct {
+	// Configured is false if the farmer didn't set any windows
+	Configured bool `json:"configured"`
+	// Open is true if disruptive operations are allowed now
+	Open bool `json:"open"`
+	// Until when the current open window closes, only set if open and configured
+	Until time.Time `json:"until"`
+	// Next time a window opens, only set if not open
+	Next time.Time `json:"next"`
+	// Windows specs
+	Windows []string `json:"windows"`
+}
+
+// Configured returns true if there are windows defined
+func (s *Schedule) Configured() bool {
+	return len(s.Windows) > 0
+}
+
+// IsOpen checks if disruptive operations are allowed at time t
+func (s *Schedule) IsOpen(t time.Time) bool {
+	if !s.Configured() {
+		return true
+	}
+
+	for i := range s.Windows {
+		if s.Windows[i].IsOpen(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Status returns the schedule status at time t
+func (s *Schedule) Status(t time.Time) Status {
+	status := Status{
+		Configured: s.Configured(),
+		Open:       !s.Configured(),
+	}
+
+	for i := range s.Windows {
+		w := &s.Windows[i]
+		status.Windows = append(status.Windows, w.String())
+
+		if start, ok := w.start(t); ok {
+			status.Open = true
+			if end := start.Add(w.Duration); end.After(status.Until) {
+				status.Until = end
+			}
+			continue
+		}
+
+		next := w.Next(t)
+		if next.IsZero() {
+			continue
+		}
+
+		if status.Next.IsZero() || next.Before(status.Next) {
+			status.Next = next
+		}
+	}
+
+	if status.Open {
+		status.Next = time.Time{}
+	}
+
+	return status
+}
+
+// FromParams builds the schedule from kernel params. Invalid windows
+// are skipped and reported in the returned error, the valid ones are
+// still returned.
+func FromParams(params kernel.Params) (Schedule, error) {
+	var schedule Schedule
+	specs, _ := params.Get(KernelParam)
+
+	var invalid []string
+	for _, spec := range specs {
+		window, err := ParseWindow(spec)
+		if err != nil {
+			invalid = append(invalid, err.Error())
+			continue
+		}
+
+		schedule.Windows = append(schedule.Windows, window)
+	}
+
+	if len(invalid) > 0 {
+		return schedule, fmt.Errorf("invalid maintenance windows: %s", strings.Join(invalid, ", "))
+	}
+
+	return schedule, nil
+}
+
+// Load the maintenance schedule from the node kernel params
+func Load() (Schedule, error) {
+	return FromParams(kernel.GetParams())
+}
@@ -0,0 +1,96 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zosbase/pkg/kernel"
+)
+
+func TestParseWindow(t *testing.T) {
+	for _, spec := range []string{
+		"0:2:*:*:6+4h",
+		"0 2 * * 6+4h",
+		"*/15:0-6:1,15:*:*+10m",
+		"30:23:*:*:0,7+90m",
+	} {
+		_, err := ParseWindow(spec)
+		require.NoError(t, err, spec)
+	}
+
+	for _, spec := range []string{
+		"0:2:*:*:6",
+		"0:2:*:*+4h",
+		"60:2:*:*:6+4h",
+		"0:2:*:*:6+0s",
+		"0:2:*:*:6+200h",
+		"0:2:0:*:*+1h",
+		"5-1:*:*:*:*+1h",
+	} {
+		_, err := ParseWindow(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	require := require.New(t)
+	// saturdays at 02:00 for 4 hours
+	w, err := ParseWindow("0:2:*:*:6+4h")
+	require.NoError(err)
+
+	// 2024-01-06 is a saturday
+	sat := time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC)
+
+	require.False(w.IsOpen(sat.Add(time.Hour + 59*time.Minute)))
+	require.True(w.IsOpen(sat.Add(2 * time.Hour)))
+	require.True(w.IsOpen(sat.Add(5*time.Hour + 59*time.Minute)))
+	require.False(w.IsOpen(sat.Add(6 * time.Hour)))
+	// sunday
+	require.False(w.IsOpen(sat.Add(26 * time.Hour)))
+
+	require.Equal(sat.Add(2*time.Hour), w.Next(sat))
+	require.Equal(sat.AddDate(0, 0, 7).Add(2*time.Hour), w.Next(sat.Add(2*time.Hour)))
+
+	// window spanning midnight
+	w, err = ParseWindow("0:23:*:*:*+2h")
+	require.NoError(err)
+	require.True(w.IsOpen(sat.Add(30 * time.Minute)))
+	require.False(w.IsOpen(sat.Add(time.Hour)))
+
+	// day of month or day of week
+	w, err = ParseWindow("0:0:1:*:1+1h")
+	require.NoError(err)
+	require.Equal(time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC), w.Next(sat))
+	require.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), w.Next(time.Date(2024, 1, 29, 1, 0, 0, 0, time.UTC)))
+
+	// never matches
+	w, err = ParseWindow("0:0:30:2:*+1h")
+	require.NoError(err)
+	require.True(w.Next(sat).IsZero())
+}
+
+func TestSchedule(t *testing.T) {
+	require := require.New(t)
+	now := time.Date(2024, 1, 6, 3, 0, 0, 0, time.UTC)
+
+	var empty Schedule
+	require.True(empty.IsOpen(now))
+	require.Equal(Status{Open: true}, empty.Status(now))
+
+	schedule, err := FromParams(kernel.Params{
+		KernelParam: {"0:2:*:*:6+4h", "invalid", "0:12:*:*:*+1h"},
+	})
+	require.Error(err)
+	require.Len(schedule.Windows, 2)
+
+	status := schedule.Status(now)
+	require.True(status.Open)
+	require.Equal(time.Date(2024, 1, 6, 6, 0, 0, 0, time.UTC), status.Until)
+	require.True(status.Next.IsZero())
+
+	status = schedule.Status(now.Add(4 * time.Hour))
+	require.False(status.Open)
+	require.Equal(time.Date(2024, 1, 6, 12, 0, 0, 0, time.UTC), status.Next)
+	require.False(schedule.IsOpen(now.Add(4 * time.Hour)))
+}
@@ -0,0 +1,45 @@
+package pkg
+
+import (
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+)
+
+//go:generate zbusc -module provision -version 0.0.1 -name metering -package stubs github.com/threefoldtech/zos4/pkg+Metering stubs/metering_stub.go
+
+// TrafficQuery selects the window of a traffic history query
+type TrafficQuery struct {
+	// From start of the window, rounded down to the metrics resolution
+	From gridtypes.Timestamp `json:"from"`
+	// To end of the window (exclusive), 0 means now
+	To gridtypes.Timestamp `json:"to"`
+	// Step splits the window into samples of this many seconds, 0 means
+	// a single sample for the whole window
+	Step uint64 `json:"step"`
+}
+
+// TrafficRecord is the metered traffic of a workload in a single
+// class and direction
+type TrafficRecord struct {
+	Workload  gridtypes.WorkloadID `json:"workload"`
+	Class     string               `json:"class"`
+	Direction string               `json:"direction"`
+	NU        uint64               `json:"nu"`
+}
+
+// TrafficSample is the metered traffic over a period of time
+type TrafficSample struct {
+	From    gridtypes.Timestamp `json:"from"`
+	To      gridtypes.Timestamp `json:"to"`
+	Records []TrafficRecord     `json:"records"`
+}
+
+// Metering exposes metered network traffic of workloads
+type Metering interface {
+	// TwinTraffic returns the metered traffic history of all workloads of a twin.
+	// History is only available within the metrics retention period.
+	TwinTraffic(twin uint32, query TrafficQuery) ([]TrafficSample, error)
+	// ReportNow reports the consumption since the last report without waiting
+	// for the next report period, and returns once all queued reports are
+	// submitted.
+	ReportNow() error
+}
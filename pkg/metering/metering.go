@@ -0,0 +1,345 @@
+// Package metering computes network units (NU) consumed by workloads. Traffic
+// is split into classes (public and private networks) and directions (rx, tx).
+// The weight of each class and direction is set from the environment config
+// (the zos-config document of the running mode) under the `metering` key, for example
+//
+//	"metering": {
+//		"classes": {
+//			"public": {"rx": {"bytes": 1}, "tx": {"bytes": 1}},
+//			"private": {"tx": {"bytes": 0.5}}
+//		}
+//	}
+//
+// A class that is not listed is not metered. If the `metering` key is missing
+// the Default config is used.
+//
+// The raw traffic counters (bytes and packets) are stored, and the weights are
+// applied to their increase when it's reported, so a weight change only applies
+// to traffic metered after it.
+//
+// Other billable resources (public ips, gpus and disks io) are metered
+// with the same keys layout so all consumption of a workload is kept in
+// the same place.
+package metering
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zosbase/pkg"
+	"github.com/threefoldtech/zosbase/pkg/environment"
+)
+
+const (
+	// ClassPublic traffic over public ips
+	ClassPublic = "public"
+	// ClassPrivate traffic over private networks (wireguard, yggdrasil and mycelium)
+	ClassPrivate = "private"
+
+	// DirectionRx received traffic
+	DirectionRx = "rx"
+	// DirectionTx sent traffic
+	DirectionTx = "tx"
+
+	// ResourcePublicIP public ip reservation, counted in seconds
+	ResourcePublicIP = "ipv4"
+	// ResourceGPU gpu attachment, counted in seconds per gpu
+	ResourceGPU = "gpu"
+	// ResourceDisk disk io of volumes and zmounts, counted in bytes
+	ResourceDisk = "disk"
+
+	// CounterSeconds time a resource was reserved
+	CounterSeconds = "seconds"
+	// CounterRead bytes read from a disk
+	CounterRead = "read"
+	// CounterWrite bytes written to a disk
+	CounterWrite = "write"
+
+	// UnitBytes raw traffic counted in bytes
+	UnitBytes = "bytes"
+	// UnitPackets raw traffic counted in packets
+	UnitPackets = "packets"
+
+	// configURL is the same document environment.GetConfig reads, the
+	// environment config type does not keep the metering key
+	configURL   = "https://raw.githubusercontent.com/threefoldtech/zos-config/main/"
+	httpTimeout = 10 * time.Second
+
+	// refreshInterval is how often the config is loaded again, same as
+	// the environment config cache
+	refreshInterval = 6 * time.Hour
+	// retryInterval wait before loading again a config that failed to load
+	retryInterval = 5 * time.Minute
+
+	keySep = "/"
+)
+
+// Weights of a single traffic direction
+type Weights struct {
+	// Bytes weight of a single byte
+	Bytes float64 `json:"bytes"`
+	// Packets weight of a single packet
+	Packets float64 `json:"packets"`
+}
+
+func (w Weights) valid() bool {
+	return w.Bytes >= 0 && w.Packets >= 0
+}
+
+// Class weights of a traffic class
+type Class struct {
+	Rx Weights `json:"rx"`
+	Tx Weights `json:"tx"`
+}
+
+// Config is the metering config
+type Config struct {
+	// Classes metered traffic classes, a missing class is not metered
+	Classes map[string]Class `json:"classes"`
+}
+
+// Sample is a raw traffic counter of a single class, direction and unit
+type Sample struct {
+	Class     string
+	Direction string
+	Unit      string
+	Value     float64
+}
+
+// Default config only meters public traffic, one NU per byte in both directions
+func Default() Config {
+	return Config{
+		Classes: map[string]Class{
+			ClassPublic: {
+				Rx: Weights{Bytes: 1},
+				Tx: Weights{Bytes: 1},
+			},
+		},
+	}
+}
+
+// Valid checks that all classes are known and weights are not negative
+func (c *Config) Valid() error {
+	for name, class := range c.Classes {
+		if name != ClassPublic && name != ClassPrivate {
+			return fmt.Errorf("unknown traffic class '%s'", name)
+		}
+
+		if !class.Rx.valid() || !class.Tx.valid() {
+			return fmt.Errorf("invalid negative weight for traffic class '%s'", name)
+		}
+	}
+
+	return nil
+}
+
+// Samples returns the raw traffic counters of a machine, for all classes.
+// Values are the machine counters, hence they only go up.
+func Samples(m pkg.MachineMetric) []Sample {
+	var samples []Sample
+	for _, class := range []string{ClassPublic, ClassPrivate} {
+		metric := m.Public
+		if class == ClassPrivate {
+			metric = m.Private
+		}
+
+		samples = append(samples,
+			Sample{Class: class, Direction: DirectionRx, Unit: UnitBytes, Value: float64(metric.NetRxBytes)},
+			Sample{Class: class, Direction: DirectionRx, Unit: UnitPackets, Value: float64(metric.NetRxPackets)},
+			Sample{Class: class, Direction: DirectionTx, Unit: UnitBytes, Value: float64(metric.NetTxBytes)},
+			Sample{Class: class, Direction: DirectionTx, Unit: UnitPackets, Value: float64(metric.NetTxPackets)},
+		)
+	}
+
+	return samples
+}
+
+// NU returns the network units of value units of traffic of class in
+// direction. Traffic of a class that is not metered is free.
+func (c *Config) NU(class, direction, unit string, value float64) float64 {
+	weights, ok := c.Classes[class]
+	if !ok {
+		return 0
+	}
+
+	w := weights.Rx
+	if direction == DirectionTx {
+		w = weights.Tx
+	}
+
+	switch unit {
+	case UnitBytes:
+		return value * w.Bytes
+	case UnitPackets:
+		return value * w.Packets
+	}
+
+	return 0
+}
+
+// Traffic returns the NU of the increase value of the traffic metric key. Keys
+// stored before the raw counters hold NU already. ok is false if key is not
+// a traffic key.
+func (c *Config) Traffic(key string, value float64) (workload, class, direction string, nu float64, ok bool) {
+	if workload, class, direction, unit, ok := ParseTrafficKey(key); ok {
+		return workload, class, direction, c.NU(class, direction, unit, value), true
+	}
+
+	workload, class, direction, ok = ParseKey(key)
+	if !ok || !IsTraffic(class) {
+		return "", "", "", 0, false
+	}
+
+	return workload, class, direction, value, true
+}
+
+// IsTraffic checks if class is a network traffic class, as opposed
+// to other metered resources
+func IsTraffic(class string) bool {
+	return class == ClassPublic || class == ClassPrivate
+}
+
+// Key returns the metric key of a workload class (or resource) and
+// direction (or counter)
+func Key(workload, class, direction string) string {
+	return strings.Join([]string{workload, class, direction}, keySep)
+}
+
+// ParseKey parses a key created with Key
+func ParseKey(key string) (workload, class, direction string, ok bool) {
+	parts := strings.Split(key, keySep)
+	if len(parts) != 3 {
+		return "", "", "", false
+	}
+
+	return parts[0], parts[1], parts[2], true
+}
+
+// TrafficKey returns the metric key of a raw traffic counter of a workload
+func TrafficKey(workload, class, direction, unit string) string {
+	return strings.Join([]string{workload, class, direction, unit}, keySep)
+}
+
+// ParseTrafficKey parses a key created with TrafficKey
+func ParseTrafficKey(key string) (workload, class, direction, unit string, ok bool) {
+	parts := strings.Split(key, keySep)
+	if len(parts) != 4 || !IsTraffic(parts[1]) {
+		return "", "", "", "", false
+	}
+
+	return parts[0], parts[1], parts[2], parts[3], true
+}
+
+// decode the metering section out of the environment config document
+func decode(data []byte) (Config, error) {
+	var doc struct {
+		Metering *Config `json:"metering"`
+	}
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return Default(), errors.Wrap(err, "failed to decode environment config")
+	}
+
+	if doc.Metering == nil {
+		return Default(), nil
+	}
+
+	if err := doc.Metering.Valid(); err != nil {
+		return Default(), err
+	}
+
+	return *doc.Metering, nil
+}
+
+// Load the metering config of the given run mode. The default config is
+// returned with the error if the config can't be loaded.
+func Load(mode environment.RunMode) (Config, error) {
+	cl := retryablehttp.NewClient()
+	cl.HTTPClient.Timeout = httpTimeout
+	cl.RetryMax = 5
+	cl.Logger = nil
+
+	response, err := cl.StandardClient().Get(fmt.Sprintf("%s%s.json", configURL, mode))
+	if err != nil {
+		return Default(), errors.Wrap(err, "failed to get environment config")
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return Default(), fmt.Errorf("failed to get environment config: %s", response.Status)
+	}
+
+	var data json.RawMessage
+	if err := json.NewDecoder(response.Body).Decode(&data); err != nil {
+		return Default(), errors.Wrap(err, "failed to read environment config")
+	}
+
+	return decode(data)
+}
+
+// Watcher keeps the metering config of a run mode up to date. It starts
+// with the default config so it never blocks on the network, and keeps
+// the last loaded config if the config can't be loaded.
+type Watcher struct {
+	load func() (Config, error)
+
+	mu     sync.RWMutex
+	config Config
+}
+
+// NewWatcher creates a watcher for the metering config of mode
+func NewWatcher(mode environment.RunMode) *Watcher {
+	return &Watcher{
+		load:   func() (Config, error) { return Load(mode) },
+		config: Default(),
+	}
+}
+
+// Config returns the current metering config
+func (w *Watcher) Config() Config {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.config
+}
+
+// update loads the config, the current config is kept on error
+func (w *Watcher) update() error {
+	config, err := w.load()
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.config = config
+	return nil
+}
+
+// Run loads the config and keeps it up to date until ctx is cancelled
+func (w *Watcher) Run(ctx context.Context) {
+	for {
+		wait := refreshInterval
+		if err := w.update(); err != nil {
+			log.Error().Err(err).Msg("failed to load metering config, keeping current config")
+			wait = retryInterval
+		} else {
+			log.Info().Interface("config", w.Config()).Msg("network metering config")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
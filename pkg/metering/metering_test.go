@@ -0,0 +1,124 @@
+package metering
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zosbase/pkg"
+)
+
+func TestNU(t *testing.T) {
+	require := require.New(t)
+
+	metric := pkg.MachineMetric{
+		Public:  pkg.NetMetric{NetRxBytes: 100, NetTxBytes: 200, NetRxPackets: 10, NetTxPackets: 20},
+		Private: pkg.NetMetric{NetRxBytes: 1000, NetTxBytes: 2000},
+	}
+
+	nu := func(config Config) map[string]float64 {
+		result := make(map[string]float64)
+		for _, sample := range Samples(metric) {
+			result[sample.Class+"/"+sample.Direction] += config.NU(sample.Class, sample.Direction, sample.Unit, sample.Value)
+		}
+		return result
+	}
+
+	require.Equal(map[string]float64{
+		"public/rx":  100,
+		"public/tx":  200,
+		"private/rx": 0,
+		"private/tx": 0,
+	}, nu(Default()))
+
+	require.Equal(map[string]float64{
+		"public/rx":  110,
+		"public/tx":  0,
+		"private/rx": 0,
+		"private/tx": 1000,
+	}, nu(Config{
+		Classes: map[string]Class{
+			ClassPublic:  {Rx: Weights{Bytes: 1, Packets: 1}},
+			ClassPrivate: {Tx: Weights{Bytes: 0.5}},
+		},
+	}))
+}
+
+func TestTraffic(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{Classes: map[string]Class{ClassPublic: {Tx: Weights{Bytes: 2}}}}
+
+	// weights apply to the increase of raw counters
+	workload, class, direction, nu, ok := config.Traffic(TrafficKey("1-2-vm", ClassPublic, DirectionTx, UnitBytes), 10)
+	require.True(ok)
+	require.Equal("1-2-vm", workload)
+	require.Equal(ClassPublic, class)
+	require.Equal(DirectionTx, direction)
+	require.Equal(20.0, nu)
+
+	// keys stored before the raw counters are nu already
+	_, _, _, nu, ok = config.Traffic(Key("1-2-vm", ClassPublic, DirectionTx), 10)
+	require.True(ok)
+	require.Equal(10.0, nu)
+
+	_, _, _, _, ok = config.Traffic(Key("1-2-vm", ResourceGPU, CounterSeconds), 10)
+	require.False(ok)
+}
+
+func TestDecode(t *testing.T) {
+	require := require.New(t)
+
+	config, err := decode([]byte(`{"registrar_url": "https://example.com"}`))
+	require.NoError(err)
+	require.Equal(Default(), config)
+
+	config, err = decode([]byte(`{"metering": {"classes": {"private": {"rx": {"bytes": 2}}}}}`))
+	require.NoError(err)
+	require.Equal(Config{Classes: map[string]Class{ClassPrivate: {Rx: Weights{Bytes: 2}}}}, config)
+
+	// nothing is metered
+	config, err = decode([]byte(`{"metering": {"classes": {}}}`))
+	require.NoError(err)
+	require.Empty(config.Classes)
+
+	_, err = decode([]byte(`{"metering": {"classes": {"other": {}}}}`))
+	require.Error(err)
+
+	_, err = decode([]byte(`{"metering": {"classes": {"public": {"tx": {"bytes": -1}}}}}`))
+	require.Error(err)
+}
+
+func TestKey(t *testing.T) {
+	require := require.New(t)
+
+	key := Key("1-2-vm", ClassPublic, DirectionTx)
+	workload, class, direction, ok := ParseKey(key)
+	require.True(ok)
+	require.Equal("1-2-vm", workload)
+	require.Equal(ClassPublic, class)
+	require.Equal(DirectionTx, direction)
+
+	_, _, _, ok = ParseKey("1-2-vm")
+	require.False(ok)
+}
+
+func TestWatcher(t *testing.T) {
+	require := require.New(t)
+
+	private := Config{Classes: map[string]Class{ClassPrivate: {Tx: Weights{Bytes: 1}}}}
+	var err error
+	w := Watcher{
+		load:   func() (Config, error) { return private, err },
+		config: Default(),
+	}
+
+	require.Equal(Default(), w.Config())
+	require.NoError(w.update())
+	require.Equal(private, w.Config())
+
+	// the last loaded config is kept
+	err = fmt.Errorf("connection refused")
+	require.Error(w.update())
+	require.Equal(private, w.Config())
+}
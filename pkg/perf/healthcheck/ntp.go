@@ -0,0 +1,70 @@
+package healthcheck
+
+import (
+	"context"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/stubs"
+	"github.com/threefoldtech/zosbase/pkg/perf"
+	"github.com/threefoldtech/zosbase/pkg/zinit"
+)
+
+const ntpInterval = time.Minute
+
+// RunNTPCheck checks the node clock against the registrar clock every
+// minute and restarts ntp if the clock is too far off. The registrar time is
+// measured by the registrar gateway, which also keeps the skew for the
+// uptime reports.
+func RunNTPCheck(ctx context.Context) {
+	operation := func() error {
+		return ntpCheck(ctx)
+	}
+
+	go func() {
+		for {
+			exp := backoff.NewExponentialBackOff()
+			retryNotify := func(err error, d time.Duration) {
+				log.Error().Err(err).Msg("failed to run ntp check")
+			}
+
+			if err := backoff.RetryNotify(operation, backoff.WithContext(exp, ctx), retryNotify); err != nil {
+				log.Error().Err(err).Send()
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(ntpInterval):
+			}
+		}
+	}()
+}
+
+func ntpCheck(ctx context.Context) error {
+	zcl, err := perf.TryGetZbusClient(ctx)
+	if err != nil {
+		return errors.Wrap(err, "ntp check expects zbus client in the context")
+	}
+
+	gw := stubs.NewRegistrarGatewayStub(zcl)
+	registrarTime, rerr := gw.GetTime(ctx)
+	if err := rerr.Err(); err != nil {
+		return err
+	}
+
+	skew := time.Until(registrarTime).Abs()
+	if skew <= zos4pkg.ClockSkewMax {
+		return nil
+	}
+
+	log.Warn().Dur("skew", skew).Msg("node clock is too far off, restarting ntp")
+	if err := zinit.Default().Kill("ntp", zinit.SIGTERM); err != nil {
+		return errors.Wrap(err, "failed to restart ntpd")
+	}
+
+	return nil
+}
@@ -97,8 +97,8 @@ func EnsureWakeOnLan(ctx context.Context) (bool, error) {
 }
 
 func (p *PowerServer) syncSelf() error {
-	power, err := p.registrarGateway.GetPowerTarget(context.Background())
-	if err != nil {
+	power, rerr := p.registrarGateway.GetPowerTarget(context.Background())
+	if err := rerr.Err(); err != nil {
 		return err
 	}
 
@@ -189,8 +189,8 @@ func (p *PowerServer) event(event *pkg.PowerTargetChangeEvent) error {
 		Uint32("node", p.node).
 		Msg("received power event for farm")
 
-	node, err := p.registrarGateway.GetNode(context.Background(), uint64(event.NodeID))
-	if err != nil {
+	node, rerr := p.registrarGateway.GetNode(context.Background(), uint64(event.NodeID))
+	if err := rerr.Err(); err != nil {
 		return err
 	}
 
@@ -232,8 +232,8 @@ func (p *PowerServer) setNodePowerState(up bool) error {
 	*/
 
 	up = !p.enabled || up
-	power, err := p.registrarGateway.GetPowerTarget(context.Background())
-	if err != nil {
+	power, rerr := p.registrarGateway.GetPowerTarget(context.Background())
+	if err := rerr.Err(); err != nil {
 		return errors.Wrap(err, "failed to check power state")
 	}
 
@@ -244,8 +244,14 @@ func (p *PowerServer) setNodePowerState(up bool) error {
 
 	log.Info().Bool("state", up).Msg("setting node power state")
 	// this to make sure node state is fixed also for nodes
-	_, err = p.registrarGateway.SetNodePowerState(context.Background(), up)
-	return err
+	_, rerr = p.registrarGateway.SetNodePowerState(context.Background(), up)
+	if rerr.IsQueued() {
+		// the gateway sends the latest power state once the registrar is back
+		log.Warn().Bool("state", up).Msg("node power state update is queued")
+		return nil
+	}
+
+	return rerr.Err()
 }
 
 func (p *PowerServer) recv(ctx context.Context) error {
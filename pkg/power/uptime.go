@@ -10,6 +10,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/shirou/gopsutil/host"
+	"github.com/threefoldtech/zos4/pkg/decommission"
 	"github.com/threefoldtech/zos4/pkg/stubs"
 	"github.com/threefoldtech/zosbase/pkg/app"
 	"github.com/threefoldtech/zosbase/pkg/utils"
@@ -24,18 +25,25 @@ type Uptime struct {
 	Mark utils.Mark
 
 	registrarGateway *stubs.RegistrarGatewayStub
+	identity         *stubs.IdentityManagerStub
 	m                sync.Mutex
 }
 
-func NewUptime(registrarGateway *stubs.RegistrarGatewayStub) (*Uptime, error) {
+func NewUptime(registrarGateway *stubs.RegistrarGatewayStub, identity *stubs.IdentityManagerStub) (*Uptime, error) {
 	return &Uptime{
 		// id:               id,
 		registrarGateway: registrarGateway,
+		identity:         identity,
 		Mark:             utils.NewMark(),
 	}, nil
 }
 
 func (u *Uptime) SendNow() error {
+	if decommission.DecommissionedNode(context.Background(), u.identity) {
+		log.Info().Msg("node is decommissioned skipping uptime reports")
+		return nil
+	}
+
 	if !isNodeHealthy() {
 		log.Error().Msg("node is not healthy skipping uptime reports")
 		return nil
@@ -55,7 +63,13 @@ func (u *Uptime) SendNow() error {
 		return errors.Wrap(err, "failed to get uptime")
 	}
 
-	return u.registrarGateway.UpdateNodeUptimeV2(context.Background(), uptime, time.Now().Unix())
+	rerr := u.registrarGateway.UpdateNodeUptimeV2(context.Background(), uptime, time.Now().Unix())
+	if rerr.IsQueued() {
+		log.Warn().Uint64("uptime", uptime).Msg("uptime report is queued")
+		return nil
+	}
+
+	return rerr.Err()
 }
 
 func (u *Uptime) uptime(ctx context.Context) error {
@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"github.com/threefoldtech/zosbase/pkg"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+)
+
+//go:generate zbusc -module provision -version 0.0.1 -name provision -package stubs github.com/threefoldtech/zos4/pkg+Provision stubs/provision_stub.go
+
+// HistoryQuery filters and paginates deployments and their change history.
+// All filters are optional, an empty query returns the first page of everything.
+type HistoryQuery struct {
+	// Cursor is the `Next` value of the previous page, 0 starts from the beginning
+	Cursor uint64 `json:"cursor"`
+	// Limit is the max number of items in a page, 0 means default page size
+	Limit uint32 `json:"limit"`
+	// Names only include workloads with one of these names
+	Names []gridtypes.Name `json:"names"`
+	// States only include workloads in one of these states
+	States []gridtypes.ResultState `json:"states"`
+	// From only include workloads (or transitions) created at or after this time
+	From gridtypes.Timestamp `json:"from"`
+	// To only include workloads (or transitions) created at or before this time
+	To gridtypes.Timestamp `json:"to"`
+	// Summary drops workloads data and results data from the response
+	Summary bool `json:"summary"`
+}
+
+// DeploymentsPage is a single page of deployments
+type DeploymentsPage struct {
+	Deployments []gridtypes.Deployment `json:"deployments"`
+	// Next is the cursor of the next page
+	Next uint64 `json:"next"`
+	// More is true if there are more items after this page
+	More bool `json:"more"`
+}
+
+// ChangesPage is a single page of a deployment transaction history
+type ChangesPage struct {
+	Changes []gridtypes.Workload `json:"changes"`
+	// Next is the cursor of the next page
+	Next uint64 `json:"next"`
+	// More is true if there are more items after this page
+	More bool `json:"more"`
+}
+
+// ProvisionState is the state of the provision engine
+type ProvisionState string
+
+const (
+	// ProvisionActive the engine is running and accepting deployments
+	ProvisionActive ProvisionState = "active"
+	// ProvisionOrphan the node has no valid farm, nothing can be provisioned
+	ProvisionOrphan ProvisionState = "orphan node"
+	// ProvisionDraining the node is being decommissioned, existing
+	// deployments can still be updated but new ones are rejected
+	ProvisionDraining ProvisionState = "draining"
+)
+
+// Provision interface extends the base provision interface with
+// paginated and filtered listing.
+type Provision interface {
+	pkg.Provision
+
+	// ListPaged lists active deployments of a twin ordered by contract id
+	ListPaged(twin uint32, query HistoryQuery) (DeploymentsPage, error)
+	// ChangesPaged returns the transaction history of a deployment oldest first
+	ChangesPaged(twin uint32, contractID uint64, query HistoryQuery) (ChangesPage, error)
+	// Status returns the state of the provision engine
+	Status() ProvisionState
+	// Drain starts (or stops) draining the node, the state is kept over
+	// reboots
+	Drain(draining bool) error
+	// ActiveDeployments returns the number of active deployments of all twins
+	ActiveDeployments() (uint32, error)
+	// DeprovisionAll schedules all active deployments for deprovision
+	DeprovisionAll(reason string) error
+}
@@ -0,0 +1,273 @@
+// Package api implements the node local REST api described by the zos-api.yml
+// spec. It's a direct transport to the provision engine that does not need RMB.
+// Calls on deployments must be signed by the owning twin, see Sign for how
+// requests are signed.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos4/pkg/provision"
+	"github.com/threefoldtech/zosbase/pkg"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+	zbprovision "github.com/threefoldtech/zosbase/pkg/provision"
+)
+
+const (
+	specFile  = "zos-api.yml"
+	indexFile = "index.html"
+)
+
+// Engine is the part of the provision engine used by the api
+type Engine interface {
+	CreateOrUpdate(twin uint32, deployment gridtypes.Deployment, update bool) error
+	Get(twin uint32, contractID uint64) (gridtypes.Deployment, error)
+	ListPublicIPs() ([]string, error)
+}
+
+// Networker is the part of the network module used by the api
+type Networker interface {
+	WireguardPorts(ctx context.Context) ([]uint, error)
+	LoadPublicConfig(ctx context.Context) (pkg.PublicConfig, error)
+	SetPublicConfig(ctx context.Context, cfg pkg.PublicConfig) error
+}
+
+// Counters is the part of the statistics used by the api
+type Counters interface {
+	GetCounters() (pkg.Counters, error)
+}
+
+// Server is the node REST api server
+type Server struct {
+	engine  Engine
+	network Networker
+	stats   Counters
+	twins   zbprovision.Twins
+	admins  zbprovision.Twins
+	swagger fs.FS
+	seen    *replayCache
+}
+
+// NewServer creates a new api server. Deployments calls are authenticated
+// against twins, while node configuration calls are authenticated against
+// admins. swagger is the file system that holds the swagger ui and the api spec
+func NewServer(
+	engine Engine,
+	network Networker,
+	stats Counters,
+	twins zbprovision.Twins,
+	admins zbprovision.Twins,
+	swagger fs.FS,
+) *Server {
+	return &Server{
+		engine:  engine,
+		network: network,
+		stats:   stats,
+		twins:   newLimitedTwins(twins),
+		admins:  newLimitedTwins(admins),
+		swagger: swagger,
+		seen:    newReplayCache(),
+	}
+}
+
+// Handler returns the api http handler
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /api/v1/deployment", authenticated(s.twins, s.seen, s.createDeployment))
+	mux.HandleFunc("PUT /api/v1/deployment", authenticated(s.twins, s.seen, s.updateDeployment))
+	mux.HandleFunc("GET /api/v1/deployment/{twin}/{id}", authenticated(s.twins, s.seen, s.getDeployment))
+	mux.HandleFunc("DELETE /api/v1/deployment/{twin}/{id}", s.deleteDeployment)
+
+	mux.HandleFunc("GET /api/v1/network/wireguard", s.listWireguardPorts)
+	mux.HandleFunc("GET /api/v1/network/publicips", s.listPublicIPs)
+	mux.HandleFunc("GET /api/v1/network/config/public", s.getPublicConfig)
+	mux.HandleFunc("POST /api/v1/network/config/public", authenticated(s.admins, s.seen, s.setPublicConfig))
+
+	mux.HandleFunc("GET /api/v1/counters", s.counters)
+
+	// swagger ui, the index expects its assets (and the spec) under v1/
+	mux.HandleFunc("GET /api/{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, s.swagger, indexFile)
+	})
+	mux.HandleFunc("GET /api/v1/{file}", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, s.swagger, r.PathValue("file"))
+	})
+
+	return mux
+}
+
+// Run starts the api server on the listen address until ctx is cancelled
+func (s *Server) Run(ctx context.Context, listen string) error {
+	server := http.Server{
+		Addr:              listen,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdown); err != nil {
+			log.Error().Err(err).Msg("failed to shutdown api server")
+		}
+	}()
+
+	log.Info().Str("listen", listen).Msg("starting api server")
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if v == nil {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Err(err).Msg("failed to write api response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{Error: err.Error()})
+}
+
+func (s *Server) deployment(w http.ResponseWriter, r *http.Request, update bool) {
+	var deployment gridtypes.Deployment
+	if err := json.NewDecoder(r.Body).Decode(&deployment); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.engine.CreateOrUpdate(getTwin(r), deployment, update); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, nil)
+}
+
+func (s *Server) createDeployment(w http.ResponseWriter, r *http.Request) {
+	s.deployment(w, r, false)
+}
+
+func (s *Server) updateDeployment(w http.ResponseWriter, r *http.Request) {
+	s.deployment(w, r, true)
+}
+
+// deploymentID parses the deployment path and makes sure it's
+// owned by the authenticated twin
+func deploymentID(w http.ResponseWriter, r *http.Request) (uint32, uint64, bool) {
+	twin, err := strconv.ParseUint(r.PathValue("twin"), 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid twin id"))
+		return 0, 0, false
+	}
+
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid deployment id"))
+		return 0, 0, false
+	}
+
+	if uint32(twin) != getTwin(r) {
+		writeError(w, http.StatusForbidden, errors.New("deployment is not owned by the calling twin"))
+		return 0, 0, false
+	}
+
+	return uint32(twin), id, true
+}
+
+func (s *Server) getDeployment(w http.ResponseWriter, r *http.Request) {
+	twin, id, ok := deploymentID(w, r)
+	if !ok {
+		return
+	}
+
+	deployment, err := s.engine.Get(twin, id)
+	if errors.Is(err, provision.ErrDeploymentNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deployment)
+}
+
+// deleteDeployment is disabled like on the rmb api, the workloads would be
+// gone while the contract is still active and billed
+func (s *Server) deleteDeployment(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, errors.New("deletion over the api is disabled, please cancel your contract instead"))
+}
+
+func (s *Server) listWireguardPorts(w http.ResponseWriter, r *http.Request) {
+	ports, err := s.network.WireguardPorts(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ports)
+}
+
+func (s *Server) listPublicIPs(w http.ResponseWriter, r *http.Request) {
+	ips, err := s.engine.ListPublicIPs()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ips)
+}
+
+func (s *Server) getPublicConfig(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.network.LoadPublicConfig(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, cfg)
+}
+
+func (s *Server) setPublicConfig(w http.ResponseWriter, r *http.Request) {
+	var cfg pkg.PublicConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.network.SetPublicConfig(r.Context(), cfg); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, nil)
+}
+
+func (s *Server) counters(w http.ResponseWriter, r *http.Request) {
+	counters, err := s.stats.GetCounters()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, counters)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos4/pkg/provision"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+)
+
+type testTwins map[uint32]ed25519.PublicKey
+
+func (t testTwins) GetKey(id uint32) ([]byte, error) {
+	key, ok := t[id]
+	if !ok {
+		return nil, fmt.Errorf("twin not found")
+	}
+	return key, nil
+}
+
+type testEngine struct {
+	Engine
+}
+
+func (e *testEngine) Get(twin uint32, contractID uint64) (gridtypes.Deployment, error) {
+	if contractID != 10 {
+		return gridtypes.Deployment{}, provision.ErrDeploymentNotFound
+	}
+	return gridtypes.Deployment{TwinID: twin, ContractID: contractID}, nil
+}
+
+func TestAuthentication(t *testing.T) {
+	require := require.New(t)
+
+	pk, sk, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+	_, other, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+
+	var engine testEngine
+	server := NewServer(&engine, nil, nil, testTwins{1: pk, 2: pk}, testTwins{}, fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte("swagger")},
+	})
+	handler := server.Handler()
+
+	do := func(method, path string, twin uint32, sk ed25519.PrivateKey) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, bytes.NewBufferString("{}"))
+		if sk != nil {
+			require.NoError(Sign(request, twin, sk))
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	require.Equal(http.StatusUnauthorized, do(http.MethodGet, "/api/v1/deployment/1/10", 1, nil).Code)
+	require.Equal(http.StatusUnauthorized, do(http.MethodGet, "/api/v1/deployment/1/10", 1, other).Code)
+	require.Equal(http.StatusUnauthorized, do(http.MethodGet, "/api/v1/deployment/1/10", 3, sk).Code)
+	require.Equal(http.StatusForbidden, do(http.MethodGet, "/api/v1/deployment/1/10", 2, sk).Code)
+	require.Equal(http.StatusNotFound, do(http.MethodGet, "/api/v1/deployment/1/11", 1, sk).Code)
+	require.Equal(http.StatusOK, do(http.MethodGet, "/api/v1/deployment/1/10", 1, sk).Code)
+
+	// a signed request can't be sent again
+	request := httptest.NewRequest(http.MethodGet, "/api/v1/deployment/1/10", nil)
+	require.NoError(Sign(request, 1, sk))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	require.Equal(http.StatusOK, response.Code)
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	require.Equal(http.StatusUnauthorized, response.Code)
+
+	// deletion is disabled, the contract must be cancelled instead
+	require.Equal(http.StatusMethodNotAllowed, do(http.MethodDelete, "/api/v1/deployment/1/10", 1, sk).Code)
+
+	// only farmer can set public config
+	require.Equal(http.StatusUnauthorized, do(http.MethodPost, "/api/v1/network/config/public", 1, sk).Code)
+
+	// a signature can't be used for another request
+	request = httptest.NewRequest(http.MethodGet, "/api/v1/deployment/1/10", nil)
+	require.NoError(Sign(request, 1, sk))
+	request.URL.Path = "/api/v1/deployment/1/11"
+	_, err = verify(request, testTwins{1: pk})
+	require.Error(err)
+
+	response = do(http.MethodGet, "/api/", 0, nil)
+	require.Equal(http.StatusOK, response.Code)
+	require.Equal("swagger", response.Body.String())
+}
+
+type countingTwins struct {
+	testTwins
+	calls int
+}
+
+func (t *countingTwins) GetKey(id uint32) ([]byte, error) {
+	t.calls++
+	return t.testTwins.GetKey(id)
+}
+
+func TestLimitedTwins(t *testing.T) {
+	require := require.New(t)
+
+	pk, _, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+
+	backend := &countingTwins{testTwins: testTwins{1: pk}}
+	twins := newLimitedTwins(backend)
+
+	// known and unknown keys are cached
+	for i := 0; i < 3; i++ {
+		key, err := twins.GetKey(1)
+		require.NoError(err)
+		require.EqualValues(pk, key)
+
+		_, err = twins.GetKey(2)
+		require.Error(err)
+	}
+	require.Equal(2, backend.calls)
+
+	// lookups of unknown twins are limited, 2 lookups are already used
+	for id := uint32(3); id <= maxLookups; id++ {
+		_, err := twins.GetKey(id)
+		require.Error(err)
+	}
+	_, err = twins.GetKey(100)
+	require.ErrorIs(err, errTooManyLookups)
+	require.Equal(maxLookups, backend.calls)
+
+	// cached keys are still served
+	_, err = twins.GetKey(1)
+	require.NoError(err)
+}
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zosbase/pkg/provision"
+)
+
+const (
+	// HeaderTwinID is the header that holds the id of the calling twin
+	HeaderTwinID = "X-Twin-Id"
+	// HeaderTimestamp is the header that holds the unix timestamp of the request
+	HeaderTimestamp = "X-Timestamp"
+	// HeaderSignature is the header that holds the base64 encoded ed25519
+	// signature of the request challenge
+	HeaderSignature = "X-Signature"
+
+	// maxSkew is the max allowed difference between the request timestamp
+	// and the node time
+	maxSkew = 5 * time.Minute
+	// maxBodySize of any request
+	maxBodySize = 4 * 1024 * 1024
+
+	// keyTTL is how long a looked up twin key is cached
+	keyTTL = 10 * time.Minute
+	// missTTL is how long a failed twin key lookup is cached
+	missTTL = time.Minute
+	// maxLookups is the number of uncached twin key lookups allowed per
+	// lookupWindow, the rest are rejected without calling the registrar
+	maxLookups   = 10
+	lookupWindow = time.Second
+)
+
+var errTooManyLookups = fmt.Errorf("too many requests, try again later")
+
+type twinKey struct{}
+
+// replayCache keeps the signatures of accepted requests until their timestamp
+// is out of the allowed window, so a captured request can't be sent again.
+type replayCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{seen: make(map[string]time.Time)}
+}
+
+// accept records the signature of a request signed at timestamp. It returns
+// false if the signature was already used.
+func (c *replayCache) accept(signature string, timestamp int64) bool {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, expiry := range c.seen {
+		if now.After(expiry) {
+			delete(c.seen, key)
+		}
+	}
+
+	if _, ok := c.seen[signature]; ok {
+		return false
+	}
+
+	c.seen[signature] = time.Unix(timestamp, 0).Add(maxSkew)
+	return true
+}
+
+type cachedKey struct {
+	key    []byte
+	err    error
+	expiry time.Time
+}
+
+// limitedTwins caches twin keys and limits the lookups of unknown twins, so
+// unauthenticated requests with random twin ids can't flood the registrar.
+type limitedTwins struct {
+	twins provision.Twins
+
+	mu      sync.Mutex
+	keys    map[uint32]cachedKey
+	window  time.Time
+	lookups int
+}
+
+func newLimitedTwins(twins provision.Twins) *limitedTwins {
+	return &limitedTwins{twins: twins, keys: make(map[uint32]cachedKey)}
+}
+
+// allow checks if one more lookup is allowed in the current window
+func (t *limitedTwins) allow(now time.Time) bool {
+	if now.Sub(t.window) >= lookupWindow {
+		t.window = now
+		t.lookups = 0
+	}
+
+	if t.lookups >= maxLookups {
+		return false
+	}
+
+	t.lookups++
+	return true
+}
+
+// GetKey implements provision.Twins
+func (t *limitedTwins) GetKey(id uint32) ([]byte, error) {
+	now := time.Now()
+
+	t.mu.Lock()
+	if cached, ok := t.keys[id]; ok && now.Before(cached.expiry) {
+		t.mu.Unlock()
+		return cached.key, cached.err
+	}
+
+	if !t.allow(now) {
+		t.mu.Unlock()
+		return nil, errTooManyLookups
+	}
+	t.mu.Unlock()
+
+	key, err := t.twins.GetKey(id)
+	ttl := keyTTL
+	if err != nil {
+		ttl = missTTL
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for cachedID, cached := range t.keys {
+		if now.After(cached.expiry) {
+			delete(t.keys, cachedID)
+		}
+	}
+	t.keys[id] = cachedKey{key: key, err: err, expiry: now.Add(ttl)}
+
+	return key, err
+}
+
+// challenge builds the signed message of a request. The signature covers the
+// method, the request uri, the timestamp, the twin and a hash of the body so
+// a captured signature can't be used for a different request.
+func challenge(method, uri string, timestamp int64, twin uint32, body []byte) []byte {
+	hash := sha256.Sum256(body)
+	return []byte(fmt.Sprintf("%s\n%s\n%d\n%d\n%x", method, uri, timestamp, twin, hash))
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	// restore the body so it can be read again
+	r.Body = io.NopCloser(bytes.NewBuffer(body))
+	return body, nil
+}
+
+// Sign sets the authentication headers on the request. It's meant
+// to be used by clients of the api.
+func Sign(r *http.Request, twin uint32, sk ed25519.PrivateKey) error {
+	body, err := readBody(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to read request body")
+	}
+
+	now := time.Now().Unix()
+	signature := ed25519.Sign(sk, challenge(r.Method, r.URL.RequestURI(), now, twin, body))
+
+	r.Header.Set(HeaderTwinID, fmt.Sprint(twin))
+	r.Header.Set(HeaderTimestamp, fmt.Sprint(now))
+	r.Header.Set(HeaderSignature, base64.StdEncoding.EncodeToString(signature))
+
+	return nil
+}
+
+// verify checks the request signature against the twin key. On success
+// the id of the twin that signed the request is returned. The request shape
+// is validated before the twin key is looked up.
+func verify(r *http.Request, twins provision.Twins) (uint32, error) {
+	twin, err := strconv.ParseUint(r.Header.Get(HeaderTwinID), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid or missing twin id header")
+	}
+
+	timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid or missing timestamp header")
+	}
+
+	if skew := time.Since(time.Unix(timestamp, 0)); skew > maxSkew || skew < -maxSkew {
+		return 0, fmt.Errorf("request timestamp is out of the allowed window")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get(HeaderSignature))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return 0, fmt.Errorf("invalid or missing signature header")
+	}
+
+	key, err := twins.GetKey(uint32(twin))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get twin key")
+	}
+
+	if len(key) != ed25519.PublicKeySize {
+		return 0, fmt.Errorf("invalid twin key")
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read request body")
+	}
+
+	msg := challenge(r.Method, r.URL.RequestURI(), timestamp, uint32(twin), body)
+	if !ed25519.Verify(ed25519.PublicKey(key), msg, signature) {
+		return 0, fmt.Errorf("invalid signature")
+	}
+
+	return uint32(twin), nil
+}
+
+// authenticated wraps the handler so it's only called for requests signed
+// by one of the given twins. Each signed request is only accepted once.
+func authenticated(twins provision.Twins, seen *replayCache, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		twin, err := verify(r, twins)
+		if errors.Is(err, errTooManyLookups) {
+			writeError(w, http.StatusTooManyRequests, err)
+			return
+		} else if err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+
+		// the timestamp is already validated by verify
+		timestamp, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if !seen.accept(r.Header.Get(HeaderSignature), timestamp) {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("request was already used"))
+			return
+		}
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), twinKey{}, twin)))
+	}
+}
+
+// getTwin returns the id of the authenticated twin
+func getTwin(r *http.Request) uint32 {
+	twin, _ := r.Context().Value(twinKey{}).(uint32)
+	return twin
+}
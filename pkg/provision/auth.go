@@ -35,8 +35,8 @@ func (s *registrarTwins) GetKey(id uint32) ([]byte, error) {
 	if value, ok := s.mem.Get(id); ok {
 		return value.([]byte), nil
 	}
-	user, err := s.registrarGateway.GetTwin(context.Background(), uint64(id))
-	if err != nil {
+	user, rerr := s.registrarGateway.GetTwin(context.Background(), uint64(id))
+	if err := rerr.Err(); err != nil {
 		return nil, errors.Wrapf(err, "could not get user with id '%d'", id)
 	}
 
@@ -57,13 +57,13 @@ type registrarAdmins struct {
 // NewRegistrarAdmins creates a twins db that implements the provision.Users interface.
 // but it also make sure the user is an admin
 func NewRegistrarAdmins(registrarGateway *stubs.RegistrarGatewayStub, farmID uint64) (provision.Twins, error) {
-	farm, err := registrarGateway.GetFarm(context.Background(), farmID)
-	if err != nil {
+	farm, rerr := registrarGateway.GetFarm(context.Background(), farmID)
+	if err := rerr.Err(); err != nil {
 		return nil, errors.Wrap(err, "failed to get farm")
 	}
 
-	twin, err := registrarGateway.GetTwin(context.Background(), farm.TwinID)
-	if err != nil {
+	twin, rerr := registrarGateway.GetTwin(context.Background(), farm.TwinID)
+	if err := rerr.Err(); err != nil {
 		return nil, err
 	}
 
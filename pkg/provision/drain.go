@@ -0,0 +1,84 @@
+package provision
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// drainingFile marks the engine as draining, it's kept in the engine root
+// so draining survives a reboot
+const drainingFile = "draining"
+
+func isDraining(root string) bool {
+	_, err := os.Stat(filepath.Join(root, drainingFile))
+	return err == nil
+}
+
+// Drain implements zos4 pkg.Provision
+func (n *NativeEngine) Drain(draining bool) error {
+	path := filepath.Join(n.root, drainingFile)
+	if draining {
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			return errors.Wrap(err, "failed to mark node as draining")
+		}
+	} else if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to unmark node as draining")
+	}
+
+	log.Info().Bool("draining", draining).Msg("provision engine draining state changed")
+	n.draining.Store(draining)
+	return nil
+}
+
+// active calls fn with each active deployment of all twins
+func (n *NativeEngine) active(fn func(twin uint32, id uint64) error) error {
+	twins, err := n.storage.Twins()
+	if err != nil {
+		return errors.Wrap(err, "failed to list twins")
+	}
+
+	for _, twin := range twins {
+		ids, err := n.storage.ByTwin(twin)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list deployments of twin %d", twin)
+		}
+
+		for _, id := range ids {
+			deployment, err := n.storage.Get(twin, id)
+			if err != nil {
+				return errors.Wrapf(err, "failed to get deployment %d", id)
+			}
+
+			if !deployment.IsActive() {
+				continue
+			}
+
+			if err := fn(twin, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ActiveDeployments implements zos4 pkg.Provision
+func (n *NativeEngine) ActiveDeployments() (count uint32, err error) {
+	err = n.active(func(uint32, uint64) error {
+		count++
+		return nil
+	})
+
+	return count, err
+}
+
+// DeprovisionAll implements zos4 pkg.Provision
+func (n *NativeEngine) DeprovisionAll(reason string) error {
+	return n.active(func(twin uint32, id uint64) error {
+		return n.Deprovision(context.Background(), twin, id, reason)
+	})
+}
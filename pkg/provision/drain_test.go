@@ -0,0 +1,66 @@
+package provision
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/provision/storage"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes/zos"
+)
+
+func TestDrain(t *testing.T) {
+	require := require.New(t)
+
+	root := t.TempDir()
+	store, err := storage.New(filepath.Join(t.TempDir(), "storage.db"))
+	require.NoError(err)
+	defer store.Close()
+
+	create := func(twin uint32, contract uint64, state gridtypes.ResultState) {
+		wl := gridtypes.Workload{
+			Name: "disk",
+			Type: zos.ZMountType,
+			Data: json.RawMessage(`{"size": 1073741824}`),
+		}
+		require.NoError(store.Create(gridtypes.Deployment{
+			TwinID:     twin,
+			ContractID: contract,
+			Workloads:  []gridtypes.Workload{wl},
+		}))
+
+		wl.Result = gridtypes.Result{Created: gridtypes.Now(), State: state}
+		require.NoError(store.Transaction(twin, contract, wl))
+	}
+
+	create(1, 1, gridtypes.StateOk)
+	create(1, 2, gridtypes.StateDeleted)
+	create(2, 3, gridtypes.StateOk)
+
+	engine, err := New(store, nil, root)
+	require.NoError(err)
+	require.Equal(pkg.ProvisionActive, engine.Status())
+
+	count, err := engine.ActiveDeployments()
+	require.NoError(err)
+	require.EqualValues(2, count)
+
+	require.NoError(engine.Drain(true))
+	require.Equal(pkg.ProvisionDraining, engine.Status())
+	require.ErrorIs(engine.CreateOrUpdate(3, gridtypes.Deployment{TwinID: 3}, false), ErrNodeDraining)
+
+	// draining is kept over restarts
+	engine.queue.Close()
+	engine, err = New(store, nil, root)
+	require.NoError(err)
+	require.Equal(pkg.ProvisionDraining, engine.Status())
+
+	require.NoError(engine.DeprovisionAll("node is decommissioned"))
+	require.Equal(2, engine.queue.Size())
+
+	require.NoError(engine.Drain(false))
+	require.Equal(pkg.ProvisionActive, engine.Status())
+}
@@ -10,6 +10,8 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v3"
@@ -18,6 +20,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/maintenance"
 	"github.com/threefoldtech/zos4/pkg/stubs"
 	"github.com/threefoldtech/zosbase/pkg"
 	"github.com/threefoldtech/zosbase/pkg/environment"
@@ -72,6 +76,22 @@ func WithCallback(cb Callback) EngineOption {
 	return &withCallback{cb}
 }
 
+// WithMaintenance confines disruptive operations to the maintenance windows
+// of the given schedule. Disruptive jobs received outside a window are kept
+// in a separate queue until the next window opens. Deprovisions are never
+// deferred.
+func WithMaintenance(s maintenance.Schedule) EngineOption {
+	return &withMaintenance{s}
+}
+
+// ErrDeploymentNotFound is returned by the engine public API if
+// the requested deployment does not exist
+var ErrDeploymentNotFound = fmt.Errorf("deployment not found")
+
+// ErrNodeDraining is returned on new deployments while the node is
+// being decommissioned
+var ErrNodeDraining = fmt.Errorf("node is draining: new deployments are not accepted")
+
 type jobOperation int
 
 const (
@@ -128,11 +148,22 @@ type NativeEngine struct {
 	nodeID           uint64
 	registrarGateway *stubs.RegistrarGatewayStub
 	callback         Callback
+
+	maintenance *maintenance.Schedule
+	deferred    *dque.DQue
+
+	// updates serializes the validation of updates against the pending ones
+	updates sync.Mutex
+	pending *pendingUpdates
+
+	root     string
+	draining atomic.Bool
 }
 
 var (
-	_ provision.Engine = (*NativeEngine)(nil)
-	_ pkg.Provision    = (*NativeEngine)(nil)
+	_ provision.Engine  = (*NativeEngine)(nil)
+	_ pkg.Provision     = (*NativeEngine)(nil)
+	_ zos4pkg.Provision = (*NativeEngine)(nil)
 )
 
 type withUserKeyGetter struct {
@@ -204,6 +235,18 @@ func (w *withCallback) apply(e *NativeEngine) {
 	e.callback = w.cb
 }
 
+type withMaintenance struct {
+	s maintenance.Schedule
+}
+
+func (w *withMaintenance) apply(e *NativeEngine) {
+	if !w.s.Configured() {
+		return
+	}
+
+	e.maintenance = &w.s
+}
+
 type nullKeyGetter struct{}
 
 func (n *nullKeyGetter) GetKey(id uint32) ([]byte, error) {
@@ -309,8 +352,11 @@ func New(storage provision.Storage, provisioner provision.Provisioner, root stri
 		admins:      &nullKeyGetter{},
 		order:       gridtypes.Types(),
 		typeIndex:   make(map[gridtypes.WorkloadType]int),
+		root:        root,
 	}
 
+	e.draining.Store(isDraining(root))
+
 	for _, opt := range opts {
 		opt.apply(e)
 	}
@@ -328,6 +374,24 @@ func New(storage provision.Storage, provisioner provision.Provisioner, root stri
 	}
 
 	e.queue = queue
+
+	// pending updates of dropped jobs are dropped as well
+	e.pending, err = loadPendingUpdates(filepath.Join(root, "pending-updates"), e.rerunAll)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load pending updates, starting over")
+	}
+
+	if e.maintenance != nil {
+		// note: unlike the jobs queue this one is not cleaned up on rerun
+		// since deferred jobs are still not applied to the deployments.
+		deferred, err := dque.NewOrOpen("deferred", root, 512, func() interface{} { return &engineJob{} })
+		if err != nil {
+			os.RemoveAll(filepath.Join(root, "deferred"))
+			return nil, errors.Wrap(err, "failed to create deferred job queue")
+		}
+		e.deferred = deferred
+	}
+
 	return e, nil
 }
 
@@ -436,6 +500,15 @@ func (e *NativeEngine) Update(ctx context.Context, update gridtypes.Deployment)
 	// this will just calculate the update
 	// steps we run it here as a sort of validation
 	// that this update is acceptable.
+	e.updates.Lock()
+	defer e.updates.Unlock()
+
+	// an update is validated against the latest accepted update, since
+	// the stored deployment is only updated once the update runs
+	if latest, ok := e.pending.Latest(update.TwinID, update.ContractID); ok {
+		deployment = latest
+	}
+
 	upgrades, err := deployment.Upgrade(&update)
 	if err != nil {
 		return errors.Wrap(provision.ErrDeploymentUpgradeValidationError, err.Error())
@@ -452,30 +525,23 @@ func (e *NativeEngine) Update(ctx context.Context, update gridtypes.Deployment)
 		}
 	}
 
-	// fields to update in storage
-	fields := []provision.Field{
-		provision.VersionField{Version: update.Version},
-		provision.SignatureRequirementField{SignatureRequirement: update.SignatureRequirement},
-	}
-
-	if deployment.Description != update.Description {
-		fields = append(fields, provision.DescriptionField{Description: update.Description})
-	}
-	if deployment.Metadata != update.Metadata {
-		fields = append(fields, provision.MetadataField{Metadata: update.Metadata})
-	}
-	// update deployment fields, workloads will then can get updated separately
-	if err := e.storage.Update(update.TwinID, update.ContractID, fields...); err != nil {
-		return errors.Wrap(err, "failed to update deployment data")
-	}
-	// all is okay we can push the job
+	// all is okay we can push the job, the deployment fields are
+	// updated once the job runs since it can be deferred
 	job := engineJob{
 		Op:     opUpdate,
 		Target: update,
 		Source: &deployment,
 	}
 
-	return e.queue.Enqueue(&job)
+	if err := e.queue.Enqueue(&job); err != nil {
+		return err
+	}
+
+	if err := e.pending.Accepted(&update); err != nil {
+		log.Error().Err(err).Msg("failed to persist pending update")
+	}
+
+	return nil
 }
 
 // Run starts reader reservation from the Source and handle them
@@ -485,11 +551,18 @@ func (e *NativeEngine) Run(root context.Context) error {
 	root = context.WithValue(root, engineKey{}, e)
 
 	if e.rerunAll {
+		// rerun is never deferred, after a reboot the workloads
+		// are not running anyway so there is nothing to disrupt.
 		if err := e.boot(root); err != nil {
 			log.Error().Err(err).Msg("error while setting up")
 		}
 	}
 
+	if e.deferred != nil {
+		defer e.deferred.Close()
+		go e.releaseDeferred(root)
+	}
+
 	for {
 		obj, err := e.queue.PeekBlock()
 		if err != nil {
@@ -505,6 +578,27 @@ func (e *NativeEngine) Run(root context.Context) error {
 			Uint64("contract", job.Target.ContractID).
 			Logger()
 
+		if e.mustDefer(job) {
+			if err := e.deferred.Enqueue(job); err != nil {
+				l.Error().Err(err).Msg("failed to defer job, running it anyway")
+			} else {
+				l.Info().Msg("job deferred to next maintenance window")
+				if err := e.pending.Deferred(&job.Target); err != nil {
+					l.Error().Err(err).Msg("failed to persist pending update")
+				}
+				if _, err := e.queue.Dequeue(); err != nil {
+					l.Error().Err(err).Msg("failed to dequeue job")
+				}
+				continue
+			}
+		}
+
+		if job.Op == opUpdate {
+			if err := e.pending.Done(&job.Target); err != nil {
+				l.Error().Err(err).Msg("failed to persist pending update")
+			}
+		}
+
 		// contract validation
 		// this should ONLY be done on provosion and update operation
 		if job.Op == opProvision ||
@@ -533,6 +627,10 @@ func (e *NativeEngine) Run(root context.Context) error {
 			e.installDeployment(ctx, &job.Target)
 		case opDeprovision:
 			e.uninstallDeployment(ctx, &job.Target, job.Message)
+			// deferred updates are dropped once released
+			if err := e.pending.Drop(job.Target.TwinID, job.Target.ContractID); err != nil {
+				l.Error().Err(err).Msg("failed to persist pending update")
+			}
 		case opPause:
 			e.lockDeployment(ctx, &job.Target)
 		case opResume:
@@ -550,9 +648,25 @@ func (e *NativeEngine) Run(root context.Context) error {
 			// - things that is not in any of the 3 lists are basically stay as is
 			// the call will also make sure the Result of those workload in both the (did not change)
 			// and update to reflect the current result on those workloads.
-			update, err := job.Source.Upgrade(&job.Target)
+			// the stored deployment is used since the version is only updated
+			// once the job runs, so an update queued against the same version
+			// is rejected here
+			current, err := e.storage.Get(job.Target.TwinID, job.Target.ContractID)
+			if err != nil {
+				l.Error().Err(err).Msg("failed to get current deployment")
+				break
+			}
+			update, err := current.Upgrade(&job.Target)
 			if err != nil {
 				l.Error().Err(err).Msg("failed to get update procedure")
+				if err := e.storage.Error(job.Target.TwinID, job.Target.ContractID, errors.Wrap(err, "failed to apply update")); err != nil {
+					l.Error().Err(err).Msg("failed to set deployment global error")
+				}
+				break
+			}
+			// update deployment fields, workloads will then can get updated separately
+			if err := e.storage.Update(job.Target.TwinID, job.Target.ContractID, updateFields(&current, &job.Target)...); err != nil {
+				l.Error().Err(err).Msg("failed to update deployment data")
 				break
 			}
 			e.updateDeployment(ctx, update)
@@ -567,6 +681,123 @@ func (e *NativeEngine) Run(root context.Context) error {
 	}
 }
 
+// updateFields returns the deployment fields to update in storage
+// to move from current to update
+func updateFields(current, update *gridtypes.Deployment) []provision.Field {
+	fields := []provision.Field{
+		provision.VersionField{Version: update.Version},
+		provision.SignatureRequirementField{SignatureRequirement: update.SignatureRequirement},
+	}
+
+	if current.Description != update.Description {
+		fields = append(fields, provision.DescriptionField{Description: update.Description})
+	}
+	if current.Metadata != update.Metadata {
+		fields = append(fields, provision.MetadataField{Metadata: update.Metadata})
+	}
+
+	return fields
+}
+
+// mustDefer checks if a job must wait for a maintenance window. Updates of a
+// deployment that has deferred updates wait as well so they run in order.
+func (e *NativeEngine) mustDefer(job *engineJob) bool {
+	if e.maintenance == nil || job.Op != opUpdate {
+		return false
+	}
+
+	if e.pending.HasDeferred(job.Target.TwinID, job.Target.ContractID) {
+		return true
+	}
+
+	return e.isDisruptive(job) && !e.maintenance.IsOpen(time.Now())
+}
+
+// isDisruptive checks if a job can restart or disrupt running workloads
+// and hence must wait for a maintenance window. Only updates of running
+// workloads are disruptive, adding and removing workloads is not.
+func (e *NativeEngine) isDisruptive(job *engineJob) bool {
+	if e.maintenance == nil || job.Op != opUpdate || job.Source == nil {
+		return false
+	}
+
+	// Upgrade sets the current results on the target workloads
+	target := job.Target
+	target.Workloads = append([]gridtypes.Workload(nil), job.Target.Workloads...)
+	ops, err := job.Source.Upgrade(&target)
+	if err != nil {
+		// the job fails anyway once it runs
+		return false
+	}
+
+	for _, op := range ops {
+		if op.Op == gridtypes.OpUpdate && op.WlID.Result.State == gridtypes.StateOk {
+			return true
+		}
+	}
+
+	return false
+}
+
+// releaseDeferred moves deferred jobs back to the jobs queue once
+// a maintenance window is open.
+func (e *NativeEngine) releaseDeferred(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		if e.maintenance.IsOpen(time.Now()) && e.deferred.Size() > 0 {
+			log.Info().Int("jobs", e.deferred.Size()).Msg("maintenance window is open, releasing deferred jobs")
+			e.releaseAll()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *NativeEngine) releaseAll() {
+	for {
+		obj, err := e.deferred.Peek()
+		if errors.Is(err, dque.ErrEmpty) {
+			return
+		} else if err != nil {
+			log.Error().Err(err).Msg("failed to check deferred jobs queue")
+			return
+		}
+
+		job := obj.(*engineJob)
+		// the deployment might have been deleted while the job was waiting
+		if dl, err := e.storage.Get(job.Target.TwinID, job.Target.ContractID); err == nil && dl.IsActive() {
+			// job is pushed first then removed from deferred queue, so a crash in
+			// between can only cause the job to run twice but never get lost.
+			if err := e.queue.Enqueue(job); err != nil {
+				log.Error().Err(err).Msg("failed to release deferred job")
+				return
+			}
+			if err := e.pending.Released(&job.Target, false); err != nil {
+				log.Error().Err(err).Msg("failed to persist pending update")
+			}
+		} else {
+			log.Info().
+				Uint32("twin", job.Target.TwinID).
+				Uint64("contract", job.Target.ContractID).
+				Msg("dropping deferred job of deleted deployment")
+			if err := e.pending.Released(&job.Target, true); err != nil {
+				log.Error().Err(err).Msg("failed to persist pending update")
+			}
+		}
+
+		if _, err := e.deferred.Dequeue(); err != nil {
+			log.Error().Err(err).Msg("failed to dequeue deferred job")
+			return
+		}
+	}
+}
+
 func (e *NativeEngine) safeCallback(d *gridtypes.Deployment, delete bool) {
 	if e.callback == nil {
 		return
@@ -588,9 +819,9 @@ func (e *NativeEngine) validate(ctx context.Context, dl *gridtypes.Deployment, n
 		return ctx, fmt.Errorf("substrate is not configured in engine")
 	}
 
-	contract, subErr := e.registrarGateway.GetContract(ctx, dl.ContractID)
-	if subErr.IsError() {
-		return nil, errors.Wrap(subErr.Err, "failed to get deployment contract")
+	contract, regErr := e.registrarGateway.GetContract(ctx, dl.ContractID)
+	if err := regErr.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to get deployment contract")
 	}
 
 	if !contract.ContractType.IsNodeContract {
@@ -598,12 +829,12 @@ func (e *NativeEngine) validate(ctx context.Context, dl *gridtypes.Deployment, n
 	}
 	ctx = withContract(ctx, contract.ContractType.NodeContract)
 
-	rent, subErr := e.registrarGateway.GetNodeRentContract(ctx, uint32(e.nodeID))
-	if subErr.IsError() && !subErr.IsCode(pkg.CodeNotFound) {
+	rent, regErr := e.registrarGateway.GetNodeRentContract(ctx, uint32(e.nodeID))
+	if regErr.IsError() && !regErr.IsCode(zos4pkg.RegistrarCodeNotFound) {
 		return nil, fmt.Errorf("failed to check node rent state")
 	}
 
-	ctx = withRented(ctx, !subErr.IsError() && rent != 0)
+	ctx = withRented(ctx, !regErr.IsError() && rent != 0)
 
 	if noValidation {
 		return ctx, nil
@@ -1027,6 +1258,10 @@ func (n *NativeEngine) CreateOrUpdate(twin uint32, deployment gridtypes.Deployme
 		return fmt.Errorf("twin id mismatch (deployment: %d, message: %d)", deployment.TwinID, twin)
 	}
 
+	if !update && n.draining.Load() {
+		return ErrNodeDraining
+	}
+
 	// make sure the account used is verified
 	check := func() error {
 		if ok, err := isTwinVerified(twin); err != nil {
@@ -1062,7 +1297,7 @@ func (n *NativeEngine) CreateOrUpdate(twin uint32, deployment gridtypes.Deployme
 func (n *NativeEngine) Get(twin uint32, contractID uint64) (gridtypes.Deployment, error) {
 	deployment, err := n.storage.Get(twin, contractID)
 	if errors.Is(err, provision.ErrDeploymentNotExists) {
-		return gridtypes.Deployment{}, fmt.Errorf("deployment not found")
+		return gridtypes.Deployment{}, ErrDeploymentNotFound
 	} else if err != nil {
 		return gridtypes.Deployment{}, err
 	}
@@ -1092,13 +1327,79 @@ func (n *NativeEngine) List(twin uint32) ([]gridtypes.Deployment, error) {
 func (n *NativeEngine) Changes(twin uint32, contractID uint64) ([]gridtypes.Workload, error) {
 	changes, err := n.storage.Changes(twin, contractID)
 	if errors.Is(err, provision.ErrDeploymentNotExists) {
-		return nil, fmt.Errorf("deployment not found")
+		return nil, ErrDeploymentNotFound
 	} else if err != nil {
 		return nil, err
 	}
 	return changes, nil
 }
 
+// ListPaged lists active deployments of a twin page by page. The cursor is
+// the contract id of the last deployment of the previous page. If the query
+// has workload filters, only deployments that has matching workloads are
+// returned and their workloads list is narrowed down to the matching ones.
+func (n *NativeEngine) ListPaged(twin uint32, query zos4pkg.HistoryQuery) (zos4pkg.DeploymentsPage, error) {
+	deploymentIDs, err := n.storage.ByTwin(twin)
+	if err != nil {
+		return zos4pkg.DeploymentsPage{}, err
+	}
+
+	sort.Slice(deploymentIDs, func(i, j int) bool {
+		return deploymentIDs[i] < deploymentIDs[j]
+	})
+
+	size := pageSize(query.Limit)
+	page := zos4pkg.DeploymentsPage{
+		Deployments: make([]gridtypes.Deployment, 0),
+		Next:        query.Cursor,
+	}
+
+	for _, id := range deploymentIDs {
+		if id <= query.Cursor {
+			continue
+		}
+
+		deployment, err := n.storage.Get(twin, id)
+		if err != nil {
+			return zos4pkg.DeploymentsPage{}, err
+		}
+		if !deployment.IsActive() || !filterDeployment(&deployment, &query) {
+			continue
+		}
+
+		if len(page.Deployments) == size {
+			page.More = true
+			break
+		}
+
+		page.Deployments = append(page.Deployments, deployment)
+		page.Next = id
+	}
+
+	return page, nil
+}
+
+// ChangesPaged returns the transaction history of a deployment page by page.
+// The cursor is the sequence of the next transaction in the history, so it
+// is not affected by history compaction.
+func (n *NativeEngine) ChangesPaged(twin uint32, contractID uint64, query zos4pkg.HistoryQuery) (zos4pkg.ChangesPage, error) {
+	storage, ok := n.storage.(pagedStorage)
+	if !ok {
+		return zos4pkg.ChangesPage{}, fmt.Errorf("storage does not support paging history")
+	}
+
+	return pageChanges(storage, twin, contractID, query)
+}
+
+// Status implements zos4 pkg.Provision
+func (n *NativeEngine) Status() zos4pkg.ProvisionState {
+	if n.draining.Load() {
+		return zos4pkg.ProvisionDraining
+	}
+
+	return zos4pkg.ProvisionActive
+}
+
 func (n *NativeEngine) ListPublicIPs() ([]string, error) {
 	// for efficiency this method should just find out configured public Ips.
 	// but currently the only way to do this is by scanning the nft rules
@@ -2,11 +2,17 @@ package provision
 
 import (
 	"encoding/json"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/maintenance"
+	"github.com/threefoldtech/zos4/pkg/provision/storage"
 	"github.com/threefoldtech/zosbase/pkg/gridtypes"
 	"github.com/threefoldtech/zosbase/pkg/gridtypes/zos"
+	"github.com/threefoldtech/zosbase/pkg/provision"
 )
 
 // func TestEngine(t *testing.T) {
@@ -182,3 +188,101 @@ func TestSortMountWorkloads(t *testing.T) {
 		assert.Equal(t, expectedWorkloads, workloads)
 	})
 }
+
+func TestPageChanges(t *testing.T) {
+	require := require.New(t)
+
+	store, err := storage.New(filepath.Join(t.TempDir(), "workloads.bolt"))
+	require.NoError(err)
+	defer store.Close()
+
+	require.NoError(store.Create(gridtypes.Deployment{TwinID: 1, ContractID: 10}))
+	require.NoError(store.Add(1, 10, gridtypes.Workload{Name: "a", Type: zos.ZMountType, Data: json.RawMessage(`{}`)}))
+	require.NoError(store.Add(1, 10, gridtypes.Workload{Name: "b", Type: zos.ZMountType}))
+
+	for _, wl := range []gridtypes.Workload{
+		{Name: "a", Type: zos.ZMountType, Result: gridtypes.Result{Created: 3, State: gridtypes.StateOk}},
+		{Name: "b", Type: zos.ZMountType, Result: gridtypes.Result{Created: 4, State: gridtypes.StateError}},
+		{Name: "a", Type: zos.ZMountType, Result: gridtypes.Result{Created: 5, State: gridtypes.StateDeleted}},
+	} {
+		require.NoError(store.Transaction(1, 10, wl))
+	}
+
+	page, err := pageChanges(store, 1, 10, pkg.HistoryQuery{Limit: 2})
+	require.NoError(err)
+	assert.Len(t, page.Changes, 2)
+	assert.True(t, page.More)
+	assert.EqualValues(t, 3, page.Next)
+
+	page, err = pageChanges(store, 1, 10, pkg.HistoryQuery{Limit: 2, Cursor: page.Next})
+	require.NoError(err)
+	assert.Len(t, page.Changes, 2)
+	assert.True(t, page.More)
+	assert.EqualValues(t, 5, page.Next)
+
+	page, err = pageChanges(store, 1, 10, pkg.HistoryQuery{Limit: 2, Cursor: page.Next})
+	require.NoError(err)
+	assert.Len(t, page.Changes, 1)
+	assert.False(t, page.More)
+	assert.EqualValues(t, 6, page.Next)
+
+	page, err = pageChanges(store, 1, 10, pkg.HistoryQuery{Names: []gridtypes.Name{"a"}, Limit: 1, Summary: true})
+	require.NoError(err)
+	assert.Len(t, page.Changes, 1)
+	assert.True(t, page.More)
+	assert.EqualValues(t, 2, page.Next)
+	assert.Nil(t, page.Changes[0].Data)
+
+	page, err = pageChanges(store, 1, 10, pkg.HistoryQuery{States: []gridtypes.ResultState{gridtypes.StateOk, gridtypes.StateError}})
+	require.NoError(err)
+	assert.Len(t, page.Changes, 2)
+	assert.False(t, page.More)
+
+	page, err = pageChanges(store, 1, 10, pkg.HistoryQuery{From: 2, To: 4, Names: []gridtypes.Name{"b"}})
+	require.NoError(err)
+	assert.Len(t, page.Changes, 1)
+	assert.EqualValues(t, 5, page.Next)
+
+	_, err = pageChanges(store, 1, 11, pkg.HistoryQuery{})
+	require.ErrorIs(err, provision.ErrDeploymentNotExists)
+}
+
+func TestIsDisruptive(t *testing.T) {
+	disk := func(name gridtypes.Name, version uint32, state gridtypes.ResultState) gridtypes.Workload {
+		return gridtypes.Workload{
+			Version: version,
+			Name:    name,
+			Type:    zos.ZMountType,
+			Data:    json.RawMessage(`{"size": 1073741824}`),
+			Result:  gridtypes.Result{State: state},
+		}
+	}
+
+	source := gridtypes.Deployment{
+		Version: 0,
+		Workloads: []gridtypes.Workload{
+			disk("running", 0, gridtypes.StateOk),
+			disk("failed", 0, gridtypes.StateError),
+		},
+	}
+
+	engine := NativeEngine{maintenance: &maintenance.Schedule{}}
+	job := func(workloads ...gridtypes.Workload) *engineJob {
+		return &engineJob{
+			Op:     opUpdate,
+			Source: &source,
+			Target: gridtypes.Deployment{Version: 1, Workloads: workloads},
+		}
+	}
+
+	// updating a running workload
+	assert.True(t, engine.isDisruptive(job(disk("running", 1, ""), disk("failed", 0, ""))))
+	// updating a failed workload
+	assert.False(t, engine.isDisruptive(job(disk("running", 0, ""), disk("failed", 1, ""))))
+	// adding and removing workloads
+	assert.False(t, engine.isDisruptive(job(disk("running", 0, ""), disk("new", 1, ""))))
+
+	// no maintenance windows
+	engine.maintenance = nil
+	assert.False(t, engine.isDisruptive(job(disk("running", 1, ""), disk("failed", 0, ""))))
+}
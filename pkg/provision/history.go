@@ -0,0 +1,123 @@
+package provision
+
+import (
+	"github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+func pageSize(limit uint32) int {
+	if limit == 0 {
+		return defaultPageSize
+	}
+	if limit > maxPageSize {
+		return maxPageSize
+	}
+
+	return int(limit)
+}
+
+// isFiltered returns true if query has any workload filter set
+func isFiltered(q *pkg.HistoryQuery) bool {
+	return len(q.Names) > 0 || len(q.States) > 0 || q.From != 0 || q.To != 0
+}
+
+// matchWorkload checks if workload (or transition) passes the query filters
+func matchWorkload(wl *gridtypes.Workload, q *pkg.HistoryQuery) bool {
+	if len(q.Names) > 0 {
+		found := false
+		for _, name := range q.Names {
+			if wl.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(q.States) > 0 && !wl.Result.State.IsAny(q.States...) {
+		return false
+	}
+
+	if q.From != 0 && wl.Result.Created < q.From {
+		return false
+	}
+
+	if q.To != 0 && wl.Result.Created > q.To {
+		return false
+	}
+
+	return true
+}
+
+// summarize drops the heavy parts of the workload, only keeping
+// the identity and the state of it
+func summarize(wl gridtypes.Workload) gridtypes.Workload {
+	wl.Data = nil
+	wl.Result.Data = nil
+	return wl
+}
+
+// filterDeployment narrows down the deployment workloads to the ones matching
+// the query. returns false if no workload matched.
+func filterDeployment(dl *gridtypes.Deployment, q *pkg.HistoryQuery) bool {
+	if !isFiltered(q) && !q.Summary {
+		return true
+	}
+
+	workloads := make([]gridtypes.Workload, 0, len(dl.Workloads))
+	for i := range dl.Workloads {
+		wl := &dl.Workloads[i]
+		if !matchWorkload(wl, q) {
+			continue
+		}
+
+		if q.Summary {
+			workloads = append(workloads, summarize(*wl))
+		} else {
+			workloads = append(workloads, *wl)
+		}
+	}
+
+	if len(workloads) == 0 && isFiltered(q) {
+		return false
+	}
+
+	dl.Workloads = workloads
+	return true
+}
+
+// pagedStorage is a storage that can page the transaction history of
+// a deployment without loading all of it (see storage.BoltStorage)
+type pagedStorage interface {
+	ChangesPage(twin uint32, deployment uint64, cursor uint64, size int, match func(wl *gridtypes.Workload) bool) ([]gridtypes.Workload, uint64, bool, error)
+}
+
+// pageChanges returns a single page of the transaction history of a deployment.
+// the cursor is the sequence of the transaction to start scanning from.
+func pageChanges(storage pagedStorage, twin uint32, contractID uint64, q pkg.HistoryQuery) (pkg.ChangesPage, error) {
+	changes, next, more, err := storage.ChangesPage(twin, contractID, q.Cursor, pageSize(q.Limit), func(wl *gridtypes.Workload) bool {
+		return matchWorkload(wl, &q)
+	})
+	if err != nil {
+		return pkg.ChangesPage{}, err
+	}
+
+	if q.Summary {
+		for i := range changes {
+			changes[i] = summarize(changes[i])
+		}
+	}
+
+	return pkg.ChangesPage{
+		Changes: changes,
+		Next:    next,
+		More:    more,
+	}, nil
+}
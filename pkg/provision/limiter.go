@@ -0,0 +1,80 @@
+package provision
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// limiter is a counting semaphore that grants slots in strict FIFO
+// order. Once somebody is waiting, new callers queue behind it even if
+// a slot becomes free, so a steady stream of short operations can not
+// starve an older waiter.
+type limiter struct {
+	m       sync.Mutex
+	max     int
+	running int
+	waiters list.List
+}
+
+func newLimiter(max int) *limiter {
+	return &limiter{max: max}
+}
+
+// acquire blocks until a slot is granted or ctx is done. On success
+// the caller must call release exactly once.
+func (l *limiter) acquire(ctx context.Context) error {
+	l.m.Lock()
+	if l.running < l.max && l.waiters.Len() == 0 {
+		l.running++
+		l.m.Unlock()
+		return nil
+	}
+
+	ch := make(chan struct{})
+	elem := l.waiters.PushBack(ch)
+	l.m.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.m.Lock()
+	select {
+	case <-ch:
+		// the slot was handed over to us while we were giving up
+		// so pass it on to the next in line.
+		l.m.Unlock()
+		l.release()
+	default:
+		l.waiters.Remove(elem)
+		l.m.Unlock()
+	}
+
+	return ctx.Err()
+}
+
+// release frees a slot, handing it over directly to the oldest waiter
+// if there is one.
+func (l *limiter) release() {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if front := l.waiters.Front(); front != nil {
+		l.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+
+	l.running--
+}
+
+// waiting returns number of callers waiting for a slot
+func (l *limiter) waiting() int {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.waiters.Len()
+}
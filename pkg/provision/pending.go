@@ -0,0 +1,169 @@
+package provision
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+)
+
+// pendingUpdate tracks the update jobs of a deployment that did not run yet
+type pendingUpdate struct {
+	// Target is the latest accepted update, new updates are validated against it
+	Target gridtypes.Deployment `json:"target"`
+	// Queued number of update jobs in the jobs queue
+	Queued int `json:"queued"`
+	// Deferred number of update jobs waiting for a maintenance window
+	Deferred int `json:"deferred"`
+	// DeferredTarget is the latest deferred update
+	DeferredTarget gridtypes.Deployment `json:"deferred_target"`
+}
+
+// pendingUpdates keeps the updates that are accepted but not applied yet, so
+// an update is validated against the previous accepted one and not against
+// the stored deployment, and the updates of a deployment run in order even
+// if some are deferred. It's persisted since jobs survive restarts.
+type pendingUpdates struct {
+	path string
+
+	mu      sync.Mutex
+	updates map[string]*pendingUpdate
+}
+
+func pendingKey(twin uint32, contract uint64) string {
+	return fmt.Sprintf("%d-%d", twin, contract)
+}
+
+// loadPendingUpdates loads the pending updates from path. If the jobs queue
+// was dropped only deferred updates are kept.
+func loadPendingUpdates(path string, jobsDropped bool) (*pendingUpdates, error) {
+	p := &pendingUpdates{path: path, updates: make(map[string]*pendingUpdate)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	} else if err != nil {
+		return p, errors.Wrap(err, "failed to read pending updates")
+	}
+
+	if err := json.Unmarshal(data, &p.updates); err != nil {
+		return p, errors.Wrap(err, "failed to decode pending updates")
+	}
+
+	if jobsDropped {
+		for key, update := range p.updates {
+			if update.Deferred == 0 {
+				delete(p.updates, key)
+				continue
+			}
+
+			update.Queued = 0
+			update.Target = update.DeferredTarget
+		}
+	}
+
+	return p, p.save()
+}
+
+func (p *pendingUpdates) save() error {
+	data, err := json.Marshal(p.updates)
+	if err != nil {
+		return err
+	}
+
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, p.path)
+}
+
+// update applies fn to the pending update of a deployment and persists the
+// change. Entries with no more jobs are dropped.
+func (p *pendingUpdates) update(twin uint32, contract uint64, fn func(u *pendingUpdate)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := pendingKey(twin, contract)
+	u, ok := p.updates[key]
+	if !ok {
+		u = &pendingUpdate{}
+	}
+
+	fn(u)
+
+	if u.Queued <= 0 && u.Deferred <= 0 {
+		delete(p.updates, key)
+	} else {
+		p.updates[key] = u
+	}
+
+	return p.save()
+}
+
+// Latest returns the latest accepted update of a deployment if any
+func (p *pendingUpdates) Latest(twin uint32, contract uint64) (gridtypes.Deployment, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u, ok := p.updates[pendingKey(twin, contract)]
+	if !ok {
+		return gridtypes.Deployment{}, false
+	}
+
+	return u.Target, true
+}
+
+// HasDeferred checks if a deployment has deferred updates
+func (p *pendingUpdates) HasDeferred(twin uint32, contract uint64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u, ok := p.updates[pendingKey(twin, contract)]
+	return ok && u.Deferred > 0
+}
+
+// Accepted records an update pushed to the jobs queue
+func (p *pendingUpdates) Accepted(target *gridtypes.Deployment) error {
+	return p.update(target.TwinID, target.ContractID, func(u *pendingUpdate) {
+		u.Target = *target
+		u.Queued++
+	})
+}
+
+// Deferred records an update moved from the jobs queue to the deferred queue
+func (p *pendingUpdates) Deferred(target *gridtypes.Deployment) error {
+	return p.update(target.TwinID, target.ContractID, func(u *pendingUpdate) {
+		u.Queued--
+		u.Deferred++
+		u.DeferredTarget = *target
+	})
+}
+
+// Released records a deferred update moved back to the jobs queue, or dropped
+func (p *pendingUpdates) Released(target *gridtypes.Deployment, dropped bool) error {
+	return p.update(target.TwinID, target.ContractID, func(u *pendingUpdate) {
+		u.Deferred--
+		if !dropped {
+			u.Queued++
+		}
+	})
+}
+
+// Done records an update that left the jobs queue
+func (p *pendingUpdates) Done(target *gridtypes.Deployment) error {
+	return p.update(target.TwinID, target.ContractID, func(u *pendingUpdate) {
+		u.Queued--
+	})
+}
+
+// Drop forgets the pending updates of a deployment
+func (p *pendingUpdates) Drop(twin uint32, contract uint64) error {
+	return p.update(twin, contract, func(u *pendingUpdate) {
+		u.Queued, u.Deferred = 0, 0
+	})
+}
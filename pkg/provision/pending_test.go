@@ -0,0 +1,57 @@
+package provision
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+)
+
+func TestPendingUpdates(t *testing.T) {
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "pending-updates")
+
+	pending, err := loadPendingUpdates(path, false)
+	require.NoError(err)
+
+	_, ok := pending.Latest(1, 10)
+	require.False(ok)
+
+	v1 := gridtypes.Deployment{TwinID: 1, ContractID: 10, Version: 1}
+	v2 := gridtypes.Deployment{TwinID: 1, ContractID: 10, Version: 2}
+
+	// the first update is deferred, the second one is validated against it
+	require.NoError(pending.Accepted(&v1))
+	require.NoError(pending.Deferred(&v1))
+	require.True(pending.HasDeferred(1, 10))
+	require.NoError(pending.Accepted(&v2))
+	latest, ok := pending.Latest(1, 10)
+	require.True(ok)
+	require.EqualValues(2, latest.Version)
+
+	// the jobs queue is dropped on rerun, only the deferred update is left
+	reloaded, err := loadPendingUpdates(path, true)
+	require.NoError(err)
+	latest, ok = reloaded.Latest(1, 10)
+	require.True(ok)
+	require.EqualValues(1, latest.Version)
+
+	// the second update is deferred behind the first one
+	require.NoError(pending.Deferred(&v2))
+	require.NoError(pending.Released(&v1, false))
+	require.NoError(pending.Released(&v2, false))
+	require.False(pending.HasDeferred(1, 10))
+	require.NoError(pending.Done(&v1))
+	_, ok = pending.Latest(1, 10)
+	require.True(ok)
+	require.NoError(pending.Done(&v2))
+	_, ok = pending.Latest(1, 10)
+	require.False(ok)
+
+	// deprovision drops everything
+	require.NoError(pending.Accepted(&v1))
+	require.NoError(pending.Drop(1, 10))
+	_, ok = pending.Latest(1, 10)
+	require.False(ok)
+}
@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"sort"
+
 	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos4/pkg"
 	"github.com/threefoldtech/zosbase/pkg/gridtypes"
 	"github.com/threefoldtech/zosbase/pkg/provision"
 )
@@ -93,15 +96,85 @@ type Pauser interface {
 	Resume(ctx context.Context, wl *gridtypes.WorkloadWithID) error
 }
 
+// MapProvisionerOption interface
+type MapProvisionerOption interface {
+	apply(p *mapProvisioner)
+}
+
+// WithConcurrencyLimits caps the number of concurrent provision and update
+// operations per workload type. Types that are not listed (or has a limit
+// of zero) are not limited. Operations that exceed the limit wait for a free
+// slot in the order they arrived.
+func WithConcurrencyLimits(limits map[gridtypes.WorkloadType]int) MapProvisionerOption {
+	return &withConcurrencyLimits{limits}
+}
+
+type withConcurrencyLimits struct {
+	limits map[gridtypes.WorkloadType]int
+}
+
+func (w *withConcurrencyLimits) apply(p *mapProvisioner) {
+	for typ, max := range w.limits {
+		if max <= 0 {
+			delete(p.limits, typ)
+			continue
+		}
+		p.limits[typ] = newLimiter(max)
+	}
+}
+
 type mapProvisioner struct {
 	managers map[gridtypes.WorkloadType]Manager
+	limits   map[gridtypes.WorkloadType]*limiter
 }
 
+var _ pkg.Provisioner = (*mapProvisioner)(nil)
+
 // NewMapProvisioner returns a new instance of a map provisioner
-func NewMapProvisioner(managers map[gridtypes.WorkloadType]Manager) provision.Provisioner {
-	return &mapProvisioner{
+func NewMapProvisioner(managers map[gridtypes.WorkloadType]Manager, opts ...MapProvisionerOption) provision.Provisioner {
+	p := &mapProvisioner{
 		managers: managers,
+		limits:   make(map[gridtypes.WorkloadType]*limiter),
+	}
+
+	for _, opt := range opts {
+		opt.apply(p)
+	}
+
+	return p
+}
+
+// Waiting implements pkg.Provisioner
+func (p *mapProvisioner) Waiting() []pkg.WorkloadQueue {
+	queues := make([]pkg.WorkloadQueue, 0, len(p.limits))
+	for typ, l := range p.limits {
+		queues = append(queues, pkg.WorkloadQueue{
+			Type:    typ,
+			Limit:   l.max,
+			Waiting: l.waiting(),
+		})
+	}
+
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].Type < queues[j].Type
+	})
+
+	return queues
+}
+
+// throttle blocks until the workload type has a free slot. The returned
+// function must be called to free the slot once the operation is done.
+func (p *mapProvisioner) throttle(ctx context.Context, typ gridtypes.WorkloadType) (func(), error) {
+	l, ok := p.limits[typ]
+	if !ok {
+		return func() {}, nil
+	}
+
+	if err := l.acquire(ctx); err != nil {
+		return nil, errors.Wrapf(err, "failed to wait for a free slot for workload type '%s'", typ)
 	}
+
+	return l.release, nil
 }
 
 func (p *mapProvisioner) Initialize(ctx context.Context) error {
@@ -126,6 +199,12 @@ func (p *mapProvisioner) Provision(ctx context.Context, wl *gridtypes.WorkloadWi
 		return result, fmt.Errorf("unknown workload type '%s' for reservation id '%s'", wl.Type, wl.ID)
 	}
 
+	release, err := p.throttle(ctx, wl.Type)
+	if err != nil {
+		return result, err
+	}
+	defer release()
+
 	data, err := manager.Provision(ctx, wl)
 	if errors.Is(err, provision.ErrNoActionNeeded) {
 		return result, err
@@ -209,6 +288,12 @@ func (p *mapProvisioner) Update(ctx context.Context, wl *gridtypes.WorkloadWithI
 		return result, fmt.Errorf("workload type '%s' does not support updating", wl.Type)
 	}
 
+	release, err := p.throttle(ctx, wl.Type)
+	if err != nil {
+		return result, err
+	}
+	defer release()
+
 	data, err := updater.Update(ctx, wl)
 	if errors.Is(err, provision.ErrNoActionNeeded) {
 		return result, err
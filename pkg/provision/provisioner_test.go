@@ -4,11 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos4/pkg"
 	"github.com/threefoldtech/zosbase/pkg/gridtypes"
 )
 
@@ -150,3 +153,97 @@ func TestProvision(t *testing.T) {
 	require.NoError(err)
 	require.Equal(gridtypes.StatePaused, result.State)
 }
+
+type testManagerBlocking struct {
+	m       sync.Mutex
+	started []string
+	release chan struct{}
+}
+
+func (t *testManagerBlocking) Provision(ctx context.Context, wl *gridtypes.WorkloadWithID) (interface{}, error) {
+	t.m.Lock()
+	t.started = append(t.started, string(wl.Name))
+	t.m.Unlock()
+
+	<-t.release
+	return nil, nil
+}
+
+func (t *testManagerBlocking) Started() []string {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return append([]string(nil), t.started...)
+}
+
+func (t *testManagerBlocking) Deprovision(ctx context.Context, wl *gridtypes.WorkloadWithID) error {
+	return nil
+}
+
+func TestProvisionConcurrencyLimit(t *testing.T) {
+	require := require.New(t)
+	mgr := testManagerBlocking{release: make(chan struct{})}
+	provisioner := NewMapProvisioner(map[gridtypes.WorkloadType]Manager{
+		testWorkloadType: &mgr,
+	}, WithConcurrencyLimits(map[gridtypes.WorkloadType]int{
+		testWorkloadType: 1,
+	}))
+
+	// called from Eventually conditions, so it can't use require
+	waiting := func() int {
+		queues := provisioner.(pkg.Provisioner).Waiting()
+		if len(queues) != 1 {
+			return -1
+		}
+		return queues[0].Waiting
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	// require must be called from the test goroutine, errors are checked
+	// once all provisions are done
+	errs := make(chan error, 4)
+	provision := func(name string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := provisioner.Provision(ctx, &gridtypes.WorkloadWithID{
+				Workload: &gridtypes.Workload{
+					Type: testWorkloadType,
+					Name: gridtypes.Name(name),
+				},
+			})
+			errs <- err
+		}()
+	}
+
+	provision("first")
+	require.Eventually(func() bool { return len(mgr.Started()) == 1 }, time.Second, time.Millisecond)
+
+	// queue the rest one by one to guarantee arrival order
+	for i, name := range []string{"second", "third", "fourth"} {
+		provision(name)
+		require.Eventually(func() bool { return waiting() == i+1 }, time.Second, time.Millisecond)
+	}
+
+	// a cancelled waiter leaves the queue without taking a slot
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := provisioner.Provision(cancelled, &gridtypes.WorkloadWithID{
+		Workload: &gridtypes.Workload{Type: testWorkloadType},
+	})
+	require.ErrorIs(err, context.Canceled)
+	require.Equal(3, waiting())
+
+	for i := 0; i < 4; i++ {
+		mgr.release <- struct{}{}
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(err)
+	}
+	require.Equal([]string{"first", "second", "third", "fourth"}, mgr.Started())
+	require.Equal(0, waiting())
+}
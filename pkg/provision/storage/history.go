@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+	"github.com/threefoldtech/zosbase/pkg/provision"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ChangesPage returns at most size transactions of a deployment that pass
+// match, starting from the transaction with sequence cursor. It returns the
+// cursor of the next page and whether there are more matching transactions
+// after this page. The cursor is the transaction sequence so it stays valid
+// even if the history is compacted between pages.
+func (b *BoltStorage) ChangesPage(twin uint32, deployment uint64, cursor uint64, size int, match func(wl *gridtypes.Workload) bool) (changes []gridtypes.Workload, next uint64, more bool, err error) {
+	next = cursor
+	changes = make([]gridtypes.Workload, 0)
+	err = b.db.View(func(tx *bolt.Tx) error {
+		twinBucket := tx.Bucket(b.u32(twin))
+		if twinBucket == nil {
+			return errors.Wrap(provision.ErrDeploymentNotExists, "twin not found")
+		}
+		dl := twinBucket.Bucket(b.u64(deployment))
+		if dl == nil {
+			return errors.Wrap(provision.ErrDeploymentNotExists, "deployment not found")
+		}
+
+		logs := dl.Bucket([]byte(keyTransactions))
+		if logs == nil {
+			return nil
+		}
+
+		cur := logs.Cursor()
+		for k, v := cur.Seek(b.u64(cursor)); k != nil; k, v = cur.Next() {
+			if len(v) == 0 {
+				continue
+			}
+
+			var wl gridtypes.Workload
+			if err := json.Unmarshal(v, &wl); err != nil {
+				return errors.Wrap(err, "failed to load transaction log")
+			}
+
+			if !match(&wl) {
+				continue
+			}
+
+			if len(changes) == size {
+				// there is at least one more match
+				more = true
+				break
+			}
+
+			changes = append(changes, wl)
+			next = b.l64(k) + 1
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// transition is the part of a transaction log needed to compact it
+type transition struct {
+	Name   gridtypes.Name `json:"name"`
+	Result struct {
+		State gridtypes.ResultState `json:"state"`
+	} `json:"result"`
+}
+
+// staleTransitions returns the indexes of the transitions that should be dropped
+// so only the last `keep` transitions of each workload are left. The creation
+// entry and the latest transition that is not unchanged are always kept, since
+// the workload state is loaded from it. logs are the transitions in history order.
+func staleTransitions(logs []transition, keep int) []int {
+	workloads := make(map[gridtypes.Name][]int)
+	state := make(map[gridtypes.Name]int)
+	for i, log := range logs {
+		workloads[log.Name] = append(workloads[log.Name], i)
+		if log.Result.State != gridtypes.StateUnChanged {
+			state[log.Name] = i
+		}
+	}
+
+	kept := make(map[int]struct{})
+	for name, indexes := range workloads {
+		kept[indexes[0]] = struct{}{}
+		if last, ok := state[name]; ok {
+			kept[last] = struct{}{}
+		}
+
+		if len(indexes) > keep {
+			indexes = indexes[len(indexes)-keep:]
+		}
+		for _, i := range indexes {
+			kept[i] = struct{}{}
+		}
+	}
+
+	var stale []int
+	for i := range logs {
+		if _, ok := kept[i]; !ok {
+			stale = append(stale, i)
+		}
+	}
+
+	return stale
+}
+
+// CompactHistory trims the transaction logs of all deployments, keeping only
+// the last `keep` transitions of each workload, besides its creation entry and
+// its latest state. Each deployment is compacted in its own transaction so the
+// storage is not blocked for long. It returns the number of dropped transitions.
+func (b *BoltStorage) CompactHistory(keep int) (int, error) {
+	if keep <= 0 {
+		return 0, fmt.Errorf("invalid number of transitions to keep '%d'", keep)
+	}
+
+	twins, err := b.Twins()
+	if err != nil {
+		return 0, err
+	}
+
+	dropped := 0
+	for _, twin := range twins {
+		deployments, err := b.ByTwin(twin)
+		if err != nil {
+			return dropped, err
+		}
+
+		for _, deployment := range deployments {
+			err := b.db.Update(func(tx *bolt.Tx) error {
+				twinBucket := tx.Bucket(b.u32(twin))
+				if twinBucket == nil {
+					return nil
+				}
+				dl := twinBucket.Bucket(b.u64(deployment))
+				if dl == nil {
+					// deleted in the mean time
+					return nil
+				}
+
+				logs := dl.Bucket([]byte(keyTransactions))
+				if logs == nil {
+					return nil
+				}
+
+				n, err := compactLogs(logs, keep)
+				dropped += n
+				return err
+			})
+
+			if err != nil {
+				return dropped, errors.Wrapf(err, "failed to compact deployment '%d' of twin '%d'", deployment, twin)
+			}
+		}
+	}
+
+	return dropped, nil
+}
+
+func compactLogs(logs *bolt.Bucket, keep int) (int, error) {
+	var (
+		keys        [][]byte
+		transitions []transition
+	)
+
+	err := logs.ForEach(func(k, v []byte) error {
+		if len(v) == 0 {
+			return nil
+		}
+
+		var wl transition
+		if err := json.Unmarshal(v, &wl); err != nil {
+			return errors.Wrap(err, "failed to load transaction log")
+		}
+
+		keys = append(keys, append([]byte(nil), k...))
+		transitions = append(transitions, wl)
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	stale := staleTransitions(transitions, keep)
+	for _, i := range stale {
+		if err := logs.Delete(keys[i]); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(stale), nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+)
+
+func TestStaleTransitions(t *testing.T) {
+	require := require.New(t)
+	log := func(name gridtypes.Name, state gridtypes.ResultState) transition {
+		var tr transition
+		tr.Name = name
+		tr.Result.State = state
+		return tr
+	}
+
+	logs := []transition{
+		log("a", gridtypes.StateInit),
+		log("b", gridtypes.StateOk),
+		log("a", gridtypes.StateOk),
+		log("a", gridtypes.StateOk),
+		log("b", gridtypes.StateOk),
+		log("c", gridtypes.StateOk),
+		log("a", gridtypes.StateOk),
+	}
+
+	// creation entries are always kept
+	require.Equal([]int{2}, staleTransitions(logs, 2))
+	require.Equal([]int{2, 3}, staleTransitions(logs, 1))
+	require.Empty(staleTransitions(logs, 4))
+
+	// the latest state is kept even if more unchanged transitions follow
+	logs = []transition{
+		log("a", gridtypes.StateInit),
+		log("a", gridtypes.StateOk),
+		log("a", gridtypes.StateUnChanged),
+		log("a", gridtypes.StateUnChanged),
+		log("a", gridtypes.StateUnChanged),
+	}
+	require.Equal([]int{2, 3}, staleTransitions(logs, 1))
+}
+
+func TestCompactHistory(t *testing.T) {
+	require := require.New(t)
+
+	db, err := New(filepath.Join(t.TempDir(), "workloads.bolt"))
+	require.NoError(err)
+	defer db.Close()
+
+	_, err = db.CompactHistory(0)
+	require.Error(err)
+
+	require.NoError(db.Create(gridtypes.Deployment{TwinID: 1, ContractID: 10}))
+	require.NoError(db.Add(1, 10, gridtypes.Workload{Name: "vm1", Type: testType1}))
+	for i := 0; i < 4; i++ {
+		require.NoError(db.Transaction(1, 10, gridtypes.Workload{
+			Type:   testType1,
+			Name:   "vm1",
+			Result: gridtypes.Result{Created: gridtypes.Now(), State: gridtypes.StateOk},
+		}))
+	}
+
+	all := func(wl *gridtypes.Workload) bool { return true }
+
+	// read the first page before compaction
+	changes, next, more, err := db.ChangesPage(1, 10, 0, 2, all)
+	require.NoError(err)
+	require.Len(changes, 2)
+	require.True(more)
+	require.EqualValues(3, next)
+
+	dropped, err := db.CompactHistory(1)
+	require.NoError(err)
+	require.Equal(3, dropped)
+
+	// the creation entry and the last transition are kept
+	changes, err = db.Changes(1, 10)
+	require.NoError(err)
+	require.Len(changes, 2)
+	require.Equal(gridtypes.StateInit, changes[0].Result.State)
+
+	// the cursor still points to where the first page stopped
+	changes, next, more, err = db.ChangesPage(1, 10, next, 2, all)
+	require.NoError(err)
+	require.Len(changes, 1)
+	require.False(more)
+	require.EqualValues(6, next)
+
+	wl, err := db.Current(1, 10, "vm1")
+	require.NoError(err)
+	require.Equal(gridtypes.StateOk, wl.Result.State)
+}
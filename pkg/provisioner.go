@@ -0,0 +1,23 @@
+package pkg
+
+import (
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+)
+
+//go:generate zbusc -module provision -version 0.0.1 -name provisioner -package stubs github.com/threefoldtech/zos4/pkg+Provisioner stubs/provisioner_stub.go
+
+// WorkloadQueue is the throttling state of a single workload type
+type WorkloadQueue struct {
+	Type gridtypes.WorkloadType `json:"type"`
+	// Limit is the max number of concurrent operations allowed on this type
+	Limit int `json:"limit"`
+	// Waiting is the number of operations waiting for a free slot
+	Waiting int `json:"waiting"`
+}
+
+// Provisioner exposes the runtime state of the workload provisioner
+type Provisioner interface {
+	// Waiting returns the queue state of all workload types that has
+	// a concurrency limit set.
+	Waiting() []WorkloadQueue
+}
@@ -1,10 +1,101 @@
 package pkg
 
+import "context"
+
 //go:generate mkdir -p stubs
 
 //go:generate zbusc -module registrar -version 0.0.1 -name registrar -package stubs github.com/threefoldtech/zos4/pkg+Registrar stubs/registrar_stub.go
 
+// RegistrationStatus is the status of the node registration
+type RegistrationStatus string
+
+const (
+	// RegistrationInProgress the node is not registered yet
+	RegistrationInProgress RegistrationStatus = "InProgress"
+	// RegistrationFailed the last registration attempt failed, it's
+	// retried at NextRetry
+	RegistrationFailed RegistrationStatus = "Failed"
+	// RegistrationDone the node is registered
+	RegistrationDone RegistrationStatus = "Done"
+)
+
+// RegistrationStep is the step a registration attempt is at
+type RegistrationStep string
+
+const (
+	// RegistrationStepChecks checks the node can be registered (disks,
+	// virtualization)
+	RegistrationStepChecks RegistrationStep = "checks"
+	// RegistrationStepLocation finds the node location
+	RegistrationStepLocation RegistrationStep = "location"
+	// RegistrationStepAccount ensures the node twin account exists
+	RegistrationStepAccount RegistrationStep = "ensure-account"
+	// RegistrationStepGetNode looks up the node on the registrar
+	RegistrationStepGetNode RegistrationStep = "get-node"
+	// RegistrationStepCreateNode creates the node on the registrar
+	RegistrationStepCreateNode RegistrationStep = "create-node"
+	// RegistrationStepUpdateNode updates the node information on the
+	// registrar if it has changed
+	RegistrationStepUpdateNode RegistrationStep = "update-node"
+	// RegistrationStepInventory publishes the node hardware inventory on
+	// the registrar if it has changed
+	RegistrationStepInventory RegistrationStep = "inventory"
+	// RegistrationStepAttestation publishes a TPM attestation of the node
+	// boot state on the registrar, it's skipped if the node has no TPM
+	RegistrationStepAttestation RegistrationStep = "attestation"
+)
+
+// LocationSource is where the node location was found
+type LocationSource string
+
+const (
+	// LocationSourceKernel the location is set by the farmer in the boot
+	// configuration (kernel params)
+	LocationSourceKernel LocationSource = "kernel"
+	// LocationSourceCache the last known location of the node
+	LocationSourceCache LocationSource = "cache"
+	// LocationSourceGeoIP the location is looked up by the node public ip
+	LocationSourceGeoIP LocationSource = "geoip"
+	// LocationSourceUnknown the location could not be found, the location
+	// on the registrar is kept
+	LocationSourceUnknown LocationSource = "unknown"
+)
+
+// RegistrationState is the state of the node registration
+type RegistrationState struct {
+	Status RegistrationStatus `json:"status"`
+	// Step of the current attempt, or the step the last attempt ended at
+	Step   RegistrationStep `json:"step"`
+	NodeID uint32           `json:"node_id"`
+	TwinID uint32           `json:"twin_id"`
+	// Error of the last attempt if it failed
+	Error string `json:"error"`
+	// NextRetry time of the next attempt (unix time) if failed
+	NextRetry int64 `json:"next_retry"`
+	// LocationSource is where the location of the last attempt was found
+	LocationSource LocationSource `json:"location_source"`
+	// Changed time of the transition to this state (unix time)
+	Changed int64 `json:"changed"`
+}
+
+// RegistrationAttempt is a finished registration attempt
+type RegistrationAttempt struct {
+	// Started and Ended times of the attempt (unix time)
+	Started int64 `json:"started"`
+	Ended   int64 `json:"ended"`
+	// Step the attempt ended at
+	Step RegistrationStep `json:"step"`
+	// Error of the attempt, empty if it succeeded
+	Error string `json:"error"`
+}
+
 type Registrar interface {
 	NodeID() (uint32, error)
 	TwinID() (uint32, error)
+	// CurrentState returns the current registration state
+	CurrentState() RegistrationState
+	// History returns the last registration attempts, oldest first
+	History() []RegistrationAttempt
+	// State streams transitions of the registration state
+	State(ctx context.Context) <-chan RegistrationState
 }
@@ -0,0 +1,105 @@
+package pkg
+
+import "errors"
+
+// RegistrarErrorCode is the kind of a registrar gateway error
+type RegistrarErrorCode int
+
+const (
+	// RegistrarCodeNoError the call succeeded
+	RegistrarCodeNoError RegistrarErrorCode = iota
+	// RegistrarCodeGeneric any error that is not of a known kind
+	RegistrarCodeGeneric
+	// RegistrarCodeNotFound the requested object does not exist
+	RegistrarCodeNotFound
+	// RegistrarCodeUnauthorized the registrar rejected the call signature
+	// or the node is not allowed to do the call
+	RegistrarCodeUnauthorized
+	// RegistrarCodeConflict the object already exists or was changed
+	RegistrarCodeConflict
+	// RegistrarCodeUnavailable the registrar can't be reached, the call can
+	// be tried again later. Mutating calls in this state may be queued
+	RegistrarCodeUnavailable
+	// RegistrarCodeRateLimited the registrar asked to slow down
+	RegistrarCodeRateLimited
+)
+
+func (c RegistrarErrorCode) String() string {
+	switch c {
+	case RegistrarCodeNoError:
+		return "no error"
+	case RegistrarCodeNotFound:
+		return "not found"
+	case RegistrarCodeUnauthorized:
+		return "unauthorized"
+	case RegistrarCodeConflict:
+		return "conflict"
+	case RegistrarCodeUnavailable:
+		return "unavailable"
+	case RegistrarCodeRateLimited:
+		return "rate limited"
+	}
+
+	return "error"
+}
+
+// RegistrarError is the error returned by the registrar gateway. Unlike an
+// error, it keeps its code when crossing zbus so callers can branch on the
+// kind of the error. The zero value is not an error.
+type RegistrarError struct {
+	Code    RegistrarErrorCode `json:"code"`
+	Message string             `json:"message"`
+	// Queued is set on mutating calls that could not reach the registrar
+	// (code RegistrarCodeUnavailable) and were queued by the gateway. The
+	// call is not applied yet, but it's sent once the registrar is back.
+	Queued bool `json:"queued"`
+}
+
+// IsQueued returns true if the call is queued to be sent later
+func (e RegistrarError) IsQueued() bool {
+	return e.IsError() && e.Queued
+}
+
+// IsError returns true if the call failed
+func (e RegistrarError) IsError() bool {
+	return e.Code != RegistrarCodeNoError
+}
+
+// IsCode returns true if the error is of any of codes
+func (e RegistrarError) IsCode(codes ...RegistrarErrorCode) bool {
+	for _, code := range codes {
+		if code == e.Code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Err returns the error as an error, or nil if the call succeeded. The
+// RegistrarError can be found again with AsRegistrarError.
+func (e RegistrarError) Err() error {
+	if !e.IsError() {
+		return nil
+	}
+
+	return registrarErr{e}
+}
+
+type registrarErr struct {
+	RegistrarError
+}
+
+func (e registrarErr) Error() string {
+	return e.Message
+}
+
+// AsRegistrarError finds a RegistrarError in the chain of err
+func AsRegistrarError(err error) (RegistrarError, bool) {
+	var e registrarErr
+	if errors.As(err, &e) {
+		return e.RegistrarError, true
+	}
+
+	return RegistrarError{}, false
+}
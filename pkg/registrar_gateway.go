@@ -6,35 +6,326 @@ import (
 	substrateTypes "github.com/centrifuge/go-substrate-rpc-client/v4/types"
 	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
 	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
-	"github.com/threefoldtech/zosbase/pkg"
 )
 
 //go:generate zbusc -module api-gateway -version 0.0.1 -name api-gateway -package stubs github.com/threefoldtech/zos4/pkg+RegistrarGateway stubs/registrar-gateway.go
 
+// ResourceConsumption is the consumption of a contract of billable resources
+// that are not part of its capacity, over a window of time
+type ResourceConsumption struct {
+	ContractID uint64 `json:"contract_id"`
+	// Timestamp is the end of the window
+	Timestamp int64 `json:"timestamp"`
+	// Window is the length of the window in seconds
+	Window uint64 `json:"window"`
+	// PublicIPSeconds total time public ips were reserved
+	PublicIPSeconds uint64 `json:"public_ip_seconds"`
+	// GPUSeconds total time gpus were attached, summed over all gpus
+	GPUSeconds uint64 `json:"gpu_seconds"`
+	// DiskReadBytes bytes read from volumes and zmounts
+	DiskReadBytes uint64 `json:"disk_read_bytes"`
+	// DiskWriteBytes bytes written to volumes and zmounts
+	DiskWriteBytes uint64 `json:"disk_write_bytes"`
+}
+
+// ContractEventKind is the kind of a contract state change
+type ContractEventKind string
+
+const (
+	// ContractEventCancelled the contract was deleted
+	ContractEventCancelled ContractEventKind = "cancelled"
+	// ContractEventLocked the contract entered grace period
+	ContractEventLocked ContractEventKind = "locked"
+	// ContractEventUnlocked the contract left grace period
+	ContractEventUnlocked ContractEventKind = "unlocked"
+)
+
+// ContractEvent is a state change of a node contract. IDs are increasing
+// so the id of the last processed event can be used as a cursor.
+type ContractEvent struct {
+	ID         uint64            `json:"id"`
+	ContractID uint64            `json:"contract_id"`
+	TwinID     uint32            `json:"twin_id"`
+	Kind       ContractEventKind `json:"kind"`
+}
+
+// OutboxStats are stats of mutating calls queued by the gateway while
+// the registrar is unreachable
+type OutboxStats struct {
+	// Depth number of queued calls
+	Depth uint64 `json:"depth"`
+	// Oldest queue time of the oldest call (unix time), 0 if empty
+	Oldest int64 `json:"oldest"`
+	// OldestMethod method of the oldest call
+	OldestMethod string `json:"oldest_method"`
+	// LastAttempt time of the last attempt to send a queued call (unix time)
+	LastAttempt int64 `json:"last_attempt"`
+	// LastError error of the last attempt, empty if it succeeded
+	LastError string `json:"last_error"`
+}
+
+// circuit breaker states of a registrar endpoint
+const (
+	// RegistrarEndpointClosed the endpoint is healthy and used
+	RegistrarEndpointClosed = "closed"
+	// RegistrarEndpointOpen the endpoint failed and is not used until a
+	// cooldown is over
+	RegistrarEndpointOpen = "open"
+	// RegistrarEndpointHalfOpen the cooldown is over, the next call decides
+	// if the endpoint is healthy again
+	RegistrarEndpointHalfOpen = "half-open"
+)
+
+// RegistrarEndpointStats are health stats of a registrar endpoint
+type RegistrarEndpointStats struct {
+	URL string `json:"url"`
+	// State of the endpoint circuit breaker (closed, open or half-open)
+	State string `json:"state"`
+	// Latency moving average of calls latency in milliseconds
+	Latency uint64 `json:"latency"`
+	// Requests number of calls sent to the endpoint
+	Requests uint64 `json:"requests"`
+	// Failures number of calls that could not reach the endpoint
+	Failures uint64 `json:"failures"`
+	// LastError error of the last failed call
+	LastError string `json:"last_error"`
+	// LastSuccess time of the last successful call (unix time), 0 if never
+	LastSuccess int64 `json:"last_success"`
+}
+
+// RegistrarStats are the connectivity stats of the registrar endpoints
+type RegistrarStats struct {
+	// Active url of the endpoint calls are sent to
+	Active    string                   `json:"active"`
+	Endpoints []RegistrarEndpointStats `json:"endpoints"`
+}
+
+// RegistrarLockStats are the stats of the wait of a gateway method for the
+// lock of its operation
+type RegistrarLockStats struct {
+	Method string `json:"method"`
+	// Calls number of times the lock was taken
+	Calls uint64 `json:"calls"`
+	// Wait total time waited for the lock in milliseconds
+	Wait uint64 `json:"wait"`
+	// MaxWait longest wait for the lock in milliseconds
+	MaxWait uint64 `json:"max_wait"`
+}
+
+// clock skew thresholds
+const (
+	// ClockSkewWarning is the skew beyond which the node clock is reported
+	// as drifting
+	ClockSkewWarning = 30 * time.Second
+	// ClockSkewMax is the default skew beyond which uptime reports are not
+	// sent to the registrar, and ntp is restarted
+	ClockSkewMax = 5 * time.Minute
+)
+
+// ClockSkew is the difference between the node clock and the registrar clock
+type ClockSkew struct {
+	// Skew is registrar time minus node time in milliseconds, positive if
+	// the node clock is behind
+	Skew int64 `json:"skew"`
+	// Checked time of the last successful measure (unix time), 0 if never
+	Checked int64 `json:"checked"`
+	// LastError error of the last measure, empty if it succeeded
+	LastError string `json:"last_error"`
+	// Blocked is true if uptime reports are not sent because of the skew
+	Blocked bool `json:"blocked"`
+}
+
+// Duration returns the absolute skew as a duration
+func (c ClockSkew) Duration() time.Duration {
+	d := time.Duration(c.Skew) * time.Millisecond
+	if d < 0 {
+		return -d
+	}
+
+	return d
+}
+
+// NodeInventory is the hardware inventory of the node as published on the
+// registrar
+type NodeInventory struct {
+	GPUs []GPUInventory `json:"gpus"`
+	// NICs are the physical network interfaces
+	NICs []NICInventory `json:"nics"`
+	// Disks are the storage disks grouped by type
+	Disks  []DiskInventory `json:"disks"`
+	Board  DMIInventory    `json:"board"`
+	System DMIInventory    `json:"system"`
+}
+
+// GPUInventory is a gpu of the node
+type GPUInventory struct {
+	// ShortID is the gpu id as used by deployments (slot/vendor/device)
+	ShortID string `json:"short_id"`
+	Vendor  string `json:"vendor"`
+	Device  string `json:"device"`
+}
+
+// NICInventory is a physical network interface of the node
+type NICInventory struct {
+	Name string `json:"name"`
+	Mac  string `json:"mac"`
+	// Speed is the link speed in Mbps, 0 if unknown (link down)
+	Speed uint64 `json:"speed"`
+	// WoL is true if the interface supports wake on lan (magic packet)
+	WoL bool `json:"wol"`
+}
+
+// DiskInventory is the disks of a type (ssd or hdd)
+type DiskInventory struct {
+	Type  string `json:"type"`
+	Count uint64 `json:"count"`
+	// Size is the total size of the disks in bytes
+	Size uint64 `json:"size"`
+}
+
+// DMIInventory is the board or system information as reported by dmi
+type DMIInventory struct {
+	Vendor  string `json:"vendor"`
+	Product string `json:"product"`
+	Version string `json:"version"`
+	Serial  string `json:"serial"`
+}
+
+// NodeAttestation is a TPM quote of the node boot state. The quote is
+// qualified with a nonce derived from the node public key, the timestamp
+// and the registrar nonce if any, and the attestation is signed by the node
+// key, so it's tied to the node identity.
+//
+// The attestation is unverified as long as AKCertified is not set: it only
+// proves the node holds the AK, not that the AK lives in a genuine TPM, so
+// the PCR values, Vendor and Emulated are claims of the node.
+type NodeAttestation struct {
+	// Vendor of the TPM as reported by the TPM
+	Vendor string `json:"vendor"`
+	// Emulated is set if the quote is made by a software TPM (swtpm). It's
+	// reported by the node, so a node can claim it's not emulated
+	Emulated bool `json:"emulated"`
+	// AKCertified is set if the AK is certified by the EK, through a
+	// credential activation of an AttestationChallenge. It's only set if
+	// the registrar issued a challenge
+	AKCertified bool `json:"ak_certified"`
+	// Nonce is the nonce of the registrar challenge, empty if the AK is not
+	// certified
+	Nonce []byte `json:"nonce,omitempty"`
+	// Secret is the activated credential of the registrar challenge, only
+	// the TPM holding both the EK and the AK can recover it
+	Secret []byte `json:"secret,omitempty"`
+	// Timestamp of the attestation (unix time)
+	Timestamp int64 `json:"timestamp"`
+	// EKPublic is the TPM endorsement key public part (PEM)
+	EKPublic string `json:"ek_public"`
+	// AKPublic is the public part of the key the quote is signed with (PEM)
+	AKPublic string `json:"ak_public"`
+	// PCRs are the quoted sha256 PCR values
+	PCRs []PCRValue `json:"pcrs"`
+	// Quote is the TPMS_ATTEST structure made by the TPM
+	Quote []byte `json:"quote"`
+	// QuoteSignature is the RSASSA-SHA256 signature of the quote by the AK
+	QuoteSignature []byte `json:"quote_signature"`
+	// Signature of the attestation by the node key
+	Signature []byte `json:"signature"`
+}
+
+// AttestationKeys are the TPM keys a node sends to get an attestation
+// challenge
+type AttestationKeys struct {
+	// EKPublic is the TPM endorsement key public part (PEM)
+	EKPublic string `json:"ek_public"`
+	// AKPublic is the TPMT_PUBLIC area of the attestation key, the AK name
+	// is computed from it
+	AKPublic []byte `json:"ak_public"`
+}
+
+// AttestationChallenge is issued by the registrar to certify the node AK.
+// The credential is made for the node EK and AK name (tpm2_makecredential),
+// so only the TPM holding both keys can activate it and recover the secret.
+type AttestationChallenge struct {
+	// Nonce the next attestation must be made with
+	Nonce []byte `json:"nonce"`
+	// Credential is the credential blob in the tpm2-tools format
+	Credential []byte `json:"credential"`
+}
+
+// PCRValue is the value of a single PCR
+type PCRValue struct {
+	Index  int    `json:"index"`
+	Digest []byte `json:"digest"`
+}
+
+// RegistrarGateway is the node access to the registrar. All methods return a
+// RegistrarError so callers can check the kind of error with its code.
 type RegistrarGateway interface {
-	CreateTwin(relay []string, rmbEncKey string) (client.Account, error)
-	EnsureAccount(relay []string, rmbEncKey string) (twin client.Account, err error)
-	GetTwin(id uint64) (client.Account, error)
-	GetTwinByPubKey(pk []byte) (uint64, error)
-
-	CreateNode(node client.Node) (uint64, error)
-	GetNode(id uint64) (client.Node, error)
-	GetNodes(farmID uint64) ([]uint64, error)
-	GetNodeByTwinID(twin uint64) (client.Node, error)
-	UpdateNode(node client.Node) error
-	UpdateNodeUptimeV2(uptime uint64, timestamp int64) (err error)
-
-	GetFarm(id uint64) (client.Farm, error)
-
-	GetTime() (time.Time, error)
-	GetZosVersion() (client.ZosVersion, error)
-
-	GetNodeContracts(node uint32) ([]substrateTypes.U64, error)
-	GetContract(id uint64) (substrate.Contract, pkg.SubstrateError)
-	GetContractIDByNameRegistration(name string) (uint64, pkg.SubstrateError)
-	GetNodeRentContract(node uint32) (uint64, pkg.SubstrateError)
-	GetPowerTarget() (power substrate.NodePower, err error)
-	Report(consumptions []substrate.NruConsumption) (substrateTypes.Hash, error)
-	SetContractConsumption(resources ...substrate.ContractResources) error
-	SetNodePowerState(up bool) (hash substrateTypes.Hash, err error)
+	CreateTwin(relay []string, rmbEncKey string) (client.Account, RegistrarError)
+	EnsureAccount(relay []string, rmbEncKey string) (twin client.Account, err RegistrarError)
+	GetTwin(id uint64) (client.Account, RegistrarError)
+	GetTwinByPubKey(pk []byte) (uint64, RegistrarError)
+	// RotateTwinKey sets the public key of the rotation twin to the new key
+	RotateTwinKey(rotation KeyRotation) RegistrarError
+
+	CreateNode(node client.Node) (uint64, RegistrarError)
+	GetNode(id uint64) (client.Node, RegistrarError)
+	GetNodes(farmID uint64) ([]uint64, RegistrarError)
+	GetNodeByTwinID(twin uint64) (client.Node, RegistrarError)
+	UpdateNode(node client.Node) RegistrarError
+	UpdateNodeUptimeV2(uptime uint64, timestamp int64) (err RegistrarError)
+	// GetNodeInventory returns the hardware inventory of the node on the
+	// registrar
+	GetNodeInventory(id uint64) (NodeInventory, RegistrarError)
+	// UpdateNodeInventory sets the hardware inventory of this node. The
+	// inventory is optional, the call is not queued and registrars that do
+	// not support it return a not found error
+	UpdateNodeInventory(inventory NodeInventory) RegistrarError
+	// GetNodeAttestation returns the last attestation of the node on the
+	// registrar
+	GetNodeAttestation(id uint64) (NodeAttestation, RegistrarError)
+	// GetAttestationChallenge gets a challenge from the registrar to
+	// certify the node AK. The call is not queued, a challenge is only valid
+	// for the next attestation
+	GetAttestationChallenge(keys AttestationKeys) (AttestationChallenge, RegistrarError)
+	// UpdateNodeAttestation publishes an attestation of this node, the call
+	// is not queued since the registrar only accepts fresh attestations
+	UpdateNodeAttestation(attestation NodeAttestation) RegistrarError
+	// RemoveNode removes this node from the registrar, the call is not
+	// queued if the registrar is unreachable
+	RemoveNode() RegistrarError
+
+	GetFarm(id uint64) (client.Farm, RegistrarError)
+
+	// GetTime returns the registrar time, the node clock is not used
+	GetTime() (time.Time, RegistrarError)
+	// ClockSkew returns the last measured skew of the node clock
+	ClockSkew() (ClockSkew, RegistrarError)
+	GetZosVersion() (client.ZosVersion, RegistrarError)
+
+	GetNodeContracts(node uint32) ([]substrateTypes.U64, RegistrarError)
+	GetNodeContractEvents(node uint32, after uint64) ([]ContractEvent, RegistrarError)
+	GetContract(id uint64) (substrate.Contract, RegistrarError)
+	GetContractIDByNameRegistration(name string) (uint64, RegistrarError)
+	GetNodeRentContract(node uint32) (uint64, RegistrarError)
+	// GetPowerTarget returns the power state and target of this node
+	GetPowerTarget() (power substrate.NodePower, err RegistrarError)
+	// GetNodePowerTarget returns the power state and target of node
+	GetNodePowerTarget(node uint32) (substrate.NodePower, RegistrarError)
+	// GetNodePublicConfig returns the public config of node
+	GetNodePublicConfig(node uint32) (substrate.OptionPublicConfig, RegistrarError)
+	Report(consumptions []substrate.NruConsumption) (substrateTypes.Hash, RegistrarError)
+	ReportResources(consumptions []ResourceConsumption) RegistrarError
+	// RegistrarStats returns the active registrar endpoint and the health
+	// of all endpoints
+	RegistrarStats() (RegistrarStats, RegistrarError)
+	// LockStats returns how long mutating methods waited for their
+	// operation lock
+	LockStats() ([]RegistrarLockStats, RegistrarError)
+	// OutboxStats returns stats of queued mutating calls
+	OutboxStats() (OutboxStats, RegistrarError)
+	// InvalidateCache drops cached lookups of methods (like GetTwin), all
+	// cached lookups are dropped if methods is empty
+	InvalidateCache(methods []string) RegistrarError
+	SetContractConsumption(resources ...substrate.ContractResources) RegistrarError
+	SetNodePowerState(up bool) (hash substrateTypes.Hash, err RegistrarError)
 }
@@ -0,0 +1,77 @@
+package registrargw
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+// attestationPath is the path of the node attestation
+func attestationPath(node uint64) string {
+	return fmt.Sprintf("nodes/%d/attestation", node)
+}
+
+func (s *httpSource) Attestation(node uint64) (attestation zos4Pkg.NodeAttestation, err error) {
+	err = s.do(http.MethodGet, attestationPath(node), nil, 0, nil, &attestation)
+	return
+}
+
+// Challenge gets an attestation challenge for the node keys
+func (s *httpSource) Challenge(keys zos4Pkg.AttestationKeys) (challenge zos4Pkg.AttestationChallenge, err error) {
+	twin, node, err := s.identity()
+	if err != nil {
+		return challenge, err
+	}
+
+	path := attestationPath(node) + "/challenge"
+	err = s.endpoints.Mutate(func(base string, _ *client.RegistrarClient) error {
+		return s.request(base, http.MethodPost, path, nil, twin, "", keys, &challenge)
+	})
+	return
+}
+
+func (s *httpSource) SetAttestation(attestation zos4Pkg.NodeAttestation) error {
+	_, err := s.submit(http.MethodPut, "attestation", "", attestation)
+	return err
+}
+
+func (r *registrarGateway) GetNodeAttestation(id uint64) (zos4Pkg.NodeAttestation, zos4Pkg.RegistrarError) {
+	log.Debug().
+		Str("method", "GetNodeAttestation").
+		Uint64("node_id", id).
+		Msg("method called")
+
+	attestation, err := cached(r.cache, "GetNodeAttestation", fmt.Sprint(id), func() (zos4Pkg.NodeAttestation, error) {
+		return r.api.Attestation(id)
+	})
+	return attestation, registrarError(err)
+}
+
+func (r *registrarGateway) GetAttestationChallenge(keys zos4Pkg.AttestationKeys) (zos4Pkg.AttestationChallenge, zos4Pkg.RegistrarError) {
+	log.Debug().
+		Str("method", "GetAttestationChallenge").
+		Msg("method called")
+
+	// a challenge is only valid for the next attestation, so it's not
+	// cached nor queued
+	challenge, err := r.api.Challenge(keys)
+	return challenge, registrarError(err)
+}
+
+func (r *registrarGateway) UpdateNodeAttestation(attestation zos4Pkg.NodeAttestation) zos4Pkg.RegistrarError {
+	log.Debug().
+		Str("method", "UpdateNodeAttestation").
+		Str("vendor", attestation.Vendor).
+		Bool("emulated", attestation.Emulated).
+		Msg("method called")
+
+	defer r.locks.lock(opNode, "UpdateNodeAttestation")()
+
+	// attestations are only accepted while fresh, a queued one would be
+	// rejected once sent
+	defer r.invalidate("GetNodeAttestation")
+	return registrarError(r.api.SetAttestation(attestation))
+}
@@ -0,0 +1,50 @@
+package registrargw
+
+import (
+	"context"
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/attestation"
+)
+
+func TestAttestation(t *testing.T) {
+	require := require.New(t)
+
+	server, gw, nodeID := registeredNode(t)
+
+	_, rerr := gw.GetNodeAttestation(nodeID)
+	require.True(rerr.IsCode(zos4Pkg.RegistrarCodeNotFound))
+
+	quoter, err := attestation.NewSoftware()
+	require.NoError(err)
+
+	challenger := func(keys zos4Pkg.AttestationKeys) (zos4Pkg.AttestationChallenge, error) {
+		challenge, rerr := gw.GetAttestationChallenge(keys)
+		return challenge, rerr.Err()
+	}
+
+	sk := ed25519.NewKeyFromSeed([]byte(strings.Repeat("n", ed25519.SeedSize)))
+	attested, err := attestation.Attest(context.Background(), quoter, sk, challenger)
+	require.NoError(err)
+	require.True(attested.AKCertified)
+
+	require.False(gw.UpdateNodeAttestation(attested).IsError())
+
+	stored, rerr := gw.GetNodeAttestation(nodeID)
+	require.False(rerr.IsError())
+	require.Equal(attested, stored)
+	require.Equal(attested, server.State().Attestations[nodeID])
+
+	// a challenge is only answered once
+	require.True(gw.UpdateNodeAttestation(attested).IsError())
+
+	// an attestation made with another key is rejected
+	other := ed25519.NewKeyFromSeed([]byte(strings.Repeat("o", ed25519.SeedSize)))
+	attested, err = attestation.Attest(context.Background(), quoter, other, nil)
+	require.NoError(err)
+	require.True(gw.UpdateNodeAttestation(attested).IsError())
+}
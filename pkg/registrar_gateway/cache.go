@@ -0,0 +1,328 @@
+package registrargw
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	cacheBucket = "cache"
+
+	// cacheRetention is how long entries are kept without being refreshed.
+	// entries older than their ttl are still served if the registrar
+	// can't be reached.
+	cacheRetention = 30 * 24 * time.Hour
+)
+
+// cacheTTL is how long a cached value is fresh per method. A stale value
+// is returned right away and refreshed in the background, unless it's
+// older than cacheMaxStale where it's refreshed before returning.
+var cacheTTL = map[string]time.Duration{
+	"GetTwin":            time.Hour,
+	"GetTwinByPubKey":    time.Hour,
+	"GetFarm":            time.Hour,
+	"GetNode":            10 * time.Minute,
+	"GetNodeByTwinID":    10 * time.Minute,
+	"GetNodes":           10 * time.Minute,
+	"GetNodeInventory":   10 * time.Minute,
+	"GetNodeAttestation": 10 * time.Minute,
+	"GetZosVersion":      5 * time.Minute,
+}
+
+// cacheMaxStale is the max age (over ttl) of a value that is served while revalidating
+const cacheMaxStale = 24 * time.Hour
+
+type cacheEntry struct {
+	Value  json.RawMessage `json:"value"`
+	Stored int64           `json:"stored"`
+}
+
+// cache is a persistent read-through cache of registrar lookups. A nil
+// cache is valid and always calls through.
+type cache struct {
+	db *bolt.DB
+
+	mu       sync.Mutex
+	inflight map[string]struct{}
+	// generation is bumped on every invalidation, values fetched before
+	// are not stored so an invalidated value is not written back
+	generation uint64
+}
+
+// newCache opens the cache at path
+func newCache(path string) (*cache, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open cache database")
+	}
+
+	c := &cache{db: db, inflight: make(map[string]struct{})}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(cacheBucket))
+		if err != nil {
+			return err
+		}
+
+		// drop entries that were not refreshed for a long time
+		expired := time.Now().Add(-cacheRetention).Unix()
+		var keys [][]byte
+		err = bucket.ForEach(func(k, v []byte) error {
+			var entry cacheEntry
+			if err := json.Unmarshal(v, &entry); err != nil || entry.Stored < expired {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return deleteKeys(bucket, keys)
+	}); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to initialize cache database")
+	}
+
+	return c, nil
+}
+
+func cacheKey(method, key string) string {
+	return method + "/" + key
+}
+
+func (c *cache) get(key string) (entry cacheEntry, ok bool, err error) {
+	err = c.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(cacheBucket)).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		ok = true
+		return json.Unmarshal(data, &entry)
+	})
+
+	return
+}
+
+func (c *cache) set(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	entry, err := json.Marshal(cacheEntry{Value: data, Stored: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(cacheBucket)).Put([]byte(key), entry)
+	})
+}
+
+func (c *cache) del(key string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(cacheBucket)).Delete([]byte(key))
+	})
+}
+
+// current returns the current invalidation generation
+func (c *cache) current() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// store sets the value of key if the cache was not invalidated since
+// generation gen, if value is nil the key is dropped instead
+func (c *cache) store(key string, gen uint64, value interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != gen {
+		log.Debug().Str("key", key).Msg("cache was invalidated, dropping fetched value")
+		return nil
+	}
+
+	if value == nil {
+		return c.del(key)
+	}
+
+	return c.set(key, value)
+}
+
+// Invalidate drops all cached values of methods. If no methods are
+// given the full cache is dropped.
+func (c *cache) Invalidate(methods ...string) error {
+	if c == nil {
+		return nil
+	}
+
+	if len(methods) == 0 {
+		return c.invalidate("")
+	}
+
+	for _, method := range methods {
+		if err := c.invalidate(cacheKey(method, "")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *cache) invalidate(prefix string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	return c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(cacheBucket))
+		var keys [][]byte
+		cur := bucket.Cursor()
+		for k, _ := cur.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, _ = cur.Next() {
+			keys = append(keys, k)
+		}
+
+		return deleteKeys(bucket, keys)
+	})
+}
+
+// deleteKeys deletes keys from bucket, deleting while iterating
+// with a cursor can skip keys.
+func deleteKeys(bucket *bolt.Bucket, keys [][]byte) error {
+	for _, key := range keys {
+		if err := bucket.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close the cache
+func (c *cache) Close() error {
+	if c == nil {
+		return nil
+	}
+
+	return c.db.Close()
+}
+
+// refresh calls fetch and stores the value in the background, only one
+// refresh per key can run at a time
+func refresh[T any](c *cache, key string, fetch func() (T, error)) {
+	c.mu.Lock()
+	if _, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		return
+	}
+	c.inflight[key] = struct{}{}
+	gen := c.generation
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.inflight, key)
+			c.mu.Unlock()
+		}()
+
+		value, err := fetch()
+		if isNotFound(err) {
+			if err := c.store(key, gen, nil); err != nil {
+				log.Error().Err(err).Str("key", key).Msg("failed to drop cached value")
+			}
+			return
+		} else if err != nil {
+			log.Debug().Err(err).Str("key", key).Msg("failed to revalidate cached value")
+			return
+		}
+
+		if err := c.store(key, gen, value); err != nil {
+			log.Error().Err(err).Str("key", key).Msg("failed to update cache")
+		}
+	}()
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) ||
+		errors.Is(err, client.ErrorAccountNotFound) ||
+		errors.Is(err, client.ErrorFarmNotFound) ||
+		errors.Is(err, client.ErrorNodeNotFound)
+}
+
+// cached returns the value of method for key from the cache if fresh.
+// Otherwise the value is fetched and cached. If fetch fails, the last
+// known value is returned no matter how old it is, unless the registrar
+// says it does not exist anymore.
+func cached[T any](c *cache, method, key string, fetch func() (T, error)) (T, error) {
+	if c == nil {
+		return fetch()
+	}
+
+	ttl, ok := cacheTTL[method]
+	if !ok {
+		return fetch()
+	}
+
+	key = cacheKey(method, key)
+	entry, found, err := c.get(key)
+	if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("failed to read cache")
+		found = false
+	}
+
+	var value T
+	if found {
+		if err := json.Unmarshal(entry.Value, &value); err != nil {
+			log.Error().Err(err).Str("key", key).Msg("failed to decode cached value")
+			found = false
+		}
+	}
+
+	if found {
+		age := time.Since(time.Unix(entry.Stored, 0))
+		if age < ttl {
+			return value, nil
+		}
+
+		if age < ttl+cacheMaxStale {
+			refresh(c, key, fetch)
+			return value, nil
+		}
+	}
+
+	gen := c.current()
+	fresh, err := fetch()
+	if isNotFound(err) {
+		if found {
+			if err := c.store(key, gen, nil); err != nil {
+				log.Error().Err(err).Str("key", key).Msg("failed to drop cached value")
+			}
+		}
+
+		return fresh, err
+	} else if err != nil {
+		if found {
+			log.Warn().Err(err).Str("key", key).Msg("registrar call failed, using cached value")
+			return value, nil
+		}
+
+		return fresh, err
+	}
+
+	if err := c.store(key, gen, fresh); err != nil {
+		log.Error().Err(err).Str("key", key).Msg("failed to update cache")
+	}
+
+	return fresh, nil
+}
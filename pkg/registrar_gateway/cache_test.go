@@ -0,0 +1,139 @@
+package registrargw
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+// age makes a cached value look like it was stored d ago
+func age(t *testing.T, c *cache, key string, d time.Duration) {
+	require.NoError(t, c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(cacheBucket))
+		var entry cacheEntry
+		if err := json.Unmarshal(bucket.Get([]byte(key)), &entry); err != nil {
+			return err
+		}
+
+		entry.Stored = time.Now().Add(-d).Unix()
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(key), data)
+	}))
+}
+
+func TestCache(t *testing.T) {
+	require := require.New(t)
+
+	c, err := newCache(filepath.Join(t.TempDir(), "cache.bolt"))
+	require.NoError(err)
+	defer c.Close()
+
+	calls := 0
+	var fail error
+	fetch := func() (uint64, error) {
+		calls++
+		return uint64(calls), fail
+	}
+
+	get := func() uint64 {
+		value, err := cached(c, "GetTwin", "1", fetch)
+		require.NoError(err)
+		return value
+	}
+
+	// first call goes through
+	require.EqualValues(1, get())
+	// then it's served from cache
+	require.EqualValues(1, get())
+	require.Equal(1, calls)
+
+	// methods without ttl are never cached
+	value, err := cached(c, "GetContract", "1", fetch)
+	require.NoError(err)
+	require.EqualValues(2, value)
+
+	// stale values are served while refreshed in the background
+	age(t, c, cacheKey("GetTwin", "1"), 2*time.Hour)
+	require.EqualValues(1, get())
+	require.Eventually(func() bool { return get() == 3 }, time.Second, 10*time.Millisecond)
+
+	// too old values are refreshed right away
+	age(t, c, cacheKey("GetTwin", "1"), 2*cacheMaxStale)
+	require.EqualValues(4, get())
+
+	// unless the registrar can't be reached
+	age(t, c, cacheKey("GetTwin", "1"), 2*cacheMaxStale)
+	fail = fmt.Errorf("connection refused")
+	require.EqualValues(4, get())
+
+	// or the value does not exist anymore
+	fail = ErrNotFound
+	_, err = cached(c, "GetTwin", "1", fetch)
+	require.ErrorIs(err, ErrNotFound)
+	_, found, err := c.get(cacheKey("GetTwin", "1"))
+	require.NoError(err)
+	require.False(found)
+
+	fail = nil
+	require.EqualValues(7, get())
+
+	_, err = cached(c, "GetFarm", "1", fetch)
+	require.NoError(err)
+
+	require.NoError(c.Invalidate("GetTwin"))
+	_, found, _ = c.get(cacheKey("GetTwin", "1"))
+	require.False(found)
+	_, found, _ = c.get(cacheKey("GetFarm", "1"))
+	require.True(found)
+
+	require.NoError(c.Invalidate())
+	_, found, _ = c.get(cacheKey("GetFarm", "1"))
+	require.False(found)
+}
+
+func TestCacheInvalidateRefresh(t *testing.T) {
+	require := require.New(t)
+
+	c, err := newCache(filepath.Join(t.TempDir(), "cache.bolt"))
+	require.NoError(err)
+	defer c.Close()
+
+	key := cacheKey("GetTwin", "1")
+	require.NoError(c.set(key, 1))
+	age(t, c, key, 2*time.Hour)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	value, err := cached(c, "GetTwin", "1", func() (uint64, error) {
+		close(started)
+		<-release
+		return 2, nil
+	})
+	require.NoError(err)
+	require.EqualValues(1, value)
+
+	// the value is invalidated while the refresh is running, the old value
+	// must not be written back
+	<-started
+	require.NoError(c.Invalidate("GetTwin"))
+	close(release)
+
+	require.Eventually(func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.inflight) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	_, found, err := c.get(key)
+	require.NoError(err)
+	require.False(found)
+}
@@ -0,0 +1,155 @@
+package registrargw
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+const (
+	// ParamMaxClockSkew kernel param with the skew (like 2m) beyond which
+	// uptime reports are not sent. 0 never blocks reports.
+	ParamMaxClockSkew = "max-clock-skew"
+
+	// clockInterval is how often the clock skew is measured
+	clockInterval = 10 * time.Minute
+	clockTimeout  = 10 * time.Second
+	// clockPath is requested to read the registrar time from the Date
+	// header of the response
+	clockPath = "zos/version"
+)
+
+// clock keeps the last measured skew of the node clock against the
+// registrar clock
+type clock struct {
+	cl  http.Client
+	max time.Duration
+
+	mu   sync.Mutex
+	skew zos4Pkg.ClockSkew
+}
+
+func newClock(max time.Duration) *clock {
+	return &clock{
+		cl:  http.Client{Timeout: clockTimeout},
+		max: max,
+	}
+}
+
+// measure returns the skew of the node clock against the registrar at base
+func (c *clock) measure(base string) (time.Duration, error) {
+	u, err := url.JoinPath(base, clockPath)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	resp, err := c.cl.Get(u)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	end := time.Now()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, errors.Wrap(err, "registrar response has no valid date")
+	}
+
+	// the date is truncated to the second, so on average the registrar
+	// time was half a second later, and the date was set half way through
+	// the round trip
+	remote := date.Add(500 * time.Millisecond)
+	local := start.Add(end.Sub(start) / 2)
+
+	return remote.Sub(local), nil
+}
+
+func (c *clock) record(skew time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		c.skew.LastError = err.Error()
+		return
+	}
+
+	c.skew.Skew = skew.Milliseconds()
+	c.skew.Checked = time.Now().Unix()
+	c.skew.LastError = ""
+}
+
+// blocked returns true if the last measured skew is beyond the max skew
+func (c *clock) blocked() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.max > 0 && c.skew.Checked != 0 && c.skew.Duration() > c.max
+}
+
+func (c *clock) get() zos4Pkg.ClockSkew {
+	blocked := c.blocked()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	skew := c.skew
+	skew.Blocked = blocked
+	return skew
+}
+
+// maxClockSkew returns the max skew set by the kernel params or the default
+func maxClockSkew(value string, ok bool) time.Duration {
+	if !ok {
+		return zos4Pkg.ClockSkewMax
+	}
+
+	max, err := time.ParseDuration(value)
+	if err != nil || max < 0 {
+		log.Error().Str("value", value).Msgf("invalid %s kernel param, using default", ParamMaxClockSkew)
+		return zos4Pkg.ClockSkewMax
+	}
+
+	return max
+}
+
+// measureClock measures the node clock skew against the registrar
+func (r *registrarGateway) measureClock() (skew time.Duration, err error) {
+	err = r.registrar.Do(func(base string, _ *client.RegistrarClient) (err error) {
+		skew, err = r.clock.measure(base)
+		return err
+	})
+
+	r.clock.record(skew, err)
+	return skew, err
+}
+
+// monitorClock measures the clock skew until the context is cancelled
+func (r *registrarGateway) monitorClock(ctx context.Context) {
+	ticker := time.NewTicker(clockInterval)
+	defer ticker.Stop()
+
+	for {
+		skew, err := r.measureClock()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to measure clock skew against the registrar")
+		} else if r.clock.blocked() {
+			log.Error().Dur("skew", skew).Msg("node clock is too far off, uptime reports are blocked")
+		} else if skew.Abs() > zos4Pkg.ClockSkewWarning {
+			log.Warn().Dur("skew", skew).Msg("node clock is drifting from the registrar clock")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
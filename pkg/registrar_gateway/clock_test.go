@@ -0,0 +1,61 @@
+package registrargw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+func TestClockSkew(t *testing.T) {
+	require := require.New(t)
+
+	offset := time.Hour
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	endpoints, err := NewEndpoints([]string{server.URL}, "")
+	require.NoError(err)
+	gw := registrarGateway{registrar: endpoints, clock: newClock(time.Minute)}
+
+	skew, rerr := gw.ClockSkew()
+	require.NoError(rerr.Err())
+	require.Zero(skew.Checked)
+	require.False(skew.Blocked)
+
+	now, rerr := gw.GetTime()
+	require.NoError(rerr.Err())
+	require.WithinDuration(time.Now().Add(offset), now, 2*time.Second)
+
+	skew, rerr = gw.ClockSkew()
+	require.NoError(rerr.Err())
+	require.NotZero(skew.Checked)
+	require.InDelta(offset.Milliseconds(), skew.Skew, 2000)
+	require.True(skew.Blocked)
+
+	rerr = gw.UpdateNodeUptimeV2(10, time.Now().Unix())
+	require.True(rerr.IsError())
+
+	// a clock that is behind is detected too
+	offset = -time.Minute / 2
+	_, rerr = gw.GetTime()
+	require.NoError(rerr.Err())
+	skew, _ = gw.ClockSkew()
+	require.InDelta(offset.Milliseconds(), skew.Skew, 2000)
+	require.False(skew.Blocked)
+}
+
+func TestMaxClockSkew(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(zos4Pkg.ClockSkewMax, maxClockSkew("", false))
+	require.Equal(2*time.Minute, maxClockSkew("2m", true))
+	require.Equal(time.Duration(0), maxClockSkew("0", true))
+	require.Equal(zos4Pkg.ClockSkewMax, maxClockSkew("soon", true))
+}
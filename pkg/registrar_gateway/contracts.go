@@ -0,0 +1,226 @@
+package registrargw
+
+import (
+	"fmt"
+
+	subTypes "github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/pkg/errors"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+// ErrNotFound is returned by contract sources if the requested object does not exist
+var ErrNotFound = errors.New("not found")
+
+// Contract types
+const (
+	ContractTypeNode = "node"
+	ContractTypeName = "name"
+	ContractTypeRent = "rent"
+)
+
+// Contract states
+const (
+	ContractStateCreated     = "created"
+	ContractStateGracePeriod = "grace_period"
+	ContractStateDeleted     = "deleted"
+)
+
+// Contract is a contract as served by the registrar. It's also the
+// format of the contracts in a local contracts file.
+type Contract struct {
+	ContractID uint64 `json:"contract_id"`
+	TwinID     uint32 `json:"twin_id"`
+	// Type is one of node, name or rent
+	Type string `json:"type"`
+	// State is one of created, grace_period or deleted
+	State string `json:"state"`
+	// NodeID of node and rent contracts
+	NodeID uint32 `json:"node_id,omitempty"`
+	// DeploymentHash hex encoded challenge hash of the deployment (node contracts)
+	DeploymentHash string `json:"deployment_hash,omitempty"`
+	DeploymentData string `json:"deployment_data,omitempty"`
+	PublicIPs      uint32 `json:"public_ips,omitempty"`
+	// Name of name contracts
+	Name string `json:"name,omitempty"`
+}
+
+// Active returns true if the contract is not deleted
+func (c *Contract) Active() bool {
+	return c.State != ContractStateDeleted
+}
+
+// Substrate converts the contract to the type the rest of the
+// system (still) expects
+func (c *Contract) Substrate() (substrate.Contract, error) {
+	result := substrate.Contract{
+		ContractID: subTypes.U64(c.ContractID),
+		TwinID:     subTypes.U32(c.TwinID),
+	}
+
+	switch c.State {
+	case ContractStateCreated:
+		result.State.IsCreated = true
+	case ContractStateGracePeriod:
+		result.State.IsGracePeriod = true
+	case ContractStateDeleted:
+		result.State.IsDeleted = true
+	default:
+		return result, fmt.Errorf("invalid contract state '%s'", c.State)
+	}
+
+	switch c.Type {
+	case ContractTypeNode:
+		result.ContractType.IsNodeContract = true
+		result.ContractType.NodeContract = substrate.NodeContract{
+			Node:           subTypes.U32(c.NodeID),
+			DeploymentHash: substrate.NewHexHash(c.DeploymentHash),
+			DeploymentData: c.DeploymentData,
+			PublicIPsCount: subTypes.U32(c.PublicIPs),
+		}
+	case ContractTypeName:
+		result.ContractType.IsNameContract = true
+		result.ContractType.NameContract = substrate.NameContract{Name: c.Name}
+	case ContractTypeRent:
+		result.ContractType.IsRentContract = true
+		result.ContractType.RentContract = substrate.RentContract{Node: subTypes.U32(c.NodeID)}
+	default:
+		return result, fmt.Errorf("invalid contract type '%s'", c.Type)
+	}
+
+	return result, nil
+}
+
+// ContractSource is where the gateway gets contracts from and sends
+// contracts related reports to
+type ContractSource interface {
+	// NodeContracts returns ids of active node contracts of node
+	NodeContracts(node uint32) ([]uint64, error)
+	// Contract returns a contract by id
+	Contract(id uint64) (Contract, error)
+	// ContractIDByName returns the id of the name contract with name
+	ContractIDByName(name string) (uint64, error)
+	// NodeRentContract returns the id of the active rent contract of node
+	NodeRentContract(node uint32) (uint64, error)
+	// Events returns state changes of node contracts after the event with id after
+	Events(node uint32, after uint64) ([]zos4Pkg.ContractEvent, error)
+	// Report sends nru consumption reports, it returns the hash of the report.
+	// key identifies the report, a report sent again with the same key is
+	// only applied once.
+	Report(key string, consumptions []substrate.NruConsumption) (subTypes.Hash, error)
+	// ReportResources sends consumption of billable resources that are not
+	// part of the contracts capacity, key identifies the call like with Report
+	ReportResources(key string, consumptions []zos4Pkg.ResourceConsumption) error
+	// SetContractConsumption sets the used capacity of contracts, key
+	// identifies the call like with Report
+	SetContractConsumption(key string, resources []substrate.ContractResources) error
+	// SetNodePowerState sets the power state of the node
+	SetNodePowerState(up bool) (subTypes.Hash, error)
+	// PowerTarget returns the power state and target of node, the target
+	// is set by the farmer
+	PowerTarget(node uint32) (substrate.NodePower, error)
+	// PublicConfig returns the public config of node set by the farmer
+	PublicConfig(node uint32) (substrate.OptionPublicConfig, error)
+}
+
+// powerUp is the power of a node that has no power target set
+var powerUp = substrate.NodePower{
+	State:  substrate.PowerState{IsUp: true},
+	Target: substrate.Power{IsUp: true},
+}
+
+// change returns the event kind of a node contract going from old to new,
+// any of them can be nil if the contract does not exist.
+func change(old, new *Contract) (zos4Pkg.ContractEventKind, bool) {
+	if old == nil && new == nil {
+		return "", false
+	}
+
+	var oldState, newState string
+	if old != nil {
+		oldState = old.State
+	}
+	if new != nil {
+		newState = new.State
+	} else {
+		newState = ContractStateDeleted
+	}
+
+	switch {
+	case oldState == newState:
+		return "", false
+	case newState == ContractStateDeleted:
+		// a contract we never seen is not worth an event
+		return zos4Pkg.ContractEventCancelled, old != nil
+	case newState == ContractStateGracePeriod:
+		return zos4Pkg.ContractEventLocked, true
+	case oldState == ContractStateGracePeriod:
+		return zos4Pkg.ContractEventUnlocked, true
+	}
+
+	return "", false
+}
+
+// maxEvents is the number of events kept by in memory sources
+const maxEvents = 1024
+
+type nodeEvent struct {
+	node  uint32
+	event zos4Pkg.ContractEvent
+}
+
+// eventLog keeps the last events of in memory sources
+type eventLog struct {
+	events []nodeEvent
+	last   uint64
+	// base if set is the min value of the next event id
+	base func() uint64
+}
+
+// record adds an event if a node contract changed state
+func (l *eventLog) record(old, new *Contract) {
+	contract := new
+	if contract == nil {
+		contract = old
+	}
+
+	if contract == nil || contract.Type != ContractTypeNode {
+		return
+	}
+
+	kind, ok := change(old, new)
+	if !ok {
+		return
+	}
+
+	l.last++
+	if l.base != nil && l.base() > l.last {
+		l.last = l.base()
+	}
+
+	l.events = append(l.events, nodeEvent{
+		node: contract.NodeID,
+		event: zos4Pkg.ContractEvent{
+			ID:         l.last,
+			ContractID: contract.ContractID,
+			TwinID:     contract.TwinID,
+			Kind:       kind,
+		},
+	})
+
+	if len(l.events) > maxEvents {
+		l.events = l.events[len(l.events)-maxEvents:]
+	}
+}
+
+// after returns events of node with ids after after
+func (l *eventLog) after(node uint32, after uint64) []zos4Pkg.ContractEvent {
+	events := []zos4Pkg.ContractEvent{}
+	for _, event := range l.events {
+		if event.node == node && event.event.ID > after {
+			events = append(events, event.event)
+		}
+	}
+
+	return events
+}
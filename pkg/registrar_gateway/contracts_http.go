@@ -0,0 +1,314 @@
+package registrargw
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	subTypes "github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/pkg/errors"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+const (
+	httpTimeout = 30 * time.Second
+	// eventsWait is how long the registrar holds an events request
+	// if there are no new events
+	eventsWait = 20 * time.Second
+)
+
+// httpSource gets contracts from the registrar http api
+type httpSource struct {
+	cl        http.Client
+	endpoints *Endpoints
+	sk        ed25519.PrivateKey
+
+	mu   sync.Mutex
+	twin uint64
+	node uint64
+}
+
+var _ ContractSource = (*httpSource)(nil)
+
+// NewHTTPSource creates a contract source that uses the registrar api of
+// endpoints, which is also used to find the node twin and id. sk is the
+// node identity key used to sign mutating requests.
+func NewHTTPSource(endpoints *Endpoints, sk ed25519.PrivateKey) ContractSource {
+	return newHTTPSource(endpoints, sk)
+}
+
+func newHTTPSource(endpoints *Endpoints, sk ed25519.PrivateKey) *httpSource {
+	return &httpSource{
+		cl:        http.Client{Timeout: httpTimeout},
+		endpoints: endpoints,
+		sk:        sk,
+	}
+}
+
+// identity returns the node twin and node id. Values are cached once found
+// since the node can be registered after the source is created.
+func (s *httpSource) identity() (twin, node uint64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.node != 0 {
+		return s.twin, s.node, nil
+	}
+
+	if s.twin == 0 {
+		account, err := call(s.endpoints, func(cl *client.RegistrarClient) (client.Account, error) {
+			return cl.GetAccountByPK(s.sk.Public().(ed25519.PublicKey))
+		})
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "failed to get node twin")
+		}
+		s.twin = account.TwinID
+	}
+
+	n, err := call(s.endpoints, func(cl *client.RegistrarClient) (client.Node, error) {
+		return cl.GetNodeByTwinID(s.twin)
+	})
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get node")
+	}
+	s.node = n.NodeID
+
+	return s.twin, s.node, nil
+}
+
+func (s *httpSource) authHeader(twin uint64) string {
+	challenge := []byte(fmt.Sprintf("%d:%d", time.Now().Unix(), twin))
+	signature := ed25519.Sign(s.key(), challenge)
+
+	return fmt.Sprintf(
+		"%s:%s",
+		base64.StdEncoding.EncodeToString(challenge),
+		base64.StdEncoding.EncodeToString(signature),
+	)
+}
+
+// do sends the request to the first reachable endpoint and decodes the
+// response into out (if not nil). if twin is not 0 the request is signed
+// on behalf of this twin. Only GET requests fail over to the next endpoint
+// if the request may have reached the registrar.
+func (s *httpSource) do(method, path string, query url.Values, twin uint64, in, out interface{}) error {
+	send := s.endpoints.Mutate
+	if method == http.MethodGet {
+		send = s.endpoints.Do
+	}
+
+	return send(func(base string, _ *client.RegistrarClient) error {
+		return s.request(base, method, path, query, twin, "", in, out)
+	})
+}
+
+// request sends a request to the registrar at base. if key is set it's sent
+// as the idempotency key of the request, so the registrar applies a request
+// that is sent again only once.
+func (s *httpSource) request(base, method, path string, query url.Values, twin uint64, key string, in, out interface{}) error {
+	u, err := url.JoinPath(base, path)
+	if err != nil {
+		return err
+	}
+
+	if len(query) != 0 {
+		u = u + "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode request body")
+		}
+		body = bytes.NewBuffer(data)
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return errors.Wrap(err, "failed to construct request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if twin != 0 {
+		req.Header.Set(AuthHeader, s.authHeader(twin))
+	}
+	if len(key) != 0 {
+		req.Header.Set(IdempotencyHeader, key)
+	}
+
+	resp, err := s.cl.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to send request to %s", path)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var response struct {
+			Error string `json:"error"`
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || response.Error == "" {
+			return fmt.Errorf("registrar responded with status '%s'", resp.Status)
+		}
+
+		return fmt.Errorf("registrar responded with status '%s': %s", resp.Status, response.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(out), "failed to decode response")
+}
+
+func (s *httpSource) list(query url.Values) (contracts []Contract, err error) {
+	err = s.do(http.MethodGet, "contracts", query, 0, nil, &contracts)
+	return
+}
+
+func (s *httpSource) NodeContracts(node uint32) ([]uint64, error) {
+	contracts, err := s.list(url.Values{
+		"node_id": []string{strconv.FormatUint(uint64(node), 10)},
+		"type":    []string{ContractTypeNode},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint64, 0, len(contracts))
+	for _, contract := range contracts {
+		if contract.Active() {
+			ids = append(ids, contract.ContractID)
+		}
+	}
+
+	return ids, nil
+}
+
+func (s *httpSource) Contract(id uint64) (contract Contract, err error) {
+	err = s.do(http.MethodGet, fmt.Sprintf("contracts/%d", id), nil, 0, nil, &contract)
+	return
+}
+
+// first returns the id of the first active contract in contracts
+func first(contracts []Contract) (uint64, error) {
+	for _, contract := range contracts {
+		if contract.Active() {
+			return contract.ContractID, nil
+		}
+	}
+
+	return 0, ErrNotFound
+}
+
+func (s *httpSource) ContractIDByName(name string) (uint64, error) {
+	contracts, err := s.list(url.Values{
+		"name": []string{name},
+		"type": []string{ContractTypeName},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return first(contracts)
+}
+
+func (s *httpSource) NodeRentContract(node uint32) (uint64, error) {
+	contracts, err := s.list(url.Values{
+		"node_id": []string{strconv.FormatUint(uint64(node), 10)},
+		"type":    []string{ContractTypeRent},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return first(contracts)
+}
+
+func (s *httpSource) Events(node uint32, after uint64) (events []zos4Pkg.ContractEvent, err error) {
+	err = s.do(http.MethodGet, fmt.Sprintf("nodes/%d/contracts/events", node), url.Values{
+		"after": []string{strconv.FormatUint(after, 10)},
+		"wait":  []string{strconv.Itoa(int(eventsWait.Seconds()))},
+	}, 0, nil, &events)
+	return
+}
+
+// submit sends a signed request for the node and returns the hash in the
+// response. key is the idempotency key of the request, it can be empty for
+// requests that can be applied more than once.
+func (s *httpSource) submit(method, path, key string, in interface{}) (hash subTypes.Hash, err error) {
+	twin, node, err := s.identity()
+	if err != nil {
+		return hash, err
+	}
+
+	var response struct {
+		Hash string `json:"hash"`
+	}
+
+	path = fmt.Sprintf("nodes/%d/%s", node, path)
+	if err := s.endpoints.Mutate(func(base string, _ *client.RegistrarClient) error {
+		return s.request(base, method, path, nil, twin, key, in, &response)
+	}); err != nil {
+		return hash, err
+	}
+
+	if response.Hash == "" {
+		return hash, nil
+	}
+
+	return subTypes.NewHashFromHexString(response.Hash)
+}
+
+func (s *httpSource) Report(key string, consumptions []substrate.NruConsumption) (subTypes.Hash, error) {
+	return s.submit(http.MethodPost, "consumption", key, consumptions)
+}
+
+func (s *httpSource) ReportResources(key string, consumptions []zos4Pkg.ResourceConsumption) error {
+	_, err := s.submit(http.MethodPost, "consumption/resources", key, consumptions)
+	return err
+}
+
+func (s *httpSource) SetContractConsumption(key string, resources []substrate.ContractResources) error {
+	_, err := s.submit(http.MethodPut, "contracts/resources", key, resources)
+	return err
+}
+
+func (s *httpSource) PowerTarget(node uint32) (power substrate.NodePower, err error) {
+	err = s.do(http.MethodGet, fmt.Sprintf("nodes/%d/power", node), nil, 0, nil, &power)
+	if errors.Is(err, ErrNotFound) {
+		return powerUp, nil
+	}
+
+	return
+}
+
+func (s *httpSource) PublicConfig(node uint32) (config substrate.OptionPublicConfig, err error) {
+	err = s.do(http.MethodGet, fmt.Sprintf("nodes/%d/public-config", node), nil, 0, nil, &config)
+	if errors.Is(err, ErrNotFound) {
+		return substrate.OptionPublicConfig{}, nil
+	}
+
+	return
+}
+
+func (s *httpSource) SetNodePowerState(up bool) (subTypes.Hash, error) {
+	return s.submit(http.MethodPut, "power", "", struct {
+		Up bool `json:"up"`
+	}{Up: up})
+}
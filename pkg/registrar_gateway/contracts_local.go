@@ -0,0 +1,227 @@
+package registrargw
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	subTypes "github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+// localSource serves contracts from a json file. It's meant for dev and
+// test nodes that are not backed by a registrar that serves contracts.
+// The file is of the form
+//
+//	{"contracts": [{"contract_id": 1, "twin_id": 10, "type": "node", "state": "created", "node_id": 1, "deployment_hash": "..."}]}
+//
+// and is reloaded when modified, so contracts can be added, locked or
+// deleted while the node is running. Changes between reloads are served
+// as contract events. Reports are only logged.
+type localSource struct {
+	path string
+
+	mu        sync.Mutex
+	modified  time.Time
+	contracts []Contract
+	events    eventLog
+}
+
+var _ ContractSource = (*localSource)(nil)
+
+// NewLocalSource creates a contract source backed by the file at path
+func NewLocalSource(path string) (ContractSource, error) {
+	source := &localSource{path: path}
+	// events ids are not persisted, so they are based on time to keep
+	// them increasing across restarts.
+	source.events.base = func() uint64 {
+		return uint64(time.Now().UnixNano())
+	}
+
+	if _, err := source.load(); err != nil {
+		return nil, err
+	}
+
+	return source, nil
+}
+
+// load returns the file contracts, reloading the file if it was modified
+func (s *localSource) load() ([]Contract, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stat, err := os.Stat(s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stat contracts file")
+	}
+
+	if !stat.ModTime().After(s.modified) {
+		return s.contracts, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read contracts file")
+	}
+
+	var file struct {
+		Contracts []Contract `json:"contracts"`
+	}
+
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode contracts file '%s'", s.path)
+	}
+
+	for i := range file.Contracts {
+		if _, err := file.Contracts[i].Substrate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid contract '%d'", file.Contracts[i].ContractID)
+		}
+	}
+
+	if !s.modified.IsZero() {
+		s.diff(file.Contracts)
+	}
+
+	s.contracts = file.Contracts
+	s.modified = stat.ModTime()
+
+	return s.contracts, nil
+}
+
+// diff records events of contracts changed between the loaded
+// contracts and the new ones
+func (s *localSource) diff(contracts []Contract) {
+	old := make(map[uint64]*Contract)
+	for i := range s.contracts {
+		old[s.contracts[i].ContractID] = &s.contracts[i]
+	}
+
+	for i := range contracts {
+		contract := &contracts[i]
+		s.events.record(old[contract.ContractID], contract)
+		delete(old, contract.ContractID)
+	}
+
+	// contracts removed from the file
+	for _, contract := range old {
+		s.events.record(contract, nil)
+	}
+}
+
+// find returns ids of all active contracts that match
+func (s *localSource) find(match func(c *Contract) bool) ([]uint64, error) {
+	contracts, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for i := range contracts {
+		contract := &contracts[i]
+		if contract.Active() && match(contract) {
+			ids = append(ids, contract.ContractID)
+		}
+	}
+
+	return ids, nil
+}
+
+func (s *localSource) NodeContracts(node uint32) ([]uint64, error) {
+	return s.find(func(c *Contract) bool {
+		return c.Type == ContractTypeNode && c.NodeID == node
+	})
+}
+
+func (s *localSource) Contract(id uint64) (Contract, error) {
+	contracts, err := s.load()
+	if err != nil {
+		return Contract{}, err
+	}
+
+	for _, contract := range contracts {
+		if contract.ContractID == id {
+			return contract, nil
+		}
+	}
+
+	return Contract{}, ErrNotFound
+}
+
+func (s *localSource) one(ids []uint64, err error) (uint64, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, ErrNotFound
+	}
+
+	return ids[0], nil
+}
+
+func (s *localSource) ContractIDByName(name string) (uint64, error) {
+	return s.one(s.find(func(c *Contract) bool {
+		return c.Type == ContractTypeName && c.Name == name
+	}))
+}
+
+func (s *localSource) NodeRentContract(node uint32) (uint64, error) {
+	return s.one(s.find(func(c *Contract) bool {
+		return c.Type == ContractTypeRent && c.NodeID == node
+	}))
+}
+
+func (s *localSource) Events(node uint32, after uint64) ([]zos4Pkg.ContractEvent, error) {
+	if _, err := s.load(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.events.after(node, after), nil
+}
+
+// hash is a stand-in for the registrar hash of a submission
+func hash(v interface{}) (subTypes.Hash, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return subTypes.Hash{}, err
+	}
+
+	sum := sha256.Sum256(data)
+	return subTypes.NewHash(sum[:]), nil
+}
+
+func (s *localSource) Report(_ string, consumptions []substrate.NruConsumption) (subTypes.Hash, error) {
+	log.Info().Int("count", len(consumptions)).Msg("local contracts source: consumption report")
+	return hash(consumptions)
+}
+
+func (s *localSource) ReportResources(_ string, consumptions []zos4Pkg.ResourceConsumption) error {
+	log.Info().Int("count", len(consumptions)).Msg("local contracts source: resources consumption report")
+	return nil
+}
+
+func (s *localSource) SetContractConsumption(_ string, resources []substrate.ContractResources) error {
+	log.Info().Int("count", len(resources)).Msg("local contracts source: contracts consumption")
+	return nil
+}
+
+func (s *localSource) SetNodePowerState(up bool) (subTypes.Hash, error) {
+	log.Info().Bool("up", up).Msg("local contracts source: power state")
+	return hash(up)
+}
+
+func (s *localSource) PowerTarget(_ uint32) (substrate.NodePower, error) {
+	return powerUp, nil
+}
+
+func (s *localSource) PublicConfig(_ uint32) (substrate.OptionPublicConfig, error) {
+	return substrate.OptionPublicConfig{}, nil
+}
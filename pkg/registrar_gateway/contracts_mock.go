@@ -0,0 +1,250 @@
+package registrargw
+
+import (
+	"sort"
+	"sync"
+
+	subTypes "github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+// MockSource is an in memory contract source for tests. Contracts can
+// be set directly, submissions are recorded once per idempotency key. If
+// Err is set all calls fail with it. Changes made with Set and Delete are
+// served as contract events.
+type MockSource struct {
+	mu sync.Mutex
+
+	Contracts map[uint64]Contract
+	Err       error
+
+	Reports   []substrate.NruConsumption
+	Usage     []zos4Pkg.ResourceConsumption
+	Resources []substrate.ContractResources
+	Power     *bool
+
+	// PowerTargets and PublicConfigs are served per node, nodes without
+	// a power target are up
+	PowerTargets  map[uint32]substrate.NodePower
+	PublicConfigs map[uint32]substrate.OptionPublicConfig
+
+	keys   map[string]struct{}
+	events eventLog
+}
+
+var _ ContractSource = (*MockSource)(nil)
+
+// NewMockSource creates a mock source with contracts
+func NewMockSource(contracts ...Contract) *MockSource {
+	m := &MockSource{
+		Contracts:     make(map[uint64]Contract),
+		PowerTargets:  make(map[uint32]substrate.NodePower),
+		PublicConfigs: make(map[uint32]substrate.OptionPublicConfig),
+		keys:          make(map[string]struct{}),
+	}
+	for _, contract := range contracts {
+		m.Contracts[contract.ContractID] = contract
+	}
+
+	return m
+}
+
+// Set adds or replaces contracts
+func (m *MockSource) Set(contracts ...Contract) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, contract := range contracts {
+		if old, ok := m.Contracts[contract.ContractID]; ok {
+			m.events.record(&old, &contract)
+		} else {
+			m.events.record(nil, &contract)
+		}
+		m.Contracts[contract.ContractID] = contract
+	}
+}
+
+// Delete removes contracts
+func (m *MockSource) Delete(ids ...uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		if old, ok := m.Contracts[id]; ok {
+			m.events.record(&old, nil)
+		}
+		delete(m.Contracts, id)
+	}
+}
+
+func (m *MockSource) find(match func(c *Contract) bool) ([]uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return nil, m.Err
+	}
+
+	ids := []uint64{}
+	for id, contract := range m.Contracts {
+		if contract.Active() && match(&contract) {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (m *MockSource) one(ids []uint64, err error) (uint64, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, ErrNotFound
+	}
+
+	return ids[0], nil
+}
+
+func (m *MockSource) NodeContracts(node uint32) ([]uint64, error) {
+	return m.find(func(c *Contract) bool {
+		return c.Type == ContractTypeNode && c.NodeID == node
+	})
+}
+
+func (m *MockSource) Contract(id uint64) (Contract, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return Contract{}, m.Err
+	}
+
+	contract, ok := m.Contracts[id]
+	if !ok {
+		return Contract{}, ErrNotFound
+	}
+
+	return contract, nil
+}
+
+func (m *MockSource) ContractIDByName(name string) (uint64, error) {
+	return m.one(m.find(func(c *Contract) bool {
+		return c.Type == ContractTypeName && c.Name == name
+	}))
+}
+
+func (m *MockSource) NodeRentContract(node uint32) (uint64, error) {
+	return m.one(m.find(func(c *Contract) bool {
+		return c.Type == ContractTypeRent && c.NodeID == node
+	}))
+}
+
+func (m *MockSource) Events(node uint32, after uint64) ([]zos4Pkg.ContractEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return nil, m.Err
+	}
+
+	return m.events.after(node, after), nil
+}
+
+// seen records key, it returns true if key was already recorded
+func (m *MockSource) seen(method, key string) bool {
+	if len(key) == 0 {
+		return false
+	}
+
+	key = method + "/" + key
+	if _, ok := m.keys[key]; ok {
+		return true
+	}
+
+	m.keys[key] = struct{}{}
+	return false
+}
+
+func (m *MockSource) Report(key string, consumptions []substrate.NruConsumption) (subTypes.Hash, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return subTypes.Hash{}, m.Err
+	}
+
+	if !m.seen("Report", key) {
+		m.Reports = append(m.Reports, consumptions...)
+	}
+	return hash(consumptions)
+}
+
+func (m *MockSource) ReportResources(key string, consumptions []zos4Pkg.ResourceConsumption) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+
+	if !m.seen("ReportResources", key) {
+		m.Usage = append(m.Usage, consumptions...)
+	}
+	return nil
+}
+
+func (m *MockSource) SetContractConsumption(key string, resources []substrate.ContractResources) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+
+	if !m.seen("SetContractConsumption", key) {
+		m.Resources = append(m.Resources, resources...)
+	}
+	return nil
+}
+
+func (m *MockSource) SetNodePowerState(up bool) (subTypes.Hash, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return subTypes.Hash{}, m.Err
+	}
+
+	m.Power = &up
+	return hash(up)
+}
+
+func (m *MockSource) PowerTarget(node uint32) (substrate.NodePower, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return substrate.NodePower{}, m.Err
+	}
+
+	if power, ok := m.PowerTargets[node]; ok {
+		return power, nil
+	}
+
+	return powerUp, nil
+}
+
+func (m *MockSource) PublicConfig(node uint32) (substrate.OptionPublicConfig, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return substrate.OptionPublicConfig{}, m.Err
+	}
+
+	return m.PublicConfigs[node], nil
+}
@@ -0,0 +1,260 @@
+package registrargw
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	subTypes "github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/stretchr/testify/require"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zosbase/pkg/environment"
+	"github.com/threefoldtech/zosbase/pkg/kernel"
+)
+
+const testHash = "e6b8ee8e2b1e3ea2c5e0f7d6cc0df4a2"
+
+var testContracts = []Contract{
+	{ContractID: 1, TwinID: 10, Type: ContractTypeNode, State: ContractStateCreated, NodeID: 1, DeploymentHash: testHash},
+	{ContractID: 2, TwinID: 10, Type: ContractTypeNode, State: ContractStateGracePeriod, NodeID: 1},
+	{ContractID: 3, TwinID: 10, Type: ContractTypeNode, State: ContractStateDeleted, NodeID: 1},
+	{ContractID: 4, TwinID: 11, Type: ContractTypeNode, State: ContractStateCreated, NodeID: 2},
+	{ContractID: 5, TwinID: 11, Type: ContractTypeRent, State: ContractStateCreated, NodeID: 2},
+	{ContractID: 6, TwinID: 11, Type: ContractTypeName, State: ContractStateCreated, Name: "example"},
+}
+
+func testSource(t *testing.T, source ContractSource) {
+	require := require.New(t)
+
+	ids, err := source.NodeContracts(1)
+	require.NoError(err)
+	require.ElementsMatch([]uint64{1, 2}, ids)
+
+	contract, err := source.Contract(2)
+	require.NoError(err)
+	require.Equal(ContractStateGracePeriod, contract.State)
+
+	_, err = source.Contract(100)
+	require.ErrorIs(err, ErrNotFound)
+
+	id, err := source.NodeRentContract(2)
+	require.NoError(err)
+	require.EqualValues(5, id)
+
+	_, err = source.NodeRentContract(1)
+	require.ErrorIs(err, ErrNotFound)
+
+	id, err = source.ContractIDByName("example")
+	require.NoError(err)
+	require.EqualValues(6, id)
+}
+
+func TestLocalSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contracts.json")
+	data, err := json.Marshal(map[string]interface{}{"contracts": testContracts})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	source, err := NewLocalSource(path)
+	require.NoError(t, err)
+
+	testSource(t, source)
+}
+
+func TestContractsFile(t *testing.T) {
+	require := require.New(t)
+
+	params := kernel.Params{ParamContracts: []string{"/tmp/contracts.json"}}
+	for _, mode := range []environment.RunMode{environment.RunningDev, environment.RunningTest} {
+		path, ok := contractsFile(mode, params)
+		require.True(ok)
+		require.Equal("/tmp/contracts.json", path)
+	}
+
+	for _, mode := range []environment.RunMode{environment.RunningQA, environment.RunningMain} {
+		_, ok := contractsFile(mode, params)
+		require.False(ok)
+	}
+
+	_, ok := contractsFile(environment.RunningDev, kernel.Params{})
+	require.False(ok)
+}
+
+func TestLocalSourceInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contracts.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"contracts": [{"contract_id": 1, "type": "unknown", "state": "created"}]}`), 0644))
+
+	_, err := NewLocalSource(path)
+	require.Error(t, err)
+}
+
+func TestMockSource(t *testing.T) {
+	testSource(t, NewMockSource(testContracts...))
+}
+
+func TestHTTPSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var result []Contract
+		for _, contract := range testContracts {
+			if r.URL.Path == "/contracts" {
+				if (query.Get("type") == "" || query.Get("type") == contract.Type) &&
+					(query.Get("node_id") == "" || query.Get("node_id") == strconv.FormatUint(uint64(contract.NodeID), 10)) &&
+					(query.Get("name") == "" || query.Get("name") == contract.Name) {
+					result = append(result, contract)
+				}
+			} else if r.URL.Path == "/contracts/"+strconv.FormatUint(contract.ContractID, 10) {
+				_ = json.NewEncoder(w).Encode(contract)
+				return
+			}
+		}
+
+		if r.URL.Path != "/contracts" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": "contract not found"}`))
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+
+	endpoints, err := NewEndpoints([]string{server.URL}, "")
+	require.NoError(t, err)
+	testSource(t, NewHTTPSource(endpoints, nil))
+}
+
+func TestHTTPSourceIdempotency(t *testing.T) {
+	require := require.New(t)
+
+	keys := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys[r.URL.Path] = r.Header.Get(IdempotencyHeader)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	endpoints, err := NewEndpoints([]string{server.URL}, "")
+	require.NoError(err)
+	sk := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	source := newHTTPSource(endpoints, sk)
+	source.twin, source.node = 10, 1
+
+	consumptions := []substrate.NruConsumption{{ContractID: 1, NRU: 10}}
+	_, err = source.Report(payloadKey(consumptions), consumptions)
+	require.NoError(err)
+	require.Equal(payloadKey(consumptions), keys["/nodes/1/consumption"])
+
+	_, err = source.SetNodePowerState(true)
+	require.NoError(err)
+	require.Empty(keys["/nodes/1/power"])
+}
+
+func TestGatewayContracts(t *testing.T) {
+	require := require.New(t)
+
+	mock := NewMockSource(testContracts...)
+	gw := registrarGateway{contracts: mock}
+
+	contract, serr := gw.GetContract(1)
+	require.False(serr.IsError())
+	require.True(contract.State.IsCreated)
+	require.True(contract.ContractType.IsNodeContract)
+	require.EqualValues(1, contract.ContractType.NodeContract.Node)
+	require.Equal(testHash, contract.ContractType.NodeContract.DeploymentHash.String())
+
+	_, serr = gw.GetContract(100)
+	require.True(serr.IsCode(zos4Pkg.RegistrarCodeNotFound))
+
+	_, serr = gw.GetNodeRentContract(1)
+	require.True(serr.IsCode(zos4Pkg.RegistrarCodeNotFound))
+
+	contracts, serr := gw.GetNodeContracts(1)
+	require.NoError(serr.Err())
+	require.Equal([]subTypes.U64{1, 2}, contracts)
+
+	mock.Set(Contract{ContractID: 1, TwinID: 10, Type: ContractTypeNode, State: ContractStateDeleted, NodeID: 1})
+	contracts, serr = gw.GetNodeContracts(1)
+	require.NoError(serr.Err())
+	require.Equal([]subTypes.U64{2}, contracts)
+
+	_, serr = gw.SetNodePowerState(false)
+	require.NoError(serr.Err())
+	require.NotNil(mock.Power)
+	require.False(*mock.Power)
+
+	power, serr := gw.GetNodePowerTarget(1)
+	require.NoError(serr.Err())
+	require.True(power.Target.IsUp)
+	mock.PowerTargets[1] = substrate.NodePower{Target: substrate.Power{IsDown: true}}
+	power, serr = gw.GetNodePowerTarget(1)
+	require.NoError(serr.Err())
+	require.True(power.Target.IsDown)
+}
+
+func TestSourceEvents(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "contracts.json")
+	var local ContractSource
+	modified := time.Now()
+	write := func(contracts []Contract) {
+		data, err := json.Marshal(map[string]interface{}{"contracts": contracts})
+		require.NoError(err)
+		require.NoError(os.WriteFile(path, data, 0644))
+		// make sure modification time changes
+		modified = modified.Add(time.Second)
+		require.NoError(os.Chtimes(path, modified, modified))
+
+		if local != nil {
+			// file is reloaded on access
+			_, err = local.NodeContracts(1)
+			require.NoError(err)
+		}
+	}
+
+	write(testContracts[:2])
+	local, err := NewLocalSource(path)
+	require.NoError(err)
+
+	mock := NewMockSource(testContracts[:2]...)
+
+	// lock 1 and remove 2
+	locked := testContracts[0]
+	locked.State = ContractStateGracePeriod
+	mock.Set(locked)
+	mock.Delete(2)
+	write([]Contract{locked})
+
+	// then unlock 1
+	mock.Set(testContracts[0])
+	write(testContracts[:1])
+
+	for _, source := range []ContractSource{mock, local} {
+		events, err := source.Events(1, 0)
+		require.NoError(err)
+		require.Len(events, 3)
+		require.EqualValues(1, events[0].ContractID)
+		require.Equal(zos4Pkg.ContractEventLocked, events[0].Kind)
+		require.EqualValues(2, events[1].ContractID)
+		require.Equal(zos4Pkg.ContractEventCancelled, events[1].Kind)
+		require.EqualValues(1, events[2].ContractID)
+		require.Equal(zos4Pkg.ContractEventUnlocked, events[2].Kind)
+		require.Greater(events[2].ID, events[0].ID)
+
+		events, err = source.Events(1, events[1].ID)
+		require.NoError(err)
+		require.Len(events, 1)
+
+		events, err = source.Events(2, 0)
+		require.NoError(err)
+		require.Empty(events)
+	}
+}
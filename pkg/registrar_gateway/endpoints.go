@@ -0,0 +1,381 @@
+package registrargw
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+const (
+	// breakerThreshold is the number of consecutive failures that opens
+	// the breaker of an endpoint
+	breakerThreshold = 3
+	// breakerCooldown is how long an open endpoint is not used
+	breakerCooldown = time.Minute
+	// healthInterval is how often endpoints are health checked
+	healthInterval = time.Minute
+	// latencyWeight is the weight of a new sample in the latency average
+	latencyWeight = 0.2
+	// callTimeout is the max time of a single registrar call, without
+	// it a hanging endpoint would never fail over
+	callTimeout = 30 * time.Second
+)
+
+// errNoEndpoint is returned if all endpoints are failing. It's considered
+// unreachable so mutating calls are queued.
+var errNoEndpoint = errors.New("no registrar endpoint is available")
+
+// endpoint is a registrar endpoint with its circuit breaker
+type endpoint struct {
+	url     string
+	seed    string
+	timeout time.Duration
+
+	mu          sync.Mutex
+	cl          *client.RegistrarClient
+	failures    int
+	opened      time.Time
+	latency     time.Duration
+	requests    uint64
+	failed      uint64
+	lastError   string
+	lastSuccess time.Time
+}
+
+// client returns the registrar client of the endpoint. The client is created
+// on first use, creating it does lookups of the node twin and id that are
+// done again later if the endpoint can't be reached.
+func (e *endpoint) client() (*client.RegistrarClient, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.cl != nil {
+		return e.cl, nil
+	}
+
+	var seed []string
+	if len(e.seed) != 0 {
+		seed = append(seed, e.seed)
+	}
+
+	cl, err := newClient(e.url, e.timeout, seed...)
+	if err != nil && (len(e.seed) == 0 || len(cl.Mnemonic()) == 0) {
+		return nil, errors.Wrap(err, "failed to create registrar client")
+	} else if err != nil {
+		log.Debug().Err(err).Str("url", e.url).Msg("failed to lookup node identity, will be done on first use")
+	}
+
+	e.cl = &cl
+	return e.cl, nil
+}
+
+// newClient creates a registrar client that gives up on calls after timeout.
+// The sdk client always uses a copy of http.DefaultClient, which has no
+// timeout, and has no option to use another one, so the http client is
+// replaced after creation. Creation does lookups with the default client,
+// so it's bounded by the same timeout.
+func newClient(url string, timeout time.Duration, seed ...string) (client.RegistrarClient, error) {
+	type created struct {
+		cl  client.RegistrarClient
+		err error
+	}
+
+	ch := make(chan created, 1)
+	go func() {
+		cl, err := client.NewRegistrarClient(url, seed...)
+		ch <- created{cl: cl, err: err}
+	}()
+
+	var result created
+	select {
+	case result = <-ch:
+	case <-time.After(timeout):
+		return client.RegistrarClient{}, errors.Errorf("timed out creating registrar client after %s", timeout)
+	}
+
+	if err := setHTTPClient(&result.cl, http.Client{Timeout: timeout}); err != nil {
+		return client.RegistrarClient{}, err
+	}
+
+	return result.cl, result.err
+}
+
+// setHTTPClient sets the unexported http client of a registrar client
+func setHTTPClient(cl *client.RegistrarClient, hc http.Client) error {
+	field := reflect.ValueOf(cl).Elem().FieldByName("httpClient")
+	if !field.IsValid() || field.Type() != reflect.TypeOf(hc) {
+		return errors.New("unsupported registrar client, can't set its http client")
+	}
+
+	reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Set(reflect.ValueOf(hc))
+	return nil
+}
+
+func (e *endpoint) state(now time.Time) string {
+	if e.failures < breakerThreshold {
+		return zos4Pkg.RegistrarEndpointClosed
+	}
+
+	if now.Sub(e.opened) < breakerCooldown {
+		return zos4Pkg.RegistrarEndpointOpen
+	}
+
+	return zos4Pkg.RegistrarEndpointHalfOpen
+}
+
+// available returns true if calls can be sent to the endpoint
+func (e *endpoint) available(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.state(now) != zos4Pkg.RegistrarEndpointOpen
+}
+
+// record records the result of a call that took d
+func (e *endpoint) record(err error, d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.requests++
+	if unreachable(err) {
+		e.failed++
+		e.failures++
+		e.lastError = err.Error()
+		if e.failures >= breakerThreshold {
+			// (re)open the breaker, this also covers a failed half-open call
+			e.opened = time.Now()
+		}
+		return
+	}
+
+	// a rejected call still means the endpoint is healthy
+	e.failures = 0
+	e.lastSuccess = time.Now()
+	if e.latency == 0 {
+		e.latency = d
+	} else {
+		e.latency = time.Duration(latencyWeight*float64(d) + (1-latencyWeight)*float64(e.latency))
+	}
+}
+
+func (e *endpoint) stats(now time.Time) zos4Pkg.RegistrarEndpointStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stats := zos4Pkg.RegistrarEndpointStats{
+		URL:       e.url,
+		State:     e.state(now),
+		Latency:   uint64(e.latency.Milliseconds()),
+		Requests:  e.requests,
+		Failures:  e.failed,
+		LastError: e.lastError,
+	}
+
+	if !e.lastSuccess.IsZero() {
+		stats.LastSuccess = e.lastSuccess.Unix()
+	}
+
+	return stats
+}
+
+// Endpoints is a pool of registrar endpoints. Calls go to the active
+// endpoint, and fail over to the other endpoints if it can't be reached.
+// Endpoints that keep failing are not used until a cooldown is over.
+type Endpoints struct {
+	endpoints []*endpoint
+
+	mu     sync.Mutex
+	active int
+}
+
+// NewEndpoints creates a pool of registrar endpoints, urls are the base
+// urls of the registrar api in order of preference. seed is the hex seed
+// of the node identity used to sign calls.
+func NewEndpoints(urls []string, seed string) (*Endpoints, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no registrar endpoints configured")
+	}
+
+	seen := make(map[string]struct{})
+	pool := &Endpoints{}
+	for _, u := range urls {
+		if _, err := url.Parse(u); err != nil {
+			return nil, errors.Wrapf(err, "invalid registrar url '%s'", u)
+		}
+
+		if _, ok := seen[u]; ok {
+			continue
+		}
+		seen[u] = struct{}{}
+
+		pool.endpoints = append(pool.endpoints, &endpoint{url: u, seed: seed, timeout: callTimeout})
+	}
+
+	return pool, nil
+}
+
+// candidates returns the endpoints to try in order, the active endpoint
+// comes first
+func (p *Endpoints) candidates() []*endpoint {
+	p.mu.Lock()
+	active := p.active
+	p.mu.Unlock()
+
+	now := time.Now()
+	var result []*endpoint
+	for i := range p.endpoints {
+		e := p.endpoints[(active+i)%len(p.endpoints)]
+		if e.available(now) {
+			result = append(result, e)
+		}
+	}
+
+	return result
+}
+
+func (p *Endpoints) activate(e *endpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current := p.endpoints[p.active]
+	if current == e {
+		return
+	}
+
+	for i := range p.endpoints {
+		if p.endpoints[i] == e {
+			log.Warn().Str("from", current.url).Str("to", e.url).Msg("registrar endpoint failed over")
+			p.active = i
+			return
+		}
+	}
+}
+
+// Do runs fn against the available endpoints until one of them can be
+// reached. Errors that are not caused by an unreachable registrar are
+// returned right away. Do is for reads, calls that change the registrar
+// state must use Mutate.
+func (p *Endpoints) Do(fn func(url string, cl *client.RegistrarClient) error) error {
+	return p.try(fn, unreachable)
+}
+
+// Mutate runs fn like Do, but only fails over to the next endpoint if the
+// call could not be sent at all. A call that timed out or failed on the
+// registrar side may have been applied already, the unreachable error is
+// returned so the call is retried later instead of sent again right away.
+func (p *Endpoints) Mutate(fn func(url string, cl *client.RegistrarClient) error) error {
+	return p.try(fn, notSent)
+}
+
+// try runs fn against the available endpoints while failover returns true
+// for its error
+func (p *Endpoints) try(fn func(url string, cl *client.RegistrarClient) error, failover func(error) bool) error {
+	err := errNoEndpoint
+	for _, e := range p.candidates() {
+		cl, cerr := e.client()
+		if cerr != nil {
+			return cerr
+		}
+
+		start := time.Now()
+		err = fn(e.url, cl)
+		e.record(err, time.Since(start))
+		if !unreachable(err) {
+			p.activate(e)
+			return err
+		}
+
+		log.Debug().Err(err).Str("url", e.url).Msg("registrar endpoint is unreachable")
+		if !failover(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// call is a generic version of Endpoints.Do for calls that return a value
+func call[T any](p *Endpoints, fn func(cl *client.RegistrarClient) (T, error)) (result T, err error) {
+	err = p.Do(func(_ string, cl *client.RegistrarClient) (err error) {
+		result, err = fn(cl)
+		return err
+	})
+
+	return
+}
+
+// mutation is a generic version of Endpoints.Mutate for calls that return
+// a value
+func mutation[T any](p *Endpoints, fn func(cl *client.RegistrarClient) (T, error)) (result T, err error) {
+	err = p.Mutate(func(_ string, cl *client.RegistrarClient) (err error) {
+		result, err = fn(cl)
+		return err
+	})
+
+	return
+}
+
+// check probes all endpoints that were not used successfully recently,
+// this closes breakers of endpoints that are back.
+func (p *Endpoints) check() {
+	now := time.Now()
+	for _, e := range p.endpoints {
+		e.mu.Lock()
+		recent := now.Sub(e.lastSuccess) < healthInterval
+		e.mu.Unlock()
+
+		if recent {
+			continue
+		}
+
+		cl, err := e.client()
+		if err != nil {
+			log.Error().Err(err).Str("url", e.url).Msg("failed to health check registrar endpoint")
+			continue
+		}
+
+		start := time.Now()
+		_, err = cl.GetZosVersion()
+		e.record(err, time.Since(start))
+	}
+}
+
+// Monitor health checks endpoints until the context is cancelled
+func (p *Endpoints) Monitor(ctx context.Context) {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	for {
+		p.check()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stats returns the active endpoint and the stats of all endpoints
+func (p *Endpoints) Stats() zos4Pkg.RegistrarStats {
+	p.mu.Lock()
+	active := p.endpoints[p.active].url
+	p.mu.Unlock()
+
+	now := time.Now()
+	stats := zos4Pkg.RegistrarStats{Active: active}
+	for _, e := range p.endpoints {
+		stats.Endpoints = append(stats.Endpoints, e.stats(now))
+	}
+
+	return stats
+}
@@ -0,0 +1,173 @@
+package registrargw
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/tools/registrar-mock/mock"
+)
+
+func TestEndpoints(t *testing.T) {
+	require := require.New(t)
+
+	_, err := NewEndpoints(nil, "")
+	require.Error(err)
+
+	pool, err := NewEndpoints([]string{"http://primary", "http://backup", "http://primary"}, "")
+	require.NoError(err)
+	require.Len(pool.endpoints, 2)
+
+	down := map[string]bool{}
+	var called []string
+	do := func() error {
+		called = called[:0]
+		return pool.Do(func(url string, _ *client.RegistrarClient) error {
+			called = append(called, url)
+			if down[url] {
+				return &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}
+			}
+			return nil
+		})
+	}
+
+	require.NoError(do())
+	require.Equal([]string{"http://primary"}, called)
+
+	// fail over to the backup, which stays active
+	down["http://primary"] = true
+	require.NoError(do())
+	require.Equal([]string{"http://primary", "http://backup"}, called)
+	require.NoError(do())
+	require.Equal([]string{"http://backup"}, called)
+	require.Equal("http://backup", pool.Stats().Active)
+
+	// rejections are returned without failing over
+	err = pool.Do(func(url string, _ *client.RegistrarClient) error {
+		return fmt.Errorf("failed with status code 400 Bad Request")
+	})
+	require.Error(err)
+	require.False(unreachable(err))
+
+	// the breaker opens after consecutive failures
+	down["http://backup"] = true
+	for i := 0; i < breakerThreshold; i++ {
+		require.Error(do())
+	}
+	err = do()
+	require.True(unreachable(err))
+	require.Empty(called)
+
+	stats := pool.Stats()
+	require.Len(stats.Endpoints, 2)
+	for _, endpoint := range stats.Endpoints {
+		require.Equal(zos4Pkg.RegistrarEndpointOpen, endpoint.State)
+		require.NotEmpty(endpoint.LastError)
+	}
+
+	// after the cooldown a call goes through and closes the breaker
+	for _, e := range pool.endpoints {
+		e.opened = time.Now().Add(-breakerCooldown)
+	}
+	require.Equal(zos4Pkg.RegistrarEndpointHalfOpen, pool.Stats().Endpoints[0].State)
+
+	down["http://primary"] = false
+	require.NoError(do())
+	stats = pool.Stats()
+	require.Equal("http://primary", stats.Active)
+	require.Equal(zos4Pkg.RegistrarEndpointClosed, stats.Endpoints[0].State)
+	require.NotZero(stats.Endpoints[0].LastSuccess)
+	// the backup was tried first and opened again
+	require.Equal(zos4Pkg.RegistrarEndpointOpen, stats.Endpoints[1].State)
+}
+
+func TestEndpointsMutate(t *testing.T) {
+	require := require.New(t)
+
+	pool, err := NewEndpoints([]string{"http://primary", "http://backup"}, "")
+	require.NoError(err)
+
+	var cause error
+	var called []string
+	mutate := func() error {
+		called = called[:0]
+		return pool.Mutate(func(url string, _ *client.RegistrarClient) error {
+			called = append(called, url)
+			if url == "http://primary" {
+				return cause
+			}
+			return nil
+		})
+	}
+
+	// a call that was never sent fails over
+	cause = &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}
+	require.NoError(mutate())
+	require.Equal([]string{"http://primary", "http://backup"}, called)
+
+	// a call that timed out may be applied, it's not sent again
+	pool.active = 0
+	cause = &net.OpError{Op: "read", Err: fmt.Errorf("i/o timeout")}
+	err = mutate()
+	require.Equal([]string{"http://primary"}, called)
+	require.True(registrarError(err).IsCode(zos4Pkg.RegistrarCodeUnavailable))
+
+	cause = fmt.Errorf("registrar responded with status '502 Bad Gateway'")
+	err = mutate()
+	require.Equal([]string{"http://primary"}, called)
+	require.True(registrarError(err).IsCode(zos4Pkg.RegistrarCodeUnavailable))
+}
+
+func TestEndpointsFailover(t *testing.T) {
+	require := require.New(t)
+
+	primary, err := mock.New(mock.Options{Faults: mock.Faults{ErrorRate: 1}})
+	require.NoError(err)
+	primarySrv := httptest.NewServer(primary)
+	defer primarySrv.Close()
+
+	backup, err := mock.New(mock.Options{State: mock.State{ZosVersion: client.ZosVersion{Version: "v4.0.1"}}})
+	require.NoError(err)
+	backupSrv := httptest.NewServer(backup)
+	defer backupSrv.Close()
+
+	pool, err := NewEndpoints([]string{primarySrv.URL + mock.APIPrefix, backupSrv.URL + mock.APIPrefix}, "")
+	require.NoError(err)
+
+	version, err := call(pool, (*client.RegistrarClient).GetZosVersion)
+	require.NoError(err)
+	require.Equal("v4.0.1", version.Version)
+
+	stats := pool.Stats()
+	require.Equal(backupSrv.URL+mock.APIPrefix, stats.Active)
+	require.EqualValues(1, stats.Endpoints[0].Failures)
+	require.Contains(stats.Endpoints[0].LastError, "503")
+}
+
+func TestEndpointsTimeout(t *testing.T) {
+	require := require.New(t)
+
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hanging.Close()
+
+	pool, err := NewEndpoints([]string{hanging.URL}, "")
+	require.NoError(err)
+	pool.endpoints[0].timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err = call(pool, (*client.RegistrarClient).GetZosVersion)
+	require.Error(err)
+	require.True(unreachable(err))
+	require.Less(time.Since(start), 5*time.Second)
+
+	// the timeout is set on the registrar client only
+	require.Zero(http.DefaultClient.Timeout)
+}
@@ -0,0 +1,87 @@
+package registrargw
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/pkg/errors"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+// the registrar client only keeps the response status in the error message
+var statusPattern = regexp.MustCompile(`status(?: code)? '?(\d{3})`)
+
+// statusCode returns the http status of the registrar response that caused
+// err, or 0 if unknown
+func statusCode(err error) int {
+	match := statusPattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0
+	}
+
+	code, _ := strconv.Atoi(match[1])
+	return code
+}
+
+// unreachable returns true if err means the call did not reach the
+// registrar (or the registrar could not process it) and should be
+// tried again later. Other errors are rejections that would fail
+// again if retried.
+func unreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, errNoEndpoint) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	code := statusCode(err)
+	return code >= 500 || code == http.StatusTooManyRequests
+}
+
+// notSent returns true if err means the call never reached the registrar,
+// so it was not applied and can be sent to another endpoint
+func notSent(err error) bool {
+	if errors.Is(err, errNoEndpoint) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// registrarError converts err to a registrar error with the code of its kind
+func registrarError(err error) zos4Pkg.RegistrarError {
+	if err == nil {
+		return zos4Pkg.RegistrarError{}
+	}
+
+	if rerr, ok := zos4Pkg.AsRegistrarError(err); ok {
+		return rerr
+	}
+
+	code := zos4Pkg.RegistrarCodeGeneric
+	status := statusCode(err)
+	switch {
+	case isNotFound(err) || status == http.StatusNotFound:
+		code = zos4Pkg.RegistrarCodeNotFound
+	case status == http.StatusTooManyRequests:
+		code = zos4Pkg.RegistrarCodeRateLimited
+	case errors.Is(err, ErrQueued) || unreachable(err):
+		code = zos4Pkg.RegistrarCodeUnavailable
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		code = zos4Pkg.RegistrarCodeUnauthorized
+	case status == http.StatusConflict:
+		code = zos4Pkg.RegistrarCodeConflict
+	}
+
+	return zos4Pkg.RegistrarError{Code: code, Message: err.Error(), Queued: errors.Is(err, ErrQueued)}
+}
@@ -0,0 +1,59 @@
+package registrargw
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+func TestUnreachable(t *testing.T) {
+	require := require.New(t)
+
+	require.False(unreachable(nil))
+	require.True(unreachable(errors.Wrap(&net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}, "failed to send request")))
+	require.True(unreachable(fmt.Errorf("failed to update node with twin id 1 with status code 502 Bad Gateway")))
+	require.True(unreachable(fmt.Errorf("registrar responded with status '503 Service Unavailable'")))
+	require.False(unreachable(fmt.Errorf("failed to update node with twin id 1 with status code 400 Bad Request")))
+}
+
+func TestRegistrarError(t *testing.T) {
+	require := require.New(t)
+
+	require.False(registrarError(nil).IsError())
+	require.NoError(registrarError(nil).Err())
+
+	cases := []struct {
+		err  error
+		code zos4Pkg.RegistrarErrorCode
+	}{
+		{errors.Wrap(client.ErrorNodeNotFound, "failed to get node"), zos4Pkg.RegistrarCodeNotFound},
+		{fmt.Errorf("registrar responded with status '404 Not Found'"), zos4Pkg.RegistrarCodeNotFound},
+		{fmt.Errorf("failed to create node with status code 401 Unauthorized"), zos4Pkg.RegistrarCodeUnauthorized},
+		{fmt.Errorf("failed to update node with status code 403 Forbidden"), zos4Pkg.RegistrarCodeUnauthorized},
+		{fmt.Errorf("failed to create account with status code 409 Conflict"), zos4Pkg.RegistrarCodeConflict},
+		{fmt.Errorf("failed to get node with status code 429 Too Many Requests"), zos4Pkg.RegistrarCodeRateLimited},
+		{fmt.Errorf("failed to get node with status code 502 Bad Gateway"), zos4Pkg.RegistrarCodeUnavailable},
+		{&net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}, zos4Pkg.RegistrarCodeUnavailable},
+		{ErrQueued, zos4Pkg.RegistrarCodeUnavailable},
+		{errNoEndpoint, zos4Pkg.RegistrarCodeUnavailable},
+		{fmt.Errorf("failed to create node with status code 400 Bad Request"), zos4Pkg.RegistrarCodeGeneric},
+	}
+
+	for _, c := range cases {
+		rerr := registrarError(c.err)
+		require.Equal(c.code, rerr.Code, c.err.Error())
+		require.Equal(c.err.Error(), rerr.Message)
+	}
+
+	// the code is kept when the error is wrapped and converted again
+	wrapped := errors.Wrap(registrarError(ErrQueued).Err(), "failed to report")
+	rerr, ok := zos4Pkg.AsRegistrarError(wrapped)
+	require.True(ok)
+	require.True(rerr.IsCode(zos4Pkg.RegistrarCodeUnavailable))
+	require.Equal(zos4Pkg.RegistrarCodeUnavailable, registrarError(wrapped).Code)
+}
@@ -0,0 +1,52 @@
+package registrargw
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+// inventoryPath is the path of the node hardware inventory
+func inventoryPath(node uint64) string {
+	return fmt.Sprintf("nodes/%d/inventory", node)
+}
+
+func (s *httpSource) Inventory(node uint64) (inventory zos4Pkg.NodeInventory, err error) {
+	err = s.do(http.MethodGet, inventoryPath(node), nil, 0, nil, &inventory)
+	return
+}
+
+func (s *httpSource) SetInventory(inventory zos4Pkg.NodeInventory) error {
+	_, err := s.submit(http.MethodPut, "inventory", "", inventory)
+	return err
+}
+
+func (r *registrarGateway) GetNodeInventory(id uint64) (zos4Pkg.NodeInventory, zos4Pkg.RegistrarError) {
+	log.Debug().
+		Str("method", "GetNodeInventory").
+		Uint64("node_id", id).
+		Msg("method called")
+
+	inventory, err := cached(r.cache, "GetNodeInventory", fmt.Sprint(id), func() (zos4Pkg.NodeInventory, error) {
+		return r.api.Inventory(id)
+	})
+	return inventory, registrarError(err)
+}
+
+func (r *registrarGateway) UpdateNodeInventory(inventory zos4Pkg.NodeInventory) zos4Pkg.RegistrarError {
+	log.Debug().
+		Str("method", "UpdateNodeInventory").
+		Int("gpus", len(inventory.GPUs)).
+		Int("nics", len(inventory.NICs)).
+		Msg("method called")
+
+	defer r.locks.lock(opNode, "UpdateNodeInventory")()
+
+	// the inventory is optional, so it's not queued. A queued call would be
+	// rejected by registrars that do not support it once replayed, and the
+	// inventory is published again on the next registration anyway
+	defer r.invalidate("GetNodeInventory")
+	return registrarError(r.api.SetInventory(inventory))
+}
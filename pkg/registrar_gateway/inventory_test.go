@@ -0,0 +1,74 @@
+package registrargw
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/tools/registrar-mock/mock"
+)
+
+// registeredNode starts a mock registrar with a farm and a node registered
+// with the returned gateway
+func registeredNode(t *testing.T) (*mock.Server, *registrarGateway, uint64) {
+	require := require.New(t)
+
+	server, err := mock.New(mock.Options{})
+	require.NoError(err)
+	srv := httptest.NewServer(server)
+	t.Cleanup(srv.Close)
+
+	url := srv.URL + mock.APIPrefix
+	farmer, err := client.NewRegistrarClient(url, hex.EncodeToString([]byte(strings.Repeat("f", ed25519.SeedSize))))
+	require.NoError(err)
+	_, err = farmer.EnsureAccount(nil, "")
+	require.NoError(err)
+	farmID, err := farmer.CreateFarm("farm", "GBB3H4F7N4R6U7ZQO4Q5G4YJ5Y4ZLZ5V5X6Y7Z8A9B2C3D4E5F6G7H8J", false)
+	require.NoError(err)
+
+	sk := ed25519.NewKeyFromSeed([]byte(strings.Repeat("n", ed25519.SeedSize)))
+	endpoints, err := NewEndpoints([]string{url}, hex.EncodeToString(sk.Seed()))
+	require.NoError(err)
+
+	account, err := call(endpoints, func(cl *client.RegistrarClient) (client.Account, error) {
+		return cl.EnsureAccount(nil, "")
+	})
+	require.NoError(err)
+	nodeID, err := call(endpoints, func(cl *client.RegistrarClient) (uint64, error) {
+		return cl.RegisterNode(client.Node{FarmID: farmID, TwinID: account.TwinID})
+	})
+	require.NoError(err)
+
+	return server, &registrarGateway{registrar: endpoints, api: newHTTPSource(endpoints, sk)}, nodeID
+}
+
+func TestInventory(t *testing.T) {
+	require := require.New(t)
+
+	server, gw, nodeID := registeredNode(t)
+
+	_, rerr := gw.GetNodeInventory(nodeID)
+	require.True(rerr.IsCode(zos4Pkg.RegistrarCodeNotFound))
+
+	inventory := zos4Pkg.NodeInventory{
+		GPUs:  []zos4Pkg.GPUInventory{{ShortID: "0000:01:00.0/10de/1c82", Vendor: "NVIDIA Corporation", Device: "GP107"}},
+		NICs:  []zos4Pkg.NICInventory{{Name: "eth0", Mac: "52:54:00:12:34:56", Speed: 1000, WoL: true}},
+		Disks: []zos4Pkg.DiskInventory{{Type: "ssd", Count: 2, Size: 1 << 40}},
+		Board: zos4Pkg.DMIInventory{Vendor: "ASUSTeK", Product: "PRIME B450M-A"},
+	}
+	require.False(gw.UpdateNodeInventory(inventory).IsError())
+
+	stored, rerr := gw.GetNodeInventory(nodeID)
+	require.False(rerr.IsError())
+	require.Equal(inventory, stored)
+	require.Equal(inventory, server.State().Inventories[nodeID])
+
+	// a key without a registered node can not set an inventory
+	other := newHTTPSource(gw.registrar, ed25519.NewKeyFromSeed([]byte(strings.Repeat("o", ed25519.SeedSize))))
+	require.Error(other.SetInventory(inventory))
+}
@@ -0,0 +1,118 @@
+package registrargw
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+// operations that are serialized independently of each other. Calls of
+// the same operation are sent in order, a slow call only delays calls of
+// its own operation.
+const (
+	opAccount     = "account"
+	opNode        = "node"
+	opUptime      = "uptime"
+	opReport      = "report"
+	opConsumption = "consumption"
+	opPower       = "power"
+)
+
+// outboxOps is the operation of each method that can be queued
+var outboxOps = map[string]string{
+	outboxEnsureAccount:          opAccount,
+	outboxUpdateNode:             opNode,
+	outboxUpdateNodeUptime:       opUptime,
+	outboxReport:                 opReport,
+	outboxReportResources:        opReport,
+	outboxSetContractConsumption: opConsumption,
+	outboxSetNodePowerState:      opPower,
+}
+
+type lockStats struct {
+	calls   uint64
+	wait    time.Duration
+	maxWait time.Duration
+}
+
+// locks are the per operation locks of the gateway, it also keeps how
+// long each method waited for its lock. The zero value is ready to use.
+type locks struct {
+	mu    sync.Mutex
+	ops   map[string]*sync.Mutex
+	stats map[string]*lockStats
+}
+
+func (l *locks) op(op string) *sync.Mutex {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.ops == nil {
+		l.ops = make(map[string]*sync.Mutex)
+	}
+
+	m, ok := l.ops[op]
+	if !ok {
+		m = &sync.Mutex{}
+		l.ops[op] = m
+	}
+
+	return m
+}
+
+func (l *locks) record(method string, wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stats == nil {
+		l.stats = make(map[string]*lockStats)
+	}
+
+	stats, ok := l.stats[method]
+	if !ok {
+		stats = &lockStats{}
+		l.stats[method] = stats
+	}
+
+	stats.calls++
+	stats.wait += wait
+	if wait > stats.maxWait {
+		stats.maxWait = wait
+	}
+}
+
+// lock takes the lock of op for method and returns the unlock function,
+// like defer l.lock(opNode, "UpdateNode")()
+func (l *locks) lock(op, method string) func() {
+	m := l.op(op)
+
+	start := time.Now()
+	m.Lock()
+	l.record(method, time.Since(start))
+
+	return m.Unlock
+}
+
+// Stats returns the lock wait stats of all methods sorted by method
+func (l *locks) Stats() []zos4Pkg.RegistrarLockStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := make([]zos4Pkg.RegistrarLockStats, 0, len(l.stats))
+	for method, stats := range l.stats {
+		result = append(result, zos4Pkg.RegistrarLockStats{
+			Method:  method,
+			Calls:   stats.calls,
+			Wait:    uint64(stats.wait.Milliseconds()),
+			MaxWait: uint64(stats.maxWait.Milliseconds()),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Method < result[j].Method
+	})
+
+	return result
+}
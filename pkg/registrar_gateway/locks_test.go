@@ -0,0 +1,53 @@
+package registrargw
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocks(t *testing.T) {
+	require := require.New(t)
+
+	var l locks
+	unlock := l.lock(opNode, "UpdateNode")
+
+	// other operations are not blocked by a slow node update
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer l.lock(opUptime, "UpdateNodeUptimeV2")()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail("uptime report is blocked by node update")
+	}
+
+	// but calls of the same operation wait
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		defer l.lock(opNode, "CreateNode")()
+	}()
+
+	select {
+	case <-done:
+		require.Fail("node calls are not serialized")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	<-done
+
+	stats := l.Stats()
+	require.Len(stats, 3)
+	require.Equal("CreateNode", stats[0].Method)
+	require.EqualValues(1, stats[0].Calls)
+	require.GreaterOrEqual(stats[0].Wait, uint64(50))
+	require.Equal(stats[0].Wait, stats[0].MaxWait)
+	require.Equal("UpdateNode", stats[1].Method)
+	require.Equal("UpdateNodeUptimeV2", stats[2].Method)
+}
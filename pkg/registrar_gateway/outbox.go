@@ -0,0 +1,360 @@
+package registrargw
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	outboxEntries = "entries"
+	outboxKeys    = "keys"
+	outboxResults = "results"
+
+	// outboxResultTTL is how long the result of a sent call is kept for
+	// the caller to pick it up
+	outboxResultTTL = 24 * time.Hour
+)
+
+// ErrQueued is returned by mutating calls that could not reach the
+// registrar and were queued to be sent later. The call is not applied yet,
+// its registrar error has code RegistrarCodeUnavailable and is queued.
+var ErrQueued = errors.New("registrar is unreachable, call is queued")
+
+// outboxEntry is a mutating call waiting to be sent to the registrar
+type outboxEntry struct {
+	ID     uint64 `json:"id"`
+	Method string `json:"method"`
+	// Key identifies the call, a call is not queued twice with the same key
+	Key string `json:"key"`
+	// Coalesce is set on calls where only the latest call matters
+	Coalesce bool            `json:"coalesce"`
+	Payload  json.RawMessage `json:"payload"`
+	Created  int64           `json:"created"`
+	Attempts uint32          `json:"attempts"`
+	Error    string          `json:"error"`
+}
+
+// outboxResult is the result of a queued call once it's sent
+type outboxResult struct {
+	Hash  string `json:"hash"`
+	Error string `json:"error"`
+	Done  int64  `json:"done"`
+}
+
+// Err returns the error of the call, nil if it was accepted
+func (r *outboxResult) Err() error {
+	if len(r.Error) == 0 {
+		return nil
+	}
+
+	return errors.New(r.Error)
+}
+
+// outbox is a durable queue of mutating calls. Calls are kept in order per
+// operation (see outboxOps), calls of different operations don't wait for
+// each other.
+type outbox struct {
+	db *bolt.DB
+
+	mu          sync.Mutex
+	lastAttempt int64
+	lastError   string
+}
+
+func newOutbox(path string) (*outbox, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open outbox database")
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{outboxEntries, outboxKeys, outboxResults} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to initialize outbox database")
+	}
+
+	return &outbox{db: db}, nil
+}
+
+// payloadKey is the key of calls that are identified by their payload
+func payloadKey(payload interface{}) string {
+	data, _ := json.Marshal(payload)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func outboxKey(method, key string) []byte {
+	return []byte(method + "/" + key)
+}
+
+func u64(v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return b[:]
+}
+
+// Push queues a call. If coalesce is set a queued call with the same
+// method and key is replaced, so only the latest call is sent. Otherwise
+// the call is dropped if it's already queued.
+func (o *outbox) Push(method, key string, coalesce bool, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode call payload")
+	}
+
+	return o.db.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket([]byte(outboxEntries))
+		keys := tx.Bucket([]byte(outboxKeys))
+
+		if existing := keys.Get(outboxKey(method, key)); existing != nil {
+			if !coalesce {
+				return nil
+			}
+
+			if err := entries.Delete(existing); err != nil {
+				return err
+			}
+		}
+
+		id, err := entries.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		entry, err := json.Marshal(outboxEntry{
+			ID:       id,
+			Method:   method,
+			Key:      key,
+			Coalesce: coalesce,
+			Payload:  data,
+			Created:  time.Now().Unix(),
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := entries.Put(u64(id), entry); err != nil {
+			return err
+		}
+
+		return keys.Put(outboxKey(method, key), u64(id))
+	})
+}
+
+// Peek returns the oldest queued call of operation op, or of any operation
+// if op is empty
+func (o *outbox) Peek(op string) (entry outboxEntry, ok bool, err error) {
+	err = o.db.View(func(tx *bolt.Tx) error {
+		var found []byte
+		err := tx.Bucket([]byte(outboxEntries)).ForEach(func(_, data []byte) error {
+			if found != nil {
+				return nil
+			}
+
+			var e outboxEntry
+			if err := json.Unmarshal(data, &e); err != nil {
+				return err
+			}
+
+			if len(op) == 0 || outboxOps[e.Method] == op {
+				found = data
+			}
+			return nil
+		})
+		if err != nil || found == nil {
+			return err
+		}
+
+		ok = true
+		return json.Unmarshal(found, &entry)
+	})
+
+	return
+}
+
+// Len returns the number of queued calls of operation op, or of all
+// operations if op is empty
+func (o *outbox) Len(op string) (n int, err error) {
+	err = o.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(outboxEntries))
+		if len(op) == 0 {
+			n = bucket.Stats().KeyN
+			return nil
+		}
+
+		return bucket.ForEach(func(_, data []byte) error {
+			var entry outboxEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+
+			if outboxOps[entry.Method] == op {
+				n++
+			}
+			return nil
+		})
+	})
+
+	return
+}
+
+// Done removes a call from the queue. hash and cause are the result of the
+// call, cause is set if it was rejected by the registrar. The result of a
+// call that is not coalesced is kept so the caller can pick it up with
+// Result when it repeats the call.
+func (o *outbox) Done(entry *outboxEntry, hash string, cause error) error {
+	o.attempt(cause)
+
+	now := time.Now()
+	return o.db.Update(func(tx *bolt.Tx) error {
+		key := outboxKey(entry.Method, entry.Key)
+		keys := tx.Bucket([]byte(outboxKeys))
+		if id := keys.Get(key); id != nil && binary.BigEndian.Uint64(id) == entry.ID {
+			if err := keys.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		results := tx.Bucket([]byte(outboxResults))
+		if err := pruneResults(results, now.Add(-outboxResultTTL)); err != nil {
+			return err
+		}
+
+		if !entry.Coalesce {
+			result := outboxResult{Hash: hash, Done: now.Unix()}
+			if cause != nil {
+				result.Error = cause.Error()
+			}
+
+			data, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+
+			if err := results.Put(key, data); err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket([]byte(outboxEntries)).Delete(u64(entry.ID))
+	})
+}
+
+// pruneResults drops results of calls done before t
+func pruneResults(results *bolt.Bucket, t time.Time) error {
+	var expired [][]byte
+	err := results.ForEach(func(k, v []byte) error {
+		var result outboxResult
+		if err := json.Unmarshal(v, &result); err != nil || result.Done < t.Unix() {
+			expired = append(expired, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range expired {
+		if err := results.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Result returns and forgets the result of a sent call with method and key,
+// ok is false if no such call was sent
+func (o *outbox) Result(method, key string) (result outboxResult, ok bool, err error) {
+	err = o.db.Update(func(tx *bolt.Tx) error {
+		results := tx.Bucket([]byte(outboxResults))
+		data := results.Get(outboxKey(method, key))
+		if data == nil {
+			return nil
+		}
+
+		ok = true
+		if err := json.Unmarshal(data, &result); err != nil {
+			return err
+		}
+
+		return results.Delete(outboxKey(method, key))
+	})
+
+	return
+}
+
+// Failed records a failed attempt to send a call, the call stays queued
+func (o *outbox) Failed(entry *outboxEntry, cause error) error {
+	o.attempt(cause)
+
+	entry.Attempts++
+	entry.Error = cause.Error()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return o.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(outboxEntries)).Put(u64(entry.ID), data)
+	})
+}
+
+func (o *outbox) attempt(cause error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.lastAttempt = time.Now().Unix()
+	o.lastError = ""
+	if cause != nil {
+		o.lastError = cause.Error()
+	}
+}
+
+// Stats returns the outbox stats
+func (o *outbox) Stats() (stats zos4Pkg.OutboxStats, err error) {
+	o.mu.Lock()
+	stats.LastAttempt = o.lastAttempt
+	stats.LastError = o.lastError
+	o.mu.Unlock()
+
+	err = o.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(outboxEntries))
+		stats.Depth = uint64(bucket.Stats().KeyN)
+
+		_, data := bucket.Cursor().First()
+		if data == nil {
+			return nil
+		}
+
+		var entry outboxEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+
+		stats.Oldest = entry.Created
+		stats.OldestMethod = entry.Method
+		return nil
+	})
+
+	return
+}
+
+// Close the outbox
+func (o *outbox) Close() error {
+	return o.db.Close()
+}
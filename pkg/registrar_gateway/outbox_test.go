@@ -0,0 +1,197 @@
+package registrargw
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+func TestOutbox(t *testing.T) {
+	require := require.New(t)
+
+	o, err := newOutbox(filepath.Join(t.TempDir(), "outbox.bolt"))
+	require.NoError(err)
+	defer o.Close()
+
+	require.NoError(o.Push(outboxUpdateNode, "", true, 1))
+	require.NoError(o.Push(outboxUpdateNodeUptime, "10", false, 10))
+	// same call is queued once
+	require.NoError(o.Push(outboxUpdateNodeUptime, "10", false, 10))
+	// only the latest update is kept, and moved to the end
+	require.NoError(o.Push(outboxUpdateNode, "", true, 2))
+
+	n, err := o.Len("")
+	require.NoError(err)
+	require.Equal(2, n)
+
+	stats, err := o.Stats()
+	require.NoError(err)
+	require.EqualValues(2, stats.Depth)
+	require.Equal(outboxUpdateNodeUptime, stats.OldestMethod)
+	require.NotZero(stats.Oldest)
+
+	entry, ok, err := o.Peek("")
+	require.NoError(err)
+	require.True(ok)
+	require.Equal(outboxUpdateNodeUptime, entry.Method)
+
+	require.NoError(o.Failed(&entry, fmt.Errorf("connection refused")))
+	entry, _, err = o.Peek("")
+	require.NoError(err)
+	require.EqualValues(1, entry.Attempts)
+	require.Equal("connection refused", entry.Error)
+
+	require.NoError(o.Done(&entry, "", nil))
+	entry, _, err = o.Peek("")
+	require.NoError(err)
+	require.Equal(outboxUpdateNode, entry.Method)
+	require.JSONEq("2", string(entry.Payload))
+
+	require.NoError(o.Done(&entry, "", nil))
+	_, ok, err = o.Peek("")
+	require.NoError(err)
+	require.False(ok)
+
+	// results are kept for calls that are not coalesced, and only once
+	result, ok, err := o.Result(outboxUpdateNodeUptime, "10")
+	require.NoError(err)
+	require.True(ok)
+	require.NoError(result.Err())
+	_, ok, err = o.Result(outboxUpdateNodeUptime, "10")
+	require.NoError(err)
+	require.False(ok)
+	_, ok, err = o.Result(outboxUpdateNode, "")
+	require.NoError(err)
+	require.False(ok)
+
+	// done calls can be queued again
+	require.NoError(o.Push(outboxUpdateNodeUptime, "10", false, 10))
+	n, err = o.Len("")
+	require.NoError(err)
+	require.Equal(1, n)
+}
+
+func TestGatewayOutbox(t *testing.T) {
+	require := require.New(t)
+
+	o, err := newOutbox(filepath.Join(t.TempDir(), "outbox.bolt"))
+	require.NoError(err)
+	defer o.Close()
+
+	mock := NewMockSource()
+	gw := registrarGateway{contracts: mock, outbox: o, kick: make(chan struct{}, 1)}
+
+	mock.Err = &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}
+	consumption := []substrate.NruConsumption{{ContractID: 1, NRU: 10}}
+	h, rerr := gw.Report(consumption)
+	require.True(rerr.IsQueued())
+	require.True(rerr.IsCode(zos4Pkg.RegistrarCodeUnavailable))
+	require.Zero(h)
+
+	_, rerr = gw.SetNodePowerState(false)
+	require.True(rerr.IsQueued())
+
+	// calls are queued behind queued calls of the same operation, even if
+	// the registrar is back. Other operations are not held back.
+	mock.Err = nil
+	later := []substrate.NruConsumption{{ContractID: 2, NRU: 20}}
+	_, rerr = gw.Report(later)
+	require.True(rerr.IsQueued())
+	require.Empty(mock.Reports)
+	_, rerr = gw.SetNodePowerState(true)
+	require.True(rerr.IsQueued())
+	require.Nil(mock.Power)
+
+	stats, rerr := gw.OutboxStats()
+	require.NoError(rerr.Err())
+	require.EqualValues(3, stats.Depth)
+	require.Equal(outboxReport, stats.OldestMethod)
+
+	require.NoError(gw.flush())
+	require.Equal(append(consumption, later...), mock.Reports)
+	require.NotNil(mock.Power)
+	require.True(*mock.Power)
+
+	// once the power queue is empty, power calls are sent right away while
+	// reports are still queued
+	mock.Err = &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}
+	queued := []substrate.NruConsumption{{ContractID: 3, NRU: 30}}
+	_, rerr = gw.Report(queued)
+	require.True(rerr.IsQueued())
+	mock.Err = nil
+	_, rerr = gw.SetNodePowerState(false)
+	require.NoError(rerr.Err())
+	require.False(*mock.Power)
+	require.NoError(gw.flush())
+	_, rerr = gw.Report(queued)
+	require.NoError(rerr.Err())
+	_, rerr = gw.Report(later)
+	require.NoError(rerr.Err())
+	require.Equal(append(append(consumption, later...), queued...), mock.Reports)
+
+	// the report is repeated until it's no longer queued, it gets the
+	// result of the queued call and is not sent again
+	h, rerr = gw.Report(consumption)
+	require.NoError(rerr.Err())
+	require.Len(mock.Reports, 3)
+	expected, err := hash(consumption)
+	require.NoError(err)
+	require.Equal(expected, h)
+
+	stats, rerr = gw.OutboxStats()
+	require.NoError(rerr.Err())
+	require.Zero(stats.Depth)
+	require.Empty(stats.LastError)
+
+	// the registrar only applies a report once, even if the node sends it
+	// again because the response was lost
+	key := payloadKey(consumption)
+	_, err = mock.Report(key, consumption)
+	require.NoError(err)
+	require.Len(mock.Reports, 3)
+
+	// rejected calls are not queued
+	mock.Err = fmt.Errorf("registrar responded with status '400 Bad Request'")
+	_, rerr = gw.Report(consumption)
+	require.True(rerr.IsCode(zos4Pkg.RegistrarCodeGeneric))
+	require.False(rerr.IsQueued())
+	stats, rerr = gw.OutboxStats()
+	require.NoError(rerr.Err())
+	require.Zero(stats.Depth)
+}
+
+func TestGatewayReportResources(t *testing.T) {
+	require := require.New(t)
+
+	o, err := newOutbox(filepath.Join(t.TempDir(), "outbox.bolt"))
+	require.NoError(err)
+	defer o.Close()
+
+	mock := NewMockSource()
+	gw := registrarGateway{contracts: mock, outbox: o, kick: make(chan struct{}, 1)}
+
+	consumption := []zos4Pkg.ResourceConsumption{{ContractID: 1}}
+	mock.Err = &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}
+	rerr := gw.ReportResources(consumption)
+	require.True(rerr.IsQueued())
+	require.Empty(mock.Usage)
+
+	mock.Err = nil
+	require.NoError(gw.flush())
+	require.Equal(consumption, mock.Usage)
+
+	// the queued report is delivered once
+	require.NoError(gw.ReportResources(consumption).Err())
+	require.Equal(consumption, mock.Usage)
+
+	// rejected reports are not reported as a success
+	mock.Err = fmt.Errorf("registrar responded with status '400 Bad Request'")
+	rerr = gw.ReportResources(consumption)
+	require.True(rerr.IsError())
+	require.False(rerr.IsQueued())
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"crypto/ed25519"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"net/url"
-	"sync"
+	"os"
+	"path/filepath"
 	"time"
 
 	subTypes "github.com/centrifuge/go-substrate-rpc-client/v4/types"
@@ -16,155 +19,548 @@ import (
 	"github.com/threefoldtech/zbus"
 	zos4Pkg "github.com/threefoldtech/zos4/pkg"
 	"github.com/threefoldtech/zos4/pkg/stubs"
-	"github.com/threefoldtech/zosbase/pkg"
 	"github.com/threefoldtech/zosbase/pkg/environment"
+	"github.com/threefoldtech/zosbase/pkg/kernel"
 )
 
-const AuthHeader = "X-Auth"
+const (
+	AuthHeader = "X-Auth"
+	// IdempotencyHeader carries the key of mutating calls that must be
+	// applied once, even if they are sent again
+	IdempotencyHeader = "Idempotency-Key"
+
+	// ParamContracts kernel param with the path of a local contracts file. If
+	// set contracts are served from this file instead of the registrar. It's
+	// only used on dev and test nodes, since reports sent to a local source
+	// are not billed.
+	ParamContracts = "zos-contracts"
+
+	// ParamRegistrar kernel param with a registrar url, can be set multiple
+	// times. Those endpoints are used before the environment registrar.
+	ParamRegistrar = "registrar"
+)
 
 type registrarGateway struct {
-	mu              sync.Mutex
-	registrarClient client.RegistrarClient
+	locks     locks
+	registrar *Endpoints
+	// api is used for registrar calls that are not in the registrar client
+	api       *httpSource
+	contracts ContractSource
+	cache     *cache
+	outbox    *outbox
+	kick      chan struct{}
+	clock     *clock
+}
+
+// methods of calls that can be queued in the outbox
+const (
+	outboxEnsureAccount          = "EnsureAccount"
+	outboxUpdateNode             = "UpdateNode"
+	outboxUpdateNodeUptime       = "UpdateNodeUptimeV2"
+	outboxReport                 = "Report"
+	outboxReportResources        = "ReportResources"
+	outboxSetContractConsumption = "SetContractConsumption"
+	outboxSetNodePowerState      = "SetNodePowerState"
+
+	// outboxRetry is how often queued calls are retried
+	outboxRetry = 30 * time.Second
+)
+
+type ensureAccountCall struct {
+	Relays    []string `json:"relays"`
+	RmbEncKey string   `json:"rmb_enc_key"`
 }
 
-func NewRegistrarGateway(ctx context.Context, cl zbus.Client) (zos4Pkg.RegistrarGateway, error) {
+type uptimeCall struct {
+	Uptime    uint64 `json:"uptime"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// NewRegistrarGateway creates a new registrar gateway, lookups are cached
+// in a database under root.
+func NewRegistrarGateway(ctx context.Context, cl zbus.Client, root string) (zos4Pkg.RegistrarGateway, error) {
 	identity := stubs.NewIdentityManagerStub(cl)
 	sk := ed25519.PrivateKey(identity.PrivateKey(ctx))
 	hexSeed := hex.EncodeToString(sk.Seed())
 
 	env := environment.MustGet()
-	url, err := url.JoinPath(env.RegistrarURL, "api", "v1")
-	if err != nil {
-		return &registrarGateway{}, err
+	urls, _ := kernel.GetParams().Get(ParamRegistrar)
+	urls = append(urls, env.RegistrarURL)
+	for i, u := range urls {
+		var err error
+		urls[i], err = url.JoinPath(u, "api", "v1")
+		if err != nil {
+			return &registrarGateway{}, errors.Wrapf(err, "invalid registrar url '%s'", u)
+		}
 	}
 
-	cli, err := client.NewRegistrarClient(url, hexSeed)
+	endpoints, err := NewEndpoints(urls, hexSeed)
 	if err != nil {
-		return &registrarGateway{}, errors.Wrap(err, "failed to create new registrar client")
+		return &registrarGateway{}, errors.Wrap(err, "failed to create registrar endpoints")
 	}
 
 	gw := &registrarGateway{
-		mu:              sync.Mutex{},
-		registrarClient: cli,
+		registrar: endpoints,
+		api:       newHTTPSource(endpoints, sk),
+		kick:      make(chan struct{}, 1),
+		clock:     newClock(maxClockSkew(kernel.GetParams().GetOne(ParamMaxClockSkew))),
+	}
+
+	if path, ok := contractsFile(env.RunningMode, kernel.GetParams()); ok {
+		log.Info().Str("path", path).Msg("using local contracts file")
+		gw.contracts, err = NewLocalSource(path)
+		if err != nil {
+			return &registrarGateway{}, errors.Wrap(err, "failed to load local contracts")
+		}
+	} else {
+		gw.contracts = gw.api
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return &registrarGateway{}, errors.Wrap(err, "failed to create gateway root")
+	}
+
+	gw.cache, err = newCache(filepath.Join(root, "cache.bolt"))
+	if err != nil {
+		// the gateway works fine without a cache
+		log.Error().Err(err).Msg("failed to open registrar cache, lookups will not be cached")
+	}
+
+	gw.outbox, err = newOutbox(filepath.Join(root, "outbox.bolt"))
+	if err != nil {
+		return &registrarGateway{}, err
 	}
 
+	go gw.registrar.Monitor(ctx)
+	go gw.replay(ctx)
+	go gw.monitorClock(ctx)
+	go gw.watchRotations(ctx, identity)
+
 	return gw, nil
 }
 
-func (r *registrarGateway) GetZosVersion() (client.ZosVersion, error) {
+// contractsFile returns the local contracts file set in params. The file is
+// ignored outside of dev and test run modes.
+func contractsFile(mode environment.RunMode, params kernel.Params) (string, bool) {
+	path, ok := params.GetOne(ParamContracts)
+	if !ok {
+		return "", false
+	}
+
+	if mode != environment.RunningDev && mode != environment.RunningTest {
+		log.Error().Str("mode", mode.String()).Msgf("%s kernel param is only allowed on dev and test nodes, ignoring", ParamContracts)
+		return "", false
+	}
+
+	return path, true
+}
+
+// mutate runs call, if the registrar can't be reached the call is queued
+// to be sent later and ErrQueued is returned. If other calls of the same
+// operation are already queued the call is queued right away to keep calls
+// in order. A call that is not
+// coalesced is repeated by its caller until it's no longer queued, once sent
+// the result of the queued call is returned instead of calling again. Caller
+// must hold the lock of the call operation.
+func (r *registrarGateway) mutate(method, key string, coalesce bool, payload interface{}, call func() (subTypes.Hash, error)) (subTypes.Hash, error) {
+	if r.outbox == nil {
+		return call()
+	}
+
+	if !coalesce {
+		result, ok, err := r.outbox.Result(method, key)
+		if err != nil {
+			return subTypes.Hash{}, errors.Wrap(err, "failed to check outbox")
+		} else if ok {
+			hash, err := parseHash(result.Hash)
+			if err != nil {
+				return hash, err
+			}
+			return hash, result.Err()
+		}
+	}
+
+	depth, err := r.outbox.Len(outboxOps[method])
+	if err != nil {
+		return subTypes.Hash{}, errors.Wrap(err, "failed to check outbox")
+	}
+
+	if depth == 0 {
+		hash, err := call()
+		if !unreachable(err) {
+			return hash, err
+		}
+
+		log.Warn().Err(err).Str("method", method).Msg("registrar is unreachable, queuing call")
+	}
+
+	if err := r.outbox.Push(method, key, coalesce, payload); err != nil {
+		return subTypes.Hash{}, errors.Wrapf(err, "failed to queue '%s' call", method)
+	}
+
+	select {
+	case r.kick <- struct{}{}:
+	default:
+	}
+
+	return subTypes.Hash{}, ErrQueued
+}
+
+// withoutHash adapts calls that don't return a hash to mutate
+func withoutHash(call func() error) func() (subTypes.Hash, error) {
+	return func() (subTypes.Hash, error) {
+		return subTypes.Hash{}, call()
+	}
+}
+
+func formatHash(hash subTypes.Hash) string {
+	if hash == (subTypes.Hash{}) {
+		return ""
+	}
+
+	return hash.Hex()
+}
+
+func parseHash(hash string) (subTypes.Hash, error) {
+	if len(hash) == 0 {
+		return subTypes.Hash{}, nil
+	}
+
+	return subTypes.NewHashFromHexString(hash)
+}
+
+// apply sends a queued call
+func (r *registrarGateway) apply(entry *outboxEntry) (subTypes.Hash, error) {
+	switch entry.Method {
+	case outboxEnsureAccount:
+		var call ensureAccountCall
+		if err := json.Unmarshal(entry.Payload, &call); err != nil {
+			return subTypes.Hash{}, err
+		}
+		_, err := r.ensureAccount(call.Relays, call.RmbEncKey)
+		return subTypes.Hash{}, err
+	case outboxUpdateNode:
+		var node client.Node
+		if err := json.Unmarshal(entry.Payload, &node); err != nil {
+			return subTypes.Hash{}, err
+		}
+		return subTypes.Hash{}, r.updateNode(node)
+	case outboxUpdateNodeUptime:
+		var call uptimeCall
+		if err := json.Unmarshal(entry.Payload, &call); err != nil {
+			return subTypes.Hash{}, err
+		}
+		return subTypes.Hash{}, r.reportUptime(call.Uptime, call.Timestamp)
+	case outboxReport:
+		var consumptions []substrate.NruConsumption
+		if err := json.Unmarshal(entry.Payload, &consumptions); err != nil {
+			return subTypes.Hash{}, err
+		}
+		return r.contracts.Report(entry.Key, consumptions)
+	case outboxReportResources:
+		var consumptions []zos4Pkg.ResourceConsumption
+		if err := json.Unmarshal(entry.Payload, &consumptions); err != nil {
+			return subTypes.Hash{}, err
+		}
+		return subTypes.Hash{}, r.contracts.ReportResources(entry.Key, consumptions)
+	case outboxSetContractConsumption:
+		var resources []substrate.ContractResources
+		if err := json.Unmarshal(entry.Payload, &resources); err != nil {
+			return subTypes.Hash{}, err
+		}
+		return subTypes.Hash{}, r.contracts.SetContractConsumption(entry.Key, resources)
+	case outboxSetNodePowerState:
+		var up bool
+		if err := json.Unmarshal(entry.Payload, &up); err != nil {
+			return subTypes.Hash{}, err
+		}
+		return r.contracts.SetNodePowerState(up)
+	}
+
+	return subTypes.Hash{}, fmt.Errorf("unknown queued method '%s'", entry.Method)
+}
+
+// flush sends queued calls of all operations in order, calls of an
+// operation are sent until its queue is empty or the registrar is
+// unreachable. An operation that can't be sent does not hold back the
+// others.
+func (r *registrarGateway) flush() error {
+	ops := make(map[string]struct{})
+	for _, op := range outboxOps {
+		ops[op] = struct{}{}
+	}
+
+	for op := range ops {
+		if err := r.flushOp(op); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flushOp sends queued calls of operation op
+func (r *registrarGateway) flushOp(op string) error {
+	for {
+		entry, ok, err := r.outbox.Peek(op)
+		if err != nil || !ok {
+			return err
+		}
+
+		unlock := r.locks.lock(op, "replay")
+		hash, cause := r.apply(&entry)
+		unlock()
+		if unreachable(cause) {
+			return r.outbox.Failed(&entry, cause)
+		}
+
+		if cause != nil {
+			// retrying will not help, the call is dropped
+			log.Error().Err(cause).
+				Str("method", entry.Method).
+				Time("queued", time.Unix(entry.Created, 0)).
+				Msg("queued call was rejected by the registrar")
+		} else {
+			log.Info().Str("method", entry.Method).Msg("queued call sent")
+		}
+
+		if err := r.outbox.Done(&entry, formatHash(hash), cause); err != nil {
+			return err
+		}
+	}
+}
+
+// replay retries queued calls until the context is cancelled
+func (r *registrarGateway) replay(ctx context.Context) {
+	ticker := time.NewTicker(outboxRetry)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.kick:
+			// give the registrar some time, calls are queued because
+			// it's not reachable.
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(outboxRetry):
+			}
+		}
+
+		if err := r.flush(); err != nil {
+			log.Error().Err(err).Msg("failed to send queued calls")
+		}
+	}
+}
+
+func (r *registrarGateway) RegistrarStats() (zos4Pkg.RegistrarStats, zos4Pkg.RegistrarError) {
+	if r.registrar == nil {
+		return zos4Pkg.RegistrarStats{}, zos4Pkg.RegistrarError{}
+	}
+
+	return r.registrar.Stats(), zos4Pkg.RegistrarError{}
+}
+
+func (r *registrarGateway) OutboxStats() (zos4Pkg.OutboxStats, zos4Pkg.RegistrarError) {
+	if r.outbox == nil {
+		return zos4Pkg.OutboxStats{}, zos4Pkg.RegistrarError{}
+	}
+
+	stats, err := r.outbox.Stats()
+	return stats, registrarError(err)
+}
+
+func (r *registrarGateway) LockStats() ([]zos4Pkg.RegistrarLockStats, zos4Pkg.RegistrarError) {
+	return r.locks.Stats(), zos4Pkg.RegistrarError{}
+}
+
+func (r *registrarGateway) InvalidateCache(methods []string) zos4Pkg.RegistrarError {
+	log.Debug().Str("method", "InvalidateCache").Strs("methods", methods).Msg("method called")
+
+	return registrarError(r.cache.Invalidate(methods...))
+}
+
+// invalidate drops cached values after a mutation
+func (r *registrarGateway) invalidate(methods ...string) {
+	if err := r.cache.Invalidate(methods...); err != nil {
+		log.Error().Err(err).Strs("methods", methods).Msg("failed to invalidate cache")
+	}
+}
+
+func (r *registrarGateway) GetZosVersion() (client.ZosVersion, zos4Pkg.RegistrarError) {
 	log.Debug().Str("method", "GetZosVersion").Msg("method called")
 
-	return r.registrarClient.GetZosVersion()
+	version, err := cached(r.cache, "GetZosVersion", "", func() (client.ZosVersion, error) {
+		return call(r.registrar, (*client.RegistrarClient).GetZosVersion)
+	})
+	return version, registrarError(err)
 }
 
-func (r *registrarGateway) CreateNode(node client.Node) (uint64, error) {
+func (r *registrarGateway) CreateNode(node client.Node) (uint64, zos4Pkg.RegistrarError) {
 	log.Debug().
 		Str("method", "CreateNode").
 		Uint32("farm_id", uint32(node.FarmID)).
 		Uint32("twin_id", uint32(node.TwinID)).
 		Msg("method called")
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	defer r.locks.lock(opNode, "CreateNode")()
 
-	return r.registrarClient.RegisterNode(node)
+	defer r.invalidate("GetNode", "GetNodeByTwinID", "GetNodes")
+	id, err := mutation(r.registrar, func(cl *client.RegistrarClient) (uint64, error) {
+		return cl.RegisterNode(node)
+	})
+	return id, registrarError(err)
 }
 
-func (r *registrarGateway) CreateTwin(relays []string, rmbEncKey string) (client.Account, error) {
+func (r *registrarGateway) CreateTwin(relays []string, rmbEncKey string) (client.Account, zos4Pkg.RegistrarError) {
 	log.Debug().
 		Str("method", "CreateTwin").
 		Strs("relay", relays).
 		Str("rmbEncKey", rmbEncKey).
 		Msg("method called")
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	defer r.locks.lock(opAccount, "CreateTwin")()
 
-	account, _, err := r.registrarClient.CreateAccount(relays, rmbEncKey)
-	return account, err
+	defer r.invalidate("GetTwin", "GetTwinByPubKey")
+	account, err := mutation(r.registrar, func(cl *client.RegistrarClient) (client.Account, error) {
+		account, _, err := cl.CreateAccount(relays, rmbEncKey)
+		return account, err
+	})
+	return account, registrarError(err)
 }
 
-func (r *registrarGateway) EnsureAccount(relays []string, rmbEncKey string) (client.Account, error) {
+func (r *registrarGateway) EnsureAccount(relays []string, rmbEncKey string) (client.Account, zos4Pkg.RegistrarError) {
 	log.Debug().
 		Str("method", "EnsureAccount").
 		Strs("relay", relays).
 		Str("rmbEncKey", rmbEncKey).
 		Msg("method called")
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	defer r.locks.lock(opAccount, "EnsureAccount")()
+
+	var account client.Account
+	_, err := r.mutate(outboxEnsureAccount, "", true, ensureAccountCall{Relays: relays, RmbEncKey: rmbEncKey}, withoutHash(func() (err error) {
+		account, err = r.ensureAccount(relays, rmbEncKey)
+		return err
+	}))
+	return account, registrarError(err)
+}
 
-	return r.registrarClient.EnsureAccount(relays, rmbEncKey)
+func (r *registrarGateway) ensureAccount(relays []string, rmbEncKey string) (client.Account, error) {
+	defer r.invalidate("GetTwin", "GetTwinByPubKey")
+	return mutation(r.registrar, func(cl *client.RegistrarClient) (client.Account, error) {
+		return cl.EnsureAccount(relays, rmbEncKey)
+	})
 }
 
-func (r *registrarGateway) GetFarm(id uint64) (client.Farm, error) {
+func (r *registrarGateway) GetFarm(id uint64) (client.Farm, zos4Pkg.RegistrarError) {
 	log.Debug().
 		Str("method", "GetFarm").
 		Uint64("farm_id", id).
 		Msg("method called")
 
-	return r.registrarClient.GetFarm(id)
+	farm, err := cached(r.cache, "GetFarm", fmt.Sprint(id), func() (client.Farm, error) {
+		return call(r.registrar, func(cl *client.RegistrarClient) (client.Farm, error) {
+			return cl.GetFarm(id)
+		})
+	})
+	return farm, registrarError(err)
 }
 
-func (r *registrarGateway) GetNode(id uint64) (client.Node, error) {
+func (r *registrarGateway) GetNode(id uint64) (client.Node, zos4Pkg.RegistrarError) {
 	log.Debug().
 		Str("method", "GetNode").
 		Uint64("node_id", id).
 		Msg("method called")
 
-	return r.registrarClient.GetNode(id)
+	node, err := cached(r.cache, "GetNode", fmt.Sprint(id), func() (client.Node, error) {
+		return call(r.registrar, func(cl *client.RegistrarClient) (client.Node, error) {
+			return cl.GetNode(id)
+		})
+	})
+	return node, registrarError(err)
 }
 
-func (r *registrarGateway) GetNodeByTwinID(twinID uint64) (client.Node, error) {
+func (r *registrarGateway) GetNodeByTwinID(twinID uint64) (client.Node, zos4Pkg.RegistrarError) {
 	log.Debug().
 		Str("method", "GetNodeByTwinID").
 		Uint64("twin_id", twinID).
 		Msg("method called")
 
-	return r.registrarClient.GetNodeByTwinID(twinID)
+	node, err := cached(r.cache, "GetNodeByTwinID", fmt.Sprint(twinID), func() (client.Node, error) {
+		return call(r.registrar, func(cl *client.RegistrarClient) (client.Node, error) {
+			return cl.GetNodeByTwinID(twinID)
+		})
+	})
+	return node, registrarError(err)
 }
 
-func (r *registrarGateway) GetNodes(farmID uint64) (nodeIDs []uint64, err error) {
+func (r *registrarGateway) GetNodes(farmID uint64) ([]uint64, zos4Pkg.RegistrarError) {
 	log.Debug().
 		Str("method", "GetNodes").
 		Uint64("farm_id", farmID).
 		Msg("method called")
-	nodes, err := r.registrarClient.ListNodes(client.NodeFilter{FarmID: &farmID})
-	for _, node := range nodes {
-		nodeIDs = append(nodeIDs, node.NodeID)
-	}
-
-	return
+	nodeIDs, err := cached(r.cache, "GetNodes", fmt.Sprint(farmID), func() (nodeIDs []uint64, err error) {
+		nodes, err := call(r.registrar, func(cl *client.RegistrarClient) ([]client.Node, error) {
+			return cl.ListNodes(client.NodeFilter{FarmID: &farmID})
+		})
+		for _, node := range nodes {
+			nodeIDs = append(nodeIDs, node.NodeID)
+		}
+
+		return
+	})
+	return nodeIDs, registrarError(err)
 }
 
-func (r *registrarGateway) GetTwin(id uint64) (result client.Account, err error) {
+func (r *registrarGateway) GetTwin(id uint64) (client.Account, zos4Pkg.RegistrarError) {
 	log.Debug().
 		Str("method", "GetTwin").
 		Uint64("twin_id", id).
 		Msg("method called")
 
-	return r.registrarClient.GetAccount(id)
+	account, err := cached(r.cache, "GetTwin", fmt.Sprint(id), func() (client.Account, error) {
+		return call(r.registrar, func(cl *client.RegistrarClient) (client.Account, error) {
+			return cl.GetAccount(id)
+		})
+	})
+	return account, registrarError(err)
 }
 
-func (r *registrarGateway) GetTwinByPubKey(pk []byte) (result uint64, err error) {
+func (r *registrarGateway) GetTwinByPubKey(pk []byte) (uint64, zos4Pkg.RegistrarError) {
 	log.Debug().
 		Str("method", "GetTwinByPubKey").
 		Str("pk", hex.EncodeToString(pk)).
 		Msg("method called")
 
-	account, err := r.registrarClient.GetAccountByPK(pk)
-	return account.TwinID, err
+	twin, err := cached(r.cache, "GetTwinByPubKey", hex.EncodeToString(pk), func() (uint64, error) {
+		account, err := call(r.registrar, func(cl *client.RegistrarClient) (client.Account, error) {
+			return cl.GetAccountByPK(pk)
+		})
+		return account.TwinID, err
+	})
+	return twin, registrarError(err)
 }
 
-func (r *registrarGateway) UpdateNode(node client.Node) error {
+func (r *registrarGateway) UpdateNode(node client.Node) zos4Pkg.RegistrarError {
 	log.Debug().
 		Str("method", "UpdateNode").
 		Uint64("twin_id", node.TwinID).
 		Msg("method called")
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	defer r.locks.lock(opNode, "UpdateNode")()
 
+	// only the latest node update matters
+	_, err := r.mutate(outboxUpdateNode, "", true, node, withoutHash(func() error {
+		return r.updateNode(node)
+	}))
+	return registrarError(err)
+}
+
+func (r *registrarGateway) updateNode(node client.Node) error {
 	update := client.NodeUpdate{
 		FarmID:       &node.FarmID,
 		Location:     &node.Location,
@@ -175,111 +571,184 @@ func (r *registrarGateway) UpdateNode(node client.Node) error {
 		Virtualized:  &node.Virtualized,
 	}
 
-	return r.registrarClient.UpdateNode(update)
+	defer r.invalidate("GetNode", "GetNodeByTwinID")
+	return r.registrar.Mutate(func(_ string, cl *client.RegistrarClient) error {
+		return cl.UpdateNode(update)
+	})
 }
 
-func (r *registrarGateway) UpdateNodeUptimeV2(uptime uint64, timestamp int64) (err error) {
+func (r *registrarGateway) UpdateNodeUptimeV2(uptime uint64, timestamp int64) zos4Pkg.RegistrarError {
 	log.Debug().
 		Str("method", "UpdateNodeUptimeV2").
 		Uint64("uptime", uptime).
 		Msg("method called")
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	return r.registrarClient.ReportUptime(client.UptimeReport{Uptime: uptime, Timestamp: timestamp})
-}
-
-func (r *registrarGateway) GetTime() (time.Time, error) {
-	// log.Trace().Str("method", "Time").Msg("method called")
-	//
-	// return g.sub.Time()
-	return time.Now(), nil
-}
-
-func (r *registrarGateway) GetContract(id uint64) (result substrate.Contract, serr pkg.SubstrateError) {
-	// log.Trace().Str("method", "GetContract").Uint64("id", id).Msg("method called")
-	// contract, err := g.sub.GetContract(id)
-	//
-	// serr = buildSubstrateError(err)
-	// if err != nil {
-	// 	return
-	// }
-	return
-}
-
-func (r *registrarGateway) GetContractIDByNameRegistration(name string) (result uint64, serr pkg.SubstrateError) {
-	// log.Trace().Str("method", "GetContractIDByNameRegistration").Str("name", name).Msg("method called")
-	// contractID, err := g.sub.GetContractIDByNameRegistration(name)
-	//
-	// serr = buildSubstrateError(err)
-	return
-}
-
-func (r *registrarGateway) GetNodeContracts(node uint32) ([]subTypes.U64, error) {
-	// log.Trace().Str("method", "GetNodeContracts").Uint32("node", node).Msg("method called")
-	// return g.sub.GetNodeContracts(node)
-	return []subTypes.U64{}, nil
-}
-
-func (r *registrarGateway) GetNodeRentContract(node uint32) (result uint64, serr pkg.SubstrateError) {
-	// log.Trace().Str("method", "GetNodeRentContract").Uint32("node", node).Msg("method called")
-	// contractID, err := g.sub.GetNodeRentContract(node)
-	//
-	// serr = buildSubstrateError(err)
-	return
-}
-
-func (r *registrarGateway) GetPowerTarget() (power substrate.NodePower, err error) {
-	// log.Trace().Str("method", "GetPowerTarget").Uint32("node id", uint32(g.nodeID)).Msg("method called")
-	// return g.sub.GetPowerTarget(uint32(g.nodeID))
-	power = substrate.NodePower{
-		State:  substrate.PowerState{IsUp: true},
-		Target: substrate.Power{IsUp: true},
-	}
-
-	return
-}
-
-func (r *registrarGateway) Report(consumptions []substrate.NruConsumption) (subTypes.Hash, error) {
-	// contractIDs := make([]uint64, 0, len(consumptions))
-	// for _, v := range consumptions {
-	// 	contractIDs = append(contractIDs, uint64(v.ContractID))
-	// }
-	//
-	// log.Debug().Str("method", "Report").Uints64("contract ids", contractIDs).Msg("method called")
-	// r.mu.Lock()
-	// defer r.mu.Unlock()
-	//
-	// url := fmt.Sprintf("%s/v1/nodes/%d/consumption", r.baseURL, r.nodeID)
-	//
-	// var body bytes.Buffer
-	// _, err := r.httpClient.Post(url, "application/json", &body)
-	// if err != nil {
-	// 	return subTypes.Hash{}, err
-	// }
-	//
-	// // I need to know what is hash to be able to respond with it
-	// return r.sub.Report(r.identity, consumptions)
-	return subTypes.Hash{}, nil
-}
-
-func (r *registrarGateway) SetContractConsumption(resources ...substrate.ContractResources) error {
-	// contractIDs := make([]uint64, 0, len(resources))
-	// for _, v := range resources {
-	// 	contractIDs = append(contractIDs, uint64(v.ContractID))
-	// }
-	// log.Debug().Str("method", "SetContractConsumption").Uints64("contract ids", contractIDs).Msg("method called")
-	// g.mu.Lock()
-	// defer g.mu.Unlock()
-	// return g.sub.SetContractConsumption(g.identity, resources...)
-	return nil
+	if r.clock.blocked() {
+		skew := r.clock.get()
+		return registrarError(errors.Errorf("node clock is off by %s, uptime is not reported", time.Duration(skew.Skew)*time.Millisecond))
+	}
+
+	defer r.locks.lock(opUptime, "UpdateNodeUptimeV2")()
+
+	_, err := r.mutate(outboxUpdateNodeUptime, fmt.Sprint(timestamp), false, uptimeCall{Uptime: uptime, Timestamp: timestamp}, withoutHash(func() error {
+		return r.reportUptime(uptime, timestamp)
+	}))
+	return registrarError(err)
+}
+
+func (r *registrarGateway) reportUptime(uptime uint64, timestamp int64) error {
+	return r.registrar.Mutate(func(_ string, cl *client.RegistrarClient) error {
+		return cl.ReportUptime(client.UptimeReport{Uptime: uptime, Timestamp: timestamp})
+	})
+}
+
+func (r *registrarGateway) GetTime() (time.Time, zos4Pkg.RegistrarError) {
+	log.Trace().Str("method", "GetTime").Msg("method called")
+
+	skew, err := r.measureClock()
+	if err != nil {
+		return time.Time{}, registrarError(errors.Wrap(err, "failed to get registrar time"))
+	}
+
+	return time.Now().Add(skew), zos4Pkg.RegistrarError{}
+}
+
+func (r *registrarGateway) ClockSkew() (zos4Pkg.ClockSkew, zos4Pkg.RegistrarError) {
+	return r.clock.get(), zos4Pkg.RegistrarError{}
+}
+
+func (r *registrarGateway) GetContract(id uint64) (substrate.Contract, zos4Pkg.RegistrarError) {
+	log.Trace().Str("method", "GetContract").Uint64("id", id).Msg("method called")
+
+	var result substrate.Contract
+	contract, err := r.contracts.Contract(id)
+	if err == nil {
+		result, err = contract.Substrate()
+	}
+
+	return result, registrarError(err)
+}
+
+func (r *registrarGateway) GetContractIDByNameRegistration(name string) (uint64, zos4Pkg.RegistrarError) {
+	log.Trace().Str("method", "GetContractIDByNameRegistration").Str("name", name).Msg("method called")
+
+	result, err := r.contracts.ContractIDByName(name)
+	return result, registrarError(err)
+}
+
+func (r *registrarGateway) GetNodeContracts(node uint32) ([]subTypes.U64, zos4Pkg.RegistrarError) {
+	log.Trace().Str("method", "GetNodeContracts").Uint32("node", node).Msg("method called")
+
+	ids, err := r.contracts.NodeContracts(node)
+	if err != nil {
+		return nil, registrarError(err)
+	}
+
+	contracts := make([]subTypes.U64, 0, len(ids))
+	for _, id := range ids {
+		contracts = append(contracts, subTypes.U64(id))
+	}
+
+	return contracts, zos4Pkg.RegistrarError{}
+}
+
+func (r *registrarGateway) GetNodeContractEvents(node uint32, after uint64) ([]zos4Pkg.ContractEvent, zos4Pkg.RegistrarError) {
+	log.Trace().Str("method", "GetNodeContractEvents").Uint32("node", node).Uint64("after", after).Msg("method called")
+
+	events, err := r.contracts.Events(node, after)
+	return events, registrarError(err)
+}
+
+func (r *registrarGateway) GetNodeRentContract(node uint32) (uint64, zos4Pkg.RegistrarError) {
+	log.Trace().Str("method", "GetNodeRentContract").Uint32("node", node).Msg("method called")
+
+	result, err := r.contracts.NodeRentContract(node)
+	return result, registrarError(err)
+}
+
+func (r *registrarGateway) GetPowerTarget() (substrate.NodePower, zos4Pkg.RegistrarError) {
+	log.Trace().Str("method", "GetPowerTarget").Msg("method called")
+
+	_, node, err := r.api.identity()
+	if err != nil {
+		return substrate.NodePower{}, registrarError(err)
+	}
+
+	power, err := r.contracts.PowerTarget(uint32(node))
+	return power, registrarError(err)
+}
+
+func (r *registrarGateway) GetNodePowerTarget(node uint32) (substrate.NodePower, zos4Pkg.RegistrarError) {
+	log.Trace().Str("method", "GetNodePowerTarget").Uint32("node", node).Msg("method called")
+
+	power, err := r.contracts.PowerTarget(node)
+	return power, registrarError(err)
 }
 
-func (r *registrarGateway) SetNodePowerState(up bool) (hash subTypes.Hash, err error) {
-	// log.Debug().Str("method", "SetNodePowerState").Bool("up", up).Msg("method called")
-	// g.mu.Lock()
-	// defer g.mu.Unlock()
-	// return g.sub.SetNodePowerState(g.identity, up)
-	return subTypes.Hash{}, nil
+func (r *registrarGateway) GetNodePublicConfig(node uint32) (substrate.OptionPublicConfig, zos4Pkg.RegistrarError) {
+	log.Trace().Str("method", "GetNodePublicConfig").Uint32("node", node).Msg("method called")
+
+	config, err := r.contracts.PublicConfig(node)
+	return config, registrarError(err)
+}
+
+func (r *registrarGateway) Report(consumptions []substrate.NruConsumption) (subTypes.Hash, zos4Pkg.RegistrarError) {
+	contractIDs := make([]uint64, 0, len(consumptions))
+	for _, v := range consumptions {
+		contractIDs = append(contractIDs, uint64(v.ContractID))
+	}
+
+	log.Debug().Str("method", "Report").Uints64("contract ids", contractIDs).Msg("method called")
+	defer r.locks.lock(opReport, "Report")()
+
+	// the payload key is also the idempotency key, so a report that is
+	// sent again after a lost response is not billed twice
+	key := payloadKey(consumptions)
+	hash, err := r.mutate(outboxReport, key, false, consumptions, func() (subTypes.Hash, error) {
+		return r.contracts.Report(key, consumptions)
+	})
+	return hash, registrarError(err)
+}
+
+func (r *registrarGateway) ReportResources(consumptions []zos4Pkg.ResourceConsumption) zos4Pkg.RegistrarError {
+	contractIDs := make([]uint64, 0, len(consumptions))
+	for _, v := range consumptions {
+		contractIDs = append(contractIDs, v.ContractID)
+	}
+
+	log.Debug().Str("method", "ReportResources").Uints64("contract ids", contractIDs).Msg("method called")
+	defer r.locks.lock(opReport, "ReportResources")()
+
+	key := payloadKey(consumptions)
+	_, err := r.mutate(outboxReportResources, key, false, consumptions, withoutHash(func() error {
+		return r.contracts.ReportResources(key, consumptions)
+	}))
+	return registrarError(err)
+}
+
+func (r *registrarGateway) SetContractConsumption(resources ...substrate.ContractResources) zos4Pkg.RegistrarError {
+	contractIDs := make([]uint64, 0, len(resources))
+	for _, v := range resources {
+		contractIDs = append(contractIDs, uint64(v.ContractID))
+	}
+
+	log.Debug().Str("method", "SetContractConsumption").Uints64("contract ids", contractIDs).Msg("method called")
+	defer r.locks.lock(opConsumption, "SetContractConsumption")()
+
+	key := payloadKey(resources)
+	_, err := r.mutate(outboxSetContractConsumption, key, false, resources, withoutHash(func() error {
+		return r.contracts.SetContractConsumption(key, resources)
+	}))
+	return registrarError(err)
+}
+
+func (r *registrarGateway) SetNodePowerState(up bool) (subTypes.Hash, zos4Pkg.RegistrarError) {
+	log.Debug().Str("method", "SetNodePowerState").Bool("up", up).Msg("method called")
+	defer r.locks.lock(opPower, "SetNodePowerState")()
+
+	// only the latest power state matters
+	hash, err := r.mutate(outboxSetNodePowerState, "", true, up, func() (subTypes.Hash, error) {
+		return r.contracts.SetNodePowerState(up)
+	})
+	return hash, registrarError(err)
 }
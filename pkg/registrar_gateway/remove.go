@@ -0,0 +1,40 @@
+package registrargw
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+func (s *httpSource) RemoveNode() error {
+	twin, node, err := s.identity()
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(http.MethodDelete, fmt.Sprintf("nodes/%d", node), nil, twin, nil, nil); err != nil {
+		return err
+	}
+
+	// the node id is looked up again if the node registers again
+	s.mu.Lock()
+	s.node = 0
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (r *registrarGateway) RemoveNode() zos4Pkg.RegistrarError {
+	log.Debug().
+		Str("method", "RemoveNode").
+		Msg("method called")
+
+	defer r.locks.lock(opNode, "RemoveNode")()
+
+	// removal is not queued, the caller must know the node is gone
+	// before it wipes its identity
+	defer r.invalidate("GetNode", "GetNodeByTwinID", "GetNodes", "GetNodeInventory", "GetNodeAttestation")
+	return registrarError(r.api.RemoveNode())
+}
@@ -0,0 +1,28 @@
+package registrargw
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+func TestRemoveNode(t *testing.T) {
+	require := require.New(t)
+
+	server, gw, nodeID := registeredNode(t)
+	require.False(gw.UpdateNodeInventory(zos4Pkg.NodeInventory{}).IsError())
+
+	_, rerr := gw.GetNode(nodeID)
+	require.False(rerr.IsError())
+
+	require.False(gw.RemoveNode().IsError())
+	require.Empty(server.State().Nodes)
+	require.Empty(server.State().Inventories)
+
+	_, rerr = gw.GetNode(nodeID)
+	require.True(rerr.IsCode(zos4Pkg.RegistrarCodeNotFound))
+
+	// the node is already gone
+	require.True(gw.RemoveNode().IsCode(zos4Pkg.RegistrarCodeNotFound))
+}
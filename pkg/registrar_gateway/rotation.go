@@ -0,0 +1,101 @@
+package registrargw
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/stubs"
+)
+
+// RotateTwinKey sends the rotation unsigned, the rotation is signed by both
+// the old and new keys which authenticates it
+func (s *httpSource) RotateTwinKey(rotation zos4Pkg.KeyRotation) error {
+	return s.do(http.MethodPost, fmt.Sprintf("accounts/%d/rotate", rotation.TwinID), nil, 0, rotation, nil)
+}
+
+// key returns the node key used to sign requests
+func (s *httpSource) key() ed25519.PrivateKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sk
+}
+
+// setKey replaces the node key used to sign requests, it returns false if
+// the key didn't change
+func (s *httpSource) setKey(sk ed25519.PrivateKey) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bytes.Equal(s.sk, sk) {
+		return false
+	}
+
+	// the twin and node stay the same, only the key changes
+	s.sk = sk
+	return true
+}
+
+// SetSeed replaces the hex seed of the node identity used by the endpoints,
+// registrar clients are created again with the new seed on next use
+func (p *Endpoints) SetSeed(seed string) {
+	for _, e := range p.endpoints {
+		e.mu.Lock()
+		e.seed = seed
+		e.cl = nil
+		e.mu.Unlock()
+	}
+}
+
+func (r *registrarGateway) RotateTwinKey(rotation zos4Pkg.KeyRotation) zos4Pkg.RegistrarError {
+	log.Debug().
+		Str("method", "RotateTwinKey").
+		Uint64("twin_id", rotation.TwinID).
+		Msg("method called")
+
+	defer r.locks.lock(opAccount, "RotateTwinKey")()
+
+	// the rotation is not queued, identityd keeps it until it's accepted
+	defer r.invalidate("GetTwin", "GetTwinByPubKey")
+	return registrarError(r.api.RotateTwinKey(rotation))
+}
+
+// reloadKey switches the gateway to the node key sk
+func (r *registrarGateway) reloadKey(sk ed25519.PrivateKey) {
+	if !r.api.setKey(sk) {
+		return
+	}
+
+	r.registrar.SetSeed(hex.EncodeToString(sk.Seed()))
+	log.Info().Msg("registrar gateway reloaded the node key")
+}
+
+// watchRotations reloads the node key each time it's rotated. The key is
+// also reloaded each time the stream is opened again, since a rotation can
+// be completed while identityd restarts.
+func (r *registrarGateway) watchRotations(ctx context.Context, identity *stubs.IdentityManagerStub) {
+	for {
+		rotations, err := identity.KeyRotations(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to watch node key rotations")
+		} else {
+			r.reloadKey(identity.PrivateKey(ctx))
+			for range rotations {
+				r.reloadKey(identity.PrivateKey(ctx))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package registrargw
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/identity"
+)
+
+func TestRotateTwinKey(t *testing.T) {
+	require := require.New(t)
+
+	_, gw, nodeID := registeredNode(t)
+	old := gw.api.key()
+	require.False(gw.UpdateNodeInventory(zos4Pkg.NodeInventory{}).IsError())
+
+	twin, rerr := gw.GetTwinByPubKey(old.Public().(ed25519.PublicKey))
+	require.False(rerr.IsError())
+
+	new := ed25519.NewKeyFromSeed([]byte(strings.Repeat("r", ed25519.SeedSize)))
+	rotation, err := identity.NewRotation(twin, old, new)
+	require.NoError(err)
+
+	// the rotation must be signed by the new key too
+	forged := rotation
+	forged.NewSignature = rotation.OldSignature
+	require.True(gw.RotateTwinKey(forged).IsCode(zos4Pkg.RegistrarCodeUnauthorized))
+
+	require.False(gw.RotateTwinKey(rotation).IsError())
+	// sending the rotation again is fine
+	require.False(gw.RotateTwinKey(rotation).IsError())
+
+	rotated, rerr := gw.GetTwinByPubKey(new.Public().(ed25519.PublicKey))
+	require.False(rerr.IsError())
+	require.Equal(twin, rotated)
+
+	// calls signed by the old key are rejected until the key is reloaded
+	require.True(gw.UpdateNodeInventory(zos4Pkg.NodeInventory{}).IsCode(zos4Pkg.RegistrarCodeUnauthorized))
+
+	gw.reloadKey(new)
+	require.False(gw.UpdateNodeInventory(zos4Pkg.NodeInventory{}).IsError())
+
+	node, rerr := gw.GetNode(nodeID)
+	require.False(rerr.IsError())
+	require.Equal(twin, node.TwinID)
+}
@@ -0,0 +1,173 @@
+package registrar
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zosbase/pkg"
+	"github.com/threefoldtech/zosbase/pkg/capacity"
+	"github.com/threefoldtech/zosbase/pkg/capacity/dmi"
+	"github.com/threefoldtech/zosbase/pkg/power"
+)
+
+const sysClassNet = "/sys/class/net"
+
+// GPUInventory returns the inventory of a gpu
+func GPUInventory(gpu capacity.PCI) zos4Pkg.GPUInventory {
+	inventory := zos4Pkg.GPUInventory{ShortID: gpu.ShortID()}
+	if vendor, device, ok := gpu.GetDevice(); ok {
+		inventory.Vendor = vendor.Name
+		inventory.Device = device.Name
+	}
+
+	return inventory
+}
+
+// NICsInventory returns the inventory of the physical network interfaces
+func NICsInventory(ctx context.Context) ([]zos4Pkg.NICInventory, error) {
+	return nicsInventory(ctx, sysClassNet, wolSupported)
+}
+
+func nicsInventory(ctx context.Context, root string, wol func(ctx context.Context, nic string) bool) ([]zos4Pkg.NICInventory, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list network interfaces")
+	}
+
+	var nics []zos4Pkg.NICInventory
+	for _, entry := range entries {
+		name := entry.Name()
+		// virtual interfaces (bridges, veths, tuns) have no device
+		if _, err := os.Stat(filepath.Join(root, name, "device")); err != nil {
+			continue
+		}
+
+		nic := zos4Pkg.NICInventory{
+			Name: name,
+			Mac:  readSys(filepath.Join(root, name, "address")),
+			WoL:  wol(ctx, name),
+		}
+
+		// speed is -1 or can't be read if the link is down
+		if speed, err := strconv.ParseInt(readSys(filepath.Join(root, name, "speed")), 10, 64); err == nil && speed > 0 {
+			nic.Speed = uint64(speed)
+		}
+
+		nics = append(nics, nic)
+	}
+
+	sort.Slice(nics, func(i, j int) bool {
+		return nics[i].Name < nics[j].Name
+	})
+
+	return nics, nil
+}
+
+func readSys(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// wolSupported checks if nic supports wake on lan with magic packet
+func wolSupported(ctx context.Context, nic string) bool {
+	support, err := power.ValueOfFlag(ctx, nic, power.SupportsWakeOn)
+	if err != nil {
+		if !errors.Is(err, power.ErrFlagNotFound) {
+			log.Debug().Err(err).Str("nic", nic).Msg("failed to detect support for wake on lan")
+		}
+		return false
+	}
+
+	return strings.Contains(support, string(power.MagicPacket))
+}
+
+// DisksInventory returns the inventory of the storage disks grouped by type.
+// Every disk is a storage pool.
+func DisksInventory(pools []pkg.PoolMetrics) []zos4Pkg.DiskInventory {
+	types := make(map[string]*zos4Pkg.DiskInventory)
+	for _, pool := range pools {
+		kind := string(pool.Type)
+		disks, ok := types[kind]
+		if !ok {
+			disks = &zos4Pkg.DiskInventory{Type: kind}
+			types[kind] = disks
+		}
+
+		disks.Count++
+		disks.Size += uint64(pool.Size)
+	}
+
+	result := make([]zos4Pkg.DiskInventory, 0, len(types))
+	for _, disks := range types {
+		result = append(result, *disks)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Type < result[j].Type
+	})
+
+	return result
+}
+
+// DMIInventory returns the dmi information of the section of type t (like
+// the baseboard or the system)
+func DMIInventory(d *dmi.DMI, t dmi.Type) zos4Pkg.DMIInventory {
+	for _, section := range d.Sections {
+		if section.Type != t || len(section.SubSections) == 0 {
+			continue
+		}
+
+		properties := section.SubSections[0].Properties
+		return zos4Pkg.DMIInventory{
+			Vendor:  properties["Manufacturer"].Val,
+			Product: properties["Product Name"].Val,
+			Version: properties["Version"].Val,
+			Serial:  properties["Serial Number"].Val,
+		}
+	}
+
+	return zos4Pkg.DMIInventory{}
+}
+
+// sameList compares lists where an empty list is the same as a nil list
+func sameList[T any](a, b []T) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// inventoryChanges returns the parts of the inventory that changed
+func inventoryChanges(current, real zos4Pkg.NodeInventory) []string {
+	var changes []string
+	if !sameList(current.GPUs, real.GPUs) {
+		changes = append(changes, "gpus")
+	}
+	if !sameList(current.NICs, real.NICs) {
+		changes = append(changes, "nics")
+	}
+	if !sameList(current.Disks, real.Disks) {
+		changes = append(changes, "disks")
+	}
+	if current.Board != real.Board {
+		changes = append(changes, "board")
+	}
+	if current.System != real.System {
+		changes = append(changes, "system")
+	}
+
+	return changes
+}
@@ -0,0 +1,149 @@
+package registrar
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zosbase/pkg"
+	"github.com/threefoldtech/zosbase/pkg/capacity/dmi"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes/zos"
+)
+
+func TestNodeChanges(t *testing.T) {
+	require := require.New(t)
+
+	real := client.Node{
+		FarmID:     1,
+		TwinID:     2,
+		Resources:  client.Resources{CRU: 4},
+		Interfaces: []client.Interface{{Name: "zos", Mac: "52:54:00:12:34:56", IPs: []string{}}},
+	}
+
+	// fields set by the registrar are ignored
+	onRegistrar := real
+	onRegistrar.NodeID = 10
+	onRegistrar.Online = true
+	onRegistrar.UptimeReports = []client.UptimeReport{{Uptime: 10}}
+	onRegistrar.Interfaces = []client.Interface{{Name: "zos", Mac: "52:54:00:12:34:56"}}
+	require.Empty(nodeChanges(onRegistrar, real))
+
+	onRegistrar.Resources.CRU = 8
+	onRegistrar.SerialNumber = "serial"
+	onRegistrar.Interfaces[0].IPs = []string{"10.0.0.1"}
+	require.Equal([]string{"resources", "interfaces", "serial_number"}, nodeChanges(onRegistrar, real))
+}
+
+func TestNodeInterfaces(t *testing.T) {
+	require := require.New(t)
+
+	ip := net.IPNet{IP: net.ParseIP("10.0.0.1"), Mask: net.CIDRMask(24, 32)}
+	zos := pkg.Interface{Name: "zos", Mac: "52:54:00:12:34:56", IPs: []net.IPNet{ip}}
+	nics := []zos4Pkg.NICInventory{
+		{Name: "eth0", Mac: "52:54:00:00:00:01", Speed: 1000, WoL: true},
+		{Name: "eth1", Mac: "52:54:00:00:00:02"},
+	}
+
+	interfaces := nodeInterfaces(zos, nics)
+	require.Equal([]client.Interface{
+		{Name: "zos", Mac: "52:54:00:12:34:56", IPs: []string{"10.0.0.1"}},
+		{Name: "eth0", Mac: "52:54:00:00:00:01", IPs: []string{}},
+		{Name: "eth1", Mac: "52:54:00:00:00:02", IPs: []string{}},
+	}, interfaces)
+
+	// a new physical interface is an update of the node
+	onRegistrar := client.Node{Interfaces: interfaces[:2]}
+	require.Equal([]string{"interfaces"}, nodeChanges(onRegistrar, client.Node{Interfaces: interfaces}))
+}
+
+func TestInventoryChanges(t *testing.T) {
+	require := require.New(t)
+
+	real := zos4Pkg.NodeInventory{
+		GPUs:  []zos4Pkg.GPUInventory{{ShortID: "0000:01:00.0/10de/1c82"}},
+		Disks: []zos4Pkg.DiskInventory{},
+		Board: zos4Pkg.DMIInventory{Vendor: "ASUSTeK"},
+	}
+
+	require.Equal([]string{"gpus", "board"}, inventoryChanges(zos4Pkg.NodeInventory{}, real))
+
+	current := real
+	current.Disks = nil
+	require.Empty(inventoryChanges(current, real))
+
+	current.NICs = []zos4Pkg.NICInventory{{Name: "eth0"}}
+	current.System.Serial = "serial"
+	require.Equal([]string{"nics", "system"}, inventoryChanges(current, real))
+}
+
+func TestDisksInventory(t *testing.T) {
+	disks := DisksInventory([]pkg.PoolMetrics{
+		{Name: "a", Type: zos.SSDDevice, Size: 100 * gridtypes.Gigabyte},
+		{Name: "b", Type: zos.HDDDevice, Size: 1000 * gridtypes.Gigabyte},
+		{Name: "c", Type: zos.SSDDevice, Size: 200 * gridtypes.Gigabyte},
+	})
+
+	require.Equal(t, []zos4Pkg.DiskInventory{
+		{Type: string(zos.HDDDevice), Count: 1, Size: uint64(1000 * gridtypes.Gigabyte)},
+		{Type: string(zos.SSDDevice), Count: 2, Size: uint64(300 * gridtypes.Gigabyte)},
+	}, disks)
+
+	require.Empty(t, DisksInventory(nil))
+}
+
+func TestNICsInventory(t *testing.T) {
+	require := require.New(t)
+
+	root := t.TempDir()
+	nic := func(name, mac, speed string, physical bool) {
+		dir := filepath.Join(root, name)
+		require.NoError(os.MkdirAll(dir, 0755))
+		require.NoError(os.WriteFile(filepath.Join(dir, "address"), []byte(mac+"\n"), 0644))
+		require.NoError(os.WriteFile(filepath.Join(dir, "speed"), []byte(speed+"\n"), 0644))
+		if physical {
+			require.NoError(os.Mkdir(filepath.Join(dir, "device"), 0755))
+		}
+	}
+
+	nic("eth1", "52:54:00:00:00:02", "-1", true)
+	nic("eth0", "52:54:00:00:00:01", "1000", true)
+	nic("zos", "52:54:00:00:00:03", "10000", false)
+
+	nics, err := nicsInventory(context.Background(), root, func(_ context.Context, nic string) bool {
+		return nic == "eth0"
+	})
+	require.NoError(err)
+	require.Equal([]zos4Pkg.NICInventory{
+		{Name: "eth0", Mac: "52:54:00:00:00:01", Speed: 1000, WoL: true},
+		{Name: "eth1", Mac: "52:54:00:00:00:02"},
+	}, nics)
+}
+
+func TestDMIInventory(t *testing.T) {
+	d := &dmi.DMI{Sections: []dmi.Section{
+		{Type: dmi.TypeBIOS, SubSections: []dmi.SubSection{{Title: "BIOS Information"}}},
+		{Type: dmi.TypeBaseboard, SubSections: []dmi.SubSection{{
+			Title: "Base Board Information",
+			Properties: map[string]dmi.PropertyData{
+				"Manufacturer":  {Val: "ASUSTeK COMPUTER INC."},
+				"Product Name":  {Val: "PRIME B450M-A"},
+				"Version":       {Val: "Rev X.0x"},
+				"Serial Number": {Val: "180923123456789"},
+			},
+		}}},
+	}}
+
+	require.Equal(t, zos4Pkg.DMIInventory{
+		Vendor:  "ASUSTeK COMPUTER INC.",
+		Product: "PRIME B450M-A",
+		Version: "Rev X.0x",
+		Serial:  "180923123456789",
+	}, DMIInventory(d, dmi.TypeBaseboard))
+	require.Equal(t, zos4Pkg.DMIInventory{}, DMIInventory(d, dmi.TypeSystem))
+}
@@ -0,0 +1,159 @@
+package registrar
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zosbase/pkg/geoip"
+	"github.com/threefoldtech/zosbase/pkg/kernel"
+)
+
+// The node location can be set by the farmer in the boot configuration, for example
+//
+//	zos-location-latitude=51.05 zos-location-longitude=3.71 zos-location-city=Ghent zos-location-country=Belgium
+//
+// latitude and longitude are required, `_` in the city and country are
+// replaced with spaces (like New_York).
+const (
+	// ParamLatitude kernel param with the node latitude
+	ParamLatitude = "zos-location-latitude"
+	// ParamLongitude kernel param with the node longitude
+	ParamLongitude = "zos-location-longitude"
+	// ParamCity kernel param with the node city
+	ParamCity = "zos-location-city"
+	// ParamCountry kernel param with the node country
+	ParamCountry = "zos-location-country"
+
+	locationCache = "/var/cache/modules/registrar/location.json"
+	// locationMaxAge is the age of a cached location after which geoip
+	// is tried first. A stale location is still used if geoip fails.
+	locationMaxAge = 30 * 24 * time.Hour
+)
+
+// cachedLocation is the last known location of the node
+type cachedLocation struct {
+	Location geoip.Location         `json:"location"`
+	Source   zos4Pkg.LocationSource `json:"source"`
+	Updated  int64                  `json:"updated"`
+}
+
+// locator finds the node location, in order from the kernel params, a
+// fresh cached location, geoip, then a stale cached location.
+type locator struct {
+	params kernel.Params
+	cache  string
+	fetch  func() (geoip.Location, error)
+}
+
+func newLocator() *locator {
+	return &locator{
+		params: kernel.GetParams(),
+		cache:  locationCache,
+		fetch:  geoip.Fetch,
+	}
+}
+
+// locate returns the node location and where it was found, it never fails.
+// the location is zero if the source is unknown.
+func (l *locator) locate() (geoip.Location, zos4Pkg.LocationSource) {
+	if loc, ok, err := l.kernel(); err != nil {
+		log.Error().Err(err).Msg("invalid location kernel params, ignoring")
+	} else if ok {
+		l.store(loc, zos4Pkg.LocationSourceKernel)
+		return loc, zos4Pkg.LocationSourceKernel
+	}
+
+	cached, err := l.load()
+	if err != nil && !os.IsNotExist(err) {
+		log.Error().Err(err).Str("path", l.cache).Msg("failed to load cached location")
+	}
+	found := err == nil
+
+	// once the farmer removes the kernel params, the location they set is
+	// only used if geoip fails
+	fresh := found && cached.Source != zos4Pkg.LocationSourceKernel &&
+		time.Since(time.Unix(cached.Updated, 0)) < locationMaxAge
+	if fresh {
+		return cached.Location, zos4Pkg.LocationSourceCache
+	}
+
+	loc, err := l.fetch()
+	if err == nil {
+		l.store(loc, zos4Pkg.LocationSourceGeoIP)
+		return loc, zos4Pkg.LocationSourceGeoIP
+	}
+
+	log.Warn().Err(err).Msg("failed to fetch location from geoip")
+	if found {
+		return cached.Location, zos4Pkg.LocationSourceCache
+	}
+
+	return geoip.Location{}, zos4Pkg.LocationSourceUnknown
+}
+
+// kernel returns the location set in the kernel params
+func (l *locator) kernel() (loc geoip.Location, ok bool, err error) {
+	lat, latOk := l.params.GetOne(ParamLatitude)
+	long, longOk := l.params.GetOne(ParamLongitude)
+	if !latOk && !longOk {
+		return loc, false, nil
+	}
+
+	if !latOk || !longOk {
+		return loc, false, errors.Errorf("both %s and %s are required", ParamLatitude, ParamLongitude)
+	}
+
+	loc.Latitude, err = strconv.ParseFloat(lat, 64)
+	if err != nil || loc.Latitude < -90 || loc.Latitude > 90 {
+		return loc, false, errors.Errorf("invalid latitude '%s'", lat)
+	}
+
+	loc.Longitude, err = strconv.ParseFloat(long, 64)
+	if err != nil || loc.Longitude < -180 || loc.Longitude > 180 {
+		return loc, false, errors.Errorf("invalid longitude '%s'", long)
+	}
+
+	loc.City = l.name(ParamCity)
+	loc.Country = l.name(ParamCountry)
+	return loc, true, nil
+}
+
+func (l *locator) name(param string) string {
+	value, ok := l.params.GetOne(param)
+	if !ok {
+		return "Unknown"
+	}
+
+	return strings.ReplaceAll(value, "_", " ")
+}
+
+func (l *locator) load() (cached cachedLocation, err error) {
+	data, err := os.ReadFile(l.cache)
+	if err != nil {
+		return cached, err
+	}
+
+	return cached, errors.Wrap(json.Unmarshal(data, &cached), "invalid cached location")
+}
+
+// store caches the location as the last known location
+func (l *locator) store(loc geoip.Location, source zos4Pkg.LocationSource) {
+	data, err := json.Marshal(cachedLocation{Location: loc, Source: source, Updated: time.Now().Unix()})
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(l.cache), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(l.cache, data, 0644)
+	}
+
+	if err != nil {
+		log.Error().Err(err).Str("path", l.cache).Msg("failed to cache location")
+	}
+}
@@ -0,0 +1,117 @@
+package registrar
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zosbase/pkg/geoip"
+	"github.com/threefoldtech/zosbase/pkg/kernel"
+)
+
+func TestLocate(t *testing.T) {
+	require := require.New(t)
+
+	ghent := geoip.Location{Latitude: 51.05, Longitude: 3.71, City: "Ghent", Country: "Belgium"}
+	cairo := geoip.Location{Latitude: 30.04, Longitude: 31.23, City: "Cairo", Country: "Egypt"}
+
+	var geoipErr error
+	l := &locator{
+		cache: filepath.Join(t.TempDir(), "location.json"),
+		fetch: func() (geoip.Location, error) {
+			return cairo, geoipErr
+		},
+	}
+
+	// nothing is known while geoip is down
+	geoipErr = fmt.Errorf("geoip is down")
+	loc, source := l.locate()
+	require.Equal(zos4Pkg.LocationSourceUnknown, source)
+	require.Equal(geoip.Location{}, loc)
+
+	geoipErr = nil
+	loc, source = l.locate()
+	require.Equal(zos4Pkg.LocationSourceGeoIP, source)
+	require.Equal(cairo, loc)
+
+	// the last known location is used while it's fresh
+	geoipErr = fmt.Errorf("geoip is down")
+	loc, source = l.locate()
+	require.Equal(zos4Pkg.LocationSourceCache, source)
+	require.Equal(cairo, loc)
+
+	// kernel params win over everything
+	l.params = kernel.Params{
+		ParamLatitude:  {"51.05"},
+		ParamLongitude: {"3.71"},
+		ParamCity:      {"Ghent"},
+		ParamCountry:   {"Belgium"},
+	}
+	loc, source = l.locate()
+	require.Equal(zos4Pkg.LocationSourceKernel, source)
+	require.Equal(ghent, loc)
+
+	// once the params are removed geoip is used, the farmer location is
+	// only a fallback
+	l.params = nil
+	loc, source = l.locate()
+	require.Equal(zos4Pkg.LocationSourceCache, source)
+	require.Equal(ghent, loc)
+
+	geoipErr = nil
+	loc, source = l.locate()
+	require.Equal(zos4Pkg.LocationSourceGeoIP, source)
+	require.Equal(cairo, loc)
+
+	// a stale location is refreshed from geoip
+	data, err := json.Marshal(cachedLocation{
+		Location: ghent,
+		Source:   zos4Pkg.LocationSourceGeoIP,
+		Updated:  time.Now().Add(-locationMaxAge - time.Hour).Unix(),
+	})
+	require.NoError(err)
+	require.NoError(os.WriteFile(l.cache, data, 0644))
+
+	loc, source = l.locate()
+	require.Equal(zos4Pkg.LocationSourceGeoIP, source)
+	require.Equal(cairo, loc)
+}
+
+func TestLocateKernel(t *testing.T) {
+	cases := []struct {
+		params kernel.Params
+		ok     bool
+		err    bool
+		loc    geoip.Location
+	}{
+		{params: kernel.Params{}},
+		{
+			params: kernel.Params{ParamLatitude: {"40.71"}, ParamLongitude: {"-74.0"}, ParamCity: {"New_York"}},
+			ok:     true,
+			loc:    geoip.Location{Latitude: 40.71, Longitude: -74, City: "New York", Country: "Unknown"},
+		},
+		{params: kernel.Params{ParamLatitude: {"40.71"}}, err: true},
+		{params: kernel.Params{ParamLatitude: {"91"}, ParamLongitude: {"0"}}, err: true},
+		{params: kernel.Params{ParamLatitude: {"0"}, ParamLongitude: {"east"}}, err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprint(c.params), func(t *testing.T) {
+			l := &locator{params: c.params}
+			loc, ok, err := l.kernel()
+			if c.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, c.ok, ok)
+			require.Equal(t, c.loc, loc)
+		})
+	}
+}
@@ -2,16 +2,18 @@ package registrar
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
-	"reflect"
-	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
 	"github.com/threefoldtech/zbus"
+	zos4Pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/attestation"
 	zos4Stubs "github.com/threefoldtech/zos4/pkg/stubs"
+	"github.com/threefoldtech/zosbase/pkg"
 	"github.com/threefoldtech/zosbase/pkg/environment"
 	"github.com/threefoldtech/zosbase/pkg/geoip"
 	gridtypes "github.com/threefoldtech/zosbase/pkg/gridtypes"
@@ -19,21 +21,40 @@ import (
 )
 
 type RegistrationInfo struct {
-	Capacity     gridtypes.Capacity
-	Location     geoip.Location
-	SecureBoot   bool
-	Virtualized  bool
-	SerialNumber string
-	// List of gpus short name
-	GPUs map[string]interface{}
+	Capacity gridtypes.Capacity
+	Location geoip.Location
+	// LocationSource is where the location was found, the location on the
+	// registrar is kept if it's unknown
+	LocationSource zos4Pkg.LocationSource
+	SecureBoot     bool
+	Virtualized    bool
+	SerialNumber   string
+	// Inventory is the hardware inventory published on the registrar
+	Inventory zos4Pkg.NodeInventory
 }
 
-func (r RegistrationInfo) WithGPU(short string) RegistrationInfo {
-	if r.GPUs == nil {
-		r.GPUs = make(map[string]interface{})
-	}
+func (r RegistrationInfo) WithGPU(v zos4Pkg.GPUInventory) RegistrationInfo {
+	r.Inventory.GPUs = append(r.Inventory.GPUs, v)
+	return r
+}
+
+func (r RegistrationInfo) WithNICs(v []zos4Pkg.NICInventory) RegistrationInfo {
+	r.Inventory.NICs = v
+	return r
+}
+
+func (r RegistrationInfo) WithDisks(v []zos4Pkg.DiskInventory) RegistrationInfo {
+	r.Inventory.Disks = v
+	return r
+}
 
-	r.GPUs[short] = nil
+func (r RegistrationInfo) WithBoard(v zos4Pkg.DMIInventory) RegistrationInfo {
+	r.Inventory.Board = v
+	return r
+}
+
+func (r RegistrationInfo) WithSystem(v zos4Pkg.DMIInventory) RegistrationInfo {
+	r.Inventory.System = v
 	return r
 }
 
@@ -47,6 +68,11 @@ func (r RegistrationInfo) WithLocation(v geoip.Location) RegistrationInfo {
 	return r
 }
 
+func (r RegistrationInfo) WithLocationSource(v zos4Pkg.LocationSource) RegistrationInfo {
+	r.LocationSource = v
+	return r
+}
+
 func (r RegistrationInfo) WithSecureBoot(v bool) RegistrationInfo {
 	r.SecureBoot = v
 	return r
@@ -66,10 +92,12 @@ func (r *Registrar) registration(ctx context.Context, cl zbus.Client, env enviro
 	// we need to collect all node information here
 	// - we already have capacity
 	// - we get the location (will not change after initial registration)
-	loc, err := geoip.Fetch()
-	if err != nil {
-		return 0, 0, errors.Wrap(err, "fetch location")
-	}
+	r.step(zos4Pkg.RegistrationStepLocation)
+	loc, source := r.locator.locate()
+	log.Info().Str("source", string(source)).Str("country", loc.Country).Str("city", loc.City).Msg("node location")
+	r.update(func(s *zos4Pkg.RegistrationState) {
+		s.LocationSource = source
+	})
 
 	log.Debug().
 		Uint64("cru", info.Capacity.CRU).
@@ -78,9 +106,9 @@ func (r *Registrar) registration(ctx context.Context, cl zbus.Client, env enviro
 		Uint64("hru", uint64(info.Capacity.HRU)).
 		Msg("node capacity")
 
-	info = info.WithLocation(loc)
+	info = info.WithLocation(loc).WithLocationSource(source)
 
-	nodeID, twinID, err = registerNode(ctx, env, cl, info)
+	nodeID, twinID, err = registerNode(ctx, env, cl, info, r.step)
 	if err != nil {
 		return 0, 0, errors.Wrap(err, "failed to register node")
 	}
@@ -97,6 +125,7 @@ func registerNode(
 	env environment.Environment,
 	cl zbus.Client,
 	info RegistrationInfo,
+	step func(zos4Pkg.RegistrationStep),
 ) (nodeID, twinID uint64, err error) {
 	var (
 		mgr              = zos4Stubs.NewIdentityManagerStub(cl)
@@ -109,17 +138,7 @@ func registerNode(
 		return 0, 0, errors.Wrap(err, "failed to get zos bridge information")
 	}
 
-	interfaces := []client.Interface{{
-		Name: infs.Interfaces["zos"].Name,
-		Mac:  infs.Interfaces["zos"].Mac,
-		IPs: func() []string {
-			ips := make([]string, 0)
-			for _, ip := range infs.Interfaces["zos"].IPs {
-				ips = append(ips, ip.IP.String())
-			}
-			return ips
-		}(),
-	}}
+	interfaces := nodeInterfaces(infs.Interfaces["zos"], info.Inventory.NICs)
 
 	resources := client.Resources{
 		HRU: uint64(info.Capacity.HRU),
@@ -137,8 +156,9 @@ func registerNode(
 
 	log.Info().Str("id", mgr.NodeID(ctx).Identity()).Msg("start registration of the node on zos4 registrar")
 
-	account, err := registrarGateway.EnsureAccount(ctx, environment.MustGet().RelaysURLs, "")
-	if err != nil {
+	step(zos4Pkg.RegistrationStepAccount)
+	account, rerr := registrarGateway.EnsureAccount(ctx, environment.MustGet().RelaysURLs, "")
+	if err := rerr.Err(); err != nil {
 		log.Info().Msg("failed to EnsureAccount")
 		return 0, 0, errors.Wrap(err, "failed to ensure account")
 	}
@@ -157,26 +177,30 @@ func registerNode(
 		SerialNumber: serial,
 	}
 
+	step(zos4Pkg.RegistrationStepGetNode)
 	node, regErr := registrarGateway.GetNodeByTwinID(ctx, twinID)
 	nodeID = node.NodeID
-	if regErr != nil {
-		if strings.Contains(regErr.Error(), client.ErrorNodeNotFound.Error()) {
-			nodeID, err = registrarGateway.CreateNode(ctx, real)
-			if err != nil {
-				return 0, 0, errors.Wrap(err, "failed to create node on registrar")
-			}
-		} else {
-			return 0, 0, errors.Wrapf(regErr, "failed to get node information for twin id: %d", twinID)
+	if regErr.IsCode(zos4Pkg.RegistrarCodeNotFound) {
+		step(zos4Pkg.RegistrationStepCreateNode)
+		nodeID, rerr = registrarGateway.CreateNode(ctx, real)
+		if err := rerr.Err(); err != nil {
+			return 0, 0, errors.Wrap(err, "failed to create node on registrar")
 		}
+	} else if err := regErr.Err(); err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to get node information for twin id: %d", twinID)
 	}
 
 	// node exists
 	var onRegistrar client.Node
-	onRegistrar, err = registrarGateway.GetNode(ctx, nodeID)
-	if err != nil {
+	onRegistrar, rerr = registrarGateway.GetNode(ctx, nodeID)
+	if err := rerr.Err(); err != nil {
 		return 0, 0, errors.Wrapf(err, "failed to get node with id: %d", nodeID)
 	}
 
+	if info.LocationSource == zos4Pkg.LocationSourceUnknown {
+		real.Location = onRegistrar.Location
+	}
+
 	// ignore virt-what value if the node is marked as real on the registrar
 	if !onRegistrar.Virtualized {
 		real.Virtualized = false
@@ -186,15 +210,152 @@ func registerNode(
 
 	// node exists. we validate everything is good
 	// otherwise we update the node
+	step(zos4Pkg.RegistrationStepUpdateNode)
 	log.Debug().Uint64("node", nodeID).Msg("node already found on registrar")
 
-	if !reflect.DeepEqual(real, onRegistrar) {
-		log.Debug().Msgf("node data have changed, issuing an update node real: %+v\nonRegistrar: %+v", real, onRegistrar)
-		err := registrarGateway.UpdateNode(ctx, real)
-		if err != nil {
+	if changes := nodeChanges(onRegistrar, real); len(changes) != 0 {
+		log.Info().Strs("changes", changes).Msg("node data have changed, issuing an update node")
+		// a queued update is sent by the gateway once the registrar is back
+		if rerr := registrarGateway.UpdateNode(ctx, real); rerr.IsQueued() {
+			log.Warn().Uint64("node", nodeID).Msg("node update is queued")
+		} else if err := rerr.Err(); err != nil {
 			return 0, 0, errors.Wrapf(err, "failed to update node data with id: %d", nodeID)
 		}
 	}
 
+	// the inventory is an optional extension of the registrar, the physical
+	// interfaces are already published with the node. Failing to publish it
+	// does not fail the registration
+	step(zos4Pkg.RegistrationStepInventory)
+	if err := updateInventory(ctx, registrarGateway, nodeID, info.Inventory); err != nil {
+		log.Warn().Err(err).Uint64("node", nodeID).Msg("failed to publish node inventory")
+	}
+
+	// the registrar does not require an attestation yet, so nodes without
+	// a working tpm can still register
+	step(zos4Pkg.RegistrationStepAttestation)
+	if err := updateAttestation(ctx, mgr, registrarGateway); err != nil {
+		log.Error().Err(err).Uint64("node", nodeID).Msg("failed to publish node attestation")
+	}
+
 	return nodeID, twinID, err
 }
+
+// updateInventory sets the node inventory on the registrar if it has changed
+func updateInventory(ctx context.Context, registrarGateway *zos4Stubs.RegistrarGatewayStub, nodeID uint64, real zos4Pkg.NodeInventory) error {
+	current, rerr := registrarGateway.GetNodeInventory(ctx, nodeID)
+	if rerr.IsCode(zos4Pkg.RegistrarCodeNotFound) {
+		current = zos4Pkg.NodeInventory{}
+	} else if err := rerr.Err(); err != nil {
+		return errors.Wrap(err, "failed to get node inventory")
+	}
+
+	changes := inventoryChanges(current, real)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	log.Info().Strs("changes", changes).Msg("node inventory has changed, issuing an update")
+	if rerr := registrarGateway.UpdateNodeInventory(ctx, real); rerr.IsCode(zos4Pkg.RegistrarCodeNotFound) {
+		log.Info().Msg("registrar does not support node inventory, skipping")
+		return nil
+	} else if err := rerr.Err(); err != nil {
+		return errors.Wrap(err, "failed to update node inventory")
+	}
+
+	return nil
+}
+
+// updateAttestation publishes a fresh attestation of the node boot state
+// if the node has a tpm
+func updateAttestation(ctx context.Context, mgr *zos4Stubs.IdentityManagerStub, registrarGateway *zos4Stubs.RegistrarGatewayStub) error {
+	if !attestation.Available(ctx) {
+		log.Info().Msg("node has no tpm, skipping attestation")
+		return nil
+	}
+
+	// the real registrar may not issue challenges yet, the attestation is
+	// then published unverified
+	challenger := func(keys zos4Pkg.AttestationKeys) (zos4Pkg.AttestationChallenge, error) {
+		challenge, rerr := registrarGateway.GetAttestationChallenge(ctx, keys)
+		if rerr.IsCode(zos4Pkg.RegistrarCodeNotFound) {
+			return challenge, attestation.ErrNoChallenge
+		}
+		return challenge, rerr.Err()
+	}
+
+	sk := ed25519.PrivateKey(mgr.PrivateKey(ctx))
+	attested, err := attestation.Attest(ctx, attestation.NewTPM(), sk, challenger)
+	if err != nil {
+		return errors.Wrap(err, "failed to attest node")
+	}
+
+	log.Info().
+		Str("vendor", attested.Vendor).
+		Bool("emulated", attested.Emulated).
+		Bool("certified", attested.AKCertified).
+		Msg("node boot state attested")
+	return errors.Wrap(registrarGateway.UpdateNodeAttestation(ctx, attested).Err(), "failed to update node attestation")
+}
+
+// nodeInterfaces returns the interfaces published on the node: the zos
+// bridge with the node ips, followed by all physical interfaces. Speed and
+// wake on lan support are not part of the registrar interface, they are only
+// published in the inventory.
+func nodeInterfaces(zos pkg.Interface, nics []zos4Pkg.NICInventory) []client.Interface {
+	ips := make([]string, 0, len(zos.IPs))
+	for _, ip := range zos.IPs {
+		ips = append(ips, ip.IP.String())
+	}
+
+	interfaces := []client.Interface{{Name: zos.Name, Mac: zos.Mac, IPs: ips}}
+	for _, nic := range nics {
+		// physical interfaces are attached to bridges, so they have no ips
+		interfaces = append(interfaces, client.Interface{Name: nic.Name, Mac: nic.Mac, IPs: []string{}})
+	}
+
+	return interfaces
+}
+
+// nodeChanges returns the fields set by the node that differ from the node
+// on the registrar. Fields set by the registrar (like uptime) are ignored.
+func nodeChanges(onRegistrar, real client.Node) []string {
+	var changes []string
+	if onRegistrar.FarmID != real.FarmID {
+		changes = append(changes, "farm_id")
+	}
+	if onRegistrar.Location != real.Location {
+		changes = append(changes, "location")
+	}
+	if onRegistrar.Resources != real.Resources {
+		changes = append(changes, "resources")
+	}
+	if !sameInterfaces(onRegistrar.Interfaces, real.Interfaces) {
+		changes = append(changes, "interfaces")
+	}
+	if onRegistrar.SerialNumber != real.SerialNumber {
+		changes = append(changes, "serial_number")
+	}
+	if onRegistrar.SecureBoot != real.SecureBoot {
+		changes = append(changes, "secure_boot")
+	}
+	if onRegistrar.Virtualized != real.Virtualized {
+		changes = append(changes, "virtualized")
+	}
+
+	return changes
+}
+
+func sameInterfaces(a, b []client.Interface) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Mac != b[i].Mac || !sameList(a[i].IPs, b[i].IPs) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -10,22 +10,23 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/zbus"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/decommission"
 	zos4stubs "github.com/threefoldtech/zos4/pkg/stubs"
 	"github.com/threefoldtech/zosbase/pkg/app"
 	"github.com/threefoldtech/zosbase/pkg/environment"
 	"github.com/threefoldtech/zosbase/pkg/stubs"
 )
 
-// should any of this be moved to pkg?
-type RegistrationState string
-
 const (
-	Failed     RegistrationState = "Failed"
-	InProgress RegistrationState = "InProgress"
-	Done       RegistrationState = "Done"
-
 	monitorAccountEvery    = 30 * time.Minute
 	updateNodeInfoInterval = 24 * time.Hour
+
+	// historySize is the number of registration attempts that are kept
+	historySize = 20
+	// stateBuffer is the number of state transitions a slow subscriber
+	// can lag behind before transitions are dropped
+	stateBuffer = 16
 )
 
 var (
@@ -33,66 +34,122 @@ var (
 	ErrFailed     = errors.New("registration failed")
 )
 
-type State struct {
-	NodeID uint32
-	TwinID uint32
-	State  RegistrationState
-	Msg    string
+type Registrar struct {
+	mutex       sync.RWMutex
+	state       zos4pkg.RegistrationState
+	history     []zos4pkg.RegistrationAttempt
+	started     time.Time
+	subscribers map[chan zos4pkg.RegistrationState]struct{}
+	locator     *locator
 }
 
-func FailedState(err error) State {
-	return State{
-		0,
-		0,
-		Failed,
-		err.Error(),
-	}
+var _ zos4pkg.Registrar = (*Registrar)(nil)
+
+func NewRegistrar(ctx context.Context, cl zbus.Client, env environment.Environment, info RegistrationInfo) *Registrar {
+	r := newRegistrar()
+	go r.register(ctx, cl, env, info)
+	return r
 }
 
-func InProgressState() State {
-	return State{
-		0,
-		0,
-		InProgress,
-		"",
+func newRegistrar() *Registrar {
+	return &Registrar{
+		state: zos4pkg.RegistrationState{
+			Status:  zos4pkg.RegistrationInProgress,
+			Step:    zos4pkg.RegistrationStepChecks,
+			Changed: time.Now().Unix(),
+		},
+		subscribers: make(map[chan zos4pkg.RegistrationState]struct{}),
+		locator:     newLocator(),
 	}
 }
 
-func DoneState(nodeID uint32, twinID uint32) State {
-	return State{
-		nodeID,
-		twinID,
-		Done,
-		"",
+// update changes the state with fn and sends the new state to subscribers
+func (r *Registrar) update(fn func(s *zos4pkg.RegistrationState)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	fn(&r.state)
+	r.state.Changed = time.Now().Unix()
+
+	for ch := range r.subscribers {
+		select {
+		case ch <- r.state:
+		default:
+			log.Warn().Msg("registration state subscriber is lagging, dropping state transition")
+		}
 	}
 }
 
-type Registrar struct {
-	state State
-	mutex sync.RWMutex
+// start marks the start of a registration attempt. A registered node stays
+// registered while it's registered again.
+func (r *Registrar) start() {
+	r.mutex.Lock()
+	r.started = time.Now()
+	r.mutex.Unlock()
+
+	r.update(func(s *zos4pkg.RegistrationState) {
+		if s.Status != zos4pkg.RegistrationDone {
+			s.Status = zos4pkg.RegistrationInProgress
+		}
+		s.Step = zos4pkg.RegistrationStepChecks
+		s.Error = ""
+		s.NextRetry = 0
+	})
 }
 
-func NewRegistrar(ctx context.Context, cl zbus.Client, env environment.Environment, info RegistrationInfo) *Registrar {
-	r := Registrar{
-		State{
-			0,
-			0,
-			InProgress,
-			"",
-		},
-		sync.RWMutex{},
-	}
-	go r.register(ctx, cl, env, info)
-	return &r
+// step sets the step of the current attempt
+func (r *Registrar) step(step zos4pkg.RegistrationStep) {
+	r.update(func(s *zos4pkg.RegistrationState) {
+		s.Step = step
+	})
 }
 
-func (r *Registrar) setState(s State) {
+// finish ends the current attempt with err
+func (r *Registrar) finish(err error) {
 	r.mutex.Lock()
-	defer r.mutex.Unlock()
-	r.state = s
+	attempt := zos4pkg.RegistrationAttempt{
+		Started: r.started.Unix(),
+		Ended:   time.Now().Unix(),
+		Step:    r.state.Step,
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	r.history = append(r.history, attempt)
+	if len(r.history) > historySize {
+		r.history = r.history[len(r.history)-historySize:]
+	}
+	r.mutex.Unlock()
+
+	if err != nil {
+		r.fail(err)
+	}
+}
+
+func (r *Registrar) fail(err error) {
+	r.update(func(s *zos4pkg.RegistrationState) {
+		s.Status = zos4pkg.RegistrationFailed
+		s.Error = err.Error()
+	})
+}
+
+func (r *Registrar) done(nodeID, twinID uint32) {
+	r.update(func(s *zos4pkg.RegistrationState) {
+		s.Status = zos4pkg.RegistrationDone
+		s.NodeID = nodeID
+		s.TwinID = twinID
+	})
 }
 
-func (r *Registrar) getState() State {
+// retry sets the time of the next attempt after a failure
+func (r *Registrar) retry(d time.Duration) {
+	r.update(func(s *zos4pkg.RegistrationState) {
+		s.NextRetry = time.Now().Add(d).Unix()
+	})
+}
+
+func (r *Registrar) getState() zos4pkg.RegistrationState {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 	return r.state
@@ -101,12 +158,13 @@ func (r *Registrar) getState() State {
 // register a node and then blocks forever watching the node account. It tries to re-activate the
 // account if needed
 func (r *Registrar) register(ctx context.Context, cl zbus.Client, env environment.Environment, info RegistrationInfo) {
+	r.start()
 	if app.CheckFlag(app.LimitedCache) {
-		r.setState(FailedState(errors.New("no disks")))
+		r.finish(errors.New("no disks"))
 		return
 	}
 	if _, err := os.Stat("/dev/kvm"); err != nil {
-		r.setState(FailedState(errors.New("virtualization is not enabled. please enable in BIOS")))
+		r.finish(errors.New("virtualization is not enabled. please enable in BIOS"))
 		return
 	}
 
@@ -115,16 +173,25 @@ func (r *Registrar) register(ctx context.Context, cl zbus.Client, env environmen
 	exp.MaxElapsedTime = 0 // retry indefinitely
 	bo := backoff.WithContext(exp, ctx)
 	register := func() {
+		if decommission.DecommissionedNode(ctx, zos4stubs.NewIdentityManagerStub(cl)) {
+			log.Info().Msg("node is decommissioned, skipping registration")
+			return
+		}
+
 		err := backoff.RetryNotify(func() error {
+			r.start()
 			nodeID, twinID, err := r.registration(ctx, cl, env, info)
+			r.finish(err)
 			if err != nil {
-				r.setState(FailedState(err))
 				return err
-			} else {
-				r.setState(DoneState(uint32(nodeID), uint32(twinID)))
 			}
+
+			r.done(uint32(nodeID), uint32(twinID))
 			return nil
-		}, bo, retryNotify)
+		}, bo, func(err error, d time.Duration) {
+			retryNotify(err, d)
+			r.retry(d)
+		})
 		if err != nil {
 			// this should never happen because we retry indefinitely
 			log.Error().Err(err).Msg("registration failed")
@@ -144,7 +211,11 @@ func (r *Registrar) register(ctx context.Context, cl zbus.Client, env environmen
 	for {
 		select {
 		case <-ctx.Done():
+			return
 		case <-time.After(monitorAccountEvery):
+			if decommission.DecommissionedNode(ctx, zos4stubs.NewIdentityManagerStub(cl)) {
+				continue
+			}
 			if err := r.reActivate(ctx, cl); err != nil {
 				log.Error().Err(err).Msg("failed to reactivate account")
 			}
@@ -160,26 +231,62 @@ func (r *Registrar) register(ctx context.Context, cl zbus.Client, env environmen
 
 func (r *Registrar) reActivate(ctx context.Context, cl zbus.Client) error {
 	registrarGateway := zos4stubs.NewRegistrarGatewayStub(cl)
-	_, err := registrarGateway.EnsureAccount(ctx, environment.MustGet().RelaysURLs, "")
+	_, rerr := registrarGateway.EnsureAccount(ctx, environment.MustGet().RelaysURLs, "")
 
-	return err
+	return rerr.Err()
 }
 
 func (r *Registrar) NodeID() (uint32, error) {
-	return r.returnIfDone(r.getState().NodeID)
+	state := r.getState()
+	return returnIfDone(state, state.NodeID)
 }
 
 func (r *Registrar) TwinID() (uint32, error) {
-	return r.returnIfDone(r.getState().TwinID)
+	state := r.getState()
+	return returnIfDone(state, state.TwinID)
+}
+
+func (r *Registrar) CurrentState() zos4pkg.RegistrationState {
+	return r.getState()
+}
+
+func (r *Registrar) History() []zos4pkg.RegistrationAttempt {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	history := make([]zos4pkg.RegistrationAttempt, len(r.history))
+	copy(history, r.history)
+	return history
+}
+
+// State streams state transitions, starting with the current state
+func (r *Registrar) State(ctx context.Context) <-chan zos4pkg.RegistrationState {
+	ch := make(chan zos4pkg.RegistrationState, stateBuffer)
+
+	r.mutex.Lock()
+	r.subscribers[ch] = struct{}{}
+	ch <- r.state
+	r.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		delete(r.subscribers, ch)
+		close(ch)
+	}()
+
+	return ch
 }
 
-func (r *Registrar) returnIfDone(v uint32) (uint32, error) {
-	if r.state.State == Failed {
-		return 0, errors.Wrap(ErrFailed, r.state.Msg)
-	} else if r.state.State == Done {
+func returnIfDone(state zos4pkg.RegistrationState, v uint32) (uint32, error) {
+	switch state.Status {
+	case zos4pkg.RegistrationFailed:
+		return 0, errors.Wrap(ErrFailed, state.Error)
+	case zos4pkg.RegistrationDone:
 		return v, nil
-	} else {
-		// InProgress
+	default:
 		return 0, ErrInProgress
 	}
 }
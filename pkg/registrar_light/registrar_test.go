@@ -0,0 +1,80 @@
+package registrar
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+func TestRegistrarState(t *testing.T) {
+	require := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := newRegistrar()
+	states := r.State(ctx)
+	require.Equal(zos4pkg.RegistrationInProgress, (<-states).Status)
+
+	_, err := r.NodeID()
+	require.ErrorIs(err, ErrInProgress)
+
+	r.start()
+	r.step(zos4pkg.RegistrationStepAccount)
+	r.finish(fmt.Errorf("registrar is down"))
+	r.retry(time.Minute)
+
+	require.Equal(zos4pkg.RegistrationStepChecks, (<-states).Step)
+	require.Equal(zos4pkg.RegistrationStepAccount, (<-states).Step)
+	failed := <-states
+	require.Equal(zos4pkg.RegistrationFailed, failed.Status)
+	require.Equal(zos4pkg.RegistrationStepAccount, failed.Step)
+	require.Equal("registrar is down", failed.Error)
+	require.NotZero((<-states).NextRetry)
+
+	_, err = r.NodeID()
+	require.ErrorIs(err, ErrFailed)
+
+	r.start()
+	require.Equal(zos4pkg.RegistrationInProgress, (<-states).Status)
+	r.step(zos4pkg.RegistrationStepCreateNode)
+	r.finish(nil)
+	r.done(10, 20)
+
+	<-states
+	done := <-states
+	require.Equal(zos4pkg.RegistrationDone, done.Status)
+	require.Empty(done.Error)
+	require.Zero(done.NextRetry)
+
+	node, err := r.NodeID()
+	require.NoError(err)
+	require.EqualValues(10, node)
+	twin, err := r.TwinID()
+	require.NoError(err)
+	require.EqualValues(20, twin)
+
+	// a registered node stays registered while it's registered again
+	r.start()
+	require.Equal(zos4pkg.RegistrationDone, r.CurrentState().Status)
+
+	history := r.History()
+	require.Len(history, 2)
+	require.Equal("registrar is down", history[0].Error)
+	require.Equal(zos4pkg.RegistrationStepAccount, history[0].Step)
+	require.Empty(history[1].Error)
+	require.Equal(zos4pkg.RegistrationStepCreateNode, history[1].Step)
+
+	for i := 0; i < historySize; i++ {
+		r.finish(nil)
+	}
+	require.Len(r.History(), historySize)
+
+	cancel()
+	for range states {
+	}
+}
@@ -0,0 +1,125 @@
+package reservation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+	"github.com/threefoldtech/zosbase/pkg/provision"
+)
+
+var _ provision.Provisioner = (*Guard)(nil)
+
+// Guard is a provisioner interceptor that enforces the reservation policy on
+// cpu, ssd and hdd. The statistics oracle only checks memory, so the guard
+// makes sure the capacity used by workloads plus the reserved capacity never
+// goes beyond the capacity offered to workloads.
+type Guard struct {
+	inner    provision.Provisioner
+	total    gridtypes.Capacity
+	storage  provision.Storage
+	reserved func() (gridtypes.Capacity, error)
+}
+
+// NewGuard creates a new guard. total is the capacity offered to workloads
+// (see Policy.Total) and reserved returns the current reserved capacity
+// (see Policy.Reserved).
+func NewGuard(total gridtypes.Capacity, storage provision.Storage, reserved func() (gridtypes.Capacity, error), inner provision.Provisioner) *Guard {
+	return &Guard{
+		inner:    inner,
+		total:    total,
+		storage:  storage,
+		reserved: reserved,
+	}
+}
+
+// check validates that the workload fits in the offered capacity next to all
+// other workloads and the reserved capacity
+func (g *Guard) check(wl *gridtypes.WorkloadWithID) error {
+	required, err := wl.Capacity()
+	if err != nil {
+		return errors.Wrap(err, "failed to calculate workload needed capacity")
+	}
+
+	// used by all workloads excluding this one, so updates are not counted twice
+	used, err := g.storage.Capacity(func(dl *gridtypes.Deployment, w *gridtypes.Workload) bool {
+		id, _ := gridtypes.NewWorkloadID(dl.TwinID, dl.ContractID, w.Name)
+		return id == wl.ID
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to get used capacity")
+	}
+
+	reserved, err := g.reserved()
+	if err != nil {
+		return errors.Wrap(err, "failed to get reserved capacity")
+	}
+
+	for _, r := range []struct {
+		name                            string
+		required, used, reserved, total uint64
+	}{
+		{"cpu cores", required.CRU, used.Cap.CRU, reserved.CRU, g.total.CRU},
+		{"ssd bytes", uint64(required.SRU), uint64(used.Cap.SRU), uint64(reserved.SRU), uint64(g.total.SRU)},
+		{"hdd bytes", uint64(required.HRU), uint64(used.Cap.HRU), uint64(reserved.HRU), uint64(g.total.HRU)},
+	} {
+		if r.required == 0 {
+			continue
+		}
+
+		var usable uint64
+		if taken := r.used + r.reserved; taken < r.total {
+			usable = r.total - taken
+		}
+
+		if r.required > usable {
+			return fmt.Errorf("cannot fulfil required %d %s out of usable %d", r.required, r.name, usable)
+		}
+	}
+
+	return nil
+}
+
+// Initialize implements the provisioner interface
+func (g *Guard) Initialize(ctx context.Context) error {
+	return g.inner.Initialize(ctx)
+}
+
+// Provision implements the provisioner interface
+func (g *Guard) Provision(ctx context.Context, wl *gridtypes.WorkloadWithID) (gridtypes.Result, error) {
+	if err := g.check(wl); err != nil {
+		return gridtypes.Result{}, errors.Wrap(err, "failed to satisfy required capacity")
+	}
+
+	return g.inner.Provision(ctx, wl)
+}
+
+// Update implements the provisioner interface
+func (g *Guard) Update(ctx context.Context, wl *gridtypes.WorkloadWithID) (gridtypes.Result, error) {
+	if err := g.check(wl); err != nil {
+		return gridtypes.Result{}, errors.Wrap(err, "failed to satisfy required capacity")
+	}
+
+	return g.inner.Update(ctx, wl)
+}
+
+// Deprovision implements the provisioner interface
+func (g *Guard) Deprovision(ctx context.Context, wl *gridtypes.WorkloadWithID) error {
+	return g.inner.Deprovision(ctx, wl)
+}
+
+// Pause implements the provisioner interface
+func (g *Guard) Pause(ctx context.Context, wl *gridtypes.WorkloadWithID) (gridtypes.Result, error) {
+	return g.inner.Pause(ctx, wl)
+}
+
+// Resume implements the provisioner interface
+func (g *Guard) Resume(ctx context.Context, wl *gridtypes.WorkloadWithID) (gridtypes.Result, error) {
+	return g.inner.Resume(ctx, wl)
+}
+
+// CanUpdate implements the provisioner interface
+func (g *Guard) CanUpdate(ctx context.Context, typ gridtypes.WorkloadType) bool {
+	return g.inner.CanUpdate(ctx, typ)
+}
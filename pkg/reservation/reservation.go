@@ -0,0 +1,243 @@
+// Package reservation implements the host reservation policy. The policy
+// defines how much of the node capacity is kept for the system and never
+// offered to workloads.
+//
+// The policy is set from the farm boot configuration as kernel params, for example
+//
+//	zos-reserve-mru=10%:2G zos-reserve-cru=1 zos-sru-headroom=20G zos-cpu-overcommit=2
+//
+// reserves 10% of the memory but at least 2 GiB, a single core, keeps 20 GiB
+// of ssd free on top of the cache disk usage and offers twice the number of
+// physical cores. A resource rule is a percentage, an absolute floor, or both
+// separated by `:`. Reserved amount is the max of both and never more than
+// the total.
+package reservation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+	"github.com/threefoldtech/zosbase/pkg/kernel"
+)
+
+const (
+	// ParamCRU reservation rule for cpu cores
+	ParamCRU = "zos-reserve-cru"
+	// ParamMRU reservation rule for memory
+	ParamMRU = "zos-reserve-mru"
+	// ParamSRU reservation rule for ssd storage
+	ParamSRU = "zos-reserve-sru"
+	// ParamHRU reservation rule for hdd storage
+	ParamHRU = "zos-reserve-hru"
+	// ParamOvercommit cpu overcommit ratio
+	ParamOvercommit = "zos-cpu-overcommit"
+	// ParamHeadroom ssd headroom kept free on top of the cache disk usage
+	ParamHeadroom = "zos-sru-headroom"
+
+	maxOvercommit = 16
+)
+
+// Rule is the reservation rule of a single resource
+type Rule struct {
+	// Percent of the total to reserve
+	Percent uint64 `json:"percent"`
+	// Floor is the min amount to reserve (in bytes, or cores for cru)
+	Floor uint64 `json:"floor"`
+}
+
+// Apply returns the reserved amount out of total
+func (r Rule) Apply(total uint64) uint64 {
+	reserved := total * r.Percent / 100
+	if reserved < r.Floor {
+		reserved = r.Floor
+	}
+
+	if reserved > total {
+		reserved = total
+	}
+
+	return reserved
+}
+
+// Policy is the host reservation policy
+type Policy struct {
+	CRU Rule `json:"cru"`
+	MRU Rule `json:"mru"`
+	SRU Rule `json:"sru"`
+	HRU Rule `json:"hru"`
+	// Overcommit is the cpu overcommit ratio, 1 means no overcommit
+	Overcommit float64 `json:"overcommit"`
+	// Headroom is ssd space kept free on top of the cache disk usage
+	Headroom gridtypes.Unit `json:"headroom"`
+}
+
+// Default returns the default policy, it reserves 10% of the
+// memory but at least 2 GiB.
+func Default() Policy {
+	return Policy{
+		MRU:        Rule{Percent: 10, Floor: uint64(2 * gridtypes.Gigabyte)},
+		Overcommit: 1,
+	}
+}
+
+// Total returns the capacity offered to workloads out of the physical
+// capacity. Only cru is affected by the overcommit ratio. The node is still
+// registered with its physical capacity.
+func (p *Policy) Total(physical gridtypes.Capacity) gridtypes.Capacity {
+	total := physical
+	total.CRU = uint64(float64(physical.CRU) * p.Overcommit)
+	return total
+}
+
+// Reserved returns the capacity reserved for the system given the node physical
+// capacity and the current cache disk usage. The cru reservation is taken from
+// the physical cores, not the overcommitted ones.
+func (p *Policy) Reserved(physical gridtypes.Capacity, cache gridtypes.Unit) gridtypes.Capacity {
+	sru := gridtypes.Unit(p.SRU.Apply(uint64(physical.SRU))) + cache + p.Headroom
+
+	return gridtypes.Capacity{
+		CRU: p.CRU.Apply(physical.CRU),
+		MRU: gridtypes.Unit(p.MRU.Apply(uint64(physical.MRU))),
+		SRU: gridtypes.Min(sru, physical.SRU),
+		HRU: gridtypes.Unit(p.HRU.Apply(uint64(physical.HRU))),
+	}
+}
+
+// ParseSize parses a size like 512M, 2G or 1T (binary units). A plain
+// number is taken as is.
+func ParseSize(s string) (uint64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	unit := uint64(1)
+	if len(s) > 0 {
+		switch s[len(s)-1] {
+		case 'K':
+			unit = uint64(gridtypes.Kilobyte)
+		case 'M':
+			unit = uint64(gridtypes.Megabyte)
+		case 'G':
+			unit = uint64(gridtypes.Gigabyte)
+		case 'T':
+			unit = uint64(gridtypes.Terabyte)
+		}
+	}
+
+	if unit != 1 {
+		s = s[:len(s)-1]
+	}
+
+	value, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+
+	return value * unit, nil
+}
+
+// ParseRule parses a rule in the form `<percent>%`, `<floor>` or `<percent>%:<floor>`
+func ParseRule(spec string) (Rule, error) {
+	var rule Rule
+	for _, part := range strings.Split(spec, ":") {
+		part = strings.TrimSpace(part)
+		if pct, ok := strings.CutSuffix(part, "%"); ok {
+			value, err := strconv.ParseUint(pct, 10, 64)
+			if err != nil || value > 100 {
+				return rule, fmt.Errorf("invalid percentage '%s' in rule '%s'", part, spec)
+			}
+			rule.Percent = value
+			continue
+		}
+
+		value, err := ParseSize(part)
+		if err != nil {
+			return rule, errors.Wrapf(err, "invalid rule '%s'", spec)
+		}
+		rule.Floor = value
+	}
+
+	return rule, nil
+}
+
+// String returns a human readable form of the rule. Floor is formatted
+// as a size unless cores is set.
+func (r Rule) String(cores bool) string {
+	floor := fmt.Sprintf("%.2f GB", float64(r.Floor)/float64(gridtypes.Gigabyte))
+	if cores {
+		floor = fmt.Sprint(r.Floor)
+	}
+
+	switch {
+	case r.Percent == 0 && r.Floor == 0:
+		return "none"
+	case r.Floor == 0:
+		return fmt.Sprintf("%d%%", r.Percent)
+	case r.Percent == 0:
+		return floor
+	default:
+		return fmt.Sprintf("%d%% (min %s)", r.Percent, floor)
+	}
+}
+
+// FromParams builds the policy from kernel params. Missing params keep
+// their default value, invalid ones are reported in the returned error
+// and the default is used instead.
+func FromParams(params kernel.Params) (Policy, error) {
+	policy := Default()
+
+	var invalid []string
+	for _, r := range []struct {
+		param string
+		rule  *Rule
+	}{
+		{ParamCRU, &policy.CRU},
+		{ParamMRU, &policy.MRU},
+		{ParamSRU, &policy.SRU},
+		{ParamHRU, &policy.HRU},
+	} {
+		param, rule := r.param, r.rule
+		spec, ok := params.GetOne(param)
+		if !ok {
+			continue
+		}
+
+		parsed, err := ParseRule(spec)
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %s", param, err))
+			continue
+		}
+		*rule = parsed
+	}
+
+	if spec, ok := params.GetOne(ParamOvercommit); ok {
+		ratio, err := strconv.ParseFloat(spec, 64)
+		if err != nil || ratio < 1 || ratio > maxOvercommit {
+			invalid = append(invalid, fmt.Sprintf("%s: ratio must be between 1 and %d", ParamOvercommit, maxOvercommit))
+		} else {
+			policy.Overcommit = ratio
+		}
+	}
+
+	if spec, ok := params.GetOne(ParamHeadroom); ok {
+		headroom, err := ParseSize(spec)
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %s", ParamHeadroom, err))
+		} else {
+			policy.Headroom = gridtypes.Unit(headroom)
+		}
+	}
+
+	if len(invalid) > 0 {
+		return policy, fmt.Errorf("invalid reservation policy: %s", strings.Join(invalid, ", "))
+	}
+
+	return policy, nil
+}
+
+// Load the reservation policy from the node kernel params
+func Load() (Policy, error) {
+	return FromParams(kernel.GetParams())
+}
@@ -0,0 +1,131 @@
+package reservation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes"
+	"github.com/threefoldtech/zosbase/pkg/gridtypes/zos"
+	"github.com/threefoldtech/zosbase/pkg/kernel"
+	"github.com/threefoldtech/zosbase/pkg/provision"
+)
+
+func TestParseRule(t *testing.T) {
+	for spec, expected := range map[string]Rule{
+		"10%":      {Percent: 10},
+		"2G":       {Floor: uint64(2 * gridtypes.Gigabyte)},
+		"10%:2GiB": {Percent: 10, Floor: uint64(2 * gridtypes.Gigabyte)},
+		"512M:5%":  {Percent: 5, Floor: uint64(512 * gridtypes.Megabyte)},
+		"2":        {Floor: 2},
+	} {
+		rule, err := ParseRule(spec)
+		require.NoError(t, err, spec)
+		require.Equal(t, expected, rule, spec)
+	}
+
+	for _, spec := range []string{"", "101%", "-1%", "2X", "10%:"} {
+		_, err := ParseRule(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestPolicyReserved(t *testing.T) {
+	require := require.New(t)
+
+	physical := gridtypes.Capacity{
+		CRU: 8,
+		MRU: 64 * gridtypes.Gigabyte,
+		SRU: 100 * gridtypes.Gigabyte,
+		HRU: 1000 * gridtypes.Gigabyte,
+	}
+
+	policy := Default()
+	reserved := policy.Reserved(physical, 5*gridtypes.Gigabyte)
+	require.Equal(gridtypes.Capacity{
+		MRU: 64 * gridtypes.Gigabyte / 10,
+		SRU: 5 * gridtypes.Gigabyte,
+	}, reserved)
+
+	// tiny node gets the floor
+	reserved = policy.Reserved(gridtypes.Capacity{MRU: 4 * gridtypes.Gigabyte}, 0)
+	require.Equal(2*gridtypes.Gigabyte, reserved.MRU)
+
+	policy, err := FromParams(kernel.Params{
+		ParamCRU:        {"1"},
+		ParamMRU:        {"5%:8G"},
+		ParamHRU:        {"1%"},
+		ParamHeadroom:   {"20G"},
+		ParamOvercommit: {"2"},
+	})
+	require.NoError(err)
+
+	reserved = policy.Reserved(physical, 5*gridtypes.Gigabyte)
+	require.Equal(gridtypes.Capacity{
+		CRU: 1,
+		MRU: 8 * gridtypes.Gigabyte,
+		SRU: 25 * gridtypes.Gigabyte,
+		HRU: 10 * gridtypes.Gigabyte,
+	}, reserved)
+	require.EqualValues(16, policy.Total(physical).CRU)
+
+	// reservation never exceeds the total
+	reserved = policy.Reserved(physical, 90*gridtypes.Gigabyte)
+	require.Equal(physical.SRU, reserved.SRU)
+
+	// invalid values fall back to defaults
+	policy, err = FromParams(kernel.Params{
+		ParamMRU:        {"200%"},
+		ParamOvercommit: {"0.5"},
+	})
+	require.Error(err)
+	require.Equal(Default(), policy)
+}
+
+type testStorage struct {
+	provision.Storage
+	used gridtypes.Capacity
+}
+
+func (s *testStorage) Capacity(exclude ...provision.Exclude) (provision.StorageCapacity, error) {
+	return provision.StorageCapacity{Cap: s.used}, nil
+}
+
+type testProvisioner struct {
+	provision.Provisioner
+}
+
+func (p *testProvisioner) Provision(ctx context.Context, wl *gridtypes.WorkloadWithID) (gridtypes.Result, error) {
+	return gridtypes.Result{State: gridtypes.StateOk}, nil
+}
+
+func TestGuard(t *testing.T) {
+	require := require.New(t)
+
+	total := gridtypes.Capacity{CRU: 8, SRU: 100 * gridtypes.Gigabyte}
+	storage := &testStorage{used: gridtypes.Capacity{SRU: 50 * gridtypes.Gigabyte}}
+	reserved := func() (gridtypes.Capacity, error) {
+		return gridtypes.Capacity{CRU: 1, SRU: 20 * gridtypes.Gigabyte}, nil
+	}
+
+	guard := NewGuard(total, storage, reserved, &testProvisioner{})
+
+	disk := func(size gridtypes.Unit) *gridtypes.WorkloadWithID {
+		id, _ := gridtypes.NewWorkloadID(1, 1, "disk")
+		return &gridtypes.WorkloadWithID{
+			ID: id,
+			Workload: &gridtypes.Workload{
+				Type: zos.ZMountType,
+				Name: "disk",
+				Data: gridtypes.MustMarshal(zos.ZMount{Size: size}),
+			},
+		}
+	}
+
+	// 30G are left after the used and reserved ssd
+	_, err := guard.Provision(context.Background(), disk(30*gridtypes.Gigabyte))
+	require.NoError(err)
+
+	_, err = guard.Provision(context.Background(), disk(31*gridtypes.Gigabyte))
+	require.Error(err)
+}
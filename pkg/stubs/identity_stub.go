@@ -145,6 +145,30 @@ func (s *IdentityManagerStub) FarmSecret(ctx context.Context) (ret0 string) {
 	return
 }
 
+func (s *IdentityManagerStub) KeyRotations(ctx context.Context) (<-chan pkg1.KeyRotation, error) {
+	ch := make(chan pkg1.KeyRotation, 1)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "KeyRotations")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg1.KeyRotation
+			if err := event.Unmarshal(&obj); err != nil {
+				panic(err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- obj:
+			default:
+			}
+		}
+	}()
+	return ch, nil
+}
+
 func (s *IdentityManagerStub) NodeID(ctx context.Context) (ret0 pkg1.StrIdentifier) {
 	args := []interface{}{}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "NodeID", args...)
@@ -177,6 +201,23 @@ func (s *IdentityManagerStub) PrivateKey(ctx context.Context) (ret0 []uint8) {
 	return
 }
 
+func (s *IdentityManagerStub) RotateKey(ctx context.Context) (ret0 pkg1.KeyRotation, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "RotateKey", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	ret1 = result.CallError()
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
 func (s *IdentityManagerStub) Sign(ctx context.Context, arg0 []uint8) (ret0 []uint8, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "Sign", args...)
@@ -224,3 +265,18 @@ func (s *IdentityManagerStub) Verify(ctx context.Context, arg0 []uint8, arg1 []u
 	}
 	return
 }
+
+func (s *IdentityManagerStub) Wipe(ctx context.Context) (ret0 error) {
+	args := []interface{}{}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "Wipe", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	ret0 = result.CallError()
+	loader := zbus.Loader{}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
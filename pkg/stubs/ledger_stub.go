@@ -0,0 +1,78 @@
+// GENERATED CODE
+// --------------
+// please do not edit manually instead use the "zbusc" to regenerate
+
+package stubs
+
+import (
+	"context"
+	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+type LedgerStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewLedgerStub(client zbus.Client) *LedgerStub {
+	return &LedgerStub{
+		client: client,
+		module: "provision",
+		object: zbus.ObjectID{
+			Name:    "ledger",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *LedgerStub) Entries(ctx context.Context, arg0 pkg.LedgerQuery) (ret0 pkg.LedgerPage, ret1 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "Entries", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	ret1 = result.CallError()
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *LedgerStub) PublicKey(ctx context.Context) (ret0 []uint8) {
+	args := []interface{}{}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "PublicKey", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *LedgerStub) PublicKeys(ctx context.Context) (ret0 [][]uint8, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "PublicKeys", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	ret1 = result.CallError()
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
@@ -0,0 +1,60 @@
+// GENERATED CODE
+// --------------
+// please do not edit manually instead use the "zbusc" to regenerate
+
+package stubs
+
+import (
+	"context"
+	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+type MeteringStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewMeteringStub(client zbus.Client) *MeteringStub {
+	return &MeteringStub{
+		client: client,
+		module: "provision",
+		object: zbus.ObjectID{
+			Name:    "metering",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *MeteringStub) ReportNow(ctx context.Context) (ret0 error) {
+	args := []interface{}{}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "ReportNow", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	ret0 = result.CallError()
+	loader := zbus.Loader{}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *MeteringStub) TwinTraffic(ctx context.Context, arg0 uint32, arg1 pkg.TrafficQuery) (ret0 []pkg.TrafficSample, ret1 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "TwinTraffic", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	ret1 = result.CallError()
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
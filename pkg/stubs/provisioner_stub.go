@@ -0,0 +1,44 @@
+// GENERATED CODE
+// --------------
+// please do not edit manually instead use the "zbusc" to regenerate
+
+package stubs
+
+import (
+	"context"
+	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+type ProvisionerStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewProvisionerStub(client zbus.Client) *ProvisionerStub {
+	return &ProvisionerStub{
+		client: client,
+		module: "provision",
+		object: zbus.ObjectID{
+			Name:    "provisioner",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *ProvisionerStub) Waiting(ctx context.Context) (ret0 []pkg.WorkloadQueue) {
+	args := []interface{}{}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "Waiting", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
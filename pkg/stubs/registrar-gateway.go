@@ -10,7 +10,7 @@ import (
 	tfchainclientgo "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
 	client "github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
 	zbus "github.com/threefoldtech/zbus"
-	pkg "github.com/threefoldtech/zosbase/pkg"
+	pkg "github.com/threefoldtech/zos4/pkg"
 	"time"
 )
 
@@ -31,16 +31,33 @@ func NewRegistrarGatewayStub(client zbus.Client) *RegistrarGatewayStub {
 	}
 }
 
-func (s *RegistrarGatewayStub) CreateNode(ctx context.Context, arg0 client.Node) (ret0 uint64, ret1 error) {
+func (s *RegistrarGatewayStub) ClockSkew(ctx context.Context) (ret0 pkg.ClockSkew, ret1 pkg.RegistrarError) {
+	args := []interface{}{}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "ClockSkew", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+		&ret1,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) CreateNode(ctx context.Context, arg0 client.Node) (ret0 uint64, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "CreateNode", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -48,16 +65,16 @@ func (s *RegistrarGatewayStub) CreateNode(ctx context.Context, arg0 client.Node)
 	return
 }
 
-func (s *RegistrarGatewayStub) CreateTwin(ctx context.Context, arg0 []string, arg1 string) (ret0 client.Account, ret1 error) {
+func (s *RegistrarGatewayStub) CreateTwin(ctx context.Context, arg0 []string, arg1 string) (ret0 client.Account, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0, arg1}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "CreateTwin", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -65,16 +82,33 @@ func (s *RegistrarGatewayStub) CreateTwin(ctx context.Context, arg0 []string, ar
 	return
 }
 
-func (s *RegistrarGatewayStub) EnsureAccount(ctx context.Context, arg0 []string, arg1 string) (ret0 client.Account, ret1 error) {
+func (s *RegistrarGatewayStub) EnsureAccount(ctx context.Context, arg0 []string, arg1 string) (ret0 client.Account, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0, arg1}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "EnsureAccount", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) GetAttestationChallenge(ctx context.Context, arg0 pkg.AttestationKeys) (ret0 pkg.AttestationChallenge, ret1 pkg.RegistrarError) {
+	args := []interface{}{arg0}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetAttestationChallenge", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -82,7 +116,7 @@ func (s *RegistrarGatewayStub) EnsureAccount(ctx context.Context, arg0 []string,
 	return
 }
 
-func (s *RegistrarGatewayStub) GetContract(ctx context.Context, arg0 uint64) (ret0 tfchainclientgo.Contract, ret1 pkg.SubstrateError) {
+func (s *RegistrarGatewayStub) GetContract(ctx context.Context, arg0 uint64) (ret0 tfchainclientgo.Contract, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetContract", args...)
 	if err != nil {
@@ -99,7 +133,7 @@ func (s *RegistrarGatewayStub) GetContract(ctx context.Context, arg0 uint64) (re
 	return
 }
 
-func (s *RegistrarGatewayStub) GetContractIDByNameRegistration(ctx context.Context, arg0 string) (ret0 uint64, ret1 pkg.SubstrateError) {
+func (s *RegistrarGatewayStub) GetContractIDByNameRegistration(ctx context.Context, arg0 string) (ret0 uint64, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetContractIDByNameRegistration", args...)
 	if err != nil {
@@ -116,16 +150,16 @@ func (s *RegistrarGatewayStub) GetContractIDByNameRegistration(ctx context.Conte
 	return
 }
 
-func (s *RegistrarGatewayStub) GetFarm(ctx context.Context, arg0 uint64) (ret0 client.Farm, ret1 error) {
+func (s *RegistrarGatewayStub) GetFarm(ctx context.Context, arg0 uint64) (ret0 client.Farm, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetFarm", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -133,16 +167,33 @@ func (s *RegistrarGatewayStub) GetFarm(ctx context.Context, arg0 uint64) (ret0 c
 	return
 }
 
-func (s *RegistrarGatewayStub) GetNode(ctx context.Context, arg0 uint64) (ret0 client.Node, ret1 error) {
+func (s *RegistrarGatewayStub) GetNode(ctx context.Context, arg0 uint64) (ret0 client.Node, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetNode", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) GetNodeAttestation(ctx context.Context, arg0 uint64) (ret0 pkg.NodeAttestation, ret1 pkg.RegistrarError) {
+	args := []interface{}{arg0}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetNodeAttestation", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -150,16 +201,16 @@ func (s *RegistrarGatewayStub) GetNode(ctx context.Context, arg0 uint64) (ret0 c
 	return
 }
 
-func (s *RegistrarGatewayStub) GetNodeByTwinID(ctx context.Context, arg0 uint64) (ret0 client.Node, ret1 error) {
+func (s *RegistrarGatewayStub) GetNodeByTwinID(ctx context.Context, arg0 uint64) (ret0 client.Node, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetNodeByTwinID", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -167,16 +218,84 @@ func (s *RegistrarGatewayStub) GetNodeByTwinID(ctx context.Context, arg0 uint64)
 	return
 }
 
-func (s *RegistrarGatewayStub) GetNodeContracts(ctx context.Context, arg0 uint32) (ret0 []types.U64, ret1 error) {
+func (s *RegistrarGatewayStub) GetNodeContractEvents(ctx context.Context, arg0 uint32, arg1 uint64) (ret0 []pkg.ContractEvent, ret1 pkg.RegistrarError) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetNodeContractEvents", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+		&ret1,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) GetNodeContracts(ctx context.Context, arg0 uint32) (ret0 []types.U64, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetNodeContracts", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) GetNodeInventory(ctx context.Context, arg0 uint64) (ret0 pkg.NodeInventory, ret1 pkg.RegistrarError) {
+	args := []interface{}{arg0}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetNodeInventory", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+		&ret1,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) GetNodePowerTarget(ctx context.Context, arg0 uint32) (ret0 tfchainclientgo.NodePower, ret1 pkg.RegistrarError) {
+	args := []interface{}{arg0}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetNodePowerTarget", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+		&ret1,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) GetNodePublicConfig(ctx context.Context, arg0 uint32) (ret0 tfchainclientgo.OptionPublicConfig, ret1 pkg.RegistrarError) {
+	args := []interface{}{arg0}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetNodePublicConfig", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -184,7 +303,7 @@ func (s *RegistrarGatewayStub) GetNodeContracts(ctx context.Context, arg0 uint32
 	return
 }
 
-func (s *RegistrarGatewayStub) GetNodeRentContract(ctx context.Context, arg0 uint32) (ret0 uint64, ret1 pkg.SubstrateError) {
+func (s *RegistrarGatewayStub) GetNodeRentContract(ctx context.Context, arg0 uint32) (ret0 uint64, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetNodeRentContract", args...)
 	if err != nil {
@@ -201,16 +320,16 @@ func (s *RegistrarGatewayStub) GetNodeRentContract(ctx context.Context, arg0 uin
 	return
 }
 
-func (s *RegistrarGatewayStub) GetNodes(ctx context.Context, arg0 uint64) (ret0 []uint64, ret1 error) {
+func (s *RegistrarGatewayStub) GetNodes(ctx context.Context, arg0 uint64) (ret0 []uint64, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetNodes", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -218,16 +337,16 @@ func (s *RegistrarGatewayStub) GetNodes(ctx context.Context, arg0 uint64) (ret0
 	return
 }
 
-func (s *RegistrarGatewayStub) GetPowerTarget(ctx context.Context) (ret0 tfchainclientgo.NodePower, ret1 error) {
+func (s *RegistrarGatewayStub) GetPowerTarget(ctx context.Context) (ret0 tfchainclientgo.NodePower, ret1 pkg.RegistrarError) {
 	args := []interface{}{}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetPowerTarget", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -235,16 +354,16 @@ func (s *RegistrarGatewayStub) GetPowerTarget(ctx context.Context) (ret0 tfchain
 	return
 }
 
-func (s *RegistrarGatewayStub) GetTime(ctx context.Context) (ret0 time.Time, ret1 error) {
+func (s *RegistrarGatewayStub) GetTime(ctx context.Context) (ret0 time.Time, ret1 pkg.RegistrarError) {
 	args := []interface{}{}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetTime", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -252,16 +371,16 @@ func (s *RegistrarGatewayStub) GetTime(ctx context.Context) (ret0 time.Time, ret
 	return
 }
 
-func (s *RegistrarGatewayStub) GetTwin(ctx context.Context, arg0 uint64) (ret0 client.Account, ret1 error) {
+func (s *RegistrarGatewayStub) GetTwin(ctx context.Context, arg0 uint64) (ret0 client.Account, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetTwin", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -269,16 +388,16 @@ func (s *RegistrarGatewayStub) GetTwin(ctx context.Context, arg0 uint64) (ret0 c
 	return
 }
 
-func (s *RegistrarGatewayStub) GetTwinByPubKey(ctx context.Context, arg0 []uint8) (ret0 uint64, ret1 error) {
+func (s *RegistrarGatewayStub) GetTwinByPubKey(ctx context.Context, arg0 []uint8) (ret0 uint64, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetTwinByPubKey", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -286,16 +405,66 @@ func (s *RegistrarGatewayStub) GetTwinByPubKey(ctx context.Context, arg0 []uint8
 	return
 }
 
-func (s *RegistrarGatewayStub) GetZosVersion(ctx context.Context) (ret0 client.ZosVersion, ret1 error) {
+func (s *RegistrarGatewayStub) GetZosVersion(ctx context.Context) (ret0 client.ZosVersion, ret1 pkg.RegistrarError) {
 	args := []interface{}{}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "GetZosVersion", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) InvalidateCache(ctx context.Context, arg0 []string) (ret0 pkg.RegistrarError) {
+	args := []interface{}{arg0}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "InvalidateCache", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) LockStats(ctx context.Context) (ret0 []pkg.RegistrarLockStats, ret1 pkg.RegistrarError) {
+	args := []interface{}{}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "LockStats", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+		&ret1,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) OutboxStats(ctx context.Context) (ret0 pkg.OutboxStats, ret1 pkg.RegistrarError) {
+	args := []interface{}{}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "OutboxStats", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -303,16 +472,49 @@ func (s *RegistrarGatewayStub) GetZosVersion(ctx context.Context) (ret0 client.Z
 	return
 }
 
-func (s *RegistrarGatewayStub) Report(ctx context.Context, arg0 []tfchainclientgo.NruConsumption) (ret0 types.Hash, ret1 error) {
+func (s *RegistrarGatewayStub) RegistrarStats(ctx context.Context) (ret0 pkg.RegistrarStats, ret1 pkg.RegistrarError) {
+	args := []interface{}{}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "RegistrarStats", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+		&ret1,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) RemoveNode(ctx context.Context) (ret0 pkg.RegistrarError) {
+	args := []interface{}{}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "RemoveNode", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) Report(ctx context.Context, arg0 []tfchainclientgo.NruConsumption) (ret0 types.Hash, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "Report", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -320,7 +522,39 @@ func (s *RegistrarGatewayStub) Report(ctx context.Context, arg0 []tfchainclientg
 	return
 }
 
-func (s *RegistrarGatewayStub) SetContractConsumption(ctx context.Context, arg0 ...tfchainclientgo.ContractResources) (ret0 error) {
+func (s *RegistrarGatewayStub) ReportResources(ctx context.Context, arg0 []pkg.ResourceConsumption) (ret0 pkg.RegistrarError) {
+	args := []interface{}{arg0}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "ReportResources", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) RotateTwinKey(ctx context.Context, arg0 pkg.KeyRotation) (ret0 pkg.RegistrarError) {
+	args := []interface{}{arg0}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "RotateTwinKey", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) SetContractConsumption(ctx context.Context, arg0 ...tfchainclientgo.ContractResources) (ret0 pkg.RegistrarError) {
 	args := []interface{}{}
 	for _, argv := range arg0 {
 		args = append(args, argv)
@@ -330,24 +564,25 @@ func (s *RegistrarGatewayStub) SetContractConsumption(ctx context.Context, arg0
 		panic(err)
 	}
 	result.PanicOnError()
-	ret0 = result.CallError()
-	loader := zbus.Loader{}
+	loader := zbus.Loader{
+		&ret0,
+	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
 	}
 	return
 }
 
-func (s *RegistrarGatewayStub) SetNodePowerState(ctx context.Context, arg0 bool) (ret0 types.Hash, ret1 error) {
+func (s *RegistrarGatewayStub) SetNodePowerState(ctx context.Context, arg0 bool) (ret0 types.Hash, ret1 pkg.RegistrarError) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "SetNodePowerState", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret1 = result.CallError()
 	loader := zbus.Loader{
 		&ret0,
+		&ret1,
 	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
@@ -355,30 +590,64 @@ func (s *RegistrarGatewayStub) SetNodePowerState(ctx context.Context, arg0 bool)
 	return
 }
 
-func (s *RegistrarGatewayStub) UpdateNode(ctx context.Context, arg0 client.Node) (ret0 error) {
+func (s *RegistrarGatewayStub) UpdateNode(ctx context.Context, arg0 client.Node) (ret0 pkg.RegistrarError) {
 	args := []interface{}{arg0}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "UpdateNode", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret0 = result.CallError()
-	loader := zbus.Loader{}
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) UpdateNodeAttestation(ctx context.Context, arg0 pkg.NodeAttestation) (ret0 pkg.RegistrarError) {
+	args := []interface{}{arg0}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "UpdateNodeAttestation", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarGatewayStub) UpdateNodeInventory(ctx context.Context, arg0 pkg.NodeInventory) (ret0 pkg.RegistrarError) {
+	args := []interface{}{arg0}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "UpdateNodeInventory", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
 	}
 	return
 }
 
-func (s *RegistrarGatewayStub) UpdateNodeUptimeV2(ctx context.Context, arg0 uint64, arg1 int64) (ret0 error) {
+func (s *RegistrarGatewayStub) UpdateNodeUptimeV2(ctx context.Context, arg0 uint64, arg1 int64) (ret0 pkg.RegistrarError) {
 	args := []interface{}{arg0, arg1}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "UpdateNodeUptimeV2", args...)
 	if err != nil {
 		panic(err)
 	}
 	result.PanicOnError()
-	ret0 = result.CallError()
-	loader := zbus.Loader{}
+	loader := zbus.Loader{
+		&ret0,
+	}
 	if err := result.Unmarshal(&loader); err != nil {
 		panic(err)
 	}
@@ -7,6 +7,7 @@ package stubs
 import (
 	"context"
 	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos4/pkg"
 )
 
 type RegistrarStub struct {
@@ -26,6 +27,38 @@ func NewRegistrarStub(client zbus.Client) *RegistrarStub {
 	}
 }
 
+func (s *RegistrarStub) CurrentState(ctx context.Context) (ret0 pkg.RegistrationState) {
+	args := []interface{}{}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "CurrentState", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *RegistrarStub) History(ctx context.Context) (ret0 []pkg.RegistrationAttempt) {
+	args := []interface{}{}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "History", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
+
 func (s *RegistrarStub) NodeID(ctx context.Context) (ret0 uint32, ret1 error) {
 	args := []interface{}{}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "NodeID", args...)
@@ -43,6 +76,30 @@ func (s *RegistrarStub) NodeID(ctx context.Context) (ret0 uint32, ret1 error) {
 	return
 }
 
+func (s *RegistrarStub) State(ctx context.Context) (<-chan pkg.RegistrationState, error) {
+	ch := make(chan pkg.RegistrationState, 1)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "State")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg.RegistrationState
+			if err := event.Unmarshal(&obj); err != nil {
+				panic(err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- obj:
+			default:
+			}
+		}
+	}()
+	return ch, nil
+}
+
 func (s *RegistrarStub) TwinID(ctx context.Context) (ret0 uint32, ret1 error) {
 	args := []interface{}{}
 	result, err := s.client.RequestContext(ctx, s.module, s.object, "TwinID", args...)
@@ -0,0 +1,45 @@
+// GENERATED CODE
+// --------------
+// please do not edit manually instead use the "zbusc" to regenerate
+
+package stubs
+
+import (
+	"context"
+	zbus "github.com/threefoldtech/zbus"
+	pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+type VMMetricsStub struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+func NewVMMetricsStub(client zbus.Client) *VMMetricsStub {
+	return &VMMetricsStub{
+		client: client,
+		module: "vmd",
+		object: zbus.ObjectID{
+			Name:    "metrics",
+			Version: "0.0.1",
+		},
+	}
+}
+
+func (s *VMMetricsStub) DiskMetrics(ctx context.Context) (ret0 pkg.DiskMetrics, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.RequestContext(ctx, s.module, s.object, "DiskMetrics", args...)
+	if err != nil {
+		panic(err)
+	}
+	result.PanicOnError()
+	ret1 = result.CallError()
+	loader := zbus.Loader{
+		&ret0,
+	}
+	if err := result.Unmarshal(&loader); err != nil {
+		panic(err)
+	}
+	return
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/threefoldtech/0-fs/storage"
 	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
 	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos4/pkg/maintenance"
 	"github.com/threefoldtech/zos4/pkg/stubs"
 	"github.com/threefoldtech/zosbase/pkg/app"
 	"github.com/threefoldtech/zosbase/pkg/environment"
@@ -65,10 +66,11 @@ func getRolloutConfig(ctx context.Context, gw *stubs.RegistrarGatewayStub) (clie
 	// if we are on devnet just update we don't need to update the version through out the registrar
 	v := client.ZosVersion{Version: "v0.0.0", SafeToUpgrade: true}
 	if env.RunningMode != environment.RunningDev {
-		v, err = gw.GetZosVersion(ctx)
-		if err != nil {
+		version, rerr := gw.GetZosVersion(ctx)
+		if err := rerr.Err(); err != nil {
 			return client.ZosVersion{}, nil, errors.Wrap(err, "failed to get zos version from registrar")
 		}
+		v = version
 	}
 
 	return v, config.RolloutUpgrade.TestFarms, nil
@@ -84,6 +86,7 @@ type Upgrader struct {
 	noZosUpgrade bool
 	hub          *hub.HubClient
 	storage      storage.Storage
+	maintenance  *maintenance.Schedule
 }
 
 // UpgraderOption interface
@@ -131,6 +134,18 @@ func Zinit(socket string) UpgraderOption {
 	}
 }
 
+// MaintenanceWindow option, only apply upgrades while one
+// of the schedule windows is open
+func MaintenanceWindow(s maintenance.Schedule) UpgraderOption {
+	return func(u *Upgrader) error {
+		if s.Configured() {
+			u.maintenance = &s
+		}
+
+		return nil
+	}
+}
+
 // NewUpgrader creates a new upgrader instance
 func NewUpgrader(root string, opts ...UpgraderOption) (*Upgrader, error) {
 	hubClient := hub.NewHubClient(defaultHubTimeout)
@@ -231,6 +246,15 @@ func (u *Upgrader) Version() semver.Version {
 func (u *Upgrader) nextUpdate() time.Duration {
 	jitter := rand.Intn(checkJitter)
 	next := checkForUpdateEvery + (time.Duration(jitter) * time.Minute)
+	if u.maintenance != nil {
+		// make sure we don't miss a short maintenance window
+		status := u.maintenance.Status(time.Now())
+		if !status.Open && !status.Next.IsZero() {
+			if until := time.Until(status.Next) + time.Duration(jitter)*time.Second; until < next {
+				next = until
+			}
+		}
+	}
 	log.Info().Str("after", next.String()).Msg("checking for update")
 	return next
 }
@@ -307,6 +331,12 @@ func (u *Upgrader) update(ctx context.Context) error {
 		}
 	}
 
+	if u.maintenance != nil && !u.maintenance.IsOpen(time.Now()) {
+		// nothing to do! waiting for the farmer maintenance window
+		log.Info().Str("version", filepath.Base(remote.Target)).Msg("update available, waiting for maintenance window")
+		return nil
+	}
+
 	log.Info().Str("running version", u.Version().String()).Str("updating to version", filepath.Base(remote.Target)).Msg("updating system...")
 	if err := u.updateTo(remote, &current); err != nil {
 		return errors.Wrapf(err, "failed to update to new tag '%s'", remote.Target)
@@ -0,0 +1,21 @@
+package pkg
+
+//go:generate zbusc -module vmd -version 0.0.1 -name metrics -package stubs github.com/threefoldtech/zos4/pkg+VMMetrics stubs/vm_metrics_stub.go
+
+// DiskIO is the read and written bytes of a disk
+type DiskIO struct {
+	Read  uint64 `json:"read"`
+	Write uint64 `json:"write"`
+}
+
+// DiskMetrics are the io counters of disks used by vms, keyed by the disk
+// name. Zmount disks are named after the file attached to the vm and volumes
+// after the directory served to the vm.
+type DiskMetrics map[string]DiskIO
+
+// VMMetrics reports vms metrics that are not part of the vm module metrics,
+// it's served by vmd next to the vm module
+type VMMetrics interface {
+	// DiskMetrics returns the io counters of the disks of all running vms
+	DiskMetrics() (DiskMetrics, error)
+}
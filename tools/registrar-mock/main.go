@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos4/tools/registrar-mock/mock"
+	"github.com/urfave/cli/v2"
+)
+
+func main() {
+	app := cli.App{
+		Name:  "registrar-mock",
+		Usage: "runs a mock node registrar for local development",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "listen",
+				Usage: "listen address",
+				Value: ":8080",
+			},
+			&cli.StringFlag{
+				Name:  "state",
+				Usage: "path of the state file, state is only kept in memory if not set",
+			},
+			&cli.Uint64Flag{
+				Name:  "admin-twin",
+				Usage: "twin allowed to set the zos version, any twin can set it if not set",
+			},
+			&cli.StringFlag{
+				Name:  "zos-version",
+				Usage: "initial zos version if not set in the state",
+			},
+			&cli.DurationFlag{
+				Name:  "latency",
+				Usage: "latency added to every api request",
+			},
+			&cli.Float64Flag{
+				Name:  "error-rate",
+				Usage: "fraction (0 to 1) of api requests that fail",
+			},
+			&cli.IntFlag{
+				Name:  "error-status",
+				Usage: "http status of failed requests",
+				Value: http.StatusServiceUnavailable,
+			},
+			&cli.BoolFlag{
+				Name:  "reject-emulated",
+				Usage: "reject node attestations made by a software tpm (swtpm)",
+			},
+			&cli.BoolFlag{
+				Name:    "debug",
+				Aliases: []string{"d"},
+				Usage:   "log every request",
+			},
+		},
+		Action: action,
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal().Err(err).Msg("exiting")
+	}
+}
+
+func action(c *cli.Context) error {
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if c.Bool("debug") {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+
+	opts := mock.Options{
+		StatePath:      c.String("state"),
+		AdminTwin:      c.Uint64("admin-twin"),
+		RejectEmulated: c.Bool("reject-emulated"),
+		Faults: mock.Faults{
+			Latency:     c.Duration("latency"),
+			ErrorRate:   c.Float64("error-rate"),
+			ErrorStatus: c.Int("error-status"),
+		},
+	}
+	opts.State.ZosVersion.Version = c.String("zos-version")
+	opts.State.ZosVersion.SafeToUpgrade = true
+
+	server, err := mock.New(opts)
+	if err != nil {
+		return errors.Wrap(err, "failed to create mock registrar")
+	}
+
+	ctx, cancel := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	srv := http.Server{Addr: c.String("listen"), Handler: server}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
+
+	log.Info().Str("listen", srv.Addr).Str("api", mock.APIPrefix).Msg("mock registrar is running")
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package mock
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/attestation"
+)
+
+func (s *Server) getAttestation(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.state.node(id); !ok {
+		writeError(w, http.StatusNotFound, "node not found")
+		return
+	}
+
+	attestation, ok := s.state.Attestations[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "node has no attestation")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, attestation)
+}
+
+func (s *Server) attestationChallenge(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	signer, ok := s.signer(w, r)
+	if !ok {
+		return
+	}
+
+	var request zos4pkg.AttestationKeys
+	if !decode(w, r, &request) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.nodeOf(w, id, signer); !ok {
+		return
+	}
+
+	challenge, issued, err := attestation.NewChallenge(request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid attestation keys: "+err.Error())
+		return
+	}
+
+	// challenges are only kept in memory, a node asks for a new one with
+	// each attestation
+	if s.challenges == nil {
+		s.challenges = make(map[uint64]attestation.Challenge)
+	}
+	s.challenges[id] = challenge
+
+	writeJSON(w, http.StatusOK, issued)
+}
+
+func (s *Server) setAttestation(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	signer, ok := s.signer(w, r)
+	if !ok {
+		return
+	}
+
+	var request zos4pkg.NodeAttestation
+	if !decode(w, r, &request) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodeOf(w, id, signer)
+	if !ok {
+		return
+	}
+
+	account, ok := s.state.account(node.TwinID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "node twin not found")
+		return
+	}
+
+	pk, err := base64.StdEncoding.DecodeString(account.PublicKey)
+	if err != nil || len(pk) != ed25519.PublicKeySize {
+		writeError(w, http.StatusBadRequest, "invalid node twin public key")
+		return
+	}
+
+	if err := attestation.Verify(request, pk, time.Now()); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid attestation: "+err.Error())
+		return
+	}
+
+	if request.AKCertified {
+		challenge, ok := s.challenges[id]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "node has no attestation challenge")
+			return
+		}
+
+		if err := challenge.Verify(request); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid attestation: "+err.Error())
+			return
+		}
+		delete(s.challenges, id)
+	}
+
+	if request.Emulated && s.opts.RejectEmulated {
+		writeError(w, http.StatusBadRequest, "attestations of emulated tpms are not accepted")
+		return
+	}
+
+	if s.state.Attestations == nil {
+		s.state.Attestations = make(map[uint64]zos4pkg.NodeAttestation)
+	}
+
+	s.state.Attestations[id] = request
+	s.commit()
+
+	writeJSON(w, http.StatusOK, request)
+}
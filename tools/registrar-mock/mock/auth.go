@@ -0,0 +1,111 @@
+package mock
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// AuthHeader is the header of signed requests
+	AuthHeader = "X-Auth"
+
+	// authWindow is how far the timestamp of a signed request can be from now
+	authWindow = 5 * time.Minute
+)
+
+var (
+	errUnauthorized = errors.New("unauthorized")
+	errForbidden    = errors.New("forbidden")
+)
+
+// verify verifies signature of message with the base64 encoded public key
+func verify(publicKey string, message, signature []byte) error {
+	pk, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(pk) != ed25519.PublicKeySize {
+		return errors.Wrap(errUnauthorized, "invalid public key")
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(pk), message, signature) {
+		return errors.Wrap(errUnauthorized, "invalid signature")
+	}
+
+	return nil
+}
+
+// checkTimestamp makes sure a signed timestamp is recent
+func checkTimestamp(ts int64) error {
+	if math.Abs(float64(time.Now().Unix()-ts)) > authWindow.Seconds() {
+		return errors.Wrap(errUnauthorized, "signature timestamp is out of the allowed window")
+	}
+
+	return nil
+}
+
+// authenticate verifies the auth header and returns the twin that signed it.
+// The header is `base64(challenge):base64(signature)` where the challenge
+// is `timestamp:twin`.
+func (s *Server) authenticate(header string) (uint64, error) {
+	encoded, sig, ok := strings.Cut(header, ":")
+	if !ok {
+		return 0, errors.Wrap(errUnauthorized, "invalid auth header")
+	}
+
+	challenge, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, errors.Wrap(errUnauthorized, "invalid auth challenge")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return 0, errors.Wrap(errUnauthorized, "invalid auth signature")
+	}
+
+	tsPart, twinPart, ok := strings.Cut(string(challenge), ":")
+	if !ok {
+		return 0, errors.Wrap(errUnauthorized, "invalid auth challenge")
+	}
+
+	ts, err := strconv.ParseInt(tsPart, 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(errUnauthorized, "invalid auth timestamp")
+	}
+
+	twin, err := strconv.ParseUint(twinPart, 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(errUnauthorized, "invalid auth twin")
+	}
+
+	if err := checkTimestamp(ts); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	account, ok := s.state.account(twin)
+	var pk string
+	if ok {
+		pk = account.PublicKey
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return 0, errors.Wrapf(errUnauthorized, "twin %d not found", twin)
+	}
+
+	if err := verify(pk, challenge, signature); err != nil {
+		return 0, err
+	}
+
+	return twin, nil
+}
+
+// accountChallenge is the challenge signed when creating an account
+func accountChallenge(ts int64, publicKey string) []byte {
+	return []byte(fmt.Sprintf("%d:%s", ts, publicKey))
+}
@@ -0,0 +1,97 @@
+package mock
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Faults are injected in requests to simulate a slow or failing registrar
+type Faults struct {
+	// Latency is added to every request
+	Latency time.Duration `json:"-"`
+	// ErrorRate is the fraction (0 to 1) of requests that fail
+	ErrorRate float64 `json:"error_rate"`
+	// ErrorStatus is the status of failed requests, defaults to 503
+	ErrorStatus int `json:"error_status"`
+	// Prefix limits faults to requests with a path under this prefix
+	// (relative to the api root, like /nodes)
+	Prefix string `json:"prefix"`
+}
+
+type faultsJSON struct {
+	// Latency as a duration string (like 500ms)
+	Latency     string  `json:"latency"`
+	ErrorRate   float64 `json:"error_rate"`
+	ErrorStatus int     `json:"error_status"`
+	Prefix      string  `json:"prefix"`
+}
+
+// MarshalJSON encodes faults with the latency as a duration string
+func (f Faults) MarshalJSON() ([]byte, error) {
+	return json.Marshal(faultsJSON{
+		Latency:     f.Latency.String(),
+		ErrorRate:   f.ErrorRate,
+		ErrorStatus: f.ErrorStatus,
+		Prefix:      f.Prefix,
+	})
+}
+
+// UnmarshalJSON decodes faults with the latency as a duration string
+func (f *Faults) UnmarshalJSON(data []byte) error {
+	var v faultsJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	var latency time.Duration
+	if v.Latency != "" {
+		var err error
+		if latency, err = time.ParseDuration(v.Latency); err != nil {
+			return err
+		}
+	}
+
+	*f = Faults{
+		Latency:     latency,
+		ErrorRate:   v.ErrorRate,
+		ErrorStatus: v.ErrorStatus,
+		Prefix:      v.Prefix,
+	}
+
+	return nil
+}
+
+func (f *Faults) applies(path string) bool {
+	return f.Prefix == "" || strings.HasPrefix(path, f.Prefix)
+}
+
+// inject applies the faults to the request, returns true if the
+// request was failed
+func (f *Faults) inject(w http.ResponseWriter, r *http.Request, path string) bool {
+	if !f.applies(path) {
+		return false
+	}
+
+	if f.Latency > 0 {
+		select {
+		case <-time.After(f.Latency):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+
+	if f.ErrorRate <= 0 || rand.Float64() >= f.ErrorRate {
+		return false
+	}
+
+	status := f.ErrorStatus
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeError(w, status, "injected failure")
+	return true
+}
@@ -0,0 +1,63 @@
+package mock
+
+import (
+	"net/http"
+
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+func (s *Server) getInventory(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.state.node(id); !ok {
+		writeError(w, http.StatusNotFound, "node not found")
+		return
+	}
+
+	inventory, ok := s.state.Inventories[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "node has no inventory")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, inventory)
+}
+
+func (s *Server) setInventory(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	signer, ok := s.signer(w, r)
+	if !ok {
+		return
+	}
+
+	var inventory zos4pkg.NodeInventory
+	if !decode(w, r, &inventory) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.nodeOf(w, id, signer); !ok {
+		return
+	}
+
+	if s.state.Inventories == nil {
+		s.state.Inventories = make(map[uint64]zos4pkg.NodeInventory)
+	}
+
+	s.state.Inventories[id] = inventory
+	s.commit()
+
+	writeJSON(w, http.StatusOK, inventory)
+}
@@ -0,0 +1,66 @@
+package mock
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/pkg/errors"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+	"github.com/threefoldtech/zos4/pkg/identity"
+)
+
+// rotateAccount sets the account public key to the new key of a rotation.
+// The request is not signed, the rotation is signed by both the old and new
+// keys instead.
+func (s *Server) rotateAccount(w http.ResponseWriter, r *http.Request) {
+	twin, ok := pathID(w, r, "twin")
+	if !ok {
+		return
+	}
+
+	var request zos4pkg.KeyRotation
+	if !decode(w, r, &request) {
+		return
+	}
+
+	if request.TwinID != twin {
+		writeError(w, http.StatusBadRequest, "rotation is for another twin")
+		return
+	}
+
+	if err := identity.VerifyRotation(request); err != nil {
+		fail(w, errors.Wrap(errUnauthorized, err.Error()))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.state.account(twin)
+	if !ok {
+		writeError(w, http.StatusNotFound, "account not found")
+		return
+	}
+
+	newKey := base64.StdEncoding.EncodeToString(request.NewKey)
+	if account.PublicKey == newKey {
+		// the rotation is sent again if the node didn't get the response
+		writeJSON(w, http.StatusOK, account)
+		return
+	}
+
+	if account.PublicKey != base64.StdEncoding.EncodeToString(request.OldKey) {
+		fail(w, errors.Wrap(errForbidden, "old key is not the account key"))
+		return
+	}
+
+	if _, ok := s.state.accountByPK(newKey); ok {
+		writeError(w, http.StatusConflict, "new key is used by another account")
+		return
+	}
+
+	account.PublicKey = newKey
+	s.commit()
+
+	writeJSON(w, http.StatusOK, account)
+}
@@ -0,0 +1,816 @@
+// Package mock implements a mock of the node registrar http api, as used by
+// the registrar client. State is kept in memory, and optionally persisted
+// in a file. Signed requests are verified like the real registrar does, and
+// faults (latency and errors) can be injected to test how nodes handle a
+// slow or failing registrar.
+package mock
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
+	"github.com/threefoldtech/zos4/pkg/attestation"
+)
+
+const (
+	// APIPrefix is the path of the registrar api, the registrar url used by
+	// clients is the server url joined with this prefix
+	APIPrefix = "/api/v1"
+
+	// maxUptimeReports is the number of uptime reports kept per node
+	maxUptimeReports = 100
+	// defaultPageSize is the page size of list calls if not set
+	defaultPageSize = 50
+)
+
+// Options of the mock registrar
+type Options struct {
+	// StatePath is the file where the state is kept, the state is only
+	// kept in memory if empty
+	StatePath string
+	// State is the initial state if StatePath is not set or does not exist
+	State State
+	// AdminTwin is the only twin allowed to set the zos version, if 0
+	// any twin can set it
+	AdminTwin uint64
+	// Faults are the initial injected faults
+	Faults Faults
+	// RejectEmulated rejects node attestations made by a software TPM
+	RejectEmulated bool
+}
+
+// Server is a mock node registrar
+type Server struct {
+	opts Options
+
+	mu     sync.Mutex
+	state  State
+	faults Faults
+	// challenges are the pending attestation challenges by node id
+	challenges map[uint64]attestation.Challenge
+
+	handler http.Handler
+}
+
+// New creates a new mock registrar
+func New(opts Options) (*Server, error) {
+	state := opts.State
+	if opts.StatePath != "" {
+		loaded, err := LoadState(opts.StatePath)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(loaded.Accounts) != 0 || len(loaded.Farms) != 0 || len(loaded.Nodes) != 0 || loaded.ZosVersion.Version != "" {
+			state = loaded
+		}
+	}
+
+	s := &Server{
+		opts:   opts,
+		state:  state,
+		faults: opts.Faults,
+	}
+
+	api := http.NewServeMux()
+	api.HandleFunc("POST /accounts", s.createAccount)
+	api.HandleFunc("GET /accounts", s.getAccount)
+	api.HandleFunc("PATCH /accounts/{twin}", s.updateAccount)
+	api.HandleFunc("POST /accounts/{twin}/rotate", s.rotateAccount)
+	api.HandleFunc("POST /farms", s.createFarm)
+	api.HandleFunc("GET /farms", s.listFarms)
+	api.HandleFunc("GET /farms/{id}", s.getFarm)
+	api.HandleFunc("PATCH /farms/{id}", s.updateFarm)
+	api.HandleFunc("POST /nodes", s.createNode)
+	api.HandleFunc("GET /nodes", s.listNodes)
+	api.HandleFunc("GET /nodes/{id}", s.getNode)
+	api.HandleFunc("PATCH /nodes/{id}", s.updateNode)
+	api.HandleFunc("DELETE /nodes/{id}", s.removeNode)
+	api.HandleFunc("POST /nodes/{id}/uptime", s.reportUptime)
+	api.HandleFunc("GET /nodes/{id}/inventory", s.getInventory)
+	api.HandleFunc("PUT /nodes/{id}/inventory", s.setInventory)
+	api.HandleFunc("GET /nodes/{id}/attestation", s.getAttestation)
+	api.HandleFunc("PUT /nodes/{id}/attestation", s.setAttestation)
+	api.HandleFunc("POST /nodes/{id}/attestation/challenge", s.attestationChallenge)
+	api.HandleFunc("GET /zos/version", s.getZosVersion)
+	api.HandleFunc("PUT /zos/version", s.setZosVersion)
+
+	mux := http.NewServeMux()
+	mux.Handle(APIPrefix+"/", http.StripPrefix(APIPrefix, s.inject(api)))
+	mux.HandleFunc("GET /mock/faults", s.getFaults)
+	mux.HandleFunc("PUT /mock/faults", s.setFaults)
+	mux.HandleFunc("GET /mock/state", s.getState)
+
+	s.handler = mux
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Debug().Str("method", r.Method).Str("path", r.URL.Path).Msg("request")
+	s.handler.ServeHTTP(w, r)
+}
+
+// SetFaults sets the injected faults
+func (s *Server) SetFaults(faults Faults) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = faults
+}
+
+// State returns a copy of the current state
+func (s *Server) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, _ := json.Marshal(s.state)
+	var state State
+	_ = json.Unmarshal(data, &state)
+	return state
+}
+
+func (s *Server) inject(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		faults := s.faults
+		s.mu.Unlock()
+
+		if faults.inject(w, r, r.URL.Path) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// commit persists the state, must be called with the lock held
+func (s *Server) commit() {
+	if s.opts.StatePath == "" {
+		return
+	}
+
+	if err := s.state.save(s.opts.StatePath); err != nil {
+		log.Error().Err(err).Str("path", s.opts.StatePath).Msg("failed to persist state")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Error().Err(err).Msg("failed to write response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// fail writes err with a status based on the error kind
+func fail(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, errUnauthorized):
+		status = http.StatusUnauthorized
+	case errors.Is(err, errForbidden):
+		status = http.StatusForbidden
+	}
+
+	writeError(w, status, err.Error())
+}
+
+// signer authenticates the request, the error is written if it fails
+func (s *Server) signer(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	header := r.Header.Get(AuthHeader)
+	if header == "" {
+		writeError(w, http.StatusUnauthorized, "missing auth header")
+		return 0, false
+	}
+
+	twin, err := s.authenticate(header)
+	if err != nil {
+		fail(w, err)
+		return 0, false
+	}
+
+	return twin, true
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+
+	return true
+}
+
+func pathID(w http.ResponseWriter, r *http.Request, name string) (uint64, bool) {
+	id, err := strconv.ParseUint(r.PathValue(name), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid "+name)
+		return 0, false
+	}
+
+	return id, true
+}
+
+// filter parses an optional uint query parameter
+func filter(r *http.Request, name string) (uint64, bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, false, nil
+	}
+
+	v, err := strconv.ParseUint(value, 10, 64)
+	return v, true, errors.Wrapf(err, "invalid %s", name)
+}
+
+// page returns the range of a page of n items
+func page(r *http.Request, n int) (start, end int) {
+	p, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+	if p < 1 {
+		p = 1
+	}
+	if size < 1 {
+		size = defaultPageSize
+	}
+
+	start = min((p-1)*size, n)
+	end = min(start+size, n)
+	return
+}
+
+func (s *Server) createAccount(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		PublicKey string   `json:"public_key"`
+		Signature string   `json:"signature"`
+		Timestamp int64    `json:"timestamp"`
+		RMBEncKey string   `json:"rmb_enc_key"`
+		Relays    []string `json:"relays"`
+	}
+
+	if !decode(w, r, &request) {
+		return
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(request.Signature)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid signature encoding")
+		return
+	}
+
+	if err := checkTimestamp(request.Timestamp); err != nil {
+		fail(w, err)
+		return
+	}
+
+	if err := verify(request.PublicKey, accountChallenge(request.Timestamp, request.PublicKey), signature); err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.state.accountByPK(request.PublicKey); ok {
+		writeError(w, http.StatusConflict, "account with the same public key already exists")
+		return
+	}
+
+	account := client.Account{
+		TwinID:    s.state.nextTwin(),
+		Relays:    request.Relays,
+		RMBEncKey: request.RMBEncKey,
+		PublicKey: request.PublicKey,
+	}
+
+	s.state.Accounts = append(s.state.Accounts, account)
+	s.commit()
+
+	writeJSON(w, http.StatusCreated, account)
+}
+
+func (s *Server) getAccount(w http.ResponseWriter, r *http.Request) {
+	twin, byTwin, err := filter(r, "twin_id")
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	pk := r.URL.Query().Get("public_key")
+	if !byTwin && pk == "" {
+		writeError(w, http.StatusBadRequest, "either twin_id or public_key must be set")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var account *client.Account
+	var ok bool
+	if byTwin {
+		account, ok = s.state.account(twin)
+	} else {
+		account, ok = s.state.accountByPK(pk)
+	}
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "account not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, account)
+}
+
+func (s *Server) updateAccount(w http.ResponseWriter, r *http.Request) {
+	twin, ok := pathID(w, r, "twin")
+	if !ok {
+		return
+	}
+
+	signer, ok := s.signer(w, r)
+	if !ok {
+		return
+	}
+
+	if signer != twin {
+		fail(w, errors.Wrap(errForbidden, "can only update own account"))
+		return
+	}
+
+	var request struct {
+		Relays    *[]string `json:"relays"`
+		RMBEncKey *string   `json:"rmb_enc_key"`
+	}
+
+	if !decode(w, r, &request) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.state.account(twin)
+	if !ok {
+		writeError(w, http.StatusNotFound, "account not found")
+		return
+	}
+
+	if request.Relays != nil {
+		account.Relays = *request.Relays
+	}
+	if request.RMBEncKey != nil {
+		account.RMBEncKey = *request.RMBEncKey
+	}
+	s.commit()
+
+	writeJSON(w, http.StatusOK, account)
+}
+
+func (s *Server) createFarm(w http.ResponseWriter, r *http.Request) {
+	signer, ok := s.signer(w, r)
+	if !ok {
+		return
+	}
+
+	var farm client.Farm
+	if !decode(w, r, &farm) {
+		return
+	}
+
+	if farm.FarmName == "" {
+		writeError(w, http.StatusBadRequest, "farm name is required")
+		return
+	}
+
+	if farm.TwinID != signer {
+		fail(w, errors.Wrap(errForbidden, "farm twin must be the signer twin"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.state.Farms {
+		if existing.FarmName == farm.FarmName {
+			writeError(w, http.StatusConflict, "farm with the same name already exists")
+			return
+		}
+	}
+
+	farm.FarmID = s.state.nextFarm()
+	s.state.Farms = append(s.state.Farms, farm)
+	s.commit()
+
+	writeJSON(w, http.StatusCreated, map[string]uint64{"farm_id": farm.FarmID})
+}
+
+func (s *Server) getFarm(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	farm, ok := s.state.farm(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "farm not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, farm)
+}
+
+func (s *Server) listFarms(w http.ResponseWriter, r *http.Request) {
+	farmID, byFarm, err := filter(r, "farm_id")
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	twinID, byTwin, err := filter(r, "twin_id")
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	name := r.URL.Query().Get("farm_name")
+	dedicated := r.URL.Query().Get("dedicated")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	farms := []client.Farm{}
+	for _, farm := range s.state.Farms {
+		if (byFarm && farm.FarmID != farmID) ||
+			(byTwin && farm.TwinID != twinID) ||
+			(name != "" && farm.FarmName != name) ||
+			(dedicated != "" && strconv.FormatBool(farm.Dedicated) != dedicated) {
+			continue
+		}
+		farms = append(farms, farm)
+	}
+
+	start, end := page(r, len(farms))
+	writeJSON(w, http.StatusOK, farms[start:end])
+}
+
+func (s *Server) updateFarm(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	signer, ok := s.signer(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		FarmName       *string `json:"farm_name"`
+		StellarAddress *string `json:"stellar_address"`
+		Dedicated      *bool   `json:"dedicated"`
+	}
+
+	if !decode(w, r, &request) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	farm, ok := s.state.farm(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "farm not found")
+		return
+	}
+
+	if farm.TwinID != signer {
+		fail(w, errors.Wrap(errForbidden, "can only update own farm"))
+		return
+	}
+
+	if request.FarmName != nil {
+		farm.FarmName = *request.FarmName
+	}
+	if request.StellarAddress != nil {
+		farm.StellarAddress = *request.StellarAddress
+	}
+	if request.Dedicated != nil {
+		farm.Dedicated = *request.Dedicated
+	}
+	s.commit()
+
+	writeJSON(w, http.StatusOK, farm)
+}
+
+func (s *Server) createNode(w http.ResponseWriter, r *http.Request) {
+	signer, ok := s.signer(w, r)
+	if !ok {
+		return
+	}
+
+	var node client.Node
+	if !decode(w, r, &node) {
+		return
+	}
+
+	if node.TwinID != signer {
+		fail(w, errors.Wrap(errForbidden, "node twin must be the signer twin"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.state.farm(node.FarmID); !ok {
+		writeError(w, http.StatusBadRequest, "farm not found")
+		return
+	}
+
+	if _, ok := s.state.nodeByTwin(node.TwinID); ok {
+		writeError(w, http.StatusConflict, "node with the same twin is already registered")
+		return
+	}
+
+	node.NodeID = s.state.nextNode()
+	node.UptimeReports = nil
+	node.LastSeen = nil
+	node.Online = false
+	node.Approved = false
+	s.state.Nodes = append(s.state.Nodes, node)
+	s.commit()
+
+	writeJSON(w, http.StatusCreated, map[string]uint64{"node_id": node.NodeID})
+}
+
+func (s *Server) getNode(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.state.node(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "node not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, node)
+}
+
+func (s *Server) listNodes(w http.ResponseWriter, r *http.Request) {
+	filters := map[string]uint64{}
+	for _, name := range []string{"node_id", "twin_id", "farm_id"} {
+		value, ok, err := filter(r, name)
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		if ok {
+			filters[name] = value
+		}
+	}
+
+	online := r.URL.Query().Get("online")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nodes := []client.Node{}
+	for _, node := range s.state.Nodes {
+		values := map[string]uint64{"node_id": node.NodeID, "twin_id": node.TwinID, "farm_id": node.FarmID}
+		match := online == "" || strconv.FormatBool(node.Online) == online
+		for name, value := range filters {
+			match = match && values[name] == value
+		}
+
+		if match {
+			nodes = append(nodes, node)
+		}
+	}
+
+	start, end := page(r, len(nodes))
+	writeJSON(w, http.StatusOK, nodes[start:end])
+}
+
+// nodeOf gets the node in the path and makes sure it's owned by the request
+// signer. Must be called with the lock held.
+func (s *Server) nodeOf(w http.ResponseWriter, id, signer uint64) (*client.Node, bool) {
+	node, ok := s.state.node(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "node not found")
+		return nil, false
+	}
+
+	if node.TwinID != signer {
+		fail(w, errors.Wrap(errForbidden, "node is not owned by the signer twin"))
+		return nil, false
+	}
+
+	return node, true
+}
+
+func (s *Server) updateNode(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	signer, ok := s.signer(w, r)
+	if !ok {
+		return
+	}
+
+	var update client.Node
+	if !decode(w, r, &update) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodeOf(w, id, signer)
+	if !ok {
+		return
+	}
+
+	if _, ok := s.state.farm(update.FarmID); !ok {
+		writeError(w, http.StatusBadRequest, "farm not found")
+		return
+	}
+
+	node.FarmID = update.FarmID
+	node.Location = update.Location
+	node.Resources = update.Resources
+	node.Interfaces = update.Interfaces
+	node.SecureBoot = update.SecureBoot
+	node.Virtualized = update.Virtualized
+	node.SerialNumber = update.SerialNumber
+	s.commit()
+
+	writeJSON(w, http.StatusOK, node)
+}
+
+func (s *Server) removeNode(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	signer, ok := s.signer(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.nodeOf(w, id, signer); !ok {
+		return
+	}
+
+	s.state.removeNode(id)
+	s.commit()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) reportUptime(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	signer, ok := s.signer(w, r)
+	if !ok {
+		return
+	}
+
+	var report client.UptimeReport
+	if !decode(w, r, &report) {
+		return
+	}
+
+	if report.Timestamp == 0 {
+		writeError(w, http.StatusBadRequest, "timestamp is required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodeOf(w, id, signer)
+	if !ok {
+		return
+	}
+
+	node.UptimeReports = append(node.UptimeReports, report)
+	if len(node.UptimeReports) > maxUptimeReports {
+		node.UptimeReports = node.UptimeReports[len(node.UptimeReports)-maxUptimeReports:]
+	}
+
+	now := time.Now()
+	node.LastSeen = &now
+	node.Online = true
+	s.commit()
+
+	writeJSON(w, http.StatusCreated, map[string]string{"message": "uptime reported"})
+}
+
+func (s *Server) getZosVersion(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	version := s.state.ZosVersion
+	s.mu.Unlock()
+
+	// the registrar serves the version as a base64 encoded json object
+	data, err := json.Marshal(version)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, base64.StdEncoding.EncodeToString(data))
+}
+
+func (s *Server) setZosVersion(w http.ResponseWriter, r *http.Request) {
+	signer, ok := s.signer(w, r)
+	if !ok {
+		return
+	}
+
+	if s.opts.AdminTwin != 0 && signer != s.opts.AdminTwin {
+		fail(w, errors.Wrap(errForbidden, "only the admin twin can set the zos version"))
+		return
+	}
+
+	var request struct {
+		Version string `json:"version"`
+	}
+
+	if !decode(w, r, &request) {
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(request.Version)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "version must be base64 encoded")
+		return
+	}
+
+	var version client.ZosVersion
+	if err := json.Unmarshal(data, &version); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid version: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state.ZosVersion = version
+	s.commit()
+
+	writeJSON(w, http.StatusOK, map[string]string{"message": "version updated"})
+}
+
+func (s *Server) getFaults(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, s.faults)
+}
+
+func (s *Server) setFaults(w http.ResponseWriter, r *http.Request) {
+	var faults Faults
+	if !decode(w, r, &faults) {
+		return
+	}
+
+	if faults.ErrorRate < 0 || faults.ErrorRate > 1 {
+		writeError(w, http.StatusBadRequest, "error_rate must be between 0 and 1")
+		return
+	}
+
+	s.SetFaults(faults)
+	log.Info().Interface("faults", faults).Msg("faults updated")
+	writeJSON(w, http.StatusOK, faults)
+}
+
+func (s *Server) getState(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.State())
+}
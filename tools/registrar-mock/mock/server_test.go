@@ -0,0 +1,155 @@
+package mock
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
+)
+
+const stellarAddress = "GBB3H4F7N4R6U7ZQO4Q5G4YJ5Y4ZLZ5V5X6Y7Z8A9B2C3D4E5F6G7H8J"
+
+func seed(b byte) string {
+	return hex.EncodeToString(ed25519.NewKeyFromSeed([]byte(strings.Repeat(string(b), ed25519.SeedSize))).Seed())
+}
+
+func newClient(t *testing.T, url, seed string) client.RegistrarClient {
+	cl, err := client.NewRegistrarClient(url+APIPrefix, seed)
+	require.NoError(t, err)
+	return cl
+}
+
+func TestRegistration(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	server, err := New(Options{StatePath: path})
+	require.NoError(err)
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	farmer := newClient(t, srv.URL, seed('f'))
+	account, err := farmer.EnsureAccount(nil, "")
+	require.NoError(err)
+	require.EqualValues(1, account.TwinID)
+
+	farmID, err := farmer.CreateFarm("farm", stellarAddress, false)
+	require.NoError(err)
+
+	node := newClient(t, srv.URL, seed('n'))
+	account, err = node.EnsureAccount([]string{"relay.grid.tf"}, "key")
+	require.NoError(err)
+	require.EqualValues(2, account.TwinID)
+
+	// accounts are created once
+	_, err = node.EnsureAccount(nil, "")
+	require.NoError(err)
+	require.Len(server.State().Accounts, 2)
+
+	nodeID, err := node.RegisterNode(client.Node{
+		FarmID:    farmID,
+		TwinID:    account.TwinID,
+		Resources: client.Resources{CRU: 4},
+	})
+	require.NoError(err)
+
+	// a node can't be registered twice
+	_, err = node.RegisterNode(client.Node{FarmID: farmID, TwinID: account.TwinID})
+	require.Error(err)
+
+	cru := client.Resources{CRU: 8}
+	require.NoError(node.UpdateNode(client.NodeUpdate{Resources: &cru}))
+	require.NoError(node.ReportUptime(client.UptimeReport{Uptime: 10, Timestamp: 100}))
+
+	found, err := farmer.GetNodeByTwinID(account.TwinID)
+	require.NoError(err)
+	require.Equal(nodeID, found.NodeID)
+	require.EqualValues(8, found.Resources.CRU)
+	require.True(found.Online)
+	require.Len(found.UptimeReports, 1)
+
+	nodes, err := farmer.ListNodes(client.NodeFilter{FarmID: &farmID})
+	require.NoError(err)
+	require.Len(nodes, 1)
+
+	_, err = farmer.GetNode(nodeID + 1)
+	require.ErrorIs(err, client.ErrorNodeNotFound)
+
+	// state is kept across restarts
+	server, err = New(Options{StatePath: path})
+	require.NoError(err)
+	require.Len(server.State().Nodes, 1)
+	require.Len(server.State().Farms, 1)
+}
+
+func TestAuth(t *testing.T) {
+	require := require.New(t)
+
+	server, err := New(Options{AdminTwin: 1})
+	require.NoError(err)
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	admin := newClient(t, srv.URL, seed('a'))
+	_, err = admin.EnsureAccount(nil, "")
+	require.NoError(err)
+
+	other := newClient(t, srv.URL, seed('o'))
+	_, err = other.EnsureAccount(nil, "")
+	require.NoError(err)
+
+	require.Error(other.SetZosVersion("v4.0.1", true))
+	require.NoError(admin.SetZosVersion("v4.0.1", true))
+
+	version, err := other.GetZosVersion()
+	require.NoError(err)
+	require.Equal("v4.0.1", version.Version)
+	require.True(version.SafeToUpgrade)
+
+	// requests signed by an unknown twin are rejected
+	req, err := http.NewRequest(http.MethodPost, srv.URL+APIPrefix+"/nodes", strings.NewReader("{}"))
+	require.NoError(err)
+	req.Header.Set(AuthHeader, "MTow:c2ln")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(err)
+	resp.Body.Close()
+	require.Equal(http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestFaults(t *testing.T) {
+	require := require.New(t)
+
+	server, err := New(Options{Faults: Faults{ErrorRate: 1, Prefix: "/zos"}})
+	require.NoError(err)
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	cl := newClient(t, srv.URL, seed('n'))
+	_, err = cl.GetZosVersion()
+	require.ErrorContains(err, "503")
+
+	// faults only apply to requests under the prefix
+	_, err = cl.EnsureAccount(nil, "")
+	require.NoError(err)
+
+	resp, err := http.Post(srv.URL+"/mock/faults", "application/json", nil)
+	require.NoError(err)
+	resp.Body.Close()
+	require.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodPut, srv.URL+"/mock/faults", strings.NewReader(`{"latency": "1ms"}`))
+	require.NoError(err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(err)
+	resp.Body.Close()
+	require.Equal(http.StatusOK, resp.StatusCode)
+
+	_, err = cl.GetZosVersion()
+	require.NoError(err)
+}
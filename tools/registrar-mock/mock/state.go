@@ -0,0 +1,148 @@
+package mock
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid4-sdk-go/node-registrar/client"
+	zos4pkg "github.com/threefoldtech/zos4/pkg"
+)
+
+// State is the full state of the mock registrar, it's what is kept in
+// the state file
+type State struct {
+	Accounts   []client.Account  `json:"accounts"`
+	Farms      []client.Farm     `json:"farms"`
+	Nodes      []client.Node     `json:"nodes"`
+	ZosVersion client.ZosVersion `json:"zos_version"`
+	// Inventories are the hardware inventories of nodes by node id
+	Inventories map[uint64]zos4pkg.NodeInventory `json:"inventories,omitempty"`
+	// Attestations are the last accepted attestations of nodes by node id
+	Attestations map[uint64]zos4pkg.NodeAttestation `json:"attestations,omitempty"`
+}
+
+// LoadState loads state from path, an empty state is returned if the
+// file does not exist
+func LoadState(path string) (State, error) {
+	var state State
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return state, errors.Wrap(err, "failed to read state file")
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, errors.Wrapf(err, "invalid state file '%s'", path)
+	}
+
+	return state, nil
+}
+
+// save writes the state to path atomically
+func (s *State) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (s *State) account(twin uint64) (*client.Account, bool) {
+	for i := range s.Accounts {
+		if s.Accounts[i].TwinID == twin {
+			return &s.Accounts[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func (s *State) accountByPK(pk string) (*client.Account, bool) {
+	for i := range s.Accounts {
+		if s.Accounts[i].PublicKey == pk {
+			return &s.Accounts[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func (s *State) farm(id uint64) (*client.Farm, bool) {
+	for i := range s.Farms {
+		if s.Farms[i].FarmID == id {
+			return &s.Farms[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func (s *State) node(id uint64) (*client.Node, bool) {
+	for i := range s.Nodes {
+		if s.Nodes[i].NodeID == id {
+			return &s.Nodes[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// removeNode removes the node with its inventory and attestation
+func (s *State) removeNode(id uint64) {
+	for i := range s.Nodes {
+		if s.Nodes[i].NodeID == id {
+			s.Nodes = append(s.Nodes[:i], s.Nodes[i+1:]...)
+			break
+		}
+	}
+
+	delete(s.Inventories, id)
+	delete(s.Attestations, id)
+}
+
+func (s *State) nodeByTwin(twin uint64) (*client.Node, bool) {
+	for i := range s.Nodes {
+		if s.Nodes[i].TwinID == twin {
+			return &s.Nodes[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func (s *State) nextTwin() (id uint64) {
+	for _, account := range s.Accounts {
+		id = max(id, account.TwinID)
+	}
+
+	return id + 1
+}
+
+func (s *State) nextFarm() (id uint64) {
+	for _, farm := range s.Farms {
+		id = max(id, farm.FarmID)
+	}
+
+	return id + 1
+}
+
+func (s *State) nextNode() (id uint64) {
+	for _, node := range s.Nodes {
+		id = max(id, node.NodeID)
+	}
+
+	return id + 1
+}